@@ -0,0 +1,50 @@
+// cmd/server/backup.go
+package main
+
+import (
+	"context"
+	"expense-tracker/internal/backup"
+	"expense-tracker/internal/database"
+	"fmt"
+)
+
+const restoreUsage = `usage: server [-db path] [-attachments-dir dir] restore SNAPSHOT
+
+Verifies SNAPSHOT and swaps it in as the database, adding the snapshot's
+attachment files to the attachments directory. Stop the server first.`
+
+func runBackup(dbPath string, opts database.Options, dir string, keep int, attachmentsDir string) error {
+	db, err := database.New(dbPath, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	snapshot, err := backup.NewManager(db, dir, keep, attachmentsDir).Create(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s (%d bytes)\n", snapshot.Path, snapshot.Size)
+	if snapshot.Attachments > 0 {
+		fmt.Printf("Copied %d attachments (%d bytes) to %s\n", snapshot.Attachments, snapshot.AttachmentsSize, snapshot.AttachmentsPath)
+	}
+	return nil
+}
+
+func runRestore(dbPath, attachmentsDir string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s", restoreUsage)
+	}
+
+	kept, err := backup.Restore(args[0], dbPath, attachmentsDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %s from %s\n", dbPath, args[0])
+	if kept != "" {
+		fmt.Printf("Previous database kept at %s\n", kept)
+	}
+	return nil
+}
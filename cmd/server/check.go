@@ -0,0 +1,42 @@
+// cmd/server/check.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/integrity"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func runCheck(dbPath string, opts database.Options, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "apply safe fixes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := database.New(dbPath, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := integrity.Check(context.Background(), db, integrity.Options{Fix: *fix})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if !report.OK {
+		return fmt.Errorf("%d unresolved issue(s)", report.Issues)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// cmd/server/jobs.go
+package main
+
+import (
+	"context"
+	"expense-tracker/internal/attachments"
+	"expense-tracker/internal/repository"
+	"log"
+	"time"
+)
+
+// trashPurgeInterval is how often expired trash is checked; retention is
+// measured in days, so hourly is plenty.
+const trashPurgeInterval = time.Hour
+
+// runTrashPurger permanently removes expenses that have been in the trash
+// longer than retention, once at startup and then every trashPurgeInterval,
+// and sweeps away the attachment files they leave behind.
+func runTrashPurger(ctx context.Context, repo repository.ExpenseRepository, files *attachments.Manager, retention time.Duration) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := repo.PurgeDeletedBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Trash: purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("Trash: purged %d expenses deleted more than %s ago", purged, retention)
+			sweepAttachments(ctx, files)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepAttachments removes attachment files nothing refers to any more.
+func sweepAttachments(ctx context.Context, files *attachments.Manager) {
+	removed, err := files.Sweep(ctx)
+	if err != nil {
+		log.Printf("Attachments: sweep failed: %v", err)
+	} else if removed > 0 {
+		log.Printf("Attachments: removed %d unreferenced files", removed)
+	}
+}
@@ -2,23 +2,98 @@
 package main
 
 import (
+	"context"
+	"expense-tracker/internal/attachments"
+	"expense-tracker/internal/backup"
 	"expense-tracker/internal/database"
 	"expense-tracker/internal/handlers"
 	"expense-tracker/internal/middleware"
+	"expense-tracker/internal/repository"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	db, err := database.New("./expenses.db")
+	dbPath := flag.String("db", "./expenses.db", "SQLite database path or postgres:// DSN")
+
+	dbOpts := database.DefaultOptions()
+	flag.StringVar(&dbOpts.JournalMode, "journal-mode", dbOpts.JournalMode, "SQLite journal mode")
+	flag.DurationVar(&dbOpts.BusyTimeout, "busy-timeout", dbOpts.BusyTimeout, "how long SQLite waits on a locked database")
+	flag.StringVar(&dbOpts.Synchronous, "synchronous", dbOpts.Synchronous, "SQLite synchronous level (OFF, NORMAL, FULL, EXTRA)")
+	flag.BoolVar(&dbOpts.ForeignKeys, "foreign-keys", dbOpts.ForeignKeys, "enforce SQLite foreign keys")
+	flag.BoolVar(&dbOpts.ImmediateTransactions, "immediate-tx", dbOpts.ImmediateTransactions, "start SQLite transactions with BEGIN IMMEDIATE")
+	flag.IntVar(&dbOpts.MaxOpenConns, "max-open-conns", dbOpts.MaxOpenConns, "maximum open database connections (0 = unlimited)")
+	flag.IntVar(&dbOpts.MaxIdleConns, "max-idle-conns", dbOpts.MaxIdleConns, "maximum idle database connections")
+	flag.DurationVar(&dbOpts.ConnMaxLifetime, "conn-max-lifetime", dbOpts.ConnMaxLifetime, "maximum lifetime of a database connection (0 = forever)")
+	flag.DurationVar(&dbOpts.ReadTimeout, "read-timeout", dbOpts.ReadTimeout, "time limit for a database lookup or list (0 = none)")
+	flag.DurationVar(&dbOpts.WriteTimeout, "write-timeout", dbOpts.WriteTimeout, "time limit for a single database change (0 = none)")
+	flag.DurationVar(&dbOpts.ReportTimeout, "report-timeout", dbOpts.ReportTimeout, "time limit for a stats or report query (0 = none)")
+	flag.DurationVar(&dbOpts.BulkTimeout, "bulk-timeout", dbOpts.BulkTimeout, "time limit for an import or other bulk change (0 = none)")
+
+	backupDir := flag.String("backup-dir", "./backups", "directory for database snapshots")
+	backupKeep := flag.Int("backup-keep", 7, "number of snapshots to keep (0 = keep all)")
+	backupInterval := flag.Duration("backup-interval", 0, "take a snapshot this often, e.g. 24h (0 = disabled)")
+	attachmentsDir := flag.String("attachments-dir", "./attachments", "directory for expense attachments")
+	attachmentMaxSize := flag.Int64("attachment-max-size", 10<<20, "largest attachment accepted, in bytes")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "purge deleted expenses after this long (0 = keep forever)")
+	flag.Parse()
+
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			if err := runMigrate(*dbPath, dbOpts, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		case "rates":
+			if err := runRates(*dbPath, dbOpts, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		case "backup":
+			if err := runBackup(*dbPath, dbOpts, *backupDir, *backupKeep, *attachmentsDir); err != nil {
+				log.Fatal(err)
+			}
+		case "check":
+			if err := runCheck(*dbPath, dbOpts, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		case "restore":
+			if err := runRestore(*dbPath, *attachmentsDir, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatalf("unknown command %q", args[0])
+		}
+		os.Exit(0)
+	}
+
+	db, err := database.New(*dbPath, dbOpts)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	h := handlers.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backups := backup.NewManager(db, *backupDir, *backupKeep, *attachmentsDir)
+	if *backupInterval > 0 {
+		go backups.Run(ctx, *backupInterval)
+		log.Printf("Scheduled backups every %s into %s (keeping %d)", *backupInterval, *backupDir, *backupKeep)
+	}
+
+	files := attachments.NewManager(db, *attachmentsDir, *attachmentMaxSize)
+	sweepAttachments(ctx, files)
+
+	if *trashRetention > 0 {
+		go runTrashPurger(ctx, repository.NewExpenseRepository(db), files, *trashRetention)
+	}
+
+	h := handlers.New(db, handlers.Options{Backups: backups, Attachments: files})
 	csrfStore := middleware.NewCSRFTokenStore()
 	r := mux.NewRouter()
 
@@ -29,21 +104,86 @@ func main() {
 	api := r.PathPrefix("/api").Subrouter()
 	api.Use(middleware.CSRFMiddleware(csrfStore))
 	
+	api.HandleFunc("/expenses/trash", h.GetTrash).Methods("GET")
+	api.HandleFunc("/expenses/trash", h.EmptyTrash).Methods("DELETE")
+	api.HandleFunc("/expenses/trash/{id}/restore", h.RestoreExpense).Methods("POST")
+	api.HandleFunc("/expenses/trash/{id}", h.PurgeExpense).Methods("DELETE")
+	api.HandleFunc("/expenses/tags", h.TagExpenses).Methods("POST")
+	api.HandleFunc("/expenses/bulk-update", h.BulkUpdateExpenses).Methods("POST")
+	api.HandleFunc("/expenses/bulk-delete", h.BulkDeleteExpenses).Methods("POST")
 	api.HandleFunc("/expenses", h.GetExpenses).Methods("GET")
 	api.HandleFunc("/expenses", h.CreateExpense).Methods("POST")
 	api.HandleFunc("/expenses/{id}", h.UpdateExpense).Methods("PUT")
 	api.HandleFunc("/expenses/{id}", h.DeleteExpense).Methods("DELETE")
+	api.HandleFunc("/expenses/{id}/history", h.GetExpenseHistory).Methods("GET")
+	api.HandleFunc("/expenses/{id}/revert", h.RevertExpense).Methods("POST")
+	api.HandleFunc("/expenses/{id}/attachments", h.GetAttachments).Methods("GET")
+	api.HandleFunc("/expenses/{id}/attachments", h.UploadAttachment).Methods("POST")
+	api.HandleFunc("/attachments/{id}", h.DownloadAttachment).Methods("GET")
+	api.HandleFunc("/attachments/{id}", h.DeleteAttachment).Methods("DELETE")
 	api.HandleFunc("/expenses/stats", h.GetStats).Methods("GET")
 	api.HandleFunc("/expenses/monthly-stats", h.GetMonthlyStats).Methods("GET")
+	api.HandleFunc("/expenses/cash-flow", h.GetCashFlow).Methods("GET")
+	// After the fixed /expenses/... paths, which {id} would otherwise match
+	api.HandleFunc("/expenses/{id}", h.GetExpense).Methods("GET")
 	api.HandleFunc("/import/csv", h.ImportFromCSV).Methods("POST")
 	api.HandleFunc("/import/confirm", h.ConfirmImport).Methods("POST")
 	
 	// Category rules routes
 	api.HandleFunc("/categorization-rules", h.GetCategoryRules).Methods("GET")
 	api.HandleFunc("/categorization-rules", h.CreateCategoryRule).Methods("POST")
+	api.HandleFunc("/categorization-rules/apply", h.ApplyCategoryRules).Methods("POST")
 	api.HandleFunc("/categorization-rules/{id}", h.UpdateCategoryRule).Methods("PUT")
 	api.HandleFunc("/categorization-rules/{id}", h.DeleteCategoryRule).Methods("DELETE")
 	api.HandleFunc("/categories", h.GetCategories).Methods("GET")
+	api.HandleFunc("/categories", h.CreateCategory).Methods("POST")
+	api.HandleFunc("/categories/{id}", h.GetCategory).Methods("GET")
+	api.HandleFunc("/categories/{id}", h.UpdateCategory).Methods("PUT")
+	api.HandleFunc("/categories/{id}", h.DeleteCategory).Methods("DELETE")
+	api.HandleFunc("/tags", h.GetTags).Methods("GET")
+	api.HandleFunc("/accounts", h.GetAccounts).Methods("GET")
+	api.HandleFunc("/accounts", h.CreateAccount).Methods("POST")
+	api.HandleFunc("/accounts/{id}", h.GetAccount).Methods("GET")
+	api.HandleFunc("/accounts/{id}", h.UpdateAccount).Methods("PUT")
+	api.HandleFunc("/accounts/{id}", h.DeleteAccount).Methods("DELETE")
+	api.HandleFunc("/accounts/{id}/register", h.GetAccountRegister).Methods("GET")
+	api.HandleFunc("/accounts/{id}/reconcile", h.ReconcileAccount).Methods("POST")
+	api.HandleFunc("/custom-fields", h.GetCustomFields).Methods("GET")
+	api.HandleFunc("/custom-fields", h.CreateCustomField).Methods("POST")
+	api.HandleFunc("/custom-fields/{id}", h.GetCustomField).Methods("GET")
+	api.HandleFunc("/custom-fields/{id}", h.UpdateCustomField).Methods("PUT")
+	api.HandleFunc("/custom-fields/{id}", h.DeleteCustomField).Methods("DELETE")
+	api.HandleFunc("/merchants", h.GetMerchants).Methods("GET")
+	api.HandleFunc("/merchants", h.CreateMerchant).Methods("POST")
+	api.HandleFunc("/merchants/reprocess", h.ReprocessMerchants).Methods("POST")
+	api.HandleFunc("/merchants/{id}", h.GetMerchant).Methods("GET")
+	api.HandleFunc("/merchants/{id}", h.UpdateMerchant).Methods("PUT")
+	api.HandleFunc("/merchants/{id}", h.DeleteMerchant).Methods("DELETE")
+	api.HandleFunc("/merchants/{id}/merge", h.MergeMerchant).Methods("POST")
+	api.HandleFunc("/claims", h.GetClaims).Methods("GET")
+	api.HandleFunc("/claims", h.CreateClaim).Methods("POST")
+	api.HandleFunc("/claims/{id}", h.GetClaim).Methods("GET")
+	api.HandleFunc("/claims/{id}", h.UpdateClaim).Methods("PUT")
+	api.HandleFunc("/claims/{id}", h.DeleteClaim).Methods("DELETE")
+	api.HandleFunc("/claims/{id}/expenses", h.AddClaimExpenses).Methods("POST")
+	api.HandleFunc("/claims/{id}/expenses", h.RemoveClaimExpenses).Methods("DELETE")
+	api.HandleFunc("/claims/{id}/status", h.SetClaimStatus).Methods("POST")
+	api.HandleFunc("/claims/{id}/export", h.ExportClaim).Methods("GET")
+
+	// Currency routes
+	api.HandleFunc("/settings", h.GetSettings).Methods("GET")
+	api.HandleFunc("/settings", h.UpdateSettings).Methods("PUT")
+	api.HandleFunc("/exchange-rates", h.GetExchangeRates).Methods("GET")
+	api.HandleFunc("/exchange-rates/import", h.ImportExchangeRates).Methods("POST")
+
+	// Admin routes
+	api.HandleFunc("/admin/diagnostics", h.GetDiagnostics).Methods("GET")
+	api.HandleFunc("/admin/integrity", h.CheckIntegrity).Methods("GET")
+	api.HandleFunc("/admin/integrity/fix", h.FixIntegrity).Methods("POST")
+	api.HandleFunc("/admin/backups", h.ListBackups).Methods("GET")
+	api.HandleFunc("/admin/backups", h.CreateBackup).Methods("POST")
+	api.HandleFunc("/admin/backups/{name}", h.DownloadBackup).Methods("GET")
+	api.HandleFunc("/admin/backups/{name}/attachments", h.DownloadBackupAttachments).Methods("GET")
 
 	// Static files (no CSRF protection needed)
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./web/static/"))))
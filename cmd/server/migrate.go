@@ -0,0 +1,106 @@
+// cmd/server/migrate.go
+package main
+
+import (
+	"expense-tracker/internal/database"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = `usage: server [-db path] migrate <command>
+
+commands:
+  status      list migrations and whether they are applied
+  up          apply all pending migrations
+  down        roll back the most recent migration
+  to VERSION  migrate up or down to VERSION (0 removes everything)`
+
+// runMigrate handles the "migrate" subcommand so schema changes can be
+// applied or rolled back without starting the HTTP server.
+func runMigrate(dbPath string, opts database.Options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+
+	db, err := database.Open(dbPath, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(db)
+	case "up":
+		if err := db.Migrate(); err != nil {
+			return err
+		}
+	case "down":
+		current, err := db.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		if current == 0 {
+			fmt.Println("Nothing to roll back")
+			return nil
+		}
+		if err := db.MigrateTo(previousVersion(current)); err != nil {
+			return err
+		}
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("%s", migrateUsage)
+		}
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := db.MigrateTo(target); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema is at version %d (latest %d)\n", version, database.LatestVersion())
+	return nil
+}
+
+func printMigrationStatus(db *database.DB) error {
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		state := "pending"
+		if s.Applied {
+			state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(os.Stdout, "%4d  %-40s %s\n", s.Version, s.Name, state)
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > database.LatestVersion() {
+		fmt.Printf("Database is at version %d, which is newer than this binary\n", version)
+	}
+	return nil
+}
+
+func previousVersion(current int) int {
+	previous := 0
+	for _, m := range database.Migrations() {
+		if m.Version < current {
+			previous = m.Version
+		}
+	}
+	return previous
+}
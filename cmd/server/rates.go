@@ -0,0 +1,52 @@
+// cmd/server/rates.go
+package main
+
+import (
+	"context"
+	"expense-tracker/internal/currency"
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/repository"
+	"fmt"
+	"os"
+)
+
+const ratesUsage = `usage: server [-db path] rates import FILE [BASE]
+
+Loads a wide exchange-rate CSV (Date column followed by one column per
+currency), such as the ECB eurofxref-hist.csv. BASE defaults to EUR.`
+
+func runRates(dbPath string, opts database.Options, args []string) error {
+	if len(args) < 2 || args[0] != "import" {
+		return fmt.Errorf("%s", ratesUsage)
+	}
+
+	base := "EUR"
+	if len(args) > 2 {
+		base = args[2]
+	}
+
+	file, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rates, err := currency.ParseRatesCSV(file, base)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New(dbPath, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := repository.NewExchangeRateRepository(db).Upsert(context.Background(), rates)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Loaded %d exchange rates quoted against %s\n", count, base)
+	return nil
+}
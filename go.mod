@@ -6,5 +6,6 @@ toolchain go1.24.5
 
 require (
 	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.12.3
 	github.com/mattn/go-sqlite3 v1.14.19
 )
@@ -0,0 +1,267 @@
+// Package attachments stores files such as receipts with expenses. Files are
+// kept on disk under the SHA-256 of their content, so the same file attached
+// twice is stored once; the database only records which expense it is on.
+package attachments
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+)
+
+var (
+	ErrTooLarge        = errors.New("attachment is too large")
+	ErrEmpty           = errors.New("attachment is empty")
+	ErrUnsupportedType = errors.New("attachment type is not supported")
+)
+
+// AllowedTypes are the content types accepted for upload. The type is sniffed
+// from the file itself, not taken from the client.
+var AllowedTypes = []string{"application/pdf", "image/gif", "image/jpeg", "image/png", "image/webp"}
+
+const (
+	// Uploads are written here first and renamed into place once hashed
+	uploadPrefix = ".upload-"
+	// Unfinished uploads older than this are left over from a crash
+	staleUpload = time.Hour
+
+	maxFilenameLength = 255
+)
+
+// Manager keeps attachment files in Dir and their records in the database.
+type Manager struct {
+	repo    repository.AttachmentRepository
+	dir     string
+	maxSize int64
+
+	// Serialises recording a file with removing unreferenced ones, so a
+	// file cannot be swept while an upload of the same content is recorded
+	mutex sync.Mutex
+}
+
+func NewManager(db *database.DB, dir string, maxSize int64) *Manager {
+	return &Manager{repo: repository.NewAttachmentRepository(db), dir: dir, maxSize: maxSize}
+}
+
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+func (m *Manager) MaxSize() int64 {
+	return m.maxSize
+}
+
+// List returns the attachments of a live expense.
+func (m *Manager) List(ctx context.Context, expenseID int) ([]models.Attachment, error) {
+	return m.repo.GetByExpense(ctx, expenseID)
+}
+
+func (m *Manager) Get(ctx context.Context, id int) (*models.Attachment, error) {
+	return m.repo.GetByID(ctx, id)
+}
+
+// Path is where the content of an attachment is stored.
+func (m *Manager) Path(a *models.Attachment) string {
+	return filePath(m.dir, a.SHA256)
+}
+
+// Add stores the content read from r and attaches it to an expense. The
+// upload is rejected if it is empty, larger than the size limit or not one of
+// AllowedTypes.
+func (m *Manager) Add(ctx context.Context, expenseID int, filename string, r io.Reader) (*models.Attachment, error) {
+	buffered := bufio.NewReaderSize(r, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(head) == 0 {
+		return nil, ErrEmpty
+	}
+	contentType := detectType(head)
+	if contentType == "" {
+		return nil, fmt.Errorf("%w: %s (allowed: %s)", ErrUnsupportedType,
+			http.DetectContentType(head), strings.Join(AllowedTypes, ", "))
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(m.dir, uploadPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(buffered, m.maxSize+1))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size > m.maxSize {
+		return nil, fmt.Errorf("%w: the limit is %d bytes", ErrTooLarge, m.maxSize)
+	}
+
+	attachment := &models.Attachment{
+		ExpenseID:   expenseID,
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	final := filePath(m.dir, attachment.SHA256)
+	if _, err := os.Stat(final); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(tmp.Name(), final); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := m.repo.Create(ctx, attachment); err != nil {
+		m.removeUnreferenced(context.Background(), attachment.SHA256)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Delete removes an attachment, and its file unless another attachment has
+// the same content.
+func (m *Manager) Delete(ctx context.Context, id int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	attachment, err := m.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := m.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	return m.removeUnreferenced(ctx, attachment.SHA256)
+}
+
+// Sweep removes files no attachment refers to any more, such as those of
+// purged expenses, and uploads abandoned by a crash. It returns how many
+// files were removed.
+func (m *Manager) Sweep(ctx context.Context) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	referenced, err := m.repo.Referenced(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	err = filepath.WalkDir(m.dir, func(path string, entry os.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == m.dir {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		name := entry.Name()
+		if strings.HasPrefix(name, uploadPrefix) {
+			info, err := entry.Info()
+			if err != nil || time.Since(info.ModTime()) < staleUpload {
+				return err
+			}
+		} else if !isDigest(name) || referenced[name] {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// removeUnreferenced deletes the file with the given digest if nothing refers
+// to it. The caller must hold the mutex.
+func (m *Manager) removeUnreferenced(ctx context.Context, digest string) error {
+	inUse, err := m.repo.IsReferenced(ctx, digest)
+	if err != nil || inUse {
+		return err
+	}
+	if err := os.Remove(filePath(m.dir, digest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// filePath shards files by the first two hex digits of their digest to keep
+// directories small.
+func filePath(dir, digest string) string {
+	return filepath.Join(dir, digest[:2], digest)
+}
+
+func isDigest(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// detectType returns the sniffed type if it is allowed, and "" otherwise.
+func detectType(head []byte) string {
+	detected, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	for _, allowed := range AllowedTypes {
+		if detected == allowed {
+			return allowed
+		}
+	}
+	return ""
+}
+
+// cleanFilename keeps only the base name of an uploaded file, which is used
+// for display and downloads and never as a path.
+func cleanFilename(name string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, `\`, "/")))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
@@ -0,0 +1,277 @@
+// Package backup takes rotating online snapshots of the SQLite database and
+// restores them after checking they are usable.
+package backup
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"expense-tracker/internal/database"
+)
+
+const (
+	snapshotPrefix = "expenses-"
+	snapshotSuffix = ".db"
+	// A snapshot's attachment files are kept in a directory beside it
+	attachmentsSuffix = ".attachments"
+	// Sortable and safe on every filesystem. Microseconds keep two
+	// snapshots taken in the same second apart.
+	timestampLayout = "20060102-150405.000000"
+	// Snapshots written before microseconds were added to the name
+	legacyTimestampLayout = "20060102-150405"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"-"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// AttachmentsPath is the directory holding the snapshot's attachment
+	// files, or "" if it has none
+	AttachmentsPath string `json:"-"`
+	Attachments     int    `json:"attachments"`
+	AttachmentsSize int64  `json:"attachments_size"`
+}
+
+// Manager writes snapshots into Dir and keeps at most Keep of them. Each
+// snapshot also gets a copy of the attachment files in attachmentsDir.
+type Manager struct {
+	db             *database.DB
+	dir            string
+	keep           int
+	attachmentsDir string
+
+	// Serialises snapshots so a manual backup cannot race the scheduler
+	mutex sync.Mutex
+}
+
+func NewManager(db *database.DB, dir string, keep int, attachmentsDir string) *Manager {
+	return &Manager{db: db, dir: dir, keep: keep, attachmentsDir: attachmentsDir}
+}
+
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Create takes a snapshot and then prunes old ones. Cancelling ctx stops the
+// database copy.
+func (m *Manager) Create(ctx context.Context) (*Snapshot, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// Step past any snapshot already holding this instant, such as one
+	// restored from elsewhere, rather than fail
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	name := snapshotName(now)
+	final := filepath.Join(m.dir, name)
+	for {
+		if _, err := os.Stat(final); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		now = now.Add(time.Microsecond)
+		name = snapshotName(now)
+		final = filepath.Join(m.dir, name)
+	}
+
+	// Write under temporary names so List never sees a partial snapshot
+	tmp := final + ".tmp"
+	tmpAttachments := attachmentsPath(final) + ".tmp"
+	cleanup := func() {
+		os.Remove(tmp)
+		os.RemoveAll(tmpAttachments)
+	}
+	cleanup()
+
+	// Attachment files are immutable and only removed once nothing refers
+	// to them, so copying them both before and after the database covers
+	// every file the database copy can refer to
+	if err := mirrorFiles(m.attachmentsDir, tmpAttachments); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := m.db.BackupTo(ctx, tmp); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := mirrorFiles(m.attachmentsDir, tmpAttachments); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if _, err := os.Stat(tmpAttachments); err == nil {
+		if err := os.Rename(tmpAttachments, attachmentsPath(final)); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		cleanup()
+		os.RemoveAll(attachmentsPath(final))
+		return nil, err
+	}
+
+	snapshot, err := m.load(name, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.rotate(); err != nil {
+		log.Printf("Backup: failed to prune old snapshots: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// List returns snapshots newest first.
+func (m *Manager) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(m.dir)
+	if os.IsNotExist(err) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		createdAt, ok := parseSnapshotName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		snapshot, err := m.load(entry.Name(), createdAt)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// Get looks a snapshot up by file name, rejecting anything outside Dir.
+func (m *Manager) Get(name string) (*Snapshot, error) {
+	if _, ok := parseSnapshotName(name); !ok || filepath.Base(name) != name {
+		return nil, ErrSnapshotNotFound
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.Name == name {
+			return &s, nil
+		}
+	}
+	return nil, ErrSnapshotNotFound
+}
+
+// Run takes a snapshot every interval until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			snapshot, err := m.Create(ctx)
+			if err != nil {
+				log.Printf("Backup: scheduled snapshot failed: %v", err)
+				continue
+			}
+			log.Printf("Backup: wrote %s (%d bytes)", snapshot.Name, snapshot.Size)
+		}
+	}
+}
+
+func (m *Manager) rotate() error {
+	if m.keep <= 0 {
+		return nil
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots[min(m.keep, len(snapshots)):] {
+		if err := os.Remove(s.Path); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(attachmentsPath(s.Path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load describes the snapshot called name in Dir.
+func (m *Manager) load(name string, createdAt time.Time) (*Snapshot, error) {
+	path := filepath.Join(m.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{Name: name, Path: path, Size: info.Size(), CreatedAt: createdAt}
+
+	files := attachmentsPath(path)
+	err = filepath.WalkDir(files, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		snapshot.AttachmentsPath = files
+		snapshot.Attachments++
+		snapshot.AttachmentsSize += info.Size()
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// attachmentsPath is the directory beside a snapshot holding its attachment
+// files.
+func attachmentsPath(snapshotPath string) string {
+	return strings.TrimSuffix(snapshotPath, snapshotSuffix) + attachmentsSuffix
+}
+
+func snapshotName(t time.Time) string {
+	return snapshotPrefix + t.Format(timestampLayout) + snapshotSuffix
+}
+
+func parseSnapshotName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+		return time.Time{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix)
+	for _, layout := range []string{timestampLayout, legacyTimestampLayout} {
+		if t, err := time.Parse(layout, stamp); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
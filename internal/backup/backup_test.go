@@ -0,0 +1,341 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"expense-tracker/internal/attachments"
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+)
+
+func openDB(t *testing.T, path string) *database.DB {
+	t.Helper()
+	db, err := database.New(path, database.DefaultOptions())
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func addExpense(t *testing.T, db *database.DB, description string) models.Expense {
+	t.Helper()
+	expense := models.Expense{
+		Date:          time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Category:      "Other",
+		Description:   description,
+		Amount:        1250,
+		Vendor:        "Vendor",
+		PaymentMethod: "Card",
+	}
+	if err := repository.NewExpenseRepository(db).Create(t.Context(), &expense); err != nil {
+		t.Fatalf("Create(%q): %v", description, err)
+	}
+	return expense
+}
+
+func countExpenses(t *testing.T, path string) int {
+	t.Helper()
+	db := openDB(t, path)
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM expenses").Scan(&count); err != nil {
+		t.Fatalf("count expenses: %v", err)
+	}
+	return count
+}
+
+func TestCreateKeepsNewest(t *testing.T) {
+	db := openDB(t, filepath.Join(t.TempDir(), "expenses.db"))
+	manager := NewManager(db, t.TempDir(), 2, "")
+
+	var created []string
+	for range 4 {
+		snapshot, err := manager.Create(t.Context())
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		created = append(created, snapshot.Name)
+	}
+
+	snapshots, err := manager.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("kept %d snapshots, want 2", len(snapshots))
+	}
+	// Newest first, and the older two pruned from disk
+	if snapshots[0].Name != created[3] || snapshots[1].Name != created[2] {
+		t.Errorf("kept %s and %s, want %s and %s", snapshots[0].Name, snapshots[1].Name, created[3], created[2])
+	}
+	for _, name := range created[:2] {
+		if _, err := os.Stat(filepath.Join(manager.Dir(), name)); !os.IsNotExist(err) {
+			t.Errorf("pruned snapshot %s still on disk: %v", name, err)
+		}
+	}
+}
+
+func TestCreateStopsWhenCancelled(t *testing.T) {
+	db := openDB(t, filepath.Join(t.TempDir(), "expenses.db"))
+	addExpense(t, db, "Lunch")
+	manager := NewManager(db, t.TempDir(), 0, "")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := manager.Create(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Create with a cancelled context = %v, want context.Canceled", err)
+	}
+
+	// Neither a snapshot nor a temporary file is left behind
+	entries, err := os.ReadDir(manager.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("cancelled Create left %d files, first %s", len(entries), entries[0].Name())
+	}
+}
+
+func TestParseSnapshotName(t *testing.T) {
+	tests := []struct {
+		name string
+		want time.Time
+		ok   bool
+	}{
+		{"expenses-20240301-101112.123456.db", time.Date(2024, 3, 1, 10, 11, 12, 123456000, time.UTC), true},
+		{"expenses-20240301-101112.db", time.Date(2024, 3, 1, 10, 11, 12, 0, time.UTC), true},
+		{"expenses-20240301.db", time.Time{}, false},
+		{"expenses-20240301-101112.123456.db.tmp", time.Time{}, false},
+		{"other-20240301-101112.db", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseSnapshotName(tt.name)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseSnapshotName(%q) = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	db := openDB(t, filepath.Join(dir, "expenses.db"))
+	addExpense(t, db, "Lunch")
+
+	good := filepath.Join(dir, "good.db")
+	if err := db.BackupTo(t.Context(), good); err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+	if err := Verify(good); err != nil {
+		t.Errorf("Verify(good) = %v", err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.db")
+	if err := os.WriteFile(corrupt, []byte("this is not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(corrupt); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Verify(corrupt) = %v, want ErrInvalidSnapshot", err)
+	}
+
+	newer := filepath.Join(dir, "newer.db")
+	if err := db.BackupTo(t.Context(), newer); err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+	raw, err := sql.Open("sqlite3", newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = raw.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')", database.LatestVersion()+1)
+	raw.Close()
+	if err != nil {
+		t.Fatalf("insert future version: %v", err)
+	}
+	if err := Verify(newer); !errors.Is(err, ErrInvalidSnapshot) || !errors.Is(err, database.ErrSchemaTooNew) {
+		t.Errorf("Verify(newer) = %v, want ErrInvalidSnapshot and ErrSchemaTooNew", err)
+	}
+
+	if err := Verify(filepath.Join(dir, "missing.db")); !os.IsNotExist(err) {
+		t.Errorf("Verify(missing) = %v, want not exist", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "expenses.db")
+
+	source := openDB(t, filepath.Join(dir, "source.db"))
+	addExpense(t, source, "Lunch")
+	addExpense(t, source, "Dinner")
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if err := source.BackupTo(t.Context(), snapshot); err != nil {
+		t.Fatalf("BackupTo: %v", err)
+	}
+
+	live, err := database.New(dbPath, database.DefaultOptions())
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	addExpense(t, live, "Coffee")
+	live.Close()
+
+	// Stand-ins for the side files of a server that did not shut down
+	// cleanly; restoring must not leave them to be replayed
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.WriteFile(dbPath+suffix, []byte(suffix), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kept, err := Restore(snapshot, dbPath, "")
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if filepath.Dir(kept) != dir || !strings.HasPrefix(filepath.Base(kept), "expenses.db.pre-restore-") {
+		t.Errorf("kept = %q", kept)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if _, err := os.Stat(dbPath + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s left beside the restored database: %v", suffix, err)
+		}
+		content, err := os.ReadFile(kept + suffix)
+		if err != nil || string(content) != suffix {
+			t.Errorf("kept %s = %q, %v", suffix, content, err)
+		}
+	}
+
+	// Drop the stand-ins so the kept database opens cleanly
+	for _, suffix := range []string{"-wal", "-shm"} {
+		os.Remove(kept + suffix)
+	}
+	if got := countExpenses(t, kept); got != 1 {
+		t.Errorf("kept database has %d expenses, want 1", got)
+	}
+	if got := countExpenses(t, dbPath); got != 2 {
+		t.Errorf("restored database has %d expenses, want 2", got)
+	}
+}
+
+func TestRestoreRejectsInvalidSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "expenses.db")
+	live, err := database.New(dbPath, database.DefaultOptions())
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	live.Close()
+
+	corrupt := filepath.Join(dir, "corrupt.db")
+	if err := os.WriteFile(corrupt, []byte("this is not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(corrupt, dbPath, ""); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("Restore(corrupt) = %v, want ErrInvalidSnapshot", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), "pre-restore") || strings.Contains(entry.Name(), "restore-tmp") {
+			t.Errorf("failed restore left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestBackupAndRestoreAttachments(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// crossDevice makes every hard link fail as it does between
+		// filesystems, so files must be copied
+		crossDevice bool
+	}{
+		{"hard links", false},
+		{"across filesystems", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.crossDevice {
+				linkFile = func(oldname, newname string) error {
+					return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+				}
+				t.Cleanup(func() { linkFile = os.Link })
+			}
+
+			dir := t.TempDir()
+			dbPath := filepath.Join(dir, "expenses.db")
+			attachmentsDir := filepath.Join(dir, "attachments")
+			db := openDB(t, dbPath)
+			files := attachments.NewManager(db, attachmentsDir, 1<<20)
+
+			expense := addExpense(t, db, "Lunch")
+			receipt := "%PDF-1.4\nreceipt\n"
+			attachment, err := files.Add(t.Context(), expense.ID, "lunch.pdf", strings.NewReader(receipt))
+			if err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			stored := files.Path(attachment)
+
+			snapshot, err := NewManager(db, filepath.Join(dir, "backups"), 0, attachmentsDir).Create(t.Context())
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if snapshot.Attachments != 1 || snapshot.AttachmentsSize != int64(len(receipt)) {
+				t.Errorf("snapshot has %d attachments of %d bytes, want 1 of %d",
+					snapshot.Attachments, snapshot.AttachmentsSize, len(receipt))
+			}
+			db.Close()
+
+			// Lose the live files, as after moving to a new machine
+			if err := os.RemoveAll(attachmentsDir); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Restore(snapshot.Path, dbPath, attachmentsDir); err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+
+			content, err := os.ReadFile(stored)
+			if err != nil || string(content) != receipt {
+				t.Fatalf("restored attachment = %q, %v; want %q", content, err, receipt)
+			}
+			restored, err := os.Stat(stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept, err := os.Stat(filepath.Join(snapshot.AttachmentsPath, mustRel(t, attachmentsDir, stored)))
+			if err != nil {
+				t.Fatalf("snapshot copy: %v", err)
+			}
+			if linked := os.SameFile(restored, kept); linked == tt.crossDevice {
+				t.Errorf("restored file shares the snapshot's inode = %v", linked)
+			}
+
+			reopened := openDB(t, dbPath)
+			restoredFiles := attachments.NewManager(reopened, attachmentsDir, 1<<20)
+			list, err := restoredFiles.List(t.Context(), expense.ID)
+			if err != nil || len(list) != 1 || list[0].SHA256 != attachment.SHA256 {
+				t.Fatalf("attachments after restore = %+v, %v", list, err)
+			}
+			if _, err := os.Stat(restoredFiles.Path(&list[0])); err != nil {
+				t.Errorf("attachment file after restore: %v", err)
+			}
+		})
+	}
+}
+
+func mustRel(t *testing.T, base, path string) string {
+	t.Helper()
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
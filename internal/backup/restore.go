@@ -0,0 +1,182 @@
+// internal/backup/restore.go
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"expense-tracker/internal/database"
+)
+
+var ErrInvalidSnapshot = errors.New("snapshot failed verification")
+
+// Verify opens a snapshot read-only and checks that it is an intact expense
+// database this binary can run against.
+func Verify(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: integrity_check reported %q", ErrInvalidSnapshot, result)
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return fmt.Errorf("%w: no schema_migrations table: %v", ErrInvalidSnapshot, err)
+	}
+	if int(version.Int64) > database.LatestVersion() {
+		return fmt.Errorf("%w: %w (snapshot is at version %d, binary supports up to %d)",
+			ErrInvalidSnapshot, database.ErrSchemaTooNew, version.Int64, database.LatestVersion())
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM expenses").Scan(&count); err != nil {
+		return fmt.Errorf("%w: cannot read expenses: %v", ErrInvalidSnapshot, err)
+	}
+
+	return nil
+}
+
+// Restore verifies snapshot and swaps it in as the database at dbPath. The
+// server must not be running. The replaced database, together with any WAL
+// and shared-memory files, is kept next to it with a ".pre-restore-<time>"
+// suffix; it returns the path of the kept database.
+//
+// The snapshot's attachment files are added to attachmentsDir. Files already
+// there are kept, and those the restored database no longer refers to are
+// removed by the server's next sweep.
+func Restore(snapshot, dbPath, attachmentsDir string) (string, error) {
+	if database.DialectFor(dbPath) != database.SQLite {
+		return "", database.ErrBackupUnsupported
+	}
+	if err := Verify(snapshot); err != nil {
+		return "", err
+	}
+	if attachmentsDir != "" {
+		if err := mirrorFiles(attachmentsPath(snapshot), attachmentsDir); err != nil {
+			return "", err
+		}
+	}
+
+	// Stage the copy beside the target so the final rename is atomic
+	staged := dbPath + ".restore-tmp"
+	if err := copyFile(snapshot, staged); err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+	if err := Verify(staged); err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+
+	stamp := time.Now().UTC().Format(timestampLayout)
+	kept := dbPath + ".pre-restore-" + stamp
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, kept); err != nil {
+			os.Remove(staged)
+			return "", err
+		}
+	} else {
+		kept = ""
+	}
+
+	// A leftover WAL would be replayed into the restored file, so move the
+	// sidecar files away with the old database
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		sidecar := dbPath + suffix
+		if _, err := os.Stat(sidecar); err != nil {
+			continue
+		}
+		target := kept + suffix
+		if kept == "" {
+			target = sidecar + ".pre-restore-" + stamp
+		}
+		if err := os.Rename(sidecar, target); err != nil {
+			os.Remove(staged)
+			return "", err
+		}
+	}
+
+	if err := os.Rename(staged, dbPath); err != nil {
+		return "", err
+	}
+	return kept, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// linkFile is os.Link, swapped in tests to take the copy fallback used when
+// the two directories are on different filesystems.
+var linkFile = os.Link
+
+// mirrorFiles adds the files under src to dst with the same relative paths,
+// hard-linking where possible. Files already in dst are left alone, as are
+// hidden files such as unfinished uploads. A missing src is not an error.
+func mirrorFiles(src, dst string) error {
+	err := filepath.WalkDir(src, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := linkFile(path, target); err == nil {
+			return nil
+		}
+		if err := copyFile(path, target); err != nil {
+			os.Remove(target)
+			return err
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
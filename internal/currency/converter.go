@@ -0,0 +1,86 @@
+// internal/currency/converter.go
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"expense-tracker/internal/models"
+)
+
+var ErrNoRate = errors.New("no exchange rate available")
+
+// Converter converts amounts between currencies using the most recent rate
+// published on or before the transaction date.
+type Converter struct {
+	// base currency -> quoted currency -> rates sorted by date
+	rates map[string]map[string][]models.ExchangeRate
+}
+
+func NewConverter(rates []models.ExchangeRate) *Converter {
+	c := &Converter{rates: make(map[string]map[string][]models.ExchangeRate)}
+	for _, rate := range rates {
+		if c.rates[rate.BaseCurrency] == nil {
+			c.rates[rate.BaseCurrency] = make(map[string][]models.ExchangeRate)
+		}
+		c.rates[rate.BaseCurrency][rate.Currency] = append(c.rates[rate.BaseCurrency][rate.Currency], rate)
+	}
+
+	for _, quotes := range c.rates {
+		for _, series := range quotes {
+			sort.Slice(series, func(i, j int) bool {
+				return series[i].Date.Before(series[j].Date)
+			})
+		}
+	}
+
+	return c
+}
+
+// Convert returns amount expressed in the target currency, rounded to the
+// nearest cent.
+func (c *Converter) Convert(amount models.Money, from, to string, on time.Time) (models.Money, error) {
+	if from == to || amount == 0 {
+		return amount, nil
+	}
+
+	// Any published base works as long as it quotes both sides
+	bases := make([]string, 0, len(c.rates))
+	for base := range c.rates {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	for _, base := range bases {
+		fromRate, ok := c.rate(base, from, on)
+		if !ok {
+			continue
+		}
+		toRate, ok := c.rate(base, to, on)
+		if !ok {
+			continue
+		}
+		return models.Money(math.Round(float64(amount) / fromRate * toRate)), nil
+	}
+
+	return 0, fmt.Errorf("%w: %s to %s on %s", ErrNoRate, from, to, on.Format("2006-01-02"))
+}
+
+func (c *Converter) rate(base, currency string, on time.Time) (float64, bool) {
+	if base == currency {
+		return 1, true
+	}
+
+	series := c.rates[base][currency]
+	// First rate strictly after the date; the one before it applies
+	i := sort.Search(len(series), func(i int) bool {
+		return series[i].Date.After(on)
+	})
+	if i == 0 || series[i-1].Rate <= 0 {
+		return 0, false
+	}
+	return series[i-1].Rate, true
+}
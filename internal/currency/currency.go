@@ -0,0 +1,96 @@
+// internal/currency/currency.go
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidCode = errors.New("invalid currency code")
+
+// symbols maps currency markers seen in bank exports to ISO 4217 codes. A
+// bare "$" is deliberately absent: it is ambiguous and falls back to the
+// import's default currency.
+var symbols = []struct {
+	symbol string
+	code   string
+}{
+	// Longer markers first so "S$" wins over "$"
+	{"US$", "USD"},
+	{"S$", "SGD"},
+	{"A$", "AUD"},
+	{"C$", "CAD"},
+	{"NZ$", "NZD"},
+	{"HK$", "HKD"},
+	{"NT$", "TWD"},
+	{"RM", "MYR"},
+	{"Rp", "IDR"},
+	{"€", "EUR"},
+	{"£", "GBP"},
+	{"¥", "JPY"},
+	{"₩", "KRW"},
+	{"฿", "THB"},
+	{"₹", "INR"},
+	{"₱", "PHP"},
+	{"₫", "VND"},
+}
+
+// NormalizeCode upper-cases and validates a three-letter ISO 4217 code.
+func NormalizeCode(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCode, code)
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidCode, code)
+		}
+	}
+	return code, nil
+}
+
+// SplitAmount separates a currency marker from an amount string such as
+// "S$12.30", "-€5", "12.30 EUR" or "$1,000". It returns the remaining number
+// text and the detected code, which is empty when no currency is implied.
+func SplitAmount(s string) (string, string) {
+	s = strings.TrimSpace(s)
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign = "-"
+		s = strings.TrimSpace(s[1:])
+	}
+
+	code := ""
+	for _, sym := range symbols {
+		if strings.HasPrefix(s, sym.symbol) {
+			code = sym.code
+			s = s[len(sym.symbol):]
+			break
+		}
+		if strings.HasSuffix(s, sym.symbol) {
+			code = sym.code
+			s = s[:len(s)-len(sym.symbol)]
+			break
+		}
+	}
+
+	if code == "" && len(s) > 3 {
+		if c, err := NormalizeCode(s[:3]); err == nil {
+			code = c
+			s = s[3:]
+		} else if c, err := NormalizeCode(s[len(s)-3:]); err == nil {
+			code = c
+			s = s[:len(s)-3]
+		}
+	}
+
+	s = strings.TrimSpace(strings.ReplaceAll(s, "$", ""))
+	if sign == "" && strings.HasPrefix(s, "-") {
+		sign = "-"
+		s = strings.TrimSpace(s[1:])
+	}
+
+	return sign + s, code
+}
@@ -0,0 +1,173 @@
+package currency
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"expense-tracker/internal/models"
+)
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "usd", want: "USD"},
+		{in: " Sgd ", want: "SGD"},
+		{in: "EURO", wantErr: true},
+		{in: "US", wantErr: true},
+		{in: "U5D", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeCode(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidCode) {
+				t.Errorf("NormalizeCode(%q) = %q, %v; want ErrInvalidCode", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeCode(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSplitAmount(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantNumber string
+		wantCode   string
+	}{
+		{"12.30", "12.30", ""},
+		{"$1,000", "1,000", ""},
+		{"-$5.00", "-5.00", ""},
+		{"S$12.30", "12.30", "SGD"},
+		{"US$7", "7", "USD"},
+		{"NZ$3.50", "3.50", "NZD"},
+		{"-€5", "-5", "EUR"},
+		{"€-5", "-5", "EUR"},
+		{"5 €", "5", "EUR"},
+		{"£0.99", "0.99", "GBP"},
+		{"¥1200", "1200", "JPY"},
+		{"RM 45.10", "45.10", "MYR"},
+		{"Rp15000", "15000", "IDR"},
+		{"12.30 EUR", "12.30", "EUR"},
+		{"EUR 12.30", "12.30", "EUR"},
+		{"-12.30 usd", "-12.30", "USD"},
+		{" 1000 ", "1000", ""},
+	}
+
+	for _, tt := range tests {
+		number, code := SplitAmount(tt.in)
+		if number != tt.wantNumber || code != tt.wantCode {
+			t.Errorf("SplitAmount(%q) = %q, %q; want %q, %q", tt.in, number, code, tt.wantNumber, tt.wantCode)
+		}
+	}
+}
+
+func date(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestConverterConvert(t *testing.T) {
+	converter := NewConverter([]models.ExchangeRate{
+		// Given out of order to check they are sorted by date
+		{Date: date("2024-03-15"), BaseCurrency: "EUR", Currency: "USD", Rate: 1.10},
+		{Date: date("2024-03-01"), BaseCurrency: "EUR", Currency: "USD", Rate: 1.05},
+		{Date: date("2024-03-01"), BaseCurrency: "EUR", Currency: "SGD", Rate: 1.45},
+		{Date: date("2024-03-15"), BaseCurrency: "EUR", Currency: "SGD", Rate: 1.50},
+		{Date: date("2024-03-01"), BaseCurrency: "EUR", Currency: "JPY", Rate: 160},
+	})
+
+	tests := []struct {
+		name     string
+		amount   models.Money
+		from, to string
+		on       string
+		want     models.Money
+		wantErr  error
+	}{
+		{name: "same currency", amount: 1234, from: "USD", to: "USD", on: "2020-01-01", want: 1234},
+		{name: "zero needs no rate", amount: 0, from: "USD", to: "CHF", on: "2024-03-10", want: 0},
+		{name: "from base", amount: 10000, from: "EUR", to: "USD", on: "2024-03-15", want: 11000},
+		{name: "to base", amount: 11000, from: "USD", to: "EUR", on: "2024-03-20", want: 10000},
+		{name: "nearest earlier date", amount: 10000, from: "EUR", to: "USD", on: "2024-03-14", want: 10500},
+		{name: "rate on the day", amount: 10000, from: "EUR", to: "USD", on: "2024-03-01", want: 10500},
+		{name: "between quoted currencies", amount: 10500, from: "USD", to: "SGD", on: "2024-03-10", want: 14500},
+		{name: "between quoted currencies later", amount: 11000, from: "USD", to: "JPY", on: "2024-04-01", want: 1600000},
+		{name: "rounded to the cent", amount: 100, from: "SGD", to: "USD", on: "2024-03-15", want: 73},
+		{name: "before the first rate", amount: 100, from: "EUR", to: "USD", on: "2024-02-29", wantErr: ErrNoRate},
+		{name: "unknown currency", amount: 100, from: "EUR", to: "CHF", on: "2024-03-15", wantErr: ErrNoRate},
+		{name: "one side missing on the date", amount: 100, from: "USD", to: "JPY", on: "2024-02-01", wantErr: ErrNoRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := converter.Convert(tt.amount, tt.from, tt.to, date(tt.on))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Convert = %d, %v; want %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Convert = %d, %v; want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRatesCSV(t *testing.T) {
+	input := "Date,USD,JPY,\n" +
+		"2024-03-15,1.0890,161.50,\n" +
+		"2024-03-14, 1.0950 ,N/A,\n" +
+		"\n" +
+		"2024-03-13,,162.10,\n"
+
+	rates, err := ParseRatesCSV(strings.NewReader(input), "eur")
+	if err != nil {
+		t.Fatalf("ParseRatesCSV: %v", err)
+	}
+	want := []models.ExchangeRate{
+		{Date: date("2024-03-15"), BaseCurrency: "EUR", Currency: "USD", Rate: 1.0890},
+		{Date: date("2024-03-15"), BaseCurrency: "EUR", Currency: "JPY", Rate: 161.50},
+		{Date: date("2024-03-14"), BaseCurrency: "EUR", Currency: "USD", Rate: 1.0950},
+		{Date: date("2024-03-13"), BaseCurrency: "EUR", Currency: "JPY", Rate: 162.10},
+	}
+	if len(rates) != len(want) {
+		t.Fatalf("got %d rates, want %d: %+v", len(rates), len(want), rates)
+	}
+	for i := range want {
+		if !rates[i].Date.Equal(want[i].Date) || rates[i].BaseCurrency != want[i].BaseCurrency ||
+			rates[i].Currency != want[i].Currency || rates[i].Rate != want[i].Rate {
+			t.Errorf("rate %d = %+v, want %+v", i, rates[i], want[i])
+		}
+	}
+
+	invalid := []struct {
+		name  string
+		input string
+		base  string
+	}{
+		{"bad base", "Date,USD\n", "EURO"},
+		{"no date column", "Day,USD\n2024-03-15,1.1\n", "EUR"},
+		{"bad currency column", "Date,Dollars\n", "EUR"},
+		{"bad date", "Date,USD\n15/03/2024,1.1\n", "EUR"},
+		{"bad rate", "Date,USD\n2024-03-15,abc\n", "EUR"},
+		{"zero rate", "Date,USD\n2024-03-15,0\n", "EUR"},
+	}
+	for _, tt := range invalid {
+		if _, err := ParseRatesCSV(strings.NewReader(tt.input), tt.base); err == nil {
+			t.Errorf("%s: ParseRatesCSV accepted %q", tt.name, tt.input)
+		}
+	}
+}
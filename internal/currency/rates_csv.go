@@ -0,0 +1,93 @@
+// internal/currency/rates_csv.go
+package currency
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"expense-tracker/internal/models"
+)
+
+// ParseRatesCSV reads a wide rate table with one row per date and one column
+// per currency, quoted against base. This is the layout of the ECB historical
+// reference rates file (eurofxref-hist.csv), whose base is EUR:
+//
+//	Date,USD,JPY,...
+//	2024-03-15,1.0890,161.50,...
+//
+// Blank and "N/A" cells are skipped.
+func ParseRatesCSV(r io.Reader, base string) ([]models.ExchangeRate, error) {
+	base, err := NormalizeCode(base)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header row: %v", err)
+	}
+	if len(header) < 2 || !strings.EqualFold(strings.TrimSpace(header[0]), "date") {
+		return nil, fmt.Errorf("first column must be Date")
+	}
+
+	codes := make([]string, len(header))
+	for i, col := range header[1:] {
+		col = strings.TrimSpace(col)
+		if col == "" {
+			continue
+		}
+		code, err := NormalizeCode(col)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %v", i+2, err)
+		}
+		codes[i+1] = code
+	}
+
+	var rates []models.ExchangeRate
+	lineNum := 1
+	for {
+		lineNum++
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", lineNum, record[0])
+		}
+
+		for i := 1; i < len(record) && i < len(codes); i++ {
+			value := strings.TrimSpace(record[i])
+			if codes[i] == "" || value == "" || strings.EqualFold(value, "N/A") {
+				continue
+			}
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate <= 0 {
+				return nil, fmt.Errorf("line %d: invalid %s rate %q", lineNum, codes[i], value)
+			}
+			rates = append(rates, models.ExchangeRate{
+				Date:         date,
+				BaseCurrency: base,
+				Currency:     codes[i],
+				Rate:         rate,
+			})
+		}
+	}
+
+	return rates, nil
+}
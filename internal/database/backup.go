@@ -0,0 +1,28 @@
+// internal/database/backup.go
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+var ErrBackupUnsupported = errors.New("online backup is only supported for SQLite; use pg_dump for PostgreSQL")
+
+// BackupTo writes a transactionally consistent copy of the live database to
+// path using VACUUM INTO. The copy is compacted and safe to take while the
+// server is serving requests. path must not exist yet. The copy runs under
+// ctx with the bulk timeout; one cut short may leave a partial file at path.
+func (db *DB) BackupTo(ctx context.Context, path string) error {
+	if db.dialect != SQLite {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return os.ErrExist
+	}
+
+	q, cancel := db.WithContext(ctx, OpBulk)
+	defer cancel()
+	_, err := q.Exec("VACUUM INTO ?", path)
+	return err
+}
@@ -2,41 +2,304 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// DB wraps *sql.DB so that queries written with "?" placeholders run on
+// every supported backend.
 type DB struct {
 	*sql.DB
+	dialect Dialect
+	options Options
+
+	fullTextSearch bool
 }
 
-func New(dataSourceName string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dataSourceName)
+// Open connects to the database without touching the schema. Use New for the
+// normal startup path. The backend is chosen from the DSN, see DialectFor.
+func Open(dataSourceName string, opts Options) (*DB, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	dialect := DialectFor(dataSourceName)
+	if dialect == SQLite {
+		dataSourceName = opts.sqliteDSN(dataSourceName)
+	}
+
+	db, err := sql.Open(string(dialect), dataSourceName)
 	if err != nil {
 		return nil, err
 	}
 
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+
 	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{DB: db, dialect: dialect, options: opts}, nil
+}
+
+func New(dataSourceName string, opts Options) (*DB, error) {
+	db, err := Open(dataSourceName, opts)
+	if err != nil {
 		return nil, err
 	}
 
 	// Run migrations
-	if _, err := db.Exec(createTablesSQL); err != nil {
+	if err := db.Migrate(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	// Seed initial categorization rules only if table is empty
 	var count int
 	if err := db.QueryRow("SELECT COUNT(*) FROM categorization_rules").Scan(&count); err != nil {
+		db.Close()
 		return nil, err
 	}
 	
 	if count == 0 {
 		if _, err := db.Exec(seedCategoryRulesSQL); err != nil {
+			db.Close()
 			return nil, err
 		}
 	}
 
-	return &DB{db}, nil
+	if err := db.setupSearch(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+func (db *DB) Options() Options {
+	return db.options
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.Rebind(query), args...)
+}
+
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.dialect.Rebind(query))
+}
+
+// Begin starts a transaction with no deadline.
+//
+// Deprecated: Use BeginTx so the transaction ends with the request and is
+// cut short by its operation's timeout.
+func (db *DB) Begin() (*Tx, error) {
+	return db.begin()
+}
+
+// begin starts a transaction for startup work such as migrations, which
+// runs before any request and must not be cut short.
+func (db *DB) begin() (*Tx, error) {
+	return db.beginTx(context.Background(), func() {})
+}
+
+// BeginTx starts a transaction whose statements run under ctx, cut short
+// by the timeout for op. The timeout is released when the transaction is
+// committed or rolled back.
+func (db *DB) BeginTx(ctx context.Context, op Operation) (*Tx, error) {
+	ctx, cancel := db.WithTimeout(ctx, op)
+	tx, err := db.beginTx(ctx, cancel)
+	if err != nil {
+		cancel()
+	}
+	return tx, err
+}
+
+func (db *DB) beginTx(ctx context.Context, cancel context.CancelFunc) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, classify(ctx, err)
+	}
+	return &Tx{Tx: tx, dialect: db.dialect, ctx: ctx, cancel: cancel}, nil
+}
+
+// WithContext returns a Querier that runs its statements under ctx, cut
+// short by the timeout for op. Call cancel once done with it.
+func (db *DB) WithContext(ctx context.Context, op Operation) (Querier, context.CancelFunc) {
+	ctx, cancel := db.WithTimeout(ctx, op)
+	return &boundDB{db: db, ctx: ctx}, cancel
+}
+
+// WithTimeout derives a context that expires after the configured timeout
+// for op. Operations without a timeout only end with ctx.
+func (db *DB) WithTimeout(ctx context.Context, op Operation) (context.Context, context.CancelFunc) {
+	if timeout := db.options.Timeout(op); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// ErrTimeout wraps the error of a statement that ran past its operation's
+// timeout, so callers can tell it from other failures.
+var ErrTimeout = errors.New("database operation timed out")
+
+// classify marks errors caused by ctx's deadline as ErrTimeout.
+func classify(ctx context.Context, err error) error {
+	if err == nil || err == sql.ErrNoRows || errors.Is(err, ErrTimeout) {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
+// Querier is the query surface shared by transactions and contexts bound
+// with WithContext, so helpers can run either inside or outside a
+// transaction.
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*Rows, error)
+	QueryRow(query string, args ...interface{}) *Row
+	ExecReturningID(query string, args ...interface{}) (int64, error)
+}
+
+// Rows and Row report errors the way Querier does, with timeouts as
+// ErrTimeout.
+type Rows struct {
+	*sql.Rows
+	ctx context.Context
+}
+
+func (r *Rows) Scan(dest ...interface{}) error {
+	return classify(r.ctx, r.Rows.Scan(dest...))
+}
+
+func (r *Rows) Err() error {
+	return classify(r.ctx, r.Rows.Err())
+}
+
+type Row struct {
+	*sql.Row
+	ctx context.Context
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	return classify(r.ctx, r.Row.Scan(dest...))
+}
+
+func (r *Row) Err() error {
+	return classify(r.ctx, r.Row.Err())
+}
+
+type boundDB struct {
+	db  *DB
+	ctx context.Context
+}
+
+func (b *boundDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := b.db.DB.ExecContext(b.ctx, b.db.dialect.Rebind(query), args...)
+	return result, classify(b.ctx, err)
+}
+
+func (b *boundDB) Query(query string, args ...interface{}) (*Rows, error) {
+	rows, err := b.db.DB.QueryContext(b.ctx, b.db.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, classify(b.ctx, err)
+	}
+	return &Rows{Rows: rows, ctx: b.ctx}, nil
+}
+
+func (b *boundDB) QueryRow(query string, args ...interface{}) *Row {
+	return &Row{Row: b.db.DB.QueryRowContext(b.ctx, b.db.dialect.Rebind(query), args...), ctx: b.ctx}
+}
+
+func (b *boundDB) ExecReturningID(query string, args ...interface{}) (int64, error) {
+	id, err := execReturningID(b.ctx, b.db.dialect, b.db.DB, query, args...)
+	return id, classify(b.ctx, err)
+}
+
+// Tx is the transaction counterpart of DB. Its statements run under the
+// context it was started with.
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func (tx *Tx) Commit() error {
+	defer tx.cancel()
+	return classify(tx.ctx, tx.Tx.Commit())
+}
+
+func (tx *Tx) Rollback() error {
+	defer tx.cancel()
+	return tx.Tx.Rollback()
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := tx.Tx.ExecContext(tx.ctx, tx.dialect.Rebind(query), args...)
+	return result, classify(tx.ctx, err)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*Rows, error) {
+	rows, err := tx.Tx.QueryContext(tx.ctx, tx.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, classify(tx.ctx, err)
+	}
+	return &Rows{Rows: rows, ctx: tx.ctx}, nil
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *Row {
+	return &Row{Row: tx.Tx.QueryRowContext(tx.ctx, tx.dialect.Rebind(query), args...), ctx: tx.ctx}
+}
+
+func (tx *Tx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.PrepareContext(tx.ctx, tx.dialect.Rebind(query))
+}
+
+func (tx *Tx) ExecReturningID(query string, args ...interface{}) (int64, error) {
+	id, err := execReturningID(tx.ctx, tx.dialect, tx.Tx, query, args...)
+	return id, classify(tx.ctx, err)
+}
+
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func execReturningID(ctx context.Context, dialect Dialect, q execQueryer, query string, args ...interface{}) (int64, error) {
+	if dialect == Postgres {
+		query = strings.TrimRight(strings.TrimSpace(query), ";") + " RETURNING id"
+		var id int64
+		err := q.QueryRowContext(ctx, dialect.Rebind(query), args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := q.ExecContext(ctx, dialect.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
 }
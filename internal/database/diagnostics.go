@@ -0,0 +1,113 @@
+// internal/database/diagnostics.go
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Diagnostics reports how the database is configured and what the backend
+// actually applied, so tuning problems show up without a shell on the host.
+type Diagnostics struct {
+	Backend             string            `json:"backend"`
+	ServerVersion       string            `json:"server_version"`
+	SchemaVersion       int               `json:"schema_version"`
+	LatestSchemaVersion int               `json:"latest_schema_version"`
+	FullTextSearch      bool              `json:"full_text_search"`
+	Configured          map[string]string `json:"configured"`
+	Effective           map[string]string `json:"effective"`
+	Pool                PoolStats         `json:"pool"`
+}
+
+type PoolStats struct {
+	MaxOpenConnections int    `json:"max_open_connections"`
+	OpenConnections    int    `json:"open_connections"`
+	InUse              int    `json:"in_use"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"wait_count"`
+	WaitDuration       string `json:"wait_duration"`
+	MaxIdleClosed      int64  `json:"max_idle_closed"`
+	MaxLifetimeClosed  int64  `json:"max_lifetime_closed"`
+}
+
+var synchronousNames = map[string]string{"0": "OFF", "1": "NORMAL", "2": "FULL", "3": "EXTRA"}
+
+// Diagnostics runs its queries under ctx with the report timeout.
+func (db *DB) Diagnostics(ctx context.Context) (*Diagnostics, error) {
+	q, cancel := db.WithContext(ctx, OpReport)
+	defer cancel()
+
+
+	d := &Diagnostics{
+		Backend:             string(db.dialect),
+		LatestSchemaVersion: LatestVersion(),
+		FullTextSearch:      db.fullTextSearch,
+		Configured: map[string]string{
+			"max_open_conns":    fmt.Sprint(db.options.MaxOpenConns),
+			"max_idle_conns":    fmt.Sprint(db.options.MaxIdleConns),
+			"conn_max_lifetime": db.options.ConnMaxLifetime.String(),
+			"read_timeout":      db.options.ReadTimeout.String(),
+			"write_timeout":     db.options.WriteTimeout.String(),
+			"report_timeout":    db.options.ReportTimeout.String(),
+			"bulk_timeout":      db.options.BulkTimeout.String(),
+		},
+		Effective: map[string]string{},
+	}
+
+	var version sql.NullInt64
+	if err := q.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return nil, err
+	}
+	d.SchemaVersion = int(version.Int64)
+
+	if db.dialect == Postgres {
+		if err := q.QueryRow("SHOW server_version").Scan(&d.ServerVersion); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := q.QueryRow("SELECT sqlite_version()").Scan(&d.ServerVersion); err != nil {
+			return nil, err
+		}
+
+		d.Configured["journal_mode"] = db.options.JournalMode
+		d.Configured["busy_timeout_ms"] = fmt.Sprint(db.options.BusyTimeout.Milliseconds())
+		d.Configured["synchronous"] = db.options.Synchronous
+		d.Configured["foreign_keys"] = fmt.Sprint(db.options.ForeignKeys)
+		d.Configured["immediate_transactions"] = fmt.Sprint(db.options.ImmediateTransactions)
+
+		// PRAGMAs are per connection, but every connection is opened from
+		// the same DSN, so any one of them is representative
+		for _, pragma := range []string{"journal_mode", "busy_timeout", "synchronous", "foreign_keys"} {
+			var value string
+			if err := q.QueryRow("PRAGMA " + pragma).Scan(&value); err != nil {
+				return nil, err
+			}
+			switch pragma {
+			case "busy_timeout":
+				pragma = "busy_timeout_ms"
+			case "synchronous":
+				if name, ok := synchronousNames[value]; ok {
+					value = name
+				}
+			case "foreign_keys":
+				value = fmt.Sprint(value == "1")
+			}
+			d.Effective[pragma] = value
+		}
+	}
+
+	stats := db.Stats()
+	d.Pool = PoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration.String(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+
+	return d, nil
+}
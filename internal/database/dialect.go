@@ -0,0 +1,75 @@
+// internal/database/dialect.go
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect captures the SQL differences between the supported backends.
+// Queries are written with "?" placeholders and SQLite-compatible syntax
+// wherever both backends agree; Rebind and the expression helpers cover the
+// rest.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+// DialectFor picks the backend from a data source name. PostgreSQL URLs and
+// key/value DSNs select Postgres; anything else is treated as a SQLite path.
+func DialectFor(dataSourceName string) Dialect {
+	dsn := strings.TrimSpace(dataSourceName)
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres
+	}
+	if strings.Contains(dsn, "host=") && strings.Contains(dsn, "dbname=") {
+		return Postgres
+	}
+	return SQLite
+}
+
+// Rebind rewrites "?" placeholders to the dialect's native form, leaving
+// quoted literals untouched.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	inQuote := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+			b.WriteByte(c)
+		case c == '?' && !inQuote:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// MonthExpr formats a date column as YYYY-MM.
+func (d Dialect) MonthExpr(column string) string {
+	if d == Postgres {
+		return "to_char(" + column + ", 'YYYY-MM')"
+	}
+	return "strftime('%Y-%m', " + column + ")"
+}
+
+// DayExpr formats a date column as YYYY-MM-DD.
+func (d Dialect) DayExpr(column string) string {
+	if d == Postgres {
+		return "to_char(" + column + ", 'YYYY-MM-DD')"
+	}
+	return "date(" + column + ")"
+}
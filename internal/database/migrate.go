@@ -0,0 +1,214 @@
+// internal/database/migrate.go
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrSchemaTooNew    = errors.New("database schema is newer than this binary")
+	ErrUnknownVersion  = errors.New("unknown schema version")
+	ErrMissingDownStep = errors.New("migration has no down step")
+)
+
+const createSchemaMigrationsSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// MigrationStatus describes whether a known migration has been applied.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+func (db *DB) ensureMigrationsTable() error {
+	_, err := db.Exec(createSchemaMigrationsSQL)
+	return err
+}
+
+// SchemaVersion returns the highest applied migration version, or 0 for an
+// empty database.
+func (db *DB) SchemaVersion() (int, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// CheckSchemaVersion refuses databases migrated by a newer binary, since
+// running against an unknown schema risks silently corrupting data.
+func (db *DB) CheckSchemaVersion() error {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestVersion() {
+		return fmt.Errorf("%w: database is at version %d, binary supports up to %d",
+			ErrSchemaTooNew, current, LatestVersion())
+	}
+	return nil
+}
+
+// Migrate applies all pending migrations.
+func (db *DB) Migrate() error {
+	return db.MigrateTo(LatestVersion())
+}
+
+// MigrateTo moves the schema up or down to the target version, running each
+// step in its own transaction.
+func (db *DB) MigrateTo(target int) error {
+	if target < 0 || (target > 0 && findMigration(target) == nil) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	if err := db.CheckSchemaVersion(); err != nil {
+		return err
+	}
+
+	applied, err := db.appliedVersions()
+	if err != nil {
+		return err
+	}
+
+	// Apply pending migrations in ascending order
+	for _, m := range migrations {
+		if m.Version > target || applied[m.Version] {
+			continue
+		}
+		if err := db.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	// Roll back anything above the target in descending order
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target || !applied[m.Version] {
+			continue
+		}
+		if err := db.revertMigration(m); err != nil {
+			return fmt.Errorf("rollback of migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrationStatus lists every known migration with its applied state.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := appliedAt[m.Version]; ok {
+			at := at
+			status.Applied = true
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (db *DB) appliedVersions() (map[int]bool, error) {
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (db *DB) applyMigration(m Migration) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.UpSQL(db.dialect)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) revertMigration(m Migration) error {
+	down := m.DownSQL(db.dialect)
+	if down == "" {
+		return ErrMissingDownStep
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(down); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func findMigration(version int) *Migration {
+	for i := range migrations {
+		if migrations[i].Version == version {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// openUnmigrated opens an empty SQLite database without running migrations.
+func openUnmigrated(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "expenses.db"), DefaultOptions())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func mustMigrateTo(t *testing.T, db *DB, target int) {
+	t.Helper()
+	if err := db.MigrateTo(target); err != nil {
+		t.Fatalf("MigrateTo(%d): %v", target, err)
+	}
+	if version, err := db.SchemaVersion(); err != nil || version != target {
+		t.Fatalf("SchemaVersion after MigrateTo(%d) = %d, %v", target, version, err)
+	}
+}
+
+// schema lists every table, index and trigger with its columns, so two
+// databases built along different paths can be compared.
+func schema(t *testing.T, db *DB) map[string][]string {
+	t.Helper()
+	rows, err := db.Query("SELECT type, name FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	objects := make(map[string]string)
+	for rows.Next() {
+		var kind, name string
+		if err := rows.Scan(&kind, &name); err != nil {
+			t.Fatal(err)
+		}
+		objects[name] = kind
+	}
+	rows.Close()
+
+	result := make(map[string][]string)
+	for name, kind := range objects {
+		pragma := "PRAGMA table_info(" + name + ")"
+		if kind == "index" {
+			pragma = "PRAGMA index_info(" + name + ")"
+		}
+		result[name] = append(result[name], kind)
+		if kind == "trigger" {
+			continue
+		}
+		info, err := db.Query(pragma)
+		if err != nil {
+			t.Fatalf("%s: %v", pragma, err)
+		}
+		columns, _ := info.Columns()
+		for info.Next() {
+			values := make([]sql.NullString, len(columns))
+			dest := make([]interface{}, len(columns))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+			if err := info.Scan(dest...); err != nil {
+				t.Fatal(err)
+			}
+			column := ""
+			for _, v := range values {
+				column += v.String + "|"
+			}
+			result[name] = append(result[name], column)
+		}
+		info.Close()
+	}
+	return result
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	fresh := openUnmigrated(t)
+	mustMigrateTo(t, fresh, LatestVersion())
+	want := schema(t, fresh)
+
+	db := openUnmigrated(t)
+	mustMigrateTo(t, db, 2)
+	if _, err := db.Exec(`
+        INSERT INTO expenses (date, category, description, amount_cents, vendor, payment_method)
+        VALUES ('2024-03-01', 'Transportation', 'Ride', 1234, 'Grab', 'Card')
+    `); err != nil {
+		t.Fatalf("insert at version 2: %v", err)
+	}
+
+	checkExpense := func(stage string) {
+		t.Helper()
+		var description string
+		var cents int64
+		if err := db.QueryRow("SELECT description, amount_cents FROM expenses").Scan(&description, &cents); err != nil {
+			t.Fatalf("%s: read expense: %v", stage, err)
+		}
+		if description != "Ride" || cents != 1234 {
+			t.Errorf("%s: expense = %q, %d", stage, description, cents)
+		}
+	}
+
+	mustMigrateTo(t, db, LatestVersion())
+	checkExpense("after migrating up")
+	if got := schema(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema after migrating up differs from a fresh database:\n got %v\nwant %v", got, want)
+	}
+
+	// Every down step followed by its up step leaves the same schema, and
+	// the expense survives as far down as its table does
+	mustMigrateTo(t, db, 2)
+	checkExpense("after migrating down to 2")
+	mustMigrateTo(t, db, LatestVersion())
+	checkExpense("after migrating up again")
+	if got := schema(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema after a round trip differs from a fresh database:\n got %v\nwant %v", got, want)
+	}
+
+	mustMigrateTo(t, db, 0)
+	if got := schema(t, db); len(got) != 1 || got["schema_migrations"] == nil {
+		t.Errorf("tables left at version 0: %v", got)
+	}
+	mustMigrateTo(t, db, LatestVersion())
+	if got := schema(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema rebuilt from 0 differs from a fresh database:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestMigrateToPartialTarget(t *testing.T) {
+	db := openUnmigrated(t)
+	mustMigrateTo(t, db, 5)
+
+	hasTable := func(name string) bool {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count > 0
+	}
+	if !hasTable("expense_history") || hasTable("categories") {
+		t.Errorf("at version 5: expense_history %v, categories %v", hasTable("expense_history"), hasTable("categories"))
+	}
+
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	if len(statuses) != LatestVersion() {
+		t.Fatalf("MigrationStatus lists %d migrations, want %d", len(statuses), LatestVersion())
+	}
+	for _, status := range statuses {
+		if status.Applied != (status.Version <= 5) || status.Applied != (status.AppliedAt != nil) {
+			t.Errorf("status of %d (%s): applied %v at %v", status.Version, status.Name, status.Applied, status.AppliedAt)
+		}
+	}
+
+	mustMigrateTo(t, db, 3)
+	if hasTable("expense_history") || !hasTable("settings") {
+		t.Errorf("at version 3: expense_history %v, settings %v", hasTable("expense_history"), hasTable("settings"))
+	}
+}
+
+func TestMigrateToUnknownVersion(t *testing.T) {
+	db := openUnmigrated(t)
+	mustMigrateTo(t, db, 4)
+
+	for _, target := range []int{-1, LatestVersion() + 1} {
+		if err := db.MigrateTo(target); !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("MigrateTo(%d) = %v, want ErrUnknownVersion", target, err)
+		}
+	}
+	if version, err := db.SchemaVersion(); err != nil || version != 4 {
+		t.Errorf("SchemaVersion after refused targets = %d, %v; want 4", version, err)
+	}
+}
+
+func TestNewRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expenses.db")
+	db, err := New(path, DefaultOptions())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')", LatestVersion()+1); err != nil {
+		t.Fatalf("insert future version: %v", err)
+	}
+	if err := db.MigrateTo(LatestVersion()); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("MigrateTo = %v, want ErrSchemaTooNew", err)
+	}
+	db.Close()
+
+	if db, err := New(path, DefaultOptions()); !errors.Is(err, ErrSchemaTooNew) {
+		if db != nil {
+			db.Close()
+		}
+		t.Fatalf("New on a newer schema = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestNewAdoptsBaselineDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expenses.db")
+
+	// A database as written before versioned migrations: the baseline
+	// tables, amounts as REAL and rules naming their category
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		createTablesSQL,
+		`INSERT INTO expenses (date, category, description, amount, vendor, payment_method)
+         VALUES ('2024-03-01', 'Transportation', 'Ride', 12.34, 'Grab', 'Card'),
+                ('2024-03-02', 'Food & Dining', 'Lunch', 5.5, 'Cafe', 'Cash')`,
+		`INSERT INTO categorization_rules (category, keyword) VALUES ('Transportation', 'TAXI')`,
+	} {
+		if _, err := legacy.Exec(stmt); err != nil {
+			legacy.Close()
+			t.Fatalf("build legacy database: %v", err)
+		}
+	}
+	legacy.Close()
+
+	db, err := New(path, DefaultOptions())
+	if err != nil {
+		t.Fatalf("New on a baseline database: %v", err)
+	}
+	defer db.Close()
+
+	if version, err := db.SchemaVersion(); err != nil || version != LatestVersion() {
+		t.Errorf("SchemaVersion = %d, %v; want %d", version, err, LatestVersion())
+	}
+
+	rows, err := db.Query(`
+        SELECT expenses.description, expenses.amount_cents, categories.name
+        FROM expenses JOIN categories ON categories.id = expenses.category_id
+        ORDER BY expenses.id
+    `)
+	if err != nil {
+		t.Fatalf("read adopted expenses: %v", err)
+	}
+	defer rows.Close()
+	type row struct {
+		description string
+		cents       int64
+		category    string
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.description, &r.cents, &r.category); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	want := []row{{"Ride", 1234, "Transportation"}, {"Lunch", 550, "Food & Dining"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("adopted expenses = %v, want %v", got, want)
+	}
+
+	// The legacy rule is kept and the defaults are not seeded over it
+	var rules int
+	var keyword string
+	if err := db.QueryRow(`
+        SELECT COUNT(*), MAX(keyword) FROM categorization_rules
+        JOIN categories ON categories.id = categorization_rules.category_id
+        WHERE categories.name = 'Transportation'
+    `).Scan(&rules, &keyword); err != nil {
+		t.Fatalf("read adopted rules: %v", err)
+	}
+	if rules != 1 || keyword != "TAXI" {
+		t.Errorf("adopted rules = %d, %q; want the one TAXI rule", rules, keyword)
+	}
+}
@@ -1,6 +1,214 @@
 // internal/database/migrations.go
 package database
 
+// Migration is a single numbered schema change. Versions must be unique and
+// increasing; once released, a migration must never be edited, only followed
+// by a new one. Up and Down are SQLite SQL; the Postgres variants are only
+// set where the dialects disagree.
+type Migration struct {
+	Version      int
+	Name         string
+	Up           string
+	Down         string
+	PostgresUp   string
+	PostgresDown string
+}
+
+// UpSQL returns the forward SQL for the given dialect.
+func (m Migration) UpSQL(d Dialect) string {
+	if d == Postgres && m.PostgresUp != "" {
+		return m.PostgresUp
+	}
+	return m.Up
+}
+
+// DownSQL returns the rollback SQL for the given dialect.
+func (m Migration) DownSQL(d Dialect) string {
+	if d == Postgres && m.PostgresDown != "" {
+		return m.PostgresDown
+	}
+	return m.Down
+}
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up:      createTablesSQL,
+		Down:    dropTablesSQL,
+
+		PostgresUp: postgresCreateTablesSQL,
+	},
+	{
+		Version: 2,
+		Name:    "amount_integer_cents",
+		Up:      amountToCentsSQL,
+		Down:    amountToDecimalSQL,
+
+		PostgresUp:   postgresAmountToCentsSQL,
+		PostgresDown: postgresAmountToDecimalSQL,
+	},
+	{
+		Version: 3,
+		Name:    "multi_currency",
+		Up:      multiCurrencySQL,
+		Down:    dropMultiCurrencySQL,
+
+		PostgresUp: postgresMultiCurrencySQL,
+	},
+	{
+		Version: 4,
+		Name:    "soft_delete",
+		Up: `
+ALTER TABLE expenses ADD COLUMN deleted_at TIMESTAMP;
+CREATE INDEX idx_expenses_deleted_at ON expenses(deleted_at);
+`,
+		Down: `
+DROP INDEX idx_expenses_deleted_at;
+ALTER TABLE expenses DROP COLUMN deleted_at;
+`,
+	},
+	{
+		Version:    5,
+		Name:       "expense_history",
+		Up:         expenseHistorySQL,
+		Down:       "DROP TABLE expense_history;",
+		PostgresUp: postgresExpenseHistorySQL,
+	},
+	{
+		Version:      6,
+		Name:         "categories",
+		Up:           categoriesSQL,
+		Down:         dropCategoriesSQL,
+		PostgresUp:   postgresCategoriesSQL,
+		PostgresDown: postgresDropCategoriesSQL,
+	},
+	{
+		Version: 7,
+		Name:    "category_hierarchy",
+		Up: `
+ALTER TABLE categories ADD COLUMN parent_id INTEGER REFERENCES categories(id);
+CREATE INDEX idx_categories_parent_id ON categories(parent_id);
+`,
+		Down: `
+DROP INDEX idx_categories_parent_id;
+ALTER TABLE categories DROP COLUMN parent_id;
+`,
+	},
+	{
+		Version:    8,
+		Name:       "tags",
+		Up:         tagsSQL,
+		Down:       "DROP TABLE expense_tags;\nDROP TABLE tags;",
+		PostgresUp: postgresTagsSQL,
+	},
+	{
+		Version:    9,
+		Name:       "expense_splits",
+		Up:         expenseSplitsSQL,
+		Down:       "DROP TABLE expense_splits;",
+		PostgresUp: postgresExpenseSplitsSQL,
+	},
+	{
+		Version: 10,
+		Name:    "accounts",
+		Up:      accountsSQL,
+		Down: `
+DROP INDEX idx_expenses_account_id;
+ALTER TABLE expenses DROP COLUMN reconciled;
+ALTER TABLE expenses DROP COLUMN account_id;
+DROP TABLE accounts;
+`,
+		PostgresUp: postgresAccountsSQL,
+	},
+	{
+		Version: 11,
+		Name:    "transaction_types",
+		Up: `
+ALTER TABLE expenses ADD COLUMN type TEXT NOT NULL DEFAULT 'expense';
+ALTER TABLE expenses ADD COLUMN transfer_account_id INTEGER REFERENCES accounts(id);
+CREATE INDEX idx_expenses_transfer_account_id ON expenses(transfer_account_id);
+`,
+		Down: `
+DROP INDEX idx_expenses_transfer_account_id;
+ALTER TABLE expenses DROP COLUMN transfer_account_id;
+ALTER TABLE expenses DROP COLUMN type;
+`,
+	},
+	{
+		Version: 12,
+		Name:    "attachments",
+		Up:      attachmentsSQL,
+		Down: `
+DROP INDEX idx_attachments_sha256;
+DROP INDEX idx_attachments_expense_id;
+DROP TABLE attachments;
+`,
+		PostgresUp: postgresAttachmentsSQL,
+	},
+	{
+		Version: 13,
+		Name:    "custom_fields",
+		Up:      customFieldsSQL,
+		Down: `
+DROP INDEX idx_expense_field_values_field_id;
+DROP TABLE expense_field_values;
+DROP INDEX idx_custom_fields_name;
+DROP TABLE custom_fields;
+`,
+		PostgresUp: postgresCustomFieldsSQL,
+	},
+	{
+		Version: 14,
+		Name:    "merchants",
+		Up:      merchantsSQL,
+		Down: `
+DROP INDEX idx_merchant_aliases_merchant_id;
+DROP INDEX idx_merchant_aliases_pattern;
+DROP TABLE merchant_aliases;
+DROP INDEX idx_merchants_name;
+DROP TABLE merchants;
+`,
+		PostgresUp: postgresMerchantsSQL,
+	},
+	{
+		Version: 15,
+		Name:    "claims",
+		Up:      claimsSQL,
+		Down: `
+DROP INDEX idx_expenses_claim_id;
+ALTER TABLE expenses DROP COLUMN claim_id;
+ALTER TABLE expenses DROP COLUMN reimbursable;
+DROP INDEX idx_claims_status;
+DROP TABLE claims;
+`,
+		PostgresUp: postgresClaimsSQL,
+	},
+	{
+		Version: 16,
+		Name:    "expense_versions",
+		Up:      "ALTER TABLE expenses ADD COLUMN version INTEGER NOT NULL DEFAULT 1;",
+		Down:    "ALTER TABLE expenses DROP COLUMN version;",
+	},
+}
+
+// LatestVersion returns the schema version this binary expects.
+func LatestVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrations returns the registered migrations in version order.
+func Migrations() []Migration {
+	result := make([]Migration, len(migrations))
+	copy(result, migrations)
+	return result
+}
+
+// createTablesSQL keeps IF NOT EXISTS so databases created before versioned
+// migrations existed are adopted as version 1 without modification.
 const createTablesSQL = `
 CREATE TABLE IF NOT EXISTS expenses (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -30,8 +238,527 @@ CREATE INDEX IF NOT EXISTS idx_categorization_rules_keyword ON categorization_ru
 CREATE UNIQUE INDEX IF NOT EXISTS idx_categorization_rules_unique ON categorization_rules(category, keyword, case_sensitive);
 `
 
+const dropTablesSQL = `
+DROP TABLE IF EXISTS categorization_rules;
+DROP TABLE IF EXISTS expenses;
+`
+
+// amountToCentsSQL rebuilds expenses so amounts are stored as integer cents.
+// SQLite kept DECIMAL(10,2) values as REAL, so each value is rounded to the
+// nearest cent once here and never touches floating point again.
+const amountToCentsSQL = `
+CREATE TABLE expenses_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    date DATE NOT NULL,
+    category TEXT NOT NULL,
+    description TEXT,
+    amount_cents INTEGER NOT NULL,
+    vendor TEXT,
+    payment_method TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO expenses_new (id, date, category, description, amount_cents, vendor, payment_method, created_at, updated_at)
+SELECT id, date, category, description, CAST(ROUND(amount * 100) AS INTEGER), vendor, payment_method, created_at, updated_at
+FROM expenses;
+
+DROP TABLE expenses;
+ALTER TABLE expenses_new RENAME TO expenses;
+
+CREATE INDEX idx_expenses_date ON expenses(date);
+CREATE INDEX idx_expenses_category ON expenses(category);
+`
+
+const amountToDecimalSQL = `
+CREATE TABLE expenses_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    date DATE NOT NULL,
+    category TEXT NOT NULL,
+    description TEXT,
+    amount DECIMAL(10,2) NOT NULL,
+    vendor TEXT,
+    payment_method TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO expenses_old (id, date, category, description, amount, vendor, payment_method, created_at, updated_at)
+SELECT id, date, category, description, amount_cents / 100.0, vendor, payment_method, created_at, updated_at
+FROM expenses;
+
+DROP TABLE expenses;
+ALTER TABLE expenses_old RENAME TO expenses;
+
+CREATE INDEX idx_expenses_date ON expenses(date);
+CREATE INDEX idx_expenses_category ON expenses(category);
+`
+
+// multiCurrencySQL tags every existing expense with the initial base
+// currency, which can be changed later through the settings API.
+const multiCurrencySQL = `
+CREATE TABLE settings (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO settings (key, value) VALUES ('base_currency', 'USD');
+
+ALTER TABLE expenses ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+CREATE INDEX idx_expenses_currency ON expenses(currency);
+
+CREATE TABLE exchange_rates (
+    date DATE NOT NULL,
+    base_currency TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    rate REAL NOT NULL,
+    PRIMARY KEY (base_currency, currency, date)
+);
+`
+
+const dropMultiCurrencySQL = `
+DROP TABLE exchange_rates;
+DROP INDEX idx_expenses_currency;
+ALTER TABLE expenses DROP COLUMN currency;
+DROP TABLE settings;
+`
+
+// expense_history has no foreign key to expenses so purged expenses keep
+// their audit trail.
+const expenseHistorySQL = `
+CREATE TABLE expense_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    expense_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    source TEXT NOT NULL,
+    before_data TEXT,
+    after_data TEXT,
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_expense_history_version ON expense_history(expense_id, version);
+`
+
+// categoriesSQL moves category names into their own table. Blank names,
+// which the old schema allowed, become "Other". Both tables that referenced
+// categories by name are rebuilt to hold category_id instead, keeping the
+// AUTOINCREMENT counter of expenses so purged ids are never reused.
+const categoriesSQL = `
+CREATE TABLE categories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    color TEXT NOT NULL DEFAULT '',
+    icon TEXT NOT NULL DEFAULT '',
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_categories_name ON categories(name);
+
+INSERT INTO categories (name)
+SELECT name FROM (
+    SELECT COALESCE(NULLIF(TRIM(category), ''), 'Other') AS name FROM expenses
+    UNION SELECT COALESCE(NULLIF(TRIM(category), ''), 'Other') FROM categorization_rules
+    UNION SELECT 'Other'
+) AS names
+ORDER BY name;
+
+CREATE TABLE expenses_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    date DATE NOT NULL,
+    category_id INTEGER NOT NULL REFERENCES categories(id),
+    description TEXT,
+    amount_cents INTEGER NOT NULL,
+    vendor TEXT,
+    payment_method TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    currency TEXT NOT NULL DEFAULT 'USD',
+    deleted_at TIMESTAMP
+);
+
+INSERT INTO expenses_new (id, date, category_id, description, amount_cents, vendor, payment_method, created_at, updated_at, currency, deleted_at)
+SELECT e.id, e.date, c.id, e.description, e.amount_cents, e.vendor, e.payment_method, e.created_at, e.updated_at, e.currency, e.deleted_at
+FROM expenses e
+JOIN categories c ON c.name = COALESCE(NULLIF(TRIM(e.category), ''), 'Other');
+
+DELETE FROM sqlite_sequence WHERE name = 'expenses_new';
+UPDATE sqlite_sequence SET name = 'expenses_new' WHERE name = 'expenses';
+DROP TABLE expenses;
+ALTER TABLE expenses_new RENAME TO expenses;
+
+CREATE INDEX idx_expenses_date ON expenses(date);
+CREATE INDEX idx_expenses_category_id ON expenses(category_id);
+CREATE INDEX idx_expenses_currency ON expenses(currency);
+CREATE INDEX idx_expenses_deleted_at ON expenses(deleted_at);
+
+CREATE TABLE categorization_rules_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    category_id INTEGER NOT NULL REFERENCES categories(id),
+    keyword TEXT NOT NULL,
+    case_sensitive BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT OR IGNORE INTO categorization_rules_new (id, category_id, keyword, case_sensitive, created_at, updated_at)
+SELECT r.id, c.id, r.keyword, r.case_sensitive, r.created_at, r.updated_at
+FROM categorization_rules r
+JOIN categories c ON c.name = COALESCE(NULLIF(TRIM(r.category), ''), 'Other')
+ORDER BY r.id;
+
+DROP TABLE categorization_rules;
+ALTER TABLE categorization_rules_new RENAME TO categorization_rules;
+
+CREATE INDEX idx_categorization_rules_category_id ON categorization_rules(category_id);
+CREATE INDEX idx_categorization_rules_keyword ON categorization_rules(keyword);
+CREATE UNIQUE INDEX idx_categorization_rules_unique ON categorization_rules(category_id, keyword, case_sensitive);
+`
+
+const dropCategoriesSQL = `
+CREATE TABLE expenses_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    date DATE NOT NULL,
+    category TEXT NOT NULL,
+    description TEXT,
+    amount_cents INTEGER NOT NULL,
+    vendor TEXT,
+    payment_method TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    currency TEXT NOT NULL DEFAULT 'USD',
+    deleted_at TIMESTAMP
+);
+
+INSERT INTO expenses_old (id, date, category, description, amount_cents, vendor, payment_method, created_at, updated_at, currency, deleted_at)
+SELECT e.id, e.date, c.name, e.description, e.amount_cents, e.vendor, e.payment_method, e.created_at, e.updated_at, e.currency, e.deleted_at
+FROM expenses e
+JOIN categories c ON c.id = e.category_id;
+
+DELETE FROM sqlite_sequence WHERE name = 'expenses_old';
+UPDATE sqlite_sequence SET name = 'expenses_old' WHERE name = 'expenses';
+DROP TABLE expenses;
+ALTER TABLE expenses_old RENAME TO expenses;
+
+CREATE INDEX idx_expenses_date ON expenses(date);
+CREATE INDEX idx_expenses_category ON expenses(category);
+CREATE INDEX idx_expenses_currency ON expenses(currency);
+CREATE INDEX idx_expenses_deleted_at ON expenses(deleted_at);
+
+CREATE TABLE categorization_rules_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    category TEXT NOT NULL,
+    keyword TEXT NOT NULL,
+    case_sensitive BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO categorization_rules_old (id, category, keyword, case_sensitive, created_at, updated_at)
+SELECT r.id, c.name, r.keyword, r.case_sensitive, r.created_at, r.updated_at
+FROM categorization_rules r
+JOIN categories c ON c.id = r.category_id;
+
+DROP TABLE categorization_rules;
+ALTER TABLE categorization_rules_old RENAME TO categorization_rules;
+
+CREATE INDEX idx_categorization_rules_category ON categorization_rules(category);
+CREATE INDEX idx_categorization_rules_keyword ON categorization_rules(keyword);
+CREATE UNIQUE INDEX idx_categorization_rules_unique ON categorization_rules(category, keyword, case_sensitive);
+
+DROP TABLE categories;
+`
+
+const tagsSQL = `
+CREATE TABLE tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_tags_name ON tags(name);
+
+CREATE TABLE expense_tags (
+    expense_id INTEGER NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (expense_id, tag_id)
+);
+
+CREATE INDEX idx_expense_tags_tag_id ON expense_tags(tag_id);
+`
+
+const expenseSplitsSQL = `
+CREATE TABLE expense_splits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    expense_id INTEGER NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    category_id INTEGER NOT NULL REFERENCES categories(id),
+    amount_cents INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_expense_splits_expense_id ON expense_splits(expense_id);
+CREATE INDEX idx_expense_splits_category_id ON expense_splits(category_id);
+`
+
+const accountsSQL = `
+CREATE TABLE accounts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    opening_balance_cents INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_accounts_name ON accounts(name);
+
+ALTER TABLE expenses ADD COLUMN account_id INTEGER REFERENCES accounts(id);
+ALTER TABLE expenses ADD COLUMN reconciled BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX idx_expenses_account_id ON expenses(account_id);
+`
+
+// attachments only records which expense a file belongs to; the content is
+// stored on disk under its SHA-256, so identical files are kept once.
+const attachmentsSQL = `
+CREATE TABLE attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    expense_id INTEGER NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    sha256 TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_attachments_expense_id ON attachments(expense_id);
+CREATE INDEX idx_attachments_sha256 ON attachments(sha256);
+`
+
+// custom_fields defines extra attributes for expenses; enum options are kept
+// as a JSON array. Values are stored as canonical text per expense.
+const customFieldsSQL = `
+CREATE TABLE custom_fields (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    label TEXT NOT NULL,
+    type TEXT NOT NULL,
+    options TEXT NOT NULL DEFAULT '[]',
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_custom_fields_name ON custom_fields(name);
+
+CREATE TABLE expense_field_values (
+    expense_id INTEGER NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+    field_id INTEGER NOT NULL REFERENCES custom_fields(id) ON DELETE CASCADE,
+    value TEXT NOT NULL,
+    PRIMARY KEY (expense_id, field_id)
+);
+
+CREATE INDEX idx_expense_field_values_field_id ON expense_field_values(field_id, value);
+`
+
+// merchants is the directory of canonical vendor names. Each alias is a
+// pattern such as "GRAB*" matched against the raw vendor from a statement.
+const merchantsSQL = `
+CREATE TABLE merchants (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_merchants_name ON merchants(name);
+
+CREATE TABLE merchant_aliases (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    merchant_id INTEGER NOT NULL REFERENCES merchants(id) ON DELETE CASCADE,
+    pattern TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_merchant_aliases_pattern ON merchant_aliases(pattern);
+CREATE INDEX idx_merchant_aliases_merchant_id ON merchant_aliases(merchant_id);
+`
+
+// claims batch reimbursable expenses to be paid back, such as by an
+// employer. An expense is on at most one claim.
+const claimsSQL = `
+CREATE TABLE claims (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'draft',
+    notes TEXT NOT NULL DEFAULT '',
+    submitted_at TIMESTAMP,
+    paid_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_claims_status ON claims(status);
+
+ALTER TABLE expenses ADD COLUMN reimbursable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE expenses ADD COLUMN claim_id INTEGER REFERENCES claims(id);
+CREATE INDEX idx_expenses_claim_id ON expenses(claim_id);
+`
+
+const postgresCreateTablesSQL = `
+CREATE TABLE IF NOT EXISTS expenses (
+    id SERIAL PRIMARY KEY,
+    date DATE NOT NULL,
+    category TEXT NOT NULL,
+    description TEXT,
+    amount NUMERIC(10,2) NOT NULL,
+    vendor TEXT,
+    payment_method TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS categorization_rules (
+    id SERIAL PRIMARY KEY,
+    category TEXT NOT NULL,
+    keyword TEXT NOT NULL,
+    case_sensitive BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_expenses_date ON expenses(date);
+CREATE INDEX IF NOT EXISTS idx_expenses_category ON expenses(category);
+CREATE INDEX IF NOT EXISTS idx_categorization_rules_category ON categorization_rules(category);
+CREATE INDEX IF NOT EXISTS idx_categorization_rules_keyword ON categorization_rules(keyword);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categorization_rules_unique ON categorization_rules(category, keyword, case_sensitive);
+`
+
+const postgresAmountToCentsSQL = `
+ALTER TABLE expenses ADD COLUMN amount_cents BIGINT;
+UPDATE expenses SET amount_cents = ROUND(amount * 100);
+ALTER TABLE expenses ALTER COLUMN amount_cents SET NOT NULL;
+ALTER TABLE expenses DROP COLUMN amount;
+`
+
+const postgresAmountToDecimalSQL = `
+ALTER TABLE expenses ADD COLUMN amount NUMERIC(10,2);
+UPDATE expenses SET amount = amount_cents / 100.0;
+ALTER TABLE expenses ALTER COLUMN amount SET NOT NULL;
+ALTER TABLE expenses DROP COLUMN amount_cents;
+`
+
+const postgresMultiCurrencySQL = `
+CREATE TABLE settings (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO settings (key, value) VALUES ('base_currency', 'USD');
+
+ALTER TABLE expenses ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+CREATE INDEX idx_expenses_currency ON expenses(currency);
+
+CREATE TABLE exchange_rates (
+    date DATE NOT NULL,
+    base_currency TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    rate DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (base_currency, currency, date)
+);
+`
+
+const postgresExpenseHistorySQL = `
+CREATE TABLE expense_history (
+    id SERIAL PRIMARY KEY,
+    expense_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    source TEXT NOT NULL,
+    before_data TEXT,
+    after_data TEXT,
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_expense_history_version ON expense_history(expense_id, version);
+`
+
+const postgresCategoriesSQL = `
+CREATE TABLE categories (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    color TEXT NOT NULL DEFAULT '',
+    icon TEXT NOT NULL DEFAULT '',
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_categories_name ON categories(name);
+
+INSERT INTO categories (name)
+SELECT name FROM (
+    SELECT COALESCE(NULLIF(TRIM(category), ''), 'Other') AS name FROM expenses
+    UNION SELECT COALESCE(NULLIF(TRIM(category), ''), 'Other') FROM categorization_rules
+    UNION SELECT 'Other'
+) AS names
+ORDER BY name;
+
+ALTER TABLE expenses ADD COLUMN category_id INTEGER REFERENCES categories(id);
+UPDATE expenses SET category_id = categories.id
+FROM categories WHERE categories.name = COALESCE(NULLIF(TRIM(expenses.category), ''), 'Other');
+ALTER TABLE expenses ALTER COLUMN category_id SET NOT NULL;
+ALTER TABLE expenses DROP COLUMN category;
+CREATE INDEX idx_expenses_category_id ON expenses(category_id);
+
+ALTER TABLE categorization_rules ADD COLUMN category_id INTEGER REFERENCES categories(id);
+UPDATE categorization_rules SET category_id = categories.id
+FROM categories WHERE categories.name = COALESCE(NULLIF(TRIM(categorization_rules.category), ''), 'Other');
+ALTER TABLE categorization_rules ALTER COLUMN category_id SET NOT NULL;
+ALTER TABLE categorization_rules DROP COLUMN category;
+
+-- Trimming can make two rules identical
+DELETE FROM categorization_rules a USING categorization_rules b
+WHERE a.id > b.id AND a.category_id = b.category_id AND a.keyword = b.keyword
+  AND a.case_sensitive IS NOT DISTINCT FROM b.case_sensitive;
+
+CREATE INDEX idx_categorization_rules_category_id ON categorization_rules(category_id);
+CREATE UNIQUE INDEX idx_categorization_rules_unique ON categorization_rules(category_id, keyword, case_sensitive);
+`
+
+const postgresDropCategoriesSQL = `
+ALTER TABLE expenses ADD COLUMN category TEXT;
+UPDATE expenses SET category = categories.name FROM categories WHERE categories.id = expenses.category_id;
+ALTER TABLE expenses ALTER COLUMN category SET NOT NULL;
+ALTER TABLE expenses DROP COLUMN category_id;
+CREATE INDEX idx_expenses_category ON expenses(category);
+
+ALTER TABLE categorization_rules ADD COLUMN category TEXT;
+UPDATE categorization_rules SET category = categories.name FROM categories WHERE categories.id = categorization_rules.category_id;
+ALTER TABLE categorization_rules ALTER COLUMN category SET NOT NULL;
+ALTER TABLE categorization_rules DROP COLUMN category_id;
+CREATE INDEX idx_categorization_rules_category ON categorization_rules(category);
+CREATE UNIQUE INDEX idx_categorization_rules_unique ON categorization_rules(category, keyword, case_sensitive);
+
+DROP TABLE categories;
+`
+
+// seedCategoryRulesSQL creates the default categories and their keyword
+// rules. VALUES columns are named column1..column3 on both backends.
 const seedCategoryRulesSQL = `
-INSERT OR IGNORE INTO categorization_rules (category, keyword, case_sensitive) VALUES
+INSERT INTO categories (name) VALUES
+('Food & Dining'), ('Food Delivery'), ('Transportation'), ('Shopping'),
+('Utilities'), ('Mobile & Telecom'), ('Healthcare'), ('Other')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO categorization_rules (category_id, keyword, case_sensitive)
+SELECT categories.id, seed.column2, seed.column3
+FROM (VALUES
 -- Transportation
 ('Transportation', 'BUS', false),
 ('Transportation', 'MRT', false),
@@ -69,5 +796,138 @@ INSERT OR IGNORE INTO categorization_rules (category, keyword, case_sensitive) V
 ('Healthcare', 'PHARMACY', false),
 ('Healthcare', 'CLINIC', false),
 ('Healthcare', 'HOSPITAL', false),
-('Healthcare', 'MEDICAL', false);
-`
\ No newline at end of file
+('Healthcare', 'MEDICAL', false)
+) AS seed
+JOIN categories ON categories.name = seed.column1
+WHERE true
+ON CONFLICT DO NOTHING;
+`
+
+const postgresTagsSQL = `
+CREATE TABLE tags (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_tags_name ON tags(name);
+
+CREATE TABLE expense_tags (
+    expense_id INTEGER NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (expense_id, tag_id)
+);
+
+CREATE INDEX idx_expense_tags_tag_id ON expense_tags(tag_id);
+`
+
+const postgresExpenseSplitsSQL = `
+CREATE TABLE expense_splits (
+    id SERIAL PRIMARY KEY,
+    expense_id INTEGER NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    category_id INTEGER NOT NULL REFERENCES categories(id),
+    amount_cents BIGINT NOT NULL,
+    note TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_expense_splits_expense_id ON expense_splits(expense_id);
+CREATE INDEX idx_expense_splits_category_id ON expense_splits(category_id);
+`
+
+const postgresAccountsSQL = `
+CREATE TABLE accounts (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    opening_balance_cents BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_accounts_name ON accounts(name);
+
+ALTER TABLE expenses ADD COLUMN account_id INTEGER REFERENCES accounts(id);
+ALTER TABLE expenses ADD COLUMN reconciled BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX idx_expenses_account_id ON expenses(account_id);
+`
+
+const postgresAttachmentsSQL = `
+CREATE TABLE attachments (
+    id SERIAL PRIMARY KEY,
+    expense_id INTEGER NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_attachments_expense_id ON attachments(expense_id);
+CREATE INDEX idx_attachments_sha256 ON attachments(sha256);
+`
+
+const postgresCustomFieldsSQL = `
+CREATE TABLE custom_fields (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    label TEXT NOT NULL,
+    type TEXT NOT NULL,
+    options TEXT NOT NULL DEFAULT '[]',
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_custom_fields_name ON custom_fields(name);
+
+CREATE TABLE expense_field_values (
+    expense_id INTEGER NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+    field_id INTEGER NOT NULL REFERENCES custom_fields(id) ON DELETE CASCADE,
+    value TEXT NOT NULL,
+    PRIMARY KEY (expense_id, field_id)
+);
+
+CREATE INDEX idx_expense_field_values_field_id ON expense_field_values(field_id, value);
+`
+
+const postgresMerchantsSQL = `
+CREATE TABLE merchants (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_merchants_name ON merchants(name);
+
+CREATE TABLE merchant_aliases (
+    id SERIAL PRIMARY KEY,
+    merchant_id INTEGER NOT NULL REFERENCES merchants(id) ON DELETE CASCADE,
+    pattern TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_merchant_aliases_pattern ON merchant_aliases(pattern);
+CREATE INDEX idx_merchant_aliases_merchant_id ON merchant_aliases(merchant_id);
+`
+
+const postgresClaimsSQL = `
+CREATE TABLE claims (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'draft',
+    notes TEXT NOT NULL DEFAULT '',
+    submitted_at TIMESTAMP,
+    paid_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_claims_status ON claims(status);
+
+ALTER TABLE expenses ADD COLUMN reimbursable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE expenses ADD COLUMN claim_id INTEGER REFERENCES claims(id);
+CREATE INDEX idx_expenses_claim_id ON expenses(claim_id);
+`
@@ -0,0 +1,165 @@
+// internal/database/options.go
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Options tunes the connection pool and, for SQLite, the per-connection
+// PRAGMAs. The SQLite settings are passed to the driver in the DSN so every
+// pooled connection gets them, not just the first one.
+type Options struct {
+	// JournalMode is the SQLite journal_mode. WAL lets readers run while a
+	// writer holds the lock.
+	JournalMode string
+	// BusyTimeout is how long SQLite retries a locked database before
+	// returning "database is locked".
+	BusyTimeout time.Duration
+	// Synchronous is the SQLite synchronous level (OFF, NORMAL, FULL, EXTRA).
+	Synchronous string
+	ForeignKeys bool
+	// ImmediateTransactions starts transactions with BEGIN IMMEDIATE so a
+	// writer waits for the lock up front instead of failing with
+	// "database is locked" when it upgrades from a read.
+	ImmediateTransactions bool
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// Timeouts bound how long one operation of each kind may take before
+	// it is cancelled with ErrTimeout. Zero means no limit.
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	ReportTimeout time.Duration
+	BulkTimeout   time.Duration
+}
+
+// Operation is a kind of database work with its own timeout.
+type Operation int
+
+const (
+	// OpRead looks up or lists rows
+	OpRead Operation = iota
+	// OpWrite changes a single record
+	OpWrite
+	// OpReport aggregates over many rows, like the stats endpoints
+	OpReport
+	// OpBulk imports or changes many records at once
+	OpBulk
+)
+
+// Timeout returns the configured timeout for op.
+func (o Options) Timeout(op Operation) time.Duration {
+	switch op {
+	case OpWrite:
+		return o.WriteTimeout
+	case OpReport:
+		return o.ReportTimeout
+	case OpBulk:
+		return o.BulkTimeout
+	default:
+		return o.ReadTimeout
+	}
+}
+
+var (
+	journalModes      = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	synchronousLevels = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// DefaultOptions suits a single server with concurrent readers and the
+// occasional bulk import.
+func DefaultOptions() Options {
+	return Options{
+		JournalMode:  "WAL",
+		BusyTimeout:  5 * time.Second,
+		Synchronous:  "NORMAL",
+		ForeignKeys:  true,
+		MaxOpenConns: 10,
+		MaxIdleConns: 5,
+
+		ImmediateTransactions: true,
+
+		ReadTimeout:   10 * time.Second,
+		WriteTimeout:  10 * time.Second,
+		ReportTimeout: 30 * time.Second,
+		BulkTimeout:   5 * time.Minute,
+	}
+}
+
+// Validate normalises the option values and rejects unknown modes.
+func (o *Options) Validate() error {
+	o.JournalMode = strings.ToUpper(strings.TrimSpace(o.JournalMode))
+	if o.JournalMode != "" && !contains(journalModes, o.JournalMode) {
+		return fmt.Errorf("invalid journal mode %q (want one of %s)", o.JournalMode, strings.Join(journalModes, ", "))
+	}
+
+	o.Synchronous = strings.ToUpper(strings.TrimSpace(o.Synchronous))
+	if o.Synchronous != "" && !contains(synchronousLevels, o.Synchronous) {
+		return fmt.Errorf("invalid synchronous level %q (want one of %s)", o.Synchronous, strings.Join(synchronousLevels, ", "))
+	}
+
+	if o.BusyTimeout < 0 || o.MaxOpenConns < 0 || o.MaxIdleConns < 0 || o.ConnMaxLifetime < 0 ||
+		o.ReadTimeout < 0 || o.WriteTimeout < 0 || o.ReportTimeout < 0 || o.BulkTimeout < 0 {
+		return fmt.Errorf("timeouts and connection limits must not be negative")
+	}
+	return nil
+}
+
+// sqliteDSN appends the driver parameters for these options, keeping any
+// that the caller already set explicitly in the DSN.
+func (o Options) sqliteDSN(dataSourceName string) string {
+	// The driver also takes each parameter under a short alias
+	params := []struct {
+		key   string
+		alias string
+		value string
+	}{
+		{"_journal_mode", "_journal", o.JournalMode},
+		{"_synchronous", "_sync", o.Synchronous},
+		{"_busy_timeout", "_timeout", fmt.Sprint(o.BusyTimeout.Milliseconds())},
+		{"_foreign_keys", "_fk", boolParam(o.ForeignKeys)},
+		{"_txlock", "_txlock", ""},
+	}
+	if o.ImmediateTransactions {
+		params[len(params)-1].value = "immediate"
+	}
+
+	var set url.Values
+	if _, query, ok := strings.Cut(dataSourceName, "?"); ok {
+		set, _ = url.ParseQuery(query)
+	}
+
+	dsn := dataSourceName
+	for _, p := range params {
+		if p.value == "" || set.Has(p.key) || set.Has(p.alias) {
+			continue
+		}
+		sep := "&"
+		if !strings.Contains(dsn, "?") {
+			sep = "?"
+		}
+		dsn += sep + p.key + "=" + p.value
+	}
+	return dsn
+}
+
+func boolParam(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
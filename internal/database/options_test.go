@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(o *Options)
+		wantErr string
+		check   func(t *testing.T, o Options)
+	}{
+		{
+			name:   "defaults",
+			modify: func(o *Options) {},
+		},
+		{
+			name: "modes are normalised",
+			modify: func(o *Options) {
+				o.JournalMode = " wal "
+				o.Synchronous = "full"
+			},
+			check: func(t *testing.T, o Options) {
+				if o.JournalMode != "WAL" || o.Synchronous != "FULL" {
+					t.Errorf("got journal %q, synchronous %q", o.JournalMode, o.Synchronous)
+				}
+			},
+		},
+		{
+			name: "empty modes leave the driver defaults",
+			modify: func(o *Options) {
+				o.JournalMode = ""
+				o.Synchronous = ""
+			},
+		},
+		{
+			name:    "unknown journal mode",
+			modify:  func(o *Options) { o.JournalMode = "fast" },
+			wantErr: "invalid journal mode",
+		},
+		{
+			name:    "unknown synchronous level",
+			modify:  func(o *Options) { o.Synchronous = "sometimes" },
+			wantErr: "invalid synchronous level",
+		},
+		{
+			name:    "negative busy timeout",
+			modify:  func(o *Options) { o.BusyTimeout = -time.Second },
+			wantErr: "must not be negative",
+		},
+		{
+			name:    "negative pool size",
+			modify:  func(o *Options) { o.MaxOpenConns = -1 },
+			wantErr: "must not be negative",
+		},
+		{
+			name:    "negative operation timeout",
+			modify:  func(o *Options) { o.BulkTimeout = -time.Minute },
+			wantErr: "must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := DefaultOptions()
+			tt.modify(&o)
+			err := o.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			if tt.check != nil {
+				tt.check(t, o)
+			}
+		})
+	}
+}
+
+func TestOptionsTimeout(t *testing.T) {
+	defaults := DefaultOptions()
+	tests := []struct {
+		op   Operation
+		want time.Duration
+	}{
+		{OpRead, 10 * time.Second},
+		{OpWrite, 10 * time.Second},
+		{OpReport, 30 * time.Second},
+		{OpBulk, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := defaults.Timeout(tt.op); got != tt.want {
+			t.Errorf("Timeout(%d) = %v, want %v", tt.op, got, tt.want)
+		}
+	}
+
+	custom := Options{ReadTimeout: time.Second, WriteTimeout: 2 * time.Second}
+	if got := custom.Timeout(OpWrite); got != 2*time.Second {
+		t.Errorf("custom Timeout(OpWrite) = %v", got)
+	}
+	if got := custom.Timeout(OpBulk); got != 0 {
+		t.Errorf("unset Timeout(OpBulk) = %v, want no limit", got)
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	defaults := DefaultOptions()
+	tests := []struct {
+		name string
+		opts Options
+		dsn  string
+		want string
+	}{
+		{
+			name: "defaults",
+			opts: defaults,
+			dsn:  "expenses.db",
+			want: "expenses.db?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_foreign_keys=1&_txlock=immediate",
+		},
+		{
+			name: "merged into existing parameters",
+			opts: defaults,
+			dsn:  "file:expenses.db?cache=shared",
+			want: "file:expenses.db?cache=shared&_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_foreign_keys=1&_txlock=immediate",
+		},
+		{
+			name: "caller's parameters are kept",
+			opts: defaults,
+			dsn:  "expenses.db?_journal_mode=DELETE&_busy_timeout=100",
+			want: "expenses.db?_journal_mode=DELETE&_busy_timeout=100&_synchronous=NORMAL&_foreign_keys=1&_txlock=immediate",
+		},
+		{
+			name: "caller's aliases are kept",
+			opts: defaults,
+			dsn:  "expenses.db?_fk=0&_sync=FULL&_journal=MEMORY&_timeout=1&_txlock=deferred",
+			want: "expenses.db?_fk=0&_sync=FULL&_journal=MEMORY&_timeout=1&_txlock=deferred",
+		},
+		{
+			name: "empty modes and deferred transactions are left out",
+			opts: Options{BusyTimeout: 2 * time.Second},
+			dsn:  "expenses.db",
+			want: "expenses.db?_busy_timeout=2000&_foreign_keys=0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.sqliteDSN(tt.dsn); got != tt.want {
+				t.Errorf("sqliteDSN(%q)\n got %s\nwant %s", tt.dsn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsReportAppliedPragmas(t *testing.T) {
+	opts := DefaultOptions()
+	opts.JournalMode = "delete"
+	opts.Synchronous = "full"
+	opts.BusyTimeout = 1500 * time.Millisecond
+	opts.ReadTimeout = 3 * time.Second
+
+	db, err := New(filepath.Join(t.TempDir(), "expenses.db"), opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	d, err := db.Diagnostics(t.Context())
+	if err != nil {
+		t.Fatalf("Diagnostics: %v", err)
+	}
+	if d.Backend != string(SQLite) || d.SchemaVersion != LatestVersion() {
+		t.Errorf("backend %q at version %d", d.Backend, d.SchemaVersion)
+	}
+
+	want := map[string]string{
+		"journal_mode":    "delete",
+		"synchronous":     "FULL",
+		"busy_timeout_ms": "1500",
+		"foreign_keys":    "true",
+	}
+	for pragma, value := range want {
+		if got := d.Effective[pragma]; !strings.EqualFold(got, value) {
+			t.Errorf("effective %s = %q, want %q", pragma, got, value)
+		}
+		if got := d.Configured[pragma]; !strings.EqualFold(got, value) {
+			t.Errorf("configured %s = %q, want %q", pragma, got, value)
+		}
+	}
+	if got := d.Configured["read_timeout"]; got != "3s" {
+		t.Errorf("configured read_timeout = %q", got)
+	}
+}
+
+func TestDiagnosticsStopWhenCancelled(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "expenses.db"), DefaultOptions())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := db.Diagnostics(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Diagnostics with a cancelled context = %v, want context.Canceled", err)
+	}
+	if err := db.BackupTo(ctx, filepath.Join(t.TempDir(), "copy.db")); !errors.Is(err, context.Canceled) {
+		t.Errorf("BackupTo with a cancelled context = %v, want context.Canceled", err)
+	}
+}
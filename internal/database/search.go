@@ -0,0 +1,106 @@
+// internal/database/search.go
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// The full-text index lives outside the versioned migrations because FTS5 is
+// only compiled into go-sqlite3 with the sqlite_fts5 build tag. Binaries
+// without it fall back to LIKE matching, so the same database must stay
+// writable by both kinds of binary.
+
+var searchTriggers = []string{"expenses_fts_ai", "expenses_fts_ad", "expenses_fts_au"}
+
+const createSearchIndexSQL = `
+CREATE VIRTUAL TABLE IF NOT EXISTS expenses_fts USING fts5(
+    description, vendor, payment_method,
+    content='expenses', content_rowid='id'
+);
+`
+
+const createSearchTriggersSQL = `
+CREATE TRIGGER IF NOT EXISTS expenses_fts_ai AFTER INSERT ON expenses BEGIN
+    INSERT INTO expenses_fts(rowid, description, vendor, payment_method)
+    VALUES (new.id, new.description, new.vendor, new.payment_method);
+END;
+
+CREATE TRIGGER IF NOT EXISTS expenses_fts_ad AFTER DELETE ON expenses BEGIN
+    INSERT INTO expenses_fts(expenses_fts, rowid, description, vendor, payment_method)
+    VALUES ('delete', old.id, old.description, old.vendor, old.payment_method);
+END;
+
+CREATE TRIGGER IF NOT EXISTS expenses_fts_au AFTER UPDATE OF description, vendor, payment_method ON expenses BEGIN
+    INSERT INTO expenses_fts(expenses_fts, rowid, description, vendor, payment_method)
+    VALUES ('delete', old.id, old.description, old.vendor, old.payment_method);
+    INSERT INTO expenses_fts(rowid, description, vendor, payment_method)
+    VALUES (new.id, new.description, new.vendor, new.payment_method);
+END;
+`
+
+// FullTextSearch reports whether the FTS5 index is available. When false,
+// searches fall back to LIKE matching.
+func (db *DB) FullTextSearch() bool {
+	return db.fullTextSearch
+}
+
+// setupSearch creates the FTS5 index and its sync triggers when the driver
+// supports them. The index is rebuilt whenever a trigger was missing, which
+// covers first use as well as table rebuilds by migrations and periods where
+// the database was written by a binary without FTS5.
+func (db *DB) setupSearch() error {
+	if db.dialect != SQLite {
+		return nil
+	}
+
+	if !db.hasFTS5() {
+		// Triggers left by an FTS5 build would make every write fail with
+		// "no such module", so drop them. setupSearch recreates them and
+		// rebuilds the index the next time an FTS5 build opens the file.
+		for _, name := range searchTriggers {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var existing int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ('" + strings.Join(searchTriggers, "', '") + "')",
+	).Scan(&existing)
+	if err != nil {
+		return err
+	}
+
+	if existing < len(searchTriggers) {
+		tx, err := db.begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec(createSearchIndexSQL); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(createSearchTriggersSQL); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO expenses_fts(expenses_fts) VALUES ('rebuild')"); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	db.fullTextSearch = true
+	return nil
+}
+
+func (db *DB) hasFTS5() bool {
+	var enabled sql.NullInt64
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	return err == nil && enabled.Int64 == 1
+}
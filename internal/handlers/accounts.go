@@ -0,0 +1,169 @@
+// internal/handlers/accounts.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetAccounts lists the accounts with their current balances.
+func (h *Handler) GetAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.accountRepo.GetAll(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts)
+}
+
+func (h *Handler) GetAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	account, err := h.accountRepo.GetByID(r.Context(), id)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(account)
+}
+
+func (h *Handler) CreateAccount(w http.ResponseWriter, r *http.Request) {
+	var account models.Account
+	if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accountRepo.Create(r.Context(), &account); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+func (h *Handler) UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	var account models.Account
+	if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accountRepo.Update(r.Context(), id, &account); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(account)
+}
+
+func (h *Handler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accountRepo.Delete(r.Context(), id); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAccountRegister lists an account's expenses with the running balance
+// after each, optionally limited to start_date and end_date.
+func (h *Handler) GetAccountRegister(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	register, err := h.accountRepo.GetRegister(r.Context(), id, r.URL.Query().Get("start_date"), r.URL.Query().Get("end_date"))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(register)
+}
+
+type reconcileRequest struct {
+	ExpenseIDs []int `json:"expense_ids"`
+	Reconciled *bool `json:"reconciled"`
+}
+
+// ReconcileAccount marks expenses on the account as reconciled, or clears
+// the mark with "reconciled": false.
+func (h *Handler) ReconcileAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	var req reconcileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.ExpenseIDs) == 0 {
+		http.Error(w, "expense_ids is required", http.StatusBadRequest)
+		return
+	}
+	reconciled := req.Reconciled == nil || *req.Reconciled
+
+	updated, err := h.accountRepo.Reconcile(r.Context(), id, req.ExpenseIDs, reconciled)
+	if err != nil {
+		if errors.Is(err, repository.ErrExpenseNotFound) {
+			http.Error(w, "Expense not found", http.StatusNotFound)
+			return
+		}
+		writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"updated": updated})
+}
+
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrAccountNotFound):
+		http.Error(w, "Account not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidAccount),
+		errors.Is(err, repository.ErrInvalidDateRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrAccountExists),
+		errors.Is(err, repository.ErrAccountInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeServerError(w, err)
+	}
+}
@@ -0,0 +1,40 @@
+// internal/handlers/admin.go
+package handlers
+
+import (
+	"encoding/json"
+	"expense-tracker/internal/integrity"
+	"net/http"
+)
+
+func (h *Handler) GetDiagnostics(w http.ResponseWriter, r *http.Request) {
+	diagnostics, err := h.db.Diagnostics(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diagnostics)
+}
+
+func (h *Handler) CheckIntegrity(w http.ResponseWriter, r *http.Request) {
+	h.runIntegrityCheck(w, r, integrity.Options{})
+}
+
+// FixIntegrity runs the checks and applies the safe fixes. Each fix is
+// recorded in the expense's history.
+func (h *Handler) FixIntegrity(w http.ResponseWriter, r *http.Request) {
+	h.runIntegrityCheck(w, r, integrity.Options{Fix: true})
+}
+
+func (h *Handler) runIntegrityCheck(w http.ResponseWriter, r *http.Request, opts integrity.Options) {
+	report, err := integrity.Check(r.Context(), h.db, opts)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,158 @@
+// internal/handlers/attachments.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/attachments"
+	"expense-tracker/internal/repository"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// multipartOverhead is allowed on top of the attachment size limit for the
+// multipart boundaries and headers around the file.
+const multipartOverhead = 64 << 10
+
+// GetAttachments lists the files attached to an expense.
+func (h *Handler) GetAttachments(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+
+	list, err := h.attachments.List(r.Context(), id)
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// UploadAttachment attaches the multipart "file" field to an expense.
+func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+
+	// Check the expense first so a large upload is not read for nothing
+	if _, err := h.expenseRepo.GetByID(r.Context(), id); err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.attachments.MaxSize()+multipartOverhead)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Attachment is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "No file uploaded", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	defer r.MultipartForm.RemoveAll()
+
+	attachment, err := h.attachments.Add(r.Context(), id, header.Filename, file)
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	log.Printf("UploadAttachment: %s (%d bytes) on expense %d", attachment.Filename, attachment.Size, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// DownloadAttachment serves an attachment's content. Images and PDFs open in
+// the browser unless ?download=1 is given.
+func (h *Handler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := h.attachments.Get(r.Context(), id)
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	file, err := os.Open(h.attachments.Path(attachment))
+	if err != nil {
+		log.Printf("DownloadAttachment: %v", err)
+		http.Error(w, "Attachment file is missing", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	disposition := "inline"
+	if r.URL.Query().Get("download") != "" {
+		disposition = "attachment"
+	}
+
+	// The content never changes, so its digest is a strong ETag
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+attachment.SHA256+`"`)
+	http.ServeContent(w, r, attachment.Filename, attachment.CreatedAt, file)
+}
+
+func (h *Handler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.attachments.Delete(r.Context(), id); err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sweepAttachments removes the files of purged expenses. A failure is only
+// logged: the files are swept again after the next purge or restart.
+func (h *Handler) sweepAttachments(ctx context.Context) {
+	if removed, err := h.attachments.Sweep(ctx); err != nil {
+		log.Printf("Attachments: sweep failed: %v", err)
+	} else if removed > 0 {
+		log.Printf("Attachments: removed %d unreferenced files", removed)
+	}
+}
+
+func writeAttachmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrExpenseNotFound):
+		http.Error(w, "Expense not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrAttachmentNotFound):
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+	case errors.Is(err, attachments.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, attachments.ErrUnsupportedType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, attachments.ErrEmpty):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeServerError(w, err)
+	}
+}
@@ -0,0 +1,130 @@
+// internal/handlers/backups.go
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/backup"
+	"expense-tracker/internal/database"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := h.backups.List()
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+func (h *Handler) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := h.backups.Create(r.Context())
+	if err != nil {
+		if errors.Is(err, database.ErrBackupUnsupported) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if errors.Is(err, database.ErrTimeout) || errors.Is(err, context.Canceled) {
+			writeServerError(w, err)
+			return
+		}
+		http.Error(w, "Backup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("CreateBackup: wrote %s (%d bytes)", snapshot.Name, snapshot.Size)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func (h *Handler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := h.backups.Get(mux.Vars(r)["name"])
+	if err != nil {
+		if err == backup.ErrSnapshotNotFound {
+			http.Error(w, "Backup not found", http.StatusNotFound)
+			return
+		}
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+snapshot.Name+`"`)
+	http.ServeFile(w, r, snapshot.Path)
+}
+
+// DownloadBackupAttachments streams a snapshot's attachment files as a zip
+// laid out like the attachments directory.
+func (h *Handler) DownloadBackupAttachments(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := h.backups.Get(mux.Vars(r)["name"])
+	if err != nil {
+		if err == backup.ErrSnapshotNotFound {
+			http.Error(w, "Backup not found", http.StatusNotFound)
+			return
+		}
+		writeServerError(w, err)
+		return
+	}
+	if snapshot.AttachmentsPath == "" {
+		http.Error(w, "Backup has no attachments", http.StatusNotFound)
+		return
+	}
+
+	name := strings.TrimSuffix(snapshot.Name, filepath.Ext(snapshot.Name)) + "-attachments.zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	// Headers are sent with the first file, so later errors can only be
+	// logged
+	archive := zip.NewWriter(w)
+	err = filepath.WalkDir(snapshot.AttachmentsPath, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(snapshot.AttachmentsPath, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		// Attachments are mostly already compressed images and PDFs
+		out, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     filepath.ToSlash(rel),
+			Method:   zip.Store,
+			Modified: info.ModTime(),
+		})
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(out, in)
+		return err
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		log.Printf("DownloadBackupAttachments: %s: %v", snapshot.Name, err)
+	}
+}
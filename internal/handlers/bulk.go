@@ -0,0 +1,156 @@
+// internal/handlers/bulk.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// bulkRequest selects expenses either by ID or with filter, which holds the
+// query parameters of GET /api/expenses, such as "vendor=amzn&type=expense".
+type bulkRequest struct {
+	ExpenseIDs []int              `json:"expense_ids"`
+	Filter     *string            `json:"filter"`
+	Changes    models.BulkChanges `json:"changes"`
+	DryRun     bool               `json:"dry_run"`
+}
+
+func (req bulkRequest) selection() (models.BulkSelection, error) {
+	selection := models.BulkSelection{IDs: req.ExpenseIDs}
+	if req.Filter == nil {
+		return selection, nil
+	}
+
+	query, err := url.ParseQuery(strings.TrimPrefix(*req.Filter, "?"))
+	if err != nil {
+		return selection, fmt.Errorf("Invalid filter: %v", err)
+	}
+	filter, err := parseBulkFilter(query)
+	if err != nil {
+		return selection, err
+	}
+	selection.Filter = &filter
+	return selection, nil
+}
+
+// filterParams are the query parameters parseExpenseFilter reads, besides
+// custom fields given as field.<name>.
+var filterParams = []string{
+	"start_date", "end_date", "category", "q", "tag", "tags_all", "tags_any", "type",
+	"account_id", "reimbursable", "claim_id", "min_amount", "max_amount", "vendor",
+	"payment_method", "uncategorized", "created_from", "created_to", "updated_from",
+	"updated_to", "sort", "order",
+}
+
+// parseBulkFilter reads a bulk filter like parseExpenseFilter, but rejects
+// what the list view lets pass: an unknown or misspelled parameter or a date
+// that does not parse would otherwise drop a condition and select more
+// expenses than meant.
+func parseBulkFilter(query url.Values) (models.ExpenseFilter, error) {
+	for key := range query {
+		if name, ok := strings.CutPrefix(key, "field."); ok && name != "" {
+			continue
+		}
+		if !slices.Contains(filterParams, key) {
+			return models.ExpenseFilter{}, fmt.Errorf("Invalid filter: unknown parameter %q", key)
+		}
+	}
+	for _, param := range []string{"start_date", "end_date"} {
+		for _, value := range query[param] {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				return models.ExpenseFilter{}, fmt.Errorf("Invalid filter: %s must be a date as YYYY-MM-DD", param)
+			}
+		}
+	}
+	return parseExpenseFilter(query)
+}
+
+// BulkUpdateExpenses sets the category, vendor or payment method of every
+// selected expense and adds or removes tags, all in one transaction. With
+// "dry_run": true nothing is saved and the response shows what would change.
+func (h *Handler) BulkUpdateExpenses(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	selection, err := req.selection()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A new vendor goes through the merchant directory, as on a single edit
+	if req.Changes.Vendor != nil {
+		directory, err := h.merchantRepo.Directory(r.Context())
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		if name, ok := directory.Match(*req.Changes.Vendor); ok {
+			req.Changes.Vendor = &name
+		}
+	}
+
+	result, err := h.expenseRepo.BulkUpdate(r.Context(), selection, req.Changes, req.DryRun)
+	if err != nil {
+		writeBulkError(w, err)
+		return
+	}
+	if !result.DryRun {
+		log.Printf("BulkUpdateExpenses: updated %d of %d expenses", result.Affected, result.Matched)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// BulkDeleteExpenses moves every selected expense to the trash in one
+// transaction, or with "dry_run": true shows what would be deleted.
+func (h *Handler) BulkDeleteExpenses(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	selection, err := req.selection()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.expenseRepo.BulkDelete(r.Context(), selection, req.DryRun)
+	if err != nil {
+		writeBulkError(w, err)
+		return
+	}
+	if !result.DryRun {
+		log.Printf("BulkDeleteExpenses: deleted %d expenses", result.Affected)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func writeBulkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrInvalidBulkChange), errors.Is(err, repository.ErrInvalidFilter),
+		isInvalidExpense(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrExpenseNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, repository.ErrClaimLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeServerError(w, err)
+	}
+}
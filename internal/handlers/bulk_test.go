@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"expense-tracker/internal/models"
+)
+
+func TestBulkFilterIsStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   int
+		// How many of the two expenses are left afterwards
+		left int
+	}{
+		{"matching vendor", "vendor=Grab", http.StatusOK, 1},
+		{"bad start date", "vendor=Grab&start_date=2024-02-30", http.StatusBadRequest, 2},
+		{"bad end date", "vendor=Grab&end_date=March", http.StatusBadRequest, 2},
+		{"misspelled key", "vendr=Grab", http.StatusBadRequest, 2},
+		{"pagination", "vendor=Grab&page=2", http.StatusBadRequest, 2},
+		{"empty field name", "field.=x", http.StatusBadRequest, 2},
+		{"unknown custom field", "vendor=Grab&field.project=x", http.StatusBadRequest, 2},
+		{"no conditions", "", http.StatusBadRequest, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestServer(t)
+			for _, vendor := range []string{"Grab", "Cafe"} {
+				expense := models.Expense{
+					Date:          time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+					Category:      "Other",
+					Description:   "Ride",
+					Amount:        1250,
+					Vendor:        vendor,
+					PaymentMethod: "Card",
+				}
+				if err := h.expenseRepo.Create(t.Context(), &expense); err != nil {
+					t.Fatalf("Create: %v", err)
+				}
+			}
+
+			body := map[string]interface{}{"filter": tt.filter}
+			rec := serve(http.HandlerFunc(h.BulkDeleteExpenses), "POST", "/api/expenses/bulk-delete", "", body)
+			if rec.Code != tt.want {
+				t.Fatalf("bulk delete = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+
+			expenses, _, err := h.expenseRepo.GetAll(t.Context(), models.ExpenseFilter{}, 1, 0)
+			if err != nil {
+				t.Fatalf("GetAll: %v", err)
+			}
+			if len(expenses) != tt.left {
+				t.Errorf("%d expenses left, want %d", len(expenses), tt.left)
+			}
+		})
+	}
+}
+
+func TestBulkUpdateRejectsBadFilter(t *testing.T) {
+	h, _ := newTestServer(t)
+	expense := createExpense(t, h)
+
+	body := map[string]interface{}{
+		"filter":  "vendor=Cafe&start_date=2024-13-01",
+		"changes": map[string]string{"payment_method": "Cash"},
+	}
+	rec := serve(http.HandlerFunc(h.BulkUpdateExpenses), "POST", "/api/expenses/bulk-update", "", body)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("bulk update = %d, want 400: %s", rec.Code, rec.Body)
+	}
+
+	got, err := h.expenseRepo.GetByID(t.Context(), expense.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.PaymentMethod != "Card" {
+		t.Errorf("payment method = %q after a rejected update", got.PaymentMethod)
+	}
+
+	body["filter"] = "vendor=Cafe&start_date=2024-03-01"
+	rec = serve(http.HandlerFunc(h.BulkUpdateExpenses), "POST", "/api/expenses/bulk-update", "", body)
+	var result struct {
+		Affected int `json:"affected"`
+	}
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&result) != nil || result.Affected != 1 {
+		t.Errorf("bulk update with a valid filter = %d, affected %d", rec.Code, result.Affected)
+	}
+}
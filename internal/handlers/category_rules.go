@@ -3,6 +3,7 @@ package handlers
 
 import (
     "encoding/json"
+    "expense-tracker/internal/database"
     "expense-tracker/internal/models"
     "net/http"
     "strconv"
@@ -15,22 +16,27 @@ func (h *Handler) GetCategoryRules(w http.ResponseWriter, r *http.Request) {
     category := r.URL.Query().Get("category")
     
     query := `
-        SELECT id, category, keyword, case_sensitive, created_at, updated_at
+        SELECT categorization_rules.id, category_id, categories.name, keyword, case_sensitive,
+               categorization_rules.created_at, categorization_rules.updated_at
         FROM categorization_rules
+        JOIN categories ON categories.id = categorization_rules.category_id
         WHERE 1=1
     `
     args := []interface{}{}
     
     if category != "" {
-        query += " AND category = ?"
+        query += " AND categories.name = ?"
         args = append(args, category)
     }
     
-    query += " ORDER BY category, keyword"
+    query += " ORDER BY categories.name, keyword"
     
-    rows, err := h.db.Query(query, args...)
+    q, cancel := h.db.WithContext(r.Context(), database.OpRead)
+    defer cancel()
+    
+    rows, err := q.Query(query, args...)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeServerError(w, err)
         return
     }
     defer rows.Close()
@@ -38,9 +44,9 @@ func (h *Handler) GetCategoryRules(w http.ResponseWriter, r *http.Request) {
     var rules []models.CategoryRule
     for rows.Next() {
         var r models.CategoryRule
-        err := rows.Scan(&r.ID, &r.Category, &r.Keyword, &r.CaseSensitive, &r.CreatedAt, &r.UpdatedAt)
+        err := rows.Scan(&r.ID, &r.CategoryID, &r.Category, &r.Keyword, &r.CaseSensitive, &r.CreatedAt, &r.UpdatedAt)
         if err != nil {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
+            writeServerError(w, err)
             return
         }
         rules = append(rules, r)
@@ -58,29 +64,35 @@ func (h *Handler) CreateCategoryRule(w http.ResponseWriter, r *http.Request) {
     }
     
     // Validate required fields
-    if strings.TrimSpace(rule.Category) == "" {
-        http.Error(w, "Category is required", http.StatusBadRequest)
-        return
-    }
     if strings.TrimSpace(rule.Keyword) == "" {
         http.Error(w, "Keyword is required", http.StatusBadRequest)
         return
     }
     
+    // Rules must point at an existing category
+    category, err := h.categoryRepo.Resolve(r.Context(), rule.CategoryID, rule.Category)
+    if err != nil {
+        if isInvalidExpense(err) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        writeServerError(w, err)
+        return
+    }
+    rule.CategoryID = category.ID
+    rule.Category = category.Name
+    
     query := `
-        INSERT INTO categorization_rules (category, keyword, case_sensitive, created_at, updated_at)
+        INSERT INTO categorization_rules (category_id, keyword, case_sensitive, created_at, updated_at)
         VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
     `
     
-    result, err := h.db.Exec(query, rule.Category, rule.Keyword, rule.CaseSensitive)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
+    q, cancel := h.db.WithContext(r.Context(), database.OpWrite)
+    defer cancel()
     
-    id, err := result.LastInsertId()
+    id, err := q.ExecReturningID(query, rule.CategoryID, rule.Keyword, rule.CaseSensitive)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeServerError(w, err)
         return
     }
     
@@ -105,30 +117,42 @@ func (h *Handler) UpdateCategoryRule(w http.ResponseWriter, r *http.Request) {
     }
     
     // Validate required fields
-    if strings.TrimSpace(rule.Category) == "" {
-        http.Error(w, "Category is required", http.StatusBadRequest)
-        return
-    }
     if strings.TrimSpace(rule.Keyword) == "" {
         http.Error(w, "Keyword is required", http.StatusBadRequest)
         return
     }
     
+    // Rules must point at an existing category
+    category, err := h.categoryRepo.Resolve(r.Context(), rule.CategoryID, rule.Category)
+    if err != nil {
+        if isInvalidExpense(err) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        writeServerError(w, err)
+        return
+    }
+    rule.CategoryID = category.ID
+    rule.Category = category.Name
+    
     query := `
         UPDATE categorization_rules 
-        SET category = ?, keyword = ?, case_sensitive = ?, updated_at = CURRENT_TIMESTAMP
+        SET category_id = ?, keyword = ?, case_sensitive = ?, updated_at = CURRENT_TIMESTAMP
         WHERE id = ?
     `
     
-    result, err := h.db.Exec(query, rule.Category, rule.Keyword, rule.CaseSensitive, id)
+    q, cancel := h.db.WithContext(r.Context(), database.OpWrite)
+    defer cancel()
+    
+    result, err := q.Exec(query, rule.CategoryID, rule.Keyword, rule.CaseSensitive, id)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeServerError(w, err)
         return
     }
     
     rowsAffected, err := result.RowsAffected()
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeServerError(w, err)
         return
     }
     
@@ -152,15 +176,18 @@ func (h *Handler) DeleteCategoryRule(w http.ResponseWriter, r *http.Request) {
     
     query := "DELETE FROM categorization_rules WHERE id = ?"
     
-    result, err := h.db.Exec(query, id)
+    q, cancel := h.db.WithContext(r.Context(), database.OpWrite)
+    defer cancel()
+    
+    result, err := q.Exec(query, id)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeServerError(w, err)
         return
     }
     
     rowsAffected, err := result.RowsAffected()
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeServerError(w, err)
         return
     }
     
@@ -172,30 +199,49 @@ func (h *Handler) DeleteCategoryRule(w http.ResponseWriter, r *http.Request) {
     w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *Handler) GetCategories(w http.ResponseWriter, r *http.Request) {
-    query := `
-        SELECT DISTINCT category
-        FROM categorization_rules
-        ORDER BY category
-    `
+// ApplyCategoryRules re-runs the categorization rules over existing expenses.
+// By default only expenses still in "Other" are touched; pass {"all": true}
+// to recategorize everything.
+func (h *Handler) ApplyCategoryRules(w http.ResponseWriter, r *http.Request) {
+    var request struct {
+        All bool `json:"all"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+            http.Error(w, "Invalid request body", http.StatusBadRequest)
+            return
+        }
+    }
+    
+    filter := models.ExpenseFilter{}
+    if !request.All {
+        filter.Category = "Other"
+    }
     
-    rows, err := h.db.Query(query)
+    expenses, _, err := h.expenseRepo.GetAll(r.Context(), filter, 1, 0)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeServerError(w, err)
         return
     }
-    defer rows.Close()
     
-    var categories []string
-    for rows.Next() {
-        var category string
-        if err := rows.Scan(&category); err != nil {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
-            return
+    categories := make(map[int]string)
+    for _, expense := range expenses {
+        if category := h.categorizeExpense(r.Context(), expense.Description); category != expense.Category {
+            categories[expense.ID] = category
         }
-        categories = append(categories, category)
+    }
+    
+    updated, err := h.expenseRepo.Recategorize(r.Context(), categories, models.SourceRuleReapply)
+    if err != nil {
+        writeServerError(w, err)
+        return
+    }
+    
+    response := map[string]interface{}{
+        "checked": len(expenses),
+        "updated": updated,
     }
     
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(categories)
+    json.NewEncoder(w).Encode(response)
 }
\ No newline at end of file
@@ -0,0 +1,241 @@
+// internal/handlers/claims.go
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetClaims lists claims, newest first. ?status= limits them to one status.
+func (h *Handler) GetClaims(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.claimRepo.GetAll(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		writeClaimError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
+func (h *Handler) GetClaim(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid claim ID", http.StatusBadRequest)
+		return
+	}
+
+	claim, err := h.claimRepo.GetByID(r.Context(), id)
+	if err != nil {
+		writeClaimError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claim)
+}
+
+func (h *Handler) CreateClaim(w http.ResponseWriter, r *http.Request) {
+	var claim models.Claim
+	if err := json.NewDecoder(r.Body).Decode(&claim); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.claimRepo.Create(r.Context(), &claim); err != nil {
+		writeClaimError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(claim)
+}
+
+// UpdateClaim changes a claim's name and notes.
+func (h *Handler) UpdateClaim(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid claim ID", http.StatusBadRequest)
+		return
+	}
+
+	var claim models.Claim
+	if err := json.NewDecoder(r.Body).Decode(&claim); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.claimRepo.Update(r.Context(), id, &claim); err != nil {
+		writeClaimError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claim)
+}
+
+// DeleteClaim removes a draft claim; its expenses stay reimbursable.
+func (h *Handler) DeleteClaim(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid claim ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.claimRepo.Delete(r.Context(), id); err != nil {
+		writeClaimError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddClaimExpenses puts {"expense_ids": [...]} on a draft claim.
+func (h *Handler) AddClaimExpenses(w http.ResponseWriter, r *http.Request) {
+	h.moveClaimExpenses(w, r, h.claimRepo.AddExpenses)
+}
+
+// RemoveClaimExpenses takes {"expense_ids": [...]} off a draft claim.
+func (h *Handler) RemoveClaimExpenses(w http.ResponseWriter, r *http.Request) {
+	h.moveClaimExpenses(w, r, h.claimRepo.RemoveExpenses)
+}
+
+func (h *Handler) moveClaimExpenses(w http.ResponseWriter, r *http.Request, move func(context.Context, int, []int) (int, error)) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid claim ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		ExpenseIDs []int `json:"expense_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.ExpenseIDs) == 0 {
+		http.Error(w, "expense_ids is required", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := move(r.Context(), id, request.ExpenseIDs)
+	if err != nil {
+		writeClaimError(w, err)
+		return
+	}
+
+	claim, err := h.claimRepo.GetByID(r.Context(), id)
+	if err != nil {
+		writeClaimError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"claim":   claim,
+		"updated": updated,
+	})
+}
+
+// SetClaimStatus moves a claim to {"status": "submitted"} or "paid", or back
+// a step.
+func (h *Handler) SetClaimStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid claim ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claim, err := h.claimRepo.SetStatus(r.Context(), id, request.Status)
+	if err != nil {
+		writeClaimError(w, err)
+		return
+	}
+
+	log.Printf("SetClaimStatus: claim %d is %s", id, claim.Status)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claim)
+}
+
+// ExportClaim downloads a claim's expenses as CSV in date order, followed by
+// a total line per currency.
+func (h *Handler) ExportClaim(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid claim ID", http.StatusBadRequest)
+		return
+	}
+
+	claim, err := h.claimRepo.GetByID(r.Context(), id)
+	if err != nil {
+		writeClaimError(w, err)
+		return
+	}
+	expenses, _, err := h.expenseRepo.GetAll(r.Context(), models.ExpenseFilter{ClaimID: id, Sort: "date", Order: "asc"}, 1, 0)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	filename := fmt.Sprintf("claim-%d.csv", claim.ID)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"DATE", "DESCRIPTION", "VENDOR", "CATEGORY", "AMOUNT", "CURRENCY"})
+	for _, e := range expenses {
+		writer.Write([]string{e.Date.Format("2006-01-02"), e.Description, e.Vendor, e.Category, e.Amount.String(), e.Currency})
+	}
+
+	codes := make([]string, 0, len(claim.Totals))
+	for code := range claim.Totals {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		writer.Write([]string{"", "Total", "", "", claim.Totals[code].String(), code})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("ExportClaim: %v", err)
+	}
+}
+
+func writeClaimError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrClaimNotFound):
+		http.Error(w, "Claim not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrExpenseNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidClaim):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrClaimLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeServerError(w, err)
+	}
+}
@@ -0,0 +1,111 @@
+// internal/handlers/custom_fields.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetCustomFields lists the custom field definitions by name.
+func (h *Handler) GetCustomFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := h.customFieldRepo.GetAll(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fields)
+}
+
+func (h *Handler) GetCustomField(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid custom field ID", http.StatusBadRequest)
+		return
+	}
+
+	field, err := h.customFieldRepo.GetByID(r.Context(), id)
+	if err != nil {
+		writeCustomFieldError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(field)
+}
+
+func (h *Handler) CreateCustomField(w http.ResponseWriter, r *http.Request) {
+	var field models.CustomField
+	if err := json.NewDecoder(r.Body).Decode(&field); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.customFieldRepo.Create(r.Context(), &field); err != nil {
+		writeCustomFieldError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(field)
+}
+
+func (h *Handler) UpdateCustomField(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid custom field ID", http.StatusBadRequest)
+		return
+	}
+
+	var field models.CustomField
+	if err := json.NewDecoder(r.Body).Decode(&field); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.customFieldRepo.Update(r.Context(), id, &field); err != nil {
+		writeCustomFieldError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(field)
+}
+
+// DeleteCustomField removes a field and every expense's value for it.
+func (h *Handler) DeleteCustomField(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid custom field ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.customFieldRepo.Delete(r.Context(), id); err != nil {
+		writeCustomFieldError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeCustomFieldError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrCustomFieldNotFound):
+		http.Error(w, "Custom field not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidCustomField):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrCustomFieldExists),
+		errors.Is(err, repository.ErrCustomFieldInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeServerError(w, err)
+	}
+}
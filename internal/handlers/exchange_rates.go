@@ -0,0 +1,78 @@
+// internal/handlers/exchange_rates.go
+package handlers
+
+import (
+	"encoding/json"
+	"expense-tracker/internal/currency"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	base := r.URL.Query().Get("base")
+	code := r.URL.Query().Get("currency")
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+
+	rates, err := h.rateRepo.List(r.Context(), base, code, limit)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
+
+// ImportExchangeRates loads a wide rate CSV such as the ECB historical file.
+// The optional "base" form field names the currency the file is quoted
+// against and defaults to EUR.
+func (h *Handler) ImportExchangeRates(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Failed to parse form: file too large or invalid", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No rates file provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	base := r.FormValue("base")
+	if base == "" {
+		base = "EUR"
+	}
+
+	rates, err := currency.ParseRatesCSV(file, base)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse rates: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.rateRepo.Upsert(r.Context(), rates)
+	if err != nil {
+		writeServerError(w, fmt.Errorf("failed to save rates: %w", err))
+		return
+	}
+
+	log.Printf("ImportExchangeRates: Loaded %d rates from %s", count, header.Filename)
+
+	response := map[string]interface{}{
+		"success": true,
+		"count":   count,
+		"base":    base,
+		"message": fmt.Sprintf("Loaded %d exchange rates", count),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
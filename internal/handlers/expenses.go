@@ -2,46 +2,216 @@
 package handlers
 
 import (
+    "context"
     "encoding/json"
+    "errors"
+    "expense-tracker/internal/attachments"
+    "expense-tracker/internal/backup"
+    "expense-tracker/internal/currency"
     "expense-tracker/internal/database"
     "expense-tracker/internal/models"
     "expense-tracker/internal/repository"
+    "fmt"
     "net/http"
+    "net/url"
+    "slices"
     "strconv"
+    "strings"
     "time"
     
     "github.com/gorilla/mux"
 )
 
 type Handler struct {
-    db         *database.DB
-    expenseRepo repository.ExpenseRepository
+    db              *database.DB
+    expenseRepo     repository.ExpenseRepository
+    settingsRepo    repository.SettingsRepository
+    rateRepo        repository.ExchangeRateRepository
+    categoryRepo    repository.CategoryRepository
+    tagRepo         repository.TagRepository
+    accountRepo     repository.AccountRepository
+    customFieldRepo repository.CustomFieldRepository
+    merchantRepo    repository.MerchantRepository
+    claimRepo       repository.ClaimRepository
+    backups         *backup.Manager
+    attachments     *attachments.Manager
 }
 
-func New(db *database.DB) *Handler {
+// Options carries the server-level services handlers depend on besides the
+// database.
+type Options struct {
+    Backups     *backup.Manager
+    Attachments *attachments.Manager
+}
+
+func New(db *database.DB, opts Options) *Handler {
     return &Handler{
-        db:         db,
-        expenseRepo: repository.NewExpenseRepository(db),
+        db:              db,
+        expenseRepo:     repository.NewExpenseRepository(db),
+        settingsRepo:    repository.NewSettingsRepository(db),
+        rateRepo:        repository.NewExchangeRateRepository(db),
+        categoryRepo:    repository.NewCategoryRepository(db),
+        tagRepo:         repository.NewTagRepository(db),
+        accountRepo:     repository.NewAccountRepository(db),
+        customFieldRepo: repository.NewCustomFieldRepository(db),
+        merchantRepo:    repository.NewMerchantRepository(db),
+        claimRepo:       repository.NewClaimRepository(db),
+        backups:         opts.Backups,
+        attachments:     opts.Attachments,
     }
 }
 
-func (h *Handler) GetExpenses(w http.ResponseWriter, r *http.Request) {
-    // Parse filters
+// isInvalidExpense reports whether a repository error was caused by the
+// submitted expense rather than by the server.
+func isInvalidExpense(err error) bool {
+    return errors.Is(err, currency.ErrInvalidCode) ||
+        errors.Is(err, repository.ErrCategoryNotFound) ||
+        errors.Is(err, repository.ErrInvalidCategory) ||
+        errors.Is(err, repository.ErrInvalidTag) ||
+        errors.Is(err, repository.ErrInvalidSplit) ||
+        errors.Is(err, repository.ErrInvalidTransaction) ||
+        errors.Is(err, repository.ErrAccountNotFound) ||
+        errors.Is(err, repository.ErrInvalidAccount) ||
+        errors.Is(err, repository.ErrInvalidCustomField)
+}
+
+// writeServerError reports an error the client did not cause. A database
+// operation that ran past its timeout is a 504, and one abandoned because the
+// client went away a 503, so neither shows up as a server fault.
+func writeServerError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, repository.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+        http.Error(w, "The database did not answer in time", http.StatusGatewayTimeout)
+    case errors.Is(err, context.Canceled):
+        http.Error(w, "Request canceled", http.StatusServiceUnavailable)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+// fieldFilters reads custom field filters given as field.<name>=<value>.
+func fieldFilters(query url.Values) map[string]string {
+    filters := make(map[string]string)
+    for key, values := range query {
+        if name, ok := strings.CutPrefix(key, "field."); ok && len(values) > 0 {
+            filters[name] = values[0]
+        }
+    }
+    return filters
+}
+
+// parseExpenseFilter reads the list filters from query parameters. Its
+// errors are meant for the client.
+func parseExpenseFilter(query url.Values) (models.ExpenseFilter, error) {
     var filter models.ExpenseFilter
     
-    if startDateStr := r.URL.Query().Get("start_date"); startDateStr != "" {
+    if startDateStr := query.Get("start_date"); startDateStr != "" {
         if parsedDate, err := time.Parse("2006-01-02", startDateStr); err == nil {
             filter.StartDate = parsedDate
         }
     }
     
-    if endDateStr := r.URL.Query().Get("end_date"); endDateStr != "" {
+    if endDateStr := query.Get("end_date"); endDateStr != "" {
         if parsedDate, err := time.Parse("2006-01-02", endDateStr); err == nil {
             filter.EndDate = parsedDate
         }
     }
     
-    filter.Category = r.URL.Query().Get("category")
+    // category may be repeated to match any of several
+    filter.Categories = query["category"]
+    filter.Query = strings.TrimSpace(query.Get("q"))
+    
+    // tag may be repeated; every listed tag must be present
+    filter.TagsAll = append(splitTags(query["tag"]), splitTags(query["tags_all"])...)
+    filter.TagsAny = splitTags(query["tags_any"])
+    
+    filter.Type = query.Get("type")
+    filter.Fields = fieldFilters(query)
+    
+    if accountStr := query.Get("account_id"); accountStr != "" {
+        accountID, err := strconv.Atoi(accountStr)
+        if err != nil || accountID <= 0 {
+            return filter, errors.New("Invalid account_id")
+        }
+        filter.AccountID = accountID
+    }
+    
+    if reimbursableStr := query.Get("reimbursable"); reimbursableStr != "" {
+        reimbursable, err := strconv.ParseBool(reimbursableStr)
+        if err != nil {
+            return filter, errors.New("Invalid reimbursable: use true or false")
+        }
+        filter.Reimbursable = &reimbursable
+    }
+    
+    // claim_id=none lists expenses on no claim
+    if claimStr := query.Get("claim_id"); claimStr == "none" {
+        filter.Unclaimed = true
+    } else if claimStr != "" {
+        claimID, err := strconv.Atoi(claimStr)
+        if err != nil || claimID <= 0 {
+            return filter, errors.New("Invalid claim_id")
+        }
+        filter.ClaimID = claimID
+    }
+    
+    for _, bound := range []struct {
+        param string
+        dest  **models.Money
+    }{{"min_amount", &filter.MinAmount}, {"max_amount", &filter.MaxAmount}} {
+        if value := query.Get(bound.param); value != "" {
+            amount, err := models.ParseMoney(value)
+            if err != nil {
+                return filter, fmt.Errorf("Invalid %s: %v", bound.param, err)
+            }
+            *bound.dest = &amount
+        }
+    }
+    
+    filter.Vendor = query.Get("vendor")
+    filter.PaymentMethod = query.Get("payment_method")
+    
+    // uncategorized=true lists expenses in the default category, alongside
+    // any category given; false leaves them out
+    if uncategorizedStr := query.Get("uncategorized"); uncategorizedStr != "" {
+        uncategorized, err := strconv.ParseBool(uncategorizedStr)
+        if err != nil {
+            return filter, errors.New("Invalid uncategorized: use true or false")
+        }
+        filter.Uncategorized = &uncategorized
+    }
+    
+    for _, bound := range []struct {
+        param string
+        end   bool
+        dest  *time.Time
+    }{
+        {"created_from", false, &filter.CreatedFrom},
+        {"created_to", true, &filter.CreatedBefore},
+        {"updated_from", false, &filter.UpdatedFrom},
+        {"updated_to", true, &filter.UpdatedBefore},
+    } {
+        if value := query.Get(bound.param); value != "" {
+            t, err := parseTimestampBound(value, bound.end)
+            if err != nil {
+                return filter, fmt.Errorf("Invalid %s: use YYYY-MM-DD or an RFC 3339 timestamp", bound.param)
+            }
+            *bound.dest = t
+        }
+    }
+    
+    filter.Sort = query.Get("sort")
+    filter.Order = query.Get("order")
+    
+    return filter, nil
+}
+
+func (h *Handler) GetExpenses(w http.ResponseWriter, r *http.Request) {
+    filter, err := parseExpenseFilter(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
     
     // Parse pagination
     page := 1
@@ -58,9 +228,22 @@ func (h *Handler) GetExpenses(w http.ResponseWriter, r *http.Request) {
         }
     }
     
-    expenses, pagination, err := h.expenseRepo.GetAll(filter, page, limit)
+    // A cursor from an earlier page's next_cursor or previous_cursor takes
+    // the place of page
+    var expenses []models.Expense
+    var pagination *repository.PaginationInfo
+    if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+        expenses, pagination, err = h.expenseRepo.GetAllByCursor(r.Context(), filter, cursor, limit)
+    } else {
+        expenses, pagination, err = h.expenseRepo.GetAll(r.Context(), filter, page, limit)
+    }
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        if errors.Is(err, repository.ErrInvalidTag) || errors.Is(err, repository.ErrInvalidTransaction) ||
+            errors.Is(err, repository.ErrInvalidCustomField) || errors.Is(err, repository.ErrInvalidFilter) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        writeServerError(w, err)
         return
     }
     
@@ -73,14 +256,32 @@ func (h *Handler) GetExpenses(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(response)
 }
 
+// parseTimestampBound reads a created or updated bound. A bare date at the
+// end of a range takes in that whole day.
+func parseTimestampBound(value string, end bool) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, value); err == nil {
+        return t, nil
+    }
+    t, err := time.Parse("2006-01-02", value)
+    if err != nil {
+        return time.Time{}, err
+    }
+    if end {
+        t = t.AddDate(0, 0, 1)
+    }
+    return t, nil
+}
+
 func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
-    startDate := r.URL.Query().Get("start_date")
-    endDate := r.URL.Query().Get("end_date")
-    category := r.URL.Query().Get("category")
+    filter, err := parseStatsFilter(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
     
-    stats, err := h.expenseRepo.GetStats(startDate, endDate, category)
+    stats, err := h.expenseRepo.GetStats(r.Context(), filter)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeStatsError(w, err)
         return
     }
     
@@ -89,13 +290,15 @@ func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetMonthlyStats(w http.ResponseWriter, r *http.Request) {
-    startDate := r.URL.Query().Get("start_date")
-    endDate := r.URL.Query().Get("end_date")
-    category := r.URL.Query().Get("category")
+    filter, err := parseStatsFilter(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
     
-    stats, err := h.expenseRepo.GetMonthlyStats(startDate, endDate, category)
+    stats, err := h.expenseRepo.GetMonthlyStats(r.Context(), filter)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeStatsError(w, err)
         return
     }
     
@@ -103,6 +306,77 @@ func (h *Handler) GetMonthlyStats(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(stats)
 }
 
+// GetCashFlow reports income, spending and net per month. Transfers between
+// accounts are neither.
+func (h *Handler) GetCashFlow(w http.ResponseWriter, r *http.Request) {
+    filter, err := parseStatsFilter(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    
+    flow, err := h.expenseRepo.GetCashFlow(r.Context(), filter)
+    if err != nil {
+        writeStatsError(w, err)
+        return
+    }
+    
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(flow)
+}
+
+func writeStatsError(w http.ResponseWriter, err error) {
+    switch {
+    case err == repository.ErrInvalidDateRange, errors.Is(err, repository.ErrInvalidTransaction),
+        errors.Is(err, repository.ErrInvalidCustomField):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, repository.ErrCategoryNotFound):
+        http.Error(w, err.Error(), http.StatusNotFound)
+    default:
+        writeServerError(w, err)
+    }
+}
+
+// parseStatsFilter reads the stats query parameters. depth rolls
+// subcategories up to that level, parent drills down into one category,
+// type picks the transaction type reported and group_by names a custom field
+// to total by.
+func parseStatsFilter(r *http.Request) (models.StatsFilter, error) {
+    query := r.URL.Query()
+    filter := models.StatsFilter{
+        StartDate: query.Get("start_date"),
+        EndDate:   query.Get("end_date"),
+        Category:  query.Get("category"),
+        Parent:    query.Get("parent"),
+        Type:      query.Get("type"),
+        Fields:    fieldFilters(query),
+        GroupBy:   query.Get("group_by"),
+    }
+    
+    if depth := query.Get("depth"); depth != "" {
+        d, err := strconv.Atoi(depth)
+        if err != nil || d < 0 {
+            return filter, errors.New("depth must be a whole number of levels, 0 for no rollup")
+        }
+        filter.Depth = d
+    }
+    
+    for name, target := range map[string]*bool{
+        "exclude_reimbursed":   &filter.ExcludeReimbursed,
+        "exclude_reimbursable": &filter.ExcludeReimbursable,
+    } {
+        if value := query.Get(name); value != "" {
+            exclude, err := strconv.ParseBool(value)
+            if err != nil {
+                return filter, fmt.Errorf("%s must be true or false", name)
+            }
+            *target = exclude
+        }
+    }
+    
+    return filter, nil
+}
+
 func (h *Handler) CreateExpense(w http.ResponseWriter, r *http.Request) {
     var expense models.Expense
     if err := json.NewDecoder(r.Body).Decode(&expense); err != nil {
@@ -110,8 +384,17 @@ func (h *Handler) CreateExpense(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    if err := h.expenseRepo.Create(&expense); err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+    if err := h.applyMerchants(r.Context(), &expense); err != nil {
+        writeServerError(w, err)
+        return
+    }
+    
+    if err := h.expenseRepo.Create(r.Context(), &expense); err != nil {
+        if isInvalidExpense(err) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        writeServerError(w, err)
         return
     }
     
@@ -137,13 +420,17 @@ func (h *Handler) ConfirmImport(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    savedExpenses, err := h.expenseRepo.BulkInsert(expenses)
+    savedExpenses, err := h.expenseRepo.BulkInsert(r.Context(), expenses)
     if err != nil {
-        http.Error(w, "Failed to import expenses: "+err.Error(), http.StatusInternalServerError)
+        if isInvalidExpense(err) {
+            http.Error(w, "Failed to import expenses: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        writeServerError(w, fmt.Errorf("failed to import expenses: %w", err))
         return
     }
     
-    var totalAmount float64
+    var totalAmount models.Money
     for _, expense := range savedExpenses {
         totalAmount += expense.Amount
     }
@@ -160,6 +447,30 @@ func (h *Handler) ConfirmImport(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(response)
 }
 
+// GetExpense returns one expense with its version as the ETag, to send back
+// in If-Match when updating or deleting it.
+func (h *Handler) GetExpense(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+        return
+    }
+    
+    expense, err := h.expenseRepo.GetByID(r.Context(), id)
+    if err != nil {
+        if err == repository.ErrExpenseNotFound {
+            http.Error(w, "Expense not found", http.StatusNotFound)
+            return
+        }
+        writeServerError(w, err)
+        return
+    }
+    
+    w.Header().Set("ETag", expenseETag(expense.Version))
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(expense)
+}
+
 func (h *Handler) UpdateExpense(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     idStr := vars["id"]
@@ -176,22 +487,44 @@ func (h *Handler) UpdateExpense(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    if err := h.expenseRepo.Update(id, &expense); err != nil {
+    if err := h.applyMerchants(r.Context(), &expense); err != nil {
+        writeServerError(w, err)
+        return
+    }
+    
+    version, err := h.expectedVersion(r, id)
+    if err == nil {
+        err = h.expenseRepo.Update(r.Context(), id, &expense, version)
+    }
+    if err != nil {
         if err == repository.ErrExpenseNotFound {
             http.Error(w, "Expense not found", http.StatusNotFound)
             return
         }
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        if err == repository.ErrVersionConflict {
+            h.writeVersionConflict(w, r, id)
+            return
+        }
+        if err == repository.ErrClaimLocked {
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        }
+        if isInvalidExpense(err) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        writeServerError(w, err)
         return
     }
     
     // Return updated expense
-    updatedExpense, err := h.expenseRepo.GetByID(id)
+    updatedExpense, err := h.expenseRepo.GetByID(r.Context(), id)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeServerError(w, err)
         return
     }
     
+    w.Header().Set("ETag", expenseETag(updatedExpense.Version))
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(updatedExpense)
 }
@@ -206,18 +539,102 @@ func (h *Handler) DeleteExpense(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    if err := h.expenseRepo.Delete(id); err != nil {
+    version, err := h.expectedVersion(r, id)
+    if err == nil {
+        err = h.expenseRepo.Delete(r.Context(), id, version)
+    }
+    if err != nil {
         if err == repository.ErrExpenseNotFound {
             http.Error(w, "Expense not found", http.StatusNotFound)
             return
         }
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        if err == repository.ErrVersionConflict {
+            h.writeVersionConflict(w, r, id)
+            return
+        }
+        if err == repository.ErrClaimLocked {
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        }
+        writeServerError(w, err)
         return
     }
     
     w.WriteHeader(http.StatusNoContent)
 }
 
+func expenseETag(version int) string {
+    return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersions reads the versions a client expects from If-Match.
+// wildcard is true when the header is missing or "*". If-Match compares tags
+// strongly, so weak tags never match and are dropped, as are tags this server
+// did not issue; a header left with no versions matches nothing.
+func ifMatchVersions(r *http.Request) (versions []int, wildcard bool) {
+    value := strings.TrimSpace(strings.Join(r.Header.Values("If-Match"), ","))
+    if value == "" || value == "*" {
+        return nil, true
+    }
+    for _, tag := range strings.Split(value, ",") {
+        tag = strings.TrimSpace(tag)
+        if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+            continue
+        }
+        if version, err := strconv.Atoi(tag[1 : len(tag)-1]); err == nil && version > 0 {
+            versions = append(versions, version)
+        }
+    }
+    return versions, false
+}
+
+// expectedVersion turns If-Match into the version the repository checks
+// before changing an expense: 0 for any, or -1 when no listed tag can match.
+// Of several listed tags only the current one can match, so it is looked up;
+// the repository still checks it atomically with the change.
+func (h *Handler) expectedVersion(r *http.Request, id int) (int, error) {
+    versions, wildcard := ifMatchVersions(r)
+    switch {
+    case wildcard:
+        return 0, nil
+    case len(versions) == 0:
+        return -1, nil
+    case len(versions) == 1:
+        return versions[0], nil
+    }
+    
+    current, err := h.expenseRepo.GetByID(r.Context(), id)
+    if err != nil {
+        return 0, err
+    }
+    if slices.Contains(versions, current.Version) {
+        return current.Version, nil
+    }
+    return -1, nil
+}
+
+// writeVersionConflict answers a failed If-Match with 412 and the expense as
+// it is now, so the client can offer to merge its edit into it.
+func (h *Handler) writeVersionConflict(w http.ResponseWriter, r *http.Request, id int) {
+    current, err := h.expenseRepo.GetByID(r.Context(), id)
+    if err != nil {
+        if err == repository.ErrExpenseNotFound {
+            http.Error(w, "Expense not found", http.StatusNotFound)
+            return
+        }
+        writeServerError(w, err)
+        return
+    }
+    
+    w.Header().Set("ETag", expenseETag(current.Version))
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusPreconditionFailed)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "error":   repository.ErrVersionConflict.Error(),
+        "current": current,
+    })
+}
+
 func (h *Handler) IndexPage(w http.ResponseWriter, r *http.Request) {
     // TODO: Serve the index.html template
     http.ServeFile(w, r, "./web/templates/index.html")
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestServer routes the single-expense endpoints to a handler on an
+// empty database.
+func newTestServer(t *testing.T) (*Handler, http.Handler) {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "expenses.db"), database.DefaultOptions())
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	h := New(db, Options{})
+	router := mux.NewRouter()
+	router.HandleFunc("/api/expenses/{id}", h.GetExpense).Methods("GET")
+	router.HandleFunc("/api/expenses/{id}", h.UpdateExpense).Methods("PUT")
+	router.HandleFunc("/api/expenses/{id}", h.DeleteExpense).Methods("DELETE")
+	return h, router
+}
+
+func createExpense(t *testing.T, h *Handler) models.Expense {
+	t.Helper()
+	expense := models.Expense{
+		Date:          time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Category:      "Other",
+		Description:   "Lunch",
+		Amount:        1250,
+		Vendor:        "Cafe",
+		PaymentMethod: "Card",
+	}
+	if err := h.expenseRepo.Create(t.Context(), &expense); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return expense
+}
+
+func serve(router http.Handler, method, path, ifMatch string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWriteServerError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"database error", errors.New("database is locked"), http.StatusInternalServerError},
+		{"timeout", fmt.Errorf("get expense: %w", repository.ErrTimeout), http.StatusGatewayTimeout},
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{"canceled", fmt.Errorf("list expenses: %w", context.Canceled), http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeServerError(rec, tt.err)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpenseETag(t *testing.T) {
+	h, router := newTestServer(t)
+	expense := createExpense(t, h)
+	path := "/api/expenses/" + strconv.Itoa(expense.ID)
+
+	rec := serve(router, "GET", path, "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("GET = %d with ETag %q, want 200 with \"1\"", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	expense.Description = "Team lunch"
+	rec = serve(router, "PUT", path, `"1"`, expense)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("PUT with current ETag = %d with ETag %q: %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+	}
+
+	// A second tab still holding version 1 is refused and shown the
+	// current expense
+	expense.Description = "Stale edit"
+	rec = serve(router, "PUT", path, `"1"`, expense)
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("PUT with stale ETag = %d with ETag %q, want 412 with \"2\"", rec.Code, rec.Header().Get("ETag"))
+	}
+	var conflict struct {
+		Error   string         `json:"error"`
+		Current models.Expense `json:"current"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&conflict); err != nil {
+		t.Fatalf("decode 412 body: %v", err)
+	}
+	if conflict.Error != repository.ErrVersionConflict.Error() ||
+		conflict.Current.Description != "Team lunch" || conflict.Current.Version != 2 {
+		t.Errorf("412 body = %+v", conflict)
+	}
+
+	rec = serve(router, "DELETE", path, `"1"`, nil)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with stale ETag = %d, want 412", rec.Code)
+	}
+	rec = serve(router, "DELETE", "/api/expenses/999", `"1"`, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of missing expense = %d, want 404", rec.Code)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		{"missing", "", http.StatusOK},
+		{"wildcard", "*", http.StatusOK},
+		{"current", `"2"`, http.StatusOK},
+		{"stale", `"1"`, http.StatusPreconditionFailed},
+		{"list with current", `"1", "2"`, http.StatusOK},
+		{"list with current unspaced", `"2","3"`, http.StatusOK},
+		{"list without current", `"1", "3"`, http.StatusPreconditionFailed},
+		{"weak current", `W/"2"`, http.StatusPreconditionFailed},
+		{"weak and strong", `W/"1", "2"`, http.StatusOK},
+		{"not issued here", `"abc"`, http.StatusPreconditionFailed},
+		{"unquoted", `2`, http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, router := newTestServer(t)
+			expense := createExpense(t, h)
+			expense.Description = "Team lunch"
+			if err := h.expenseRepo.Update(t.Context(), expense.ID, &expense, 0); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			path := "/api/expenses/" + strconv.Itoa(expense.ID)
+
+			expense.Description = "Edited"
+			if rec := serve(router, "PUT", path, tt.ifMatch, expense); rec.Code != tt.want {
+				t.Errorf("PUT = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	t.Run("delete with list", func(t *testing.T) {
+		h, router := newTestServer(t)
+		expense := createExpense(t, h)
+		path := "/api/expenses/" + strconv.Itoa(expense.ID)
+
+		if rec := serve(router, "DELETE", path, `"5", "1"`, nil); rec.Code != http.StatusNoContent {
+			t.Errorf("DELETE = %d, want 204: %s", rec.Code, rec.Body)
+		}
+	})
+}
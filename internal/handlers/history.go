@@ -0,0 +1,77 @@
+// internal/handlers/history.go
+package handlers
+
+import (
+	"encoding/json"
+	"expense-tracker/internal/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) GetExpenseHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.expenseRepo.GetHistory(r.Context(), id)
+	if err != nil {
+		if err == repository.ErrExpenseNotFound {
+			http.Error(w, "Expense not found", http.StatusNotFound)
+			return
+		}
+		writeServerError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"expense_id": id,
+		"history":    history,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) RevertExpense(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Version <= 0 {
+		http.Error(w, "version must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	reverted, err := h.expenseRepo.RevertToVersion(r.Context(), id, request.Version)
+	if err != nil {
+		switch {
+		case err == repository.ErrExpenseNotFound:
+			http.Error(w, "Expense not found", http.StatusNotFound)
+		case err == repository.ErrVersionNotFound:
+			http.Error(w, "Version not found", http.StatusNotFound)
+		case isInvalidExpense(err), err == repository.ErrClaimLocked:
+			// The version refers to something that has changed since,
+			// such as a deleted account
+			http.Error(w, "Cannot revert to this version: "+err.Error(), http.StatusConflict)
+		default:
+			writeServerError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reverted)
+}
@@ -2,8 +2,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"expense-tracker/internal/currency"
+	"expense-tracker/internal/database"
 	"expense-tracker/internal/models"
 	"expense-tracker/internal/repository"
 	"fmt"
@@ -15,6 +18,12 @@ import (
 	"time"
 )
 
+// Bounds accepted by parseAmount for a single imported transaction.
+const (
+	MinImportAmount = models.MinAmount
+	MaxImportAmount = models.MaxAmount
+)
+
 func (h *Handler) ImportFromCSV(w http.ResponseWriter, r *http.Request) {
 	log.Printf("ImportFromCSV: Received %s request", r.Method)
 	
@@ -52,8 +61,52 @@ func (h *Handler) ImportFromCSV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// A statement imported into an account puts every row on that account
+	var statementAccount *models.Account
+	if accountStr := r.FormValue("account_id"); accountStr != "" {
+		accountID, err := strconv.Atoi(accountStr)
+		if err != nil {
+			http.Error(w, "Invalid account_id", http.StatusBadRequest)
+			return
+		}
+		if statementAccount, err = h.accountRepo.GetByID(r.Context(), accountID); err != nil {
+			writeAccountError(w, err)
+			return
+		}
+	}
+	
+	// Amounts without a currency marker default to the form's currency,
+	// falling back to the account's and then the base currency
+	defaultCurrency := r.FormValue("currency")
+	if defaultCurrency == "" && statementAccount != nil {
+		defaultCurrency = statementAccount.Currency
+	}
+	if defaultCurrency == "" {
+		defaultCurrency, err = h.settingsRepo.BaseCurrency(r.Context())
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+	}
+	defaultCurrency, err = currency.NormalizeCode(defaultCurrency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	fields, err := h.customFieldRepo.GetAll(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	merchants, err := h.merchantRepo.Directory(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	
 	// Parse the CSV
-	expenses, err := h.parseCSV(file)
+	expenses, err := h.parseCSV(r.Context(), file, defaultCurrency, fields, merchants)
 	if err != nil {
 		log.Printf("ImportFromCSV: Failed to parse CSV: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to parse CSV: %v", err), http.StatusBadRequest)
@@ -66,8 +119,24 @@ func (h *Handler) ImportFromCSV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Tags from the form, such as a trip name, go on every row
+	formTags := splitTags([]string{r.FormValue("tags")})
+	for i := range expenses {
+		tags, err := repository.NormalizeTags(append(expenses[i].Tags, formTags...))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		expenses[i].Tags = tags
+	}
+	
+	if err := h.assignAccounts(r.Context(), expenses, statementAccount); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	
 	// Check for duplicates
-	duplicateInfos, err := h.expenseRepo.CheckForDuplicates(expenses)
+	duplicateInfos, err := h.expenseRepo.CheckForDuplicates(r.Context(), expenses)
 	if err != nil {
 		log.Printf("ImportFromCSV: Failed to check duplicates: %v", err)
 		// Continue without duplicate info rather than failing the import
@@ -106,7 +175,7 @@ func (h *Handler) ImportFromCSV(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *Handler) parseCSV(file io.Reader) ([]models.Expense, error) {
+func (h *Handler) parseCSV(ctx context.Context, file io.Reader, defaultCurrency string, fields []models.CustomField, merchants *repository.MerchantDirectory) ([]models.Expense, error) {
 	reader := csv.NewReader(file)
 	
 	// Read header row
@@ -151,7 +220,7 @@ func (h *Handler) parseCSV(file io.Reader) ([]models.Expense, error) {
 		}
 		
 		// Parse expense from record
-		expense, err := h.parseExpenseFromRecord(record, headerMap, lineNum)
+		expense, err := h.parseExpenseFromRecord(ctx, record, headerMap, lineNum, defaultCurrency, fields, merchants)
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("line %d: %v", lineNum, err))
 			continue
@@ -173,7 +242,7 @@ func (h *Handler) parseCSV(file io.Reader) ([]models.Expense, error) {
 	return expenses, nil
 }
 
-func (h *Handler) parseExpenseFromRecord(record []string, headerMap map[string]int, lineNum int) (models.Expense, error) {
+func (h *Handler) parseExpenseFromRecord(ctx context.Context, record []string, headerMap map[string]int, lineNum int, defaultCurrency string, fields []models.CustomField, merchants *repository.MerchantDirectory) (models.Expense, error) {
 	var expense models.Expense
 	
 	// Parse required fields
@@ -202,17 +271,32 @@ func (h *Handler) parseExpenseFromRecord(record []string, headerMap map[string]i
 		return expense, fmt.Errorf("empty amount")
 	}
 	
-	amount, err := h.parseAmount(amountStr)
+	amount, detectedCurrency, err := h.parseAmount(amountStr)
 	if err != nil {
 		return expense, fmt.Errorf("invalid amount '%s': %v", amountStr, err)
 	}
 	expense.Amount = amount
 	
+	// An explicit CURRENCY column wins over a symbol in the amount
+	expense.Currency = defaultCurrency
+	if detectedCurrency != "" {
+		expense.Currency = detectedCurrency
+	}
+	if currencyStr := strings.TrimSpace(h.getFieldValue(record, headerMap, "CURRENCY")); currencyStr != "" {
+		code, err := currency.NormalizeCode(currencyStr)
+		if err != nil {
+			return expense, err
+		}
+		expense.Currency = code
+	}
+	
 	// Optional fields
 	location := strings.TrimSpace(h.getFieldValue(record, headerMap, "LOCATION"))
 	if location != "" {
 		expense.Vendor = location
 	}
+	// Raw statement strings become the merchant's canonical name
+	merchants.Apply(&expense)
 	
 	creditCard := strings.TrimSpace(h.getFieldValue(record, headerMap, "CREDIT_CARD"))
 	if creditCard != "" {
@@ -221,8 +305,38 @@ func (h *Handler) parseExpenseFromRecord(record []string, headerMap map[string]i
 		expense.PaymentMethod = "CSV Import"
 	}
 	
+	// TAGS lists several tags separated by commas or semicolons
+	expense.Tags = append([]string{}, splitTags([]string{h.getFieldValue(record, headerMap, "TAGS")})...)
+
+	// TYPE marks income rows; a statement row cannot say where a transfer
+	// went, so transfers are entered by hand
+	switch txType := strings.ToLower(strings.TrimSpace(h.getFieldValue(record, headerMap, "TYPE"))); txType {
+	case "", models.TransactionExpense:
+		expense.Type = models.TransactionExpense
+	case models.TransactionIncome:
+		expense.Type = models.TransactionIncome
+	default:
+		return expense, fmt.Errorf("invalid type '%s': must be expense or income", txType)
+	}
+
+	// Custom fields are read from a column named after the field or its
+	// label
+	values := models.FieldValues{}
+	for _, field := range fields {
+		column := strings.ToUpper(field.Name)
+		if _, exists := headerMap[column]; !exists {
+			column = strings.ToUpper(field.Label)
+		}
+		values[field.Name] = h.getFieldValue(record, headerMap, column)
+	}
+	fieldValues, err := repository.NormalizeFieldValues(fields, values)
+	if err != nil {
+		return expense, err
+	}
+	expense.Fields = fieldValues
+
 	// Set defaults
-	expense.Category = h.categorizeExpense(description)
+	expense.Category = h.categorizeExpense(ctx, description)
 	
 	return expense, nil
 }
@@ -265,47 +379,53 @@ func (h *Handler) parseDate(dateStr string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("unsupported date format. Tried formats: %s", strings.Join(attemptedFormats, ", "))
 }
 
-func (h *Handler) parseAmount(amountStr string) (float64, error) {
-	// Remove common currency symbols and whitespace
-	amountStr = strings.TrimSpace(amountStr)
-	amountStr = strings.ReplaceAll(amountStr, "$", "")
+// parseAmount returns the amount and the currency implied by any symbol or
+// ISO code around it. The currency is empty for bare numbers and "$".
+func (h *Handler) parseAmount(amountStr string) (models.Money, string, error) {
+	// Separate currency markers, then remove thousands separators
+	amountStr, code := currency.SplitAmount(amountStr)
 	amountStr = strings.ReplaceAll(amountStr, ",", "")
 	amountStr = strings.TrimSpace(amountStr)
 	
 	if amountStr == "" {
-		return 0, fmt.Errorf("empty amount after cleaning")
+		return 0, "", fmt.Errorf("empty amount after cleaning")
 	}
 	
-	// Parse the amount
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	// Parse the amount as exact cents
+	amount, err := models.ParseMoney(amountStr)
 	if err != nil {
-		return 0, fmt.Errorf("invalid number format: %v", err)
+		return 0, "", fmt.Errorf("invalid number format: %v", err)
 	}
 	
 	// Validate reasonable range (allow negative amounts for refunds)
-	if amount < -999999.99 {
-		return 0, fmt.Errorf("amount too small (below -999999.99)")
+	if amount < MinImportAmount {
+		return 0, "", fmt.Errorf("amount too small (below %s)", MinImportAmount)
 	}
 	
-	if amount > 999999.99 {
-		return 0, fmt.Errorf("amount too large")
+	if amount > MaxImportAmount {
+		return 0, "", fmt.Errorf("amount too large")
 	}
 	
-	return amount, nil
+	return amount, code, nil
 }
 
-func (h *Handler) categorizeExpense(description string) string {
+func (h *Handler) categorizeExpense(ctx context.Context, description string) string {
 	// Query categorization rules from database
 	query := `
-		SELECT category, keyword, case_sensitive
+		SELECT categories.name, keyword, case_sensitive
 		FROM categorization_rules
-		ORDER BY category, keyword
+		JOIN categories ON categories.id = categorization_rules.category_id
+		WHERE categories.archived = FALSE
+		ORDER BY categories.name, keyword
 	`
 	
-	rows, err := h.db.Query(query)
+	q, cancel := h.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	
+	rows, err := q.Query(query)
 	if err != nil {
 		// Fallback to "Other" if database query fails
-		return "Other"
+		return repository.DefaultCategory
 	}
 	defer rows.Close()
 	
@@ -333,5 +453,35 @@ func (h *Handler) categorizeExpense(description string) string {
 		}
 	}
 	
-	return "Other"
-}
\ No newline at end of file
+	return repository.DefaultCategory
+}
+// assignAccounts puts imported rows on an account: the statement's account
+// when one was chosen, or else the account named in the row's card column,
+// provided it is in the row's currency.
+func (h *Handler) assignAccounts(ctx context.Context, expenses []models.Expense, statement *models.Account) error {
+	if statement != nil {
+		for i := range expenses {
+			id := statement.ID
+			expenses[i].AccountID = &id
+			expenses[i].Account = statement.Name
+		}
+		return nil
+	}
+
+	accounts, err := h.accountRepo.GetAll(ctx)
+	if err != nil || len(accounts) == 0 {
+		return err
+	}
+	for i := range expenses {
+		for _, account := range accounts {
+			if strings.EqualFold(strings.TrimSpace(expenses[i].PaymentMethod), account.Name) &&
+				expenses[i].Currency == account.Currency {
+				id := account.ID
+				expenses[i].AccountID = &id
+				expenses[i].Account = account.Name
+				break
+			}
+		}
+	}
+	return nil
+}
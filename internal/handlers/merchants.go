@@ -0,0 +1,188 @@
+// internal/handlers/merchants.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetMerchants lists the merchant directory by name.
+func (h *Handler) GetMerchants(w http.ResponseWriter, r *http.Request) {
+	merchants, err := h.merchantRepo.GetAll(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(merchants)
+}
+
+func (h *Handler) GetMerchant(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid merchant ID", http.StatusBadRequest)
+		return
+	}
+
+	merchant, err := h.merchantRepo.GetByID(r.Context(), id)
+	if err != nil {
+		writeMerchantError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(merchant)
+}
+
+func (h *Handler) CreateMerchant(w http.ResponseWriter, r *http.Request) {
+	var merchant models.Merchant
+	if err := json.NewDecoder(r.Body).Decode(&merchant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.merchantRepo.Create(r.Context(), &merchant); err != nil {
+		writeMerchantError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// UpdateMerchant renames a merchant, along with the vendor of its expenses,
+// and replaces its aliases if they are given.
+func (h *Handler) UpdateMerchant(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid merchant ID", http.StatusBadRequest)
+		return
+	}
+
+	var merchant models.Merchant
+	if err := json.NewDecoder(r.Body).Decode(&merchant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.merchantRepo.Update(r.Context(), id, &merchant); err != nil {
+		writeMerchantError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(merchant)
+}
+
+func (h *Handler) DeleteMerchant(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid merchant ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.merchantRepo.Delete(r.Context(), id); err != nil {
+		writeMerchantError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MergeMerchant folds the merchant into the one given as {"into": id}. Its
+// name and aliases move to that merchant and its expenses are renamed.
+func (h *Handler) MergeMerchant(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid merchant ID", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Into int `json:"into"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Into == 0 {
+		http.Error(w, "Request must name the merchant to merge into", http.StatusBadRequest)
+		return
+	}
+
+	merchant, renamed, err := h.merchantRepo.Merge(r.Context(), id, request.Into)
+	if err != nil {
+		writeMerchantError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"merchant": merchant,
+		"renamed":  renamed,
+	})
+}
+
+// ReprocessMerchants matches the vendor of every expense against the
+// directory again, for example after adding aliases.
+func (h *Handler) ReprocessMerchants(w http.ResponseWriter, r *http.Request) {
+	directory, err := h.merchantRepo.Directory(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	expenses, _, err := h.expenseRepo.GetAll(r.Context(), models.ExpenseFilter{}, 1, 0)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	vendors := make(map[int]string)
+	for _, expense := range expenses {
+		if directory.Apply(&expense) {
+			vendors[expense.ID] = expense.Vendor
+		}
+	}
+
+	updated, err := h.expenseRepo.SetVendors(r.Context(), vendors, models.SourceMerchants)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"checked": len(expenses),
+		"updated": updated,
+	})
+}
+
+// applyMerchants replaces a submitted vendor with its merchant name.
+func (h *Handler) applyMerchants(ctx context.Context, expense *models.Expense) error {
+	directory, err := h.merchantRepo.Directory(ctx)
+	if err != nil {
+		return err
+	}
+	directory.Apply(expense)
+	return nil
+}
+
+func writeMerchantError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrMerchantNotFound):
+		http.Error(w, "Merchant not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidMerchant):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrMerchantExists),
+		errors.Is(err, repository.ErrAliasExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeServerError(w, err)
+	}
+}
@@ -0,0 +1,41 @@
+// internal/handlers/settings.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/currency"
+	"expense-tracker/internal/models"
+	"net/http"
+)
+
+func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.settingsRepo.Get(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *Handler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var settings models.Settings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.settingsRepo.Update(r.Context(), &settings); err != nil {
+		if errors.Is(err, currency.ErrInvalidCode) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
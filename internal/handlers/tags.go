@@ -0,0 +1,75 @@
+// internal/handlers/tags.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"expense-tracker/internal/repository"
+	"net/http"
+	"strings"
+)
+
+// GetTags lists the tags in use with how many expenses carry each.
+func (h *Handler) GetTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.tagRepo.GetAll(r.Context())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+type tagExpensesRequest struct {
+	ExpenseIDs []int    `json:"expense_ids"`
+	Add        []string `json:"add"`
+	Remove     []string `json:"remove"`
+}
+
+// TagExpenses adds and removes tags on several expenses at once.
+func (h *Handler) TagExpenses(w http.ResponseWriter, r *http.Request) {
+	var req tagExpensesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.ExpenseIDs) == 0 {
+		http.Error(w, "expense_ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		http.Error(w, "nothing to add or remove", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := h.expenseRepo.UpdateTags(r.Context(), req.ExpenseIDs, req.Add, req.Remove)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrExpenseNotFound):
+			http.Error(w, "Expense not found", http.StatusNotFound)
+		case errors.Is(err, repository.ErrInvalidTag):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeServerError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"updated": updated})
+}
+
+// splitTags reads tags from query values or CSV cells, which list several
+// tags separated by commas or semicolons.
+func splitTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
@@ -0,0 +1,106 @@
+// internal/handlers/trash.go
+package handlers
+
+import (
+	"encoding/json"
+	"expense-tracker/internal/repository"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	page := 1
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	expenses, pagination, err := h.expenseRepo.GetTrash(r.Context(), page, limit)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"expenses":   expenses,
+		"pagination": pagination,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) RestoreExpense(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.expenseRepo.Restore(r.Context(), id); err != nil {
+		if err == repository.ErrExpenseNotFound {
+			http.Error(w, "Expense not found in trash", http.StatusNotFound)
+			return
+		}
+		writeServerError(w, err)
+		return
+	}
+
+	restored, err := h.expenseRepo.GetByID(r.Context(), id)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restored)
+}
+
+func (h *Handler) PurgeExpense(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.expenseRepo.Purge(r.Context(), id); err != nil {
+		if err == repository.ErrExpenseNotFound {
+			http.Error(w, "Expense not found in trash", http.StatusNotFound)
+			return
+		}
+		writeServerError(w, err)
+		return
+	}
+	h.sweepAttachments(r.Context())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EmptyTrash permanently removes everything currently in the trash.
+func (h *Handler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	purged, err := h.expenseRepo.PurgeDeletedBefore(r.Context(), time.Now().Add(time.Second))
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	if purged > 0 {
+		h.sweepAttachments(r.Context())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"purged":  purged,
+	})
+}
@@ -0,0 +1,396 @@
+// Package integrity checks the database file and the expense data for
+// problems that the application would otherwise hide or trip over.
+package integrity
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Check statuses.
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Report is the machine-readable result of a check run.
+type Report struct {
+	CheckedAt    time.Time     `json:"checked_at"`
+	Backend      string        `json:"backend"`
+	OK           bool          `json:"ok"`
+	FixesApplied bool          `json:"fixes_applied"`
+	Issues       int           `json:"issues"`
+	Fixed        int           `json:"fixed"`
+	Checks       []CheckResult `json:"checks"`
+}
+
+// CheckResult is the outcome of one check. Status is failed while any of its
+// issues remain unfixed.
+type CheckResult struct {
+	Name   string  `json:"name"`
+	Status string  `json:"status"`
+	Detail string  `json:"detail,omitempty"`
+	Issues []Issue `json:"issues"`
+}
+
+// Issue is a single problem. Fix describes the safe fix when there is one;
+// Fixed reports whether it was applied in this run.
+type Issue struct {
+	ExpenseID int    `json:"expense_id,omitempty"`
+	Field     string `json:"field,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Problem   string `json:"problem"`
+	Fix       string `json:"fix,omitempty"`
+	Fixed     bool   `json:"fixed"`
+}
+
+// Options control a check run.
+type Options struct {
+	// Fix applies the safe fixes. Only expenses whose category no longer
+	// exists are fixed; everything else is reported for a person to decide.
+	Fix bool
+}
+
+// Check runs every check against db and returns the report. Each check has
+// the report timeout; ctx cancels the run.
+func Check(ctx context.Context, db *database.DB, opts Options) (*Report, error) {
+	report := &Report{
+		CheckedAt: time.Now().UTC(),
+		Backend:   string(db.Dialect()),
+	}
+
+	for _, check := range []func(context.Context, *database.DB) (CheckResult, error){checkIntegrity, checkForeignKeys} {
+		result, err := check(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		report.add(result)
+	}
+
+	dates, err := checkDates(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	report.add(dates)
+
+	amounts, err := checkAmounts(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	report.add(amounts)
+
+	// Expenses with a broken date are left alone: loading them for a
+	// category fix would read the date back as zero and save that instead.
+	badDates := make(map[int]bool)
+	for _, issue := range dates.Issues {
+		badDates[issue.ExpenseID] = true
+	}
+
+	categories, err := checkCategories(ctx, db, opts.Fix, badDates)
+	if err != nil {
+		return nil, err
+	}
+	report.add(categories)
+
+	report.FixesApplied = opts.Fix
+	report.OK = true
+	for _, c := range report.Checks {
+		for _, issue := range c.Issues {
+			if issue.Fixed {
+				report.Fixed++
+			} else {
+				report.Issues++
+				report.OK = false
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (r *Report) add(result CheckResult) {
+	if result.Issues == nil {
+		result.Issues = []Issue{}
+	}
+	if result.Status == "" {
+		result.Status = StatusOK
+		for _, issue := range result.Issues {
+			if !issue.Fixed {
+				result.Status = StatusFailed
+				break
+			}
+		}
+	}
+	r.Checks = append(r.Checks, result)
+}
+
+func checkIntegrity(ctx context.Context, db *database.DB) (CheckResult, error) {
+	q, cancel := db.WithContext(ctx, database.OpReport)
+	defer cancel()
+	result := CheckResult{Name: "integrity_check"}
+	if db.Dialect() != database.SQLite {
+		result.Status = StatusSkipped
+		result.Detail = "only available on SQLite"
+		return result, nil
+	}
+
+	rows, err := q.Query("PRAGMA integrity_check")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			return result, err
+		}
+		if message != "ok" {
+			result.Issues = append(result.Issues, Issue{Problem: message})
+		}
+	}
+	return result, rows.Err()
+}
+
+func checkForeignKeys(ctx context.Context, db *database.DB) (CheckResult, error) {
+	q, cancel := db.WithContext(ctx, database.OpReport)
+	defer cancel()
+	result := CheckResult{Name: "foreign_key_check"}
+	if db.Dialect() != database.SQLite {
+		result.Status = StatusSkipped
+		result.Detail = "PostgreSQL enforces foreign keys on write"
+		return result, nil
+	}
+
+	rows, err := q.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return result, err
+		}
+		result.Issues = append(result.Issues, Issue{
+			Field:   table,
+			Value:   fmt.Sprint(rowid.Int64),
+			Problem: fmt.Sprintf("row %d in %s references a missing %s row", rowid.Int64, table, parent),
+		})
+	}
+	return result, rows.Err()
+}
+
+// checkDates finds dates the driver cannot read. go-sqlite3 silently turns an
+// unparseable TIMESTAMP into the zero time, so the raw text is inspected.
+func checkDates(ctx context.Context, db *database.DB) (CheckResult, error) {
+	q, cancel := db.WithContext(ctx, database.OpReport)
+	defer cancel()
+	result := CheckResult{Name: "dates"}
+
+	if db.Dialect() != database.SQLite {
+		rows, err := q.Query("SELECT id, date FROM expenses WHERE deleted_at IS NULL ORDER BY id")
+		if err != nil {
+			return result, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			var date sql.NullTime
+			if err := rows.Scan(&id, &date); err != nil {
+				return result, err
+			}
+			if !date.Valid || date.Time.Year() <= 1 {
+				result.Issues = append(result.Issues, Issue{ExpenseID: id, Field: "date", Problem: "missing or zero date"})
+			}
+		}
+		return result, rows.Err()
+	}
+
+	rows, err := q.Query("SELECT id, typeof(date), CAST(date AS TEXT) FROM expenses WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var kind string
+		var raw sql.NullString
+		if err := rows.Scan(&id, &kind, &raw); err != nil {
+			return result, err
+		}
+
+		issue := Issue{ExpenseID: id, Field: "date", Value: raw.String}
+		switch kind {
+		case "null":
+			issue.Problem = "missing date"
+		case "text":
+			parsed, ok := parseSQLiteTimestamp(raw.String)
+			if !ok {
+				issue.Problem = "unparseable date"
+			} else if parsed.Year() <= 1 {
+				issue.Problem = "zero date"
+			}
+		case "integer", "real":
+			if raw.String == "0" {
+				issue.Problem = "zero date"
+			}
+		default:
+			issue.Problem = "date stored as " + kind
+		}
+
+		if issue.Problem != "" {
+			result.Issues = append(result.Issues, issue)
+		}
+	}
+	return result, rows.Err()
+}
+
+// parseSQLiteTimestamp mirrors how go-sqlite3 reads TIMESTAMP text.
+func parseSQLiteTimestamp(s string) (time.Time, bool) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, s, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func checkAmounts(ctx context.Context, db *database.DB) (CheckResult, error) {
+	q, cancel := db.WithContext(ctx, database.OpReport)
+	defer cancel()
+	result := CheckResult{
+		Name:   "amounts",
+		Detail: fmt.Sprintf("allowed range %s to %s", models.MinAmount, models.MaxAmount),
+	}
+
+	rows, err := q.Query(`
+        SELECT id, amount_cents FROM expenses
+        WHERE deleted_at IS NULL AND (amount_cents < ? OR amount_cents > ?)
+        ORDER BY id
+    `, models.MinAmount, models.MaxAmount)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var amount models.Money
+		if err := rows.Scan(&id, &amount); err != nil {
+			return result, err
+		}
+		result.Issues = append(result.Issues, Issue{
+			ExpenseID: id,
+			Field:     "amount",
+			Value:     amount.String(),
+			Problem:   "amount outside the allowed range",
+		})
+	}
+	return result, rows.Err()
+}
+
+// checkCategories reports expenses and rules whose category is missing from
+// the category list. Such rows can only appear while foreign keys are not
+// enforced. Expenses are moved to the default category; rules are reported.
+func checkCategories(ctx context.Context, db *database.DB, fix bool, skip map[int]bool) (CheckResult, error) {
+	q, cancel := db.WithContext(ctx, database.OpReport)
+	defer cancel()
+	result := CheckResult{Name: "categories"}
+
+	rows, err := q.Query(`
+        SELECT expenses.id, expenses.category_id FROM expenses
+        LEFT JOIN categories ON categories.id = expenses.category_id
+        WHERE expenses.deleted_at IS NULL AND categories.id IS NULL
+        ORDER BY expenses.id
+    `)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	fixes := make(map[int]string)
+	fixIndex := make(map[int]int)
+	for rows.Next() {
+		var id, categoryID int
+		if err := rows.Scan(&id, &categoryID); err != nil {
+			return result, err
+		}
+
+		issue := Issue{
+			ExpenseID: id,
+			Field:     "category_id",
+			Value:     fmt.Sprint(categoryID),
+			Problem:   "category is not in the category list",
+		}
+		if skip[id] {
+			issue.Fix = fmt.Sprintf("set category to %q once the date is fixed", repository.DefaultCategory)
+		} else {
+			issue.Fix = fmt.Sprintf("set category to %q", repository.DefaultCategory)
+			fixes[id] = repository.DefaultCategory
+			fixIndex[id] = len(result.Issues)
+		}
+		result.Issues = append(result.Issues, issue)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	rows.Close()
+
+	rules, err := q.Query(`
+        SELECT categorization_rules.id, categorization_rules.category_id FROM categorization_rules
+        LEFT JOIN categories ON categories.id = categorization_rules.category_id
+        WHERE categories.id IS NULL
+        ORDER BY categorization_rules.id
+    `)
+	if err != nil {
+		return result, err
+	}
+	defer rules.Close()
+
+	for rules.Next() {
+		var id, categoryID int
+		if err := rules.Scan(&id, &categoryID); err != nil {
+			return result, err
+		}
+		result.Issues = append(result.Issues, Issue{
+			Field:   "categorization_rules.category_id",
+			Value:   fmt.Sprint(categoryID),
+			Problem: fmt.Sprintf("rule %d points at a missing category", id),
+		})
+	}
+	if err := rules.Err(); err != nil {
+		return result, err
+	}
+	rules.Close()
+
+	// Recategorize writes through the repository so every fix shows up in
+	// the expense's history and can be reverted
+	if fix && len(fixes) > 0 {
+		repo := repository.NewExpenseRepository(db)
+		if _, err := repo.Recategorize(ctx, fixes, models.SourceIntegrityFix); err != nil {
+			return result, err
+		}
+		for id := range fixes {
+			result.Issues[fixIndex[id]].Fixed = true
+		}
+	}
+
+	return result, nil
+}
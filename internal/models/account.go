@@ -0,0 +1,62 @@
+// internal/models/account.go
+package models
+
+import "time"
+
+// Account types.
+const (
+	AccountCreditCard = "credit_card"
+	AccountBank       = "bank"
+	AccountCash       = "cash"
+	AccountEWallet    = "e_wallet"
+)
+
+// AccountTypes lists the valid account types.
+var AccountTypes = []string{AccountCreditCard, AccountBank, AccountCash, AccountEWallet}
+
+// Account is where money for an expense came from. Every expense charged to
+// an account is in the account's currency.
+//
+// Balance is the opening balance plus income and transfers in, less
+// expenses and transfers out; ReconciledBalance only counts transactions
+// marked as reconciled, so it can be checked against a statement. Both are
+// computed and ignored on write.
+type Account struct {
+	ID                int       `json:"id"`
+	Name              string    `json:"name"`
+	Type              string    `json:"type"`
+	Currency          string    `json:"currency"`
+	OpeningBalance    Money     `json:"opening_balance"`
+	Balance           Money     `json:"balance"`
+	ReconciledBalance Money     `json:"reconciled_balance"`
+	ExpenseCount      int       `json:"expense_count"`
+	Unreconciled      int       `json:"unreconciled"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// AccountRegister is an account's expenses in date order with the running
+// balance after each. OpeningBalance is the balance before the first entry,
+// which includes everything dated before the requested range.
+type AccountRegister struct {
+	Account        Account        `json:"account"`
+	StartDate      string         `json:"start_date,omitempty"`
+	EndDate        string         `json:"end_date,omitempty"`
+	OpeningBalance Money          `json:"opening_balance"`
+	ClosingBalance Money          `json:"closing_balance"`
+	Entries        []AccountEntry `json:"entries"`
+}
+
+// AccountEntry is one line of an AccountRegister. Change is how the entry
+// moved the balance: negative for money leaving the account.
+type AccountEntry struct {
+	ExpenseID   int       `json:"expense_id"`
+	Type        string    `json:"type"`
+	Date        time.Time `json:"date"`
+	Description string    `json:"description"`
+	Category    string    `json:"category"`
+	Amount      Money     `json:"amount"`
+	Change      Money     `json:"change"`
+	Reconciled  bool      `json:"reconciled"`
+	Balance     Money     `json:"balance"`
+}
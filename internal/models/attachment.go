@@ -0,0 +1,16 @@
+// internal/models/attachment.go
+package models
+
+import "time"
+
+// Attachment is a file such as a receipt kept with an expense. SHA256 is the
+// hex digest of the content, which is also the name it is stored under.
+type Attachment struct {
+	ID          int       `json:"id"`
+	ExpenseID   int       `json:"expense_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+}
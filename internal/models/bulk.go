@@ -0,0 +1,37 @@
+// internal/models/bulk.go
+package models
+
+// BulkSelection picks the expenses a bulk change applies to: either the
+// listed IDs or every live expense matching Filter, never both.
+type BulkSelection struct {
+	IDs    []int
+	Filter *ExpenseFilter
+}
+
+// BulkChanges are the fields a bulk update sets on every selected expense.
+// Nil fields are left alone, and tags are added and removed rather than
+// replaced, so the tags each expense already has are kept.
+type BulkChanges struct {
+	Category      *string  `json:"category"`
+	Vendor        *string  `json:"vendor"`
+	PaymentMethod *string  `json:"payment_method"`
+	AddTags       []string `json:"add_tags"`
+	RemoveTags    []string `json:"remove_tags"`
+}
+
+// IsEmpty reports whether the changes would leave every expense as it is.
+func (c BulkChanges) IsEmpty() bool {
+	return c.Category == nil && c.Vendor == nil && c.PaymentMethod == nil &&
+		len(c.AddTags) == 0 && len(c.RemoveTags) == 0
+}
+
+// BulkResult reports what a bulk change did, or on a dry run what it would
+// have done. Matched counts the selected expenses and Affected those that
+// changed; Sample holds the first few affected ones as they are after the
+// change.
+type BulkResult struct {
+	DryRun   bool      `json:"dry_run"`
+	Matched  int       `json:"matched"`
+	Affected int       `json:"affected"`
+	Sample   []Expense `json:"sample"`
+}
@@ -0,0 +1,25 @@
+// internal/models/category.go
+package models
+
+import "time"
+
+// CategoryPathSeparator joins the names in a category's path.
+const CategoryPathSeparator = " > "
+
+// Category is an entry in the category list. Archived categories stay valid
+// for the expenses and rules that use them but are hidden from pickers.
+//
+// Categories form a tree through ParentID, which is nil for top-level
+// categories. Path is the names from the root down, such as
+// "Food & Dining > Coffee".
+type Category struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *int      `json:"parent_id"`
+	Path      string    `json:"path"`
+	Color     string    `json:"color"`
+	Icon      string    `json:"icon"`
+	Archived  bool      `json:"archived"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
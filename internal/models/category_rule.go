@@ -7,6 +7,7 @@ import (
 
 type CategoryRule struct {
     ID            int       `json:"id"`
+    CategoryID    int       `json:"category_id"`
     Category      string    `json:"category"`
     Keyword       string    `json:"keyword"`
     CaseSensitive bool      `json:"case_sensitive"`
@@ -0,0 +1,31 @@
+// internal/models/claim.go
+package models
+
+import "time"
+
+// Claim statuses. A claim is put together as a draft, submitted for
+// payment and finally marked paid.
+const (
+	ClaimDraft     = "draft"
+	ClaimSubmitted = "submitted"
+	ClaimPaid      = "paid"
+)
+
+// ClaimStatuses lists the valid claim statuses.
+var ClaimStatuses = []string{ClaimDraft, ClaimSubmitted, ClaimPaid}
+
+// Claim groups reimbursable expenses to be paid back. Expenses and Totals
+// count its live expenses, with totals kept per currency since a claim can
+// cover several. Only draft claims can change which expenses they hold.
+type Claim struct {
+	ID          int              `json:"id"`
+	Name        string           `json:"name"`
+	Status      string           `json:"status"`
+	Notes       string           `json:"notes"`
+	Expenses    int              `json:"expenses"`
+	Totals      map[string]Money `json:"totals"`
+	SubmittedAt *time.Time       `json:"submitted_at"`
+	PaidAt      *time.Time       `json:"paid_at"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
@@ -0,0 +1,68 @@
+// internal/models/custom_field.go
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Custom field types.
+const (
+	FieldText   = "text"
+	FieldNumber = "number"
+	FieldDate   = "date"
+	FieldEnum   = "enum"
+)
+
+// FieldTypes lists the valid custom field types.
+var FieldTypes = []string{FieldText, FieldNumber, FieldDate, FieldEnum}
+
+// CustomField is an extra attribute expenses can carry, such as a project
+// code or cost center. Name is the key used in an expense's fields, in
+// filters and in stats grouping; Label is for display. Enum fields only
+// accept one of Options.
+type CustomField struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Label     string    `json:"label"`
+	Type      string    `json:"type"`
+	Options   []string  `json:"options"`
+	Required  bool      `json:"required"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FieldValues holds an expense's custom field values by field name. Values
+// are kept as text in a canonical form: numbers without trailing zeros and
+// dates as YYYY-MM-DD.
+type FieldValues map[string]string
+
+// UnmarshalJSON accepts numbers and booleans as well as strings, and treats
+// null as an empty value.
+func (f *FieldValues) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*f = nil
+		return nil
+	}
+
+	values := make(FieldValues, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			values[name] = ""
+		case string:
+			values[name] = v
+		case float64, bool:
+			values[name] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("field %q must be a single value", name)
+		}
+	}
+	*f = values
+	return nil
+}
@@ -0,0 +1,19 @@
+// internal/models/exchange_rate.go
+package models
+
+import (
+	"time"
+)
+
+// ExchangeRate is the number of Currency units worth one BaseCurrency unit
+// on Date, e.g. {BaseCurrency: "EUR", Currency: "USD", Rate: 1.089}.
+type ExchangeRate struct {
+	Date         time.Time `json:"date"`
+	BaseCurrency string    `json:"base_currency"`
+	Currency     string    `json:"currency"`
+	Rate         float64   `json:"rate"`
+}
+
+type Settings struct {
+	BaseCurrency string `json:"base_currency"`
+}
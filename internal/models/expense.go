@@ -6,22 +6,194 @@ import (
     "encoding/json"
 )
 
+// Transaction types. Income and transfers share the expenses table; only
+// expenses count as spending.
+const (
+    TransactionExpense  = "expense"
+    TransactionIncome   = "income"
+    TransactionTransfer = "transfer"
+)
+
+// TransactionTypes lists the valid transaction types.
+var TransactionTypes = []string{TransactionExpense, TransactionIncome, TransactionTransfer}
+
 type Expense struct {
-    ID            int       `json:"id"`
-    Date          time.Time `json:"date"`
-    Category      string    `json:"category"`
-    Description   string    `json:"description"`
-    Amount        float64   `json:"amount"`
-    Vendor        string    `json:"vendor"`
-    PaymentMethod string    `json:"payment_method"`
-    CreatedAt     time.Time `json:"created_at"`
-    UpdatedAt     time.Time `json:"updated_at"`
+    ID            int        `json:"id"`
+    
+    // Type is expense, income or transfer. It defaults to expense, and
+    // leaving it out of an update keeps the current type.
+    Type          string     `json:"type"`
+    
+    Date          time.Time  `json:"date"`
+    CategoryID    int        `json:"category_id"`
+    Category      string     `json:"category"`
+    Description   string     `json:"description"`
+    Amount        Money      `json:"amount"`
+    Currency      string     `json:"currency"`
+    Vendor        string     `json:"vendor"`
+    PaymentMethod string     `json:"payment_method"`
+    
+    // AccountID and Account name the account the expense was paid from,
+    // like CategoryID and Category. Leaving both out of an update keeps the
+    // current account; account_id 0 takes the expense off its account.
+    AccountID     *int       `json:"account_id"`
+    Account       string     `json:"account"`
+    
+    // TransferAccountID and TransferAccount name the account a transfer
+    // moves money into, with the same rules as AccountID and Account. Only
+    // transfers have one.
+    TransferAccountID *int   `json:"transfer_account_id"`
+    TransferAccount   string `json:"transfer_account"`
+    
+    // Reconciled marks an expense as matched against the account's
+    // statement. It is set through the account, not by create or update.
+    Reconciled    bool       `json:"reconciled"`
+    
+    // Reimbursable marks an expense paid personally that is to be claimed
+    // back. Leaving it out of an update keeps the current value.
+    Reimbursable  *bool      `json:"reimbursable"`
+    
+    // ClaimID is the claim a reimbursable expense is on. It is set through
+    // the claim, not by create or update.
+    ClaimID       *int       `json:"claim_id"`
+    
+    // Tags are lower-case labels that cut across categories. Leaving them
+    // out of an update keeps the current tags; an empty list clears them.
+    Tags          []string   `json:"tags"`
+    
+    // Splits divide the expense across categories for stats; the lines
+    // must add up to Amount. Like Tags, leaving them out of an update keeps
+    // them and an empty list removes the split.
+    Splits        []ExpenseSplit `json:"splits"`
+    
+    // Fields are the custom field values by field name. Like Tags, leaving
+    // them out of an update keeps them and an object replaces them; an
+    // empty value clears a field.
+    Fields        FieldValues `json:"fields"`
+    
+    // Attachments counts the files kept with the expense. It is managed
+    // through the attachment endpoints and ignored on write.
+    Attachments   int        `json:"attachments"`
+    
+    // Version goes up with every change to the expense and is its ETag.
+    // It is ignored on write; If-Match makes an update conditional on it.
+    Version       int        `json:"version"`
+    
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+    DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+    
+    // Highlight is only set on search results
+    Highlight     *ExpenseHighlight `json:"highlight,omitempty"`
+}
+
+// ExpenseSplit is one line of a split expense. It shares the expense's date
+// and currency.
+type ExpenseSplit struct {
+    ID         int    `json:"id"`
+    CategoryID int    `json:"category_id"`
+    Category   string `json:"category"`
+    Amount     Money  `json:"amount"`
+    Note       string `json:"note"`
+}
+
+// ExpenseHighlight holds the searched fields with matches wrapped in
+// <mark></mark>. The surrounding text is HTML-escaped.
+type ExpenseHighlight struct {
+    Description   string `json:"description"`
+    Vendor        string `json:"vendor"`
+    PaymentMethod string `json:"payment_method"`
 }
 
 type ExpenseFilter struct {
     StartDate time.Time
     EndDate   time.Time
     Category  string
+    
+    // Query is a free-text search over description, vendor and payment
+    // method. Results are ordered by relevance instead of date.
+    Query     string
+    
+    // TagsAny matches expenses with at least one of the tags, TagsAll only
+    // those carrying every one of them
+    TagsAny   []string
+    TagsAll   []string
+    
+    // AccountID limits the list to one account's expenses, including
+    // transfers into it
+    AccountID int
+    
+    // Type limits the list to one transaction type
+    Type      string
+    
+    // Fields matches custom field values by field name; an empty value
+    // matches expenses without one
+    Fields    map[string]string
+    
+    // Reimbursable limits the list to expenses with or without the flag
+    Reimbursable *bool
+    
+    // ClaimID limits the list to one claim's expenses; Unclaimed to
+    // expenses on no claim
+    ClaimID   int
+    Unclaimed bool
+    
+    // MinAmount and MaxAmount bound the amount, both ends included
+    MinAmount *Money
+    MaxAmount *Money
+    
+    // Vendor and PaymentMethod match part of the value, ignoring case
+    Vendor        string
+    PaymentMethod string
+    
+    // Categories matches any of the named categories, like Category.
+    // Uncategorized adds expenses in the default category when true and
+    // leaves them out when false
+    Categories    []string
+    Uncategorized *bool
+    
+    // Created and updated ranges include the start and exclude the end
+    CreatedFrom   time.Time
+    CreatedBefore time.Time
+    UpdatedFrom   time.Time
+    UpdatedBefore time.Time
+    
+    // Sort names the column to order by and Order is "asc" or "desc". The
+    // default is newest first, or relevance when searching
+    Sort  string
+    Order string
+}
+
+// StatsFilter selects the expenses a stats report covers and how their
+// categories are grouped. Dates are YYYY-MM-DD and may be left empty.
+type StatsFilter struct {
+    StartDate string
+    EndDate   string
+    Category  string
+    
+    // Depth rolls subcategory totals up into their ancestor at that level,
+    // 1 being the top level. 0 reports every category on its own.
+    Depth     int
+    
+    // Parent drills down into one category: only its subtree is counted and
+    // totals are reported per direct child
+    Parent    string
+    
+    // Type is the transaction type reported, expense when empty. The cash
+    // flow report ignores it.
+    Type      string
+    
+    // Fields filters by custom field values like ExpenseFilter.Fields
+    Fields    map[string]string
+    
+    // GroupBy names a custom field to total by as well as by category
+    GroupBy   string
+    
+    // ExcludeReimbursed leaves out expenses on paid claims, which were not
+    // personal spending in the end. ExcludeReimbursable leaves out every
+    // reimbursable expense, claimed back yet or not.
+    ExcludeReimbursed   bool
+    ExcludeReimbursable bool
 }
 
 
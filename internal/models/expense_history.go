@@ -0,0 +1,50 @@
+// internal/models/expense_history.go
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions recorded in an expense's history.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+	ActionRevert  = "revert"
+	// ActionBaseline records the state of an expense that existed before
+	// history was kept, just ahead of its first recorded change.
+	ActionBaseline = "baseline"
+)
+
+// Sources say what made a change.
+const (
+	SourceManual      = "manual"
+	SourceCSVImport   = "csv_import"
+	SourceRuleReapply = "rule_reapply"
+	SourceRetention   = "retention"
+	SourceMigration   = "migration"
+	// SourceIntegrityFix marks safe fixes applied by the integrity check.
+	SourceIntegrityFix = "integrity_fix"
+	// SourceMerchants marks vendors renamed by the merchant directory.
+	SourceMerchants = "merchant_directory"
+	// SourceBulkEdit marks changes made by a bulk update or delete.
+	SourceBulkEdit = "bulk_edit"
+)
+
+// ExpenseChange is one entry in an expense's audit history. Before and After
+// are full snapshots of the expense; After of version N is the state that a
+// revert to version N restores.
+type ExpenseChange struct {
+	ID            int             `json:"id"`
+	ExpenseID     int             `json:"expense_id"`
+	Version       int             `json:"version"`
+	Action        string          `json:"action"`
+	Source        string          `json:"source"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after,omitempty"`
+	ChangedFields []string        `json:"changed_fields,omitempty"`
+	ChangedAt     time.Time       `json:"changed_at"`
+}
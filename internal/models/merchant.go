@@ -0,0 +1,17 @@
+// internal/models/merchant.go
+package models
+
+import "time"
+
+// Merchant is a canonical vendor name. Aliases are patterns for the raw
+// strings a bank uses for it, such as "GRAB*RIDE*": "*" matches any run of
+// characters and matching ignores case. Expenses is how many live expenses
+// have the merchant as their vendor.
+type Merchant struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	Expenses  int       `json:"expenses"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
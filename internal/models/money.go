@@ -0,0 +1,149 @@
+// internal/models/money.go
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money is a fixed-point amount in hundredths of the currency unit (cents).
+// It is stored and summed as an integer so totals never drift, and encoded
+// in JSON as a plain decimal number such as 12.34.
+type Money int64
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// Bounds for a single expense amount. Import rejects anything outside them
+// and the integrity check reports stored amounts that fall outside.
+const (
+	MinAmount Money = -99999999
+	MaxAmount Money = 99999999
+)
+
+// decimalPattern matches a plain signed decimal with an optional exponent.
+// big.Rat would also take fractions like "1/2" and hex like "0x10".
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]{1,3})?$`)
+
+// ParseMoney parses a decimal string such as "12.34", "-0.5" or "1e3".
+// Digits beyond the second decimal place are rounded half away from zero.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty", ErrInvalidMoney)
+	}
+	if !decimalPattern.MatchString(s) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	r.Mul(r, big.NewRat(100, 1))
+
+	// Round half away from zero: truncate |r| + 1/2
+	neg := r.Sign() < 0
+	r.Abs(r)
+	r.Add(r, big.NewRat(1, 2))
+	cents := new(big.Int).Quo(r.Num(), r.Denom())
+	// -cents must fit too, so math.MinInt64 is out of range
+	if !cents.IsInt64() {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidMoney, s)
+	}
+
+	m := Money(cents.Int64())
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+// MoneyFromFloat converts a float to the nearest cent. Prefer ParseMoney when
+// the original text is available.
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * 100))
+}
+
+// Cents returns the amount in minor units.
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// Float64 returns the amount in major units, for display only.
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+func (m Money) String() string {
+	sign := ""
+	// uint64 holds the magnitude of math.MinInt64, which int64 cannot
+	cents := uint64(m)
+	if m < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string and parses its
+// decimal text directly, so values never pass through float64.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores Money as integer cents.
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// Scan reads integer cents. A REAL can only be a legacy amount in major
+// units, so it is rounded to the nearest cent as the cents migration does.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*m = Money(v)
+	case float64:
+		*m = MoneyFromFloat(v)
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case nil:
+		*m = 0
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidMoney, src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	cents, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: cannot scan %q", ErrInvalidMoney, s)
+	}
+	*m = Money(cents)
+	return nil
+}
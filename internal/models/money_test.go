@@ -0,0 +1,134 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "12.34", want: 1234},
+		{in: " 7 ", want: 700},
+		{in: "-0.5", want: -50},
+		{in: "+3.", want: 300},
+		{in: ".25", want: 25},
+		{in: "1e3", want: 100000},
+		{in: "1.5E-1", want: 15},
+		{in: "0.005", want: 1},
+		{in: "-0.005", want: -1},
+		{in: "0.0049", want: 0},
+		{in: "2.675", want: 268},
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "-92233720368547758.07", want: -math.MaxInt64},
+		{in: "-92233720368547758.08", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "1/2", wantErr: true},
+		{in: "0x10", wantErr: true},
+		{in: "1,000.00", wantErr: true},
+		{in: "$5", wantErr: true},
+		{in: "1e", wantErr: true},
+		{in: ".", wantErr: true},
+		{in: "1e99999", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("ParseMoney(%q) = %v, %v; want ErrInvalidMoney", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{1234, "12.34"},
+		{-100000, "-1000.00"},
+		{math.MaxInt64, "92233720368547758.07"},
+		{math.MinInt64, "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	type payload struct {
+		Amount Money `json:"amount"`
+	}
+
+	for _, m := range []Money{0, 1, -1, 1234, -99999999, math.MaxInt64} {
+		data, err := json.Marshal(payload{Amount: m})
+		if err != nil {
+			t.Fatalf("Marshal(%d): %v", int64(m), err)
+		}
+		var got payload
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		if got.Amount != m {
+			t.Errorf("round trip of %d gave %d via %s", int64(m), int64(got.Amount), data)
+		}
+	}
+
+	var got payload
+	if err := json.Unmarshal([]byte(`{"amount":"19.99"}`), &got); err != nil || got.Amount != 1999 {
+		t.Errorf("quoted amount = %d, %v; want 1999", int64(got.Amount), err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount":"1/2"}`), &got); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("fraction amount error = %v, want ErrInvalidMoney", err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src     interface{}
+		want    Money
+		wantErr bool
+	}{
+		{src: int64(1234), want: 1234},
+		{src: []byte("-50"), want: -50},
+		{src: "700", want: 700},
+		{src: nil, want: 0},
+		{src: 12.34, want: 1234},
+		{src: 0.125, want: 13},
+		{src: -2.5, want: -250},
+		{src: "12.34", wantErr: true},
+		{src: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		m := Money(99)
+		err := m.Scan(tt.src)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("Scan(%#v) = %d, %v; want ErrInvalidMoney", tt.src, int64(m), err)
+			}
+			continue
+		}
+		if err != nil || m != tt.want {
+			t.Errorf("Scan(%#v) = %d, %v; want %d", tt.src, int64(m), err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// internal/models/tag.go
+package models
+
+import "time"
+
+// Tag is a label that can be put on any number of expenses. ExpenseCount
+// only counts expenses that are not in the trash.
+type Tag struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	ExpenseCount int       `json:"expense_count"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,472 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"expense-tracker/internal/currency"
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+type AccountRepository interface {
+	GetAll(ctx context.Context) ([]models.Account, error)
+	GetByID(ctx context.Context, id int) (*models.Account, error)
+	Create(ctx context.Context, account *models.Account) error
+	Update(ctx context.Context, id int, account *models.Account) error
+	Delete(ctx context.Context, id int) error
+
+	// GetRegister lists the account's expenses between two YYYY-MM-DD dates,
+	// either of which may be empty, with the running balance after each
+	GetRegister(ctx context.Context, id int, startDate, endDate string) (*models.AccountRegister, error)
+
+	// Reconcile marks expenses on the account as reconciled, or clears the
+	// mark, in one transaction
+	Reconcile(ctx context.Context, id int, expenseIDs []int, reconciled bool) (int, error)
+}
+
+type accountRepository struct {
+	db       *database.DB
+	settings SettingsRepository
+}
+
+func NewAccountRepository(db *database.DB) AccountRepository {
+	return &accountRepository{db: db, settings: NewSettingsRepository(db)}
+}
+
+const accountColumns = `accounts.id, accounts.name, accounts.type, accounts.currency, accounts.opening_balance_cents, accounts.created_at, accounts.updated_at`
+
+// accountBalanceChange is how much an expense moves the balance of the
+// account it is joined to by accountExpenses: income and transfers in add to
+// it, expenses and transfers out take from it.
+const accountBalanceChange = `CASE
+        WHEN expenses.transfer_account_id = accounts.id THEN expenses.amount_cents
+        WHEN expenses.type = 'income' THEN expenses.amount_cents
+        ELSE -expenses.amount_cents
+    END`
+
+// accountExpenses joins an account to the live expenses on it, on either
+// side of a transfer.
+const accountExpenses = `expenses.deleted_at IS NULL
+        AND (expenses.account_id = accounts.id OR expenses.transfer_account_id = accounts.id)`
+
+// accountBalances selects accountColumns followed by the computed balances
+// and counts, for the accounts matched by the where clause appended to it.
+const accountBalances = `
+    SELECT ` + accountColumns + `,
+           accounts.opening_balance_cents + COALESCE(SUM(` + accountBalanceChange + `), 0),
+           accounts.opening_balance_cents + COALESCE(SUM(CASE WHEN expenses.reconciled THEN ` + accountBalanceChange + ` ELSE 0 END), 0),
+           COUNT(expenses.id),
+           COALESCE(SUM(CASE WHEN expenses.id IS NOT NULL AND NOT expenses.reconciled THEN 1 ELSE 0 END), 0)
+    FROM accounts
+    LEFT JOIN expenses ON ` + accountExpenses
+
+const accountBalancesGroupBy = ` GROUP BY ` + accountColumns
+
+func scanAccount(row rowScanner, extra ...interface{}) (models.Account, error) {
+	var a models.Account
+	dest := []interface{}{&a.ID, &a.Name, &a.Type, &a.Currency, &a.OpeningBalance, &a.CreatedAt, &a.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return a, err
+}
+
+func scanAccountBalances(row rowScanner) (models.Account, error) {
+	var balance, reconciled models.Money
+	var count, unreconciled int
+	a, err := scanAccount(row, &balance, &reconciled, &count, &unreconciled)
+	a.Balance = balance
+	a.ReconciledBalance = reconciled
+	a.ExpenseCount = count
+	a.Unreconciled = unreconciled
+	return a, err
+}
+
+// GetAll lists the accounts by name with their current balances.
+func (r *accountRepository) GetAll(ctx context.Context) ([]models.Account, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	rows, err := q.Query(accountBalances + accountBalancesGroupBy + " ORDER BY accounts.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []models.Account{}
+	for rows.Next() {
+		a, err := scanAccountBalances(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+func (r *accountRepository) GetByID(ctx context.Context, id int) (*models.Account, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	return getAccount(q, id)
+}
+
+func (r *accountRepository) Create(ctx context.Context, account *models.Account) error {
+	if err := r.validateAccount(ctx, account); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkAccountNameFree(tx, account.Name, 0); err != nil {
+		return err
+	}
+
+	id, err := tx.ExecReturningID(`
+        INSERT INTO accounts (name, type, currency, opening_balance_cents, created_at, updated_at)
+        VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    `, account.Name, account.Type, account.Currency, account.OpeningBalance)
+	if err != nil {
+		return err
+	}
+
+	created, err := getAccount(tx, int(id))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*account = *created
+	return nil
+}
+
+// Update changes an account. The currency can only change while no expense,
+// including trashed ones, is on the account.
+func (r *accountRepository) Update(ctx context.Context, id int, account *models.Account) error {
+	if err := r.validateAccount(ctx, account); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := getAccount(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkAccountNameFree(tx, account.Name, id); err != nil {
+		return err
+	}
+	if account.Currency != existing.Currency {
+		uses, err := countAccountExpenses(tx, id)
+		if err != nil {
+			return err
+		}
+		if uses > 0 {
+			return fmt.Errorf("%w; its currency cannot change", ErrAccountInUse)
+		}
+	}
+
+	_, err = tx.Exec(`
+        UPDATE accounts
+        SET name = ?, type = ?, currency = ?, opening_balance_cents = ?, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, account.Name, account.Type, account.Currency, account.OpeningBalance, id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := getAccount(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*account = *updated
+	return nil
+}
+
+// Delete removes an account that no expense, including trashed ones, is on.
+func (r *accountRepository) Delete(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getAccount(tx, id); err != nil {
+		return err
+	}
+	uses, err := countAccountExpenses(tx, id)
+	if err != nil {
+		return err
+	}
+	if uses > 0 {
+		return ErrAccountInUse
+	}
+
+	if _, err := tx.Exec("DELETE FROM accounts WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *accountRepository) GetRegister(ctx context.Context, id int, startDate, endDate string) (*models.AccountRegister, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	account, err := getAccount(q, id)
+	if err != nil {
+		return nil, err
+	}
+
+	register := &models.AccountRegister{
+		Account:        *account,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		OpeningBalance: account.OpeningBalance,
+		Entries:        []models.AccountEntry{},
+	}
+
+	const from = ` FROM accounts JOIN expenses ON ` + accountExpenses
+	where := " WHERE accounts.id = ?"
+	args := []interface{}{id}
+	rangeArgs, err := appendDateRange(&where, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, rangeArgs...)
+
+	// Everything before the range is carried into the opening balance
+	if startDate != "" {
+		var before models.Money
+		err := q.QueryRow(`
+            SELECT COALESCE(SUM(`+accountBalanceChange+`), 0)`+from+`
+            WHERE accounts.id = ? AND date < ?
+        `, id, rangeArgs[0]).Scan(&before)
+		if err != nil {
+			return nil, err
+		}
+		register.OpeningBalance += before
+	}
+
+	rows, err := q.Query(`
+        SELECT expenses.id, expenses.type, expenses.date, expenses.description, `+categoryName+`,
+               expenses.amount_cents, `+accountBalanceChange+`, expenses.reconciled`+from+`
+        LEFT JOIN categories ON categories.id = expenses.category_id`+where+`
+        ORDER BY expenses.date, expenses.id
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balance := register.OpeningBalance
+	for rows.Next() {
+		var entry models.AccountEntry
+		if err := rows.Scan(&entry.ExpenseID, &entry.Type, &entry.Date, &entry.Description, &entry.Category,
+			&entry.Amount, &entry.Change, &entry.Reconciled); err != nil {
+			return nil, err
+		}
+		balance += entry.Change
+		entry.Balance = balance
+		register.Entries = append(register.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	register.ClosingBalance = balance
+	return register, nil
+}
+
+// Reconcile records each change in the expense's history. Nothing changes if
+// any expense is missing or on a different account.
+func (r *accountRepository) Reconcile(ctx context.Context, id int, expenseIDs []int, reconciled bool) (int, error) {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	account, err := getAccount(tx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[int]bool)
+	updated := 0
+	for _, expenseID := range expenseIDs {
+		if seen[expenseID] {
+			continue
+		}
+		seen[expenseID] = true
+
+		before, err := getExpense(tx, expenseID, false)
+		if err != nil {
+			return 0, err
+		}
+		if !sameAccount(before.AccountID, &id) && !sameAccount(before.TransferAccountID, &id) {
+			return 0, fmt.Errorf("%w: expense %d is not on account %q", ErrInvalidAccount, expenseID, account.Name)
+		}
+		if before.Reconciled == reconciled {
+			continue
+		}
+
+		_, err = tx.Exec("UPDATE expenses SET reconciled = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", reconciled, expenseID)
+		if err != nil {
+			return 0, err
+		}
+		after, err := getExpense(tx, expenseID, true)
+		if err != nil {
+			return 0, err
+		}
+		if err := recordChange(tx, expenseID, models.ActionUpdate, models.SourceManual, before, after); err != nil {
+			return 0, err
+		}
+		updated++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func getAccount(q database.Querier, id int) (*models.Account, error) {
+	a, err := scanAccountBalances(q.QueryRow(accountBalances+" WHERE accounts.id = ?"+accountBalancesGroupBy, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// resolveAccount looks an account up by ID, or by name (ignoring case) when
+// id is nil or zero. It returns nil when neither is given. When both are
+// given they must agree.
+func resolveAccount(q database.Querier, id *int, name string) (*models.Account, error) {
+	name = strings.TrimSpace(name)
+
+	var row *database.Row
+	switch {
+	case id != nil && *id != 0:
+		row = q.QueryRow("SELECT "+accountColumns+" FROM accounts WHERE id = ?", *id)
+	case name != "":
+		row = q.QueryRow("SELECT "+accountColumns+" FROM accounts WHERE LOWER(name) = LOWER(?)", name)
+	default:
+		return nil, nil
+	}
+
+	a, err := scanAccount(row)
+	if err == sql.ErrNoRows {
+		if name != "" {
+			return nil, fmt.Errorf("%w: %q", ErrAccountNotFound, name)
+		}
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && !strings.EqualFold(name, a.Name) {
+		return nil, fmt.Errorf("%w: account %q does not match account_id %d", ErrInvalidAccount, name, a.ID)
+	}
+	return &a, nil
+}
+
+// expenseAccount resolves an account an expense is written with and checks
+// that the expense is in the account's currency. current is kept when the
+// expense names no account; an ID of 0 removes it whatever the name says.
+func expenseAccount(q database.Querier, currency string, id *int, name string, current *int) (*models.Account, error) {
+	switch {
+	case id != nil && *id == 0:
+		return nil, nil
+	case id == nil && strings.TrimSpace(name) == "":
+		id = current
+	}
+
+	account, err := resolveAccount(q, id, name)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	if account.Currency != currency {
+		return nil, fmt.Errorf("%w: expense is in %s but account %q is in %s",
+			ErrInvalidAccount, currency, account.Name, account.Currency)
+	}
+	return account, nil
+}
+
+func sameAccount(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func countAccountExpenses(q database.Querier, id int) (int, error) {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM expenses WHERE account_id = ? OR transfer_account_id = ?", id, id).Scan(&count)
+	return count, err
+}
+
+func checkAccountNameFree(q database.Querier, name string, exceptID int) error {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM accounts WHERE LOWER(name) = LOWER(?) AND id <> ?", name, exceptID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAccountExists
+	}
+	return nil
+}
+
+func (r *accountRepository) validateAccount(ctx context.Context, a *models.Account) error {
+	a.Name = strings.TrimSpace(a.Name)
+	a.Type = strings.TrimSpace(a.Type)
+
+	if a.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidAccount)
+	}
+
+	valid := false
+	for _, t := range models.AccountTypes {
+		if a.Type == t {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("%w: type must be one of %s", ErrInvalidAccount, strings.Join(models.AccountTypes, ", "))
+	}
+
+	if strings.TrimSpace(a.Currency) == "" {
+		base, err := r.settings.BaseCurrency(ctx)
+		if err != nil {
+			return err
+		}
+		a.Currency = base
+	} else {
+		code, err := currency.NormalizeCode(a.Currency)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAccount, err)
+		}
+		a.Currency = code
+	}
+
+	if a.OpeningBalance < models.MinAmount || a.OpeningBalance > models.MaxAmount {
+		return fmt.Errorf("%w: opening balance must be between %s and %s", ErrInvalidAccount, models.MinAmount, models.MaxAmount)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+// AttachmentRepository records which expense each stored file belongs to.
+// The files themselves are kept by the attachments package.
+type AttachmentRepository interface {
+	// GetByExpense lists the attachments of a live expense, oldest first
+	GetByExpense(ctx context.Context, expenseID int) ([]models.Attachment, error)
+	GetByID(ctx context.Context, id int) (*models.Attachment, error)
+	Create(ctx context.Context, attachment *models.Attachment) error
+	Delete(ctx context.Context, id int) error
+
+	// IsReferenced reports whether any attachment, including those of
+	// trashed expenses, uses the file with the given digest
+	IsReferenced(ctx context.Context, sha256 string) (bool, error)
+
+	// Referenced returns the digest of every file still in use
+	Referenced(ctx context.Context) (map[string]bool, error)
+}
+
+type attachmentRepository struct {
+	db *database.DB
+}
+
+func NewAttachmentRepository(db *database.DB) AttachmentRepository {
+	return &attachmentRepository{db: db}
+}
+
+const attachmentColumns = `id, expense_id, filename, content_type, size_bytes, sha256, created_at`
+
+func scanAttachment(row rowScanner) (models.Attachment, error) {
+	var a models.Attachment
+	err := row.Scan(&a.ID, &a.ExpenseID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.CreatedAt)
+	return a, err
+}
+
+func (r *attachmentRepository) GetByExpense(ctx context.Context, expenseID int) ([]models.Attachment, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	if _, err := getExpense(q, expenseID, false); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query("SELECT "+attachmentColumns+" FROM attachments WHERE expense_id = ? ORDER BY id", expenseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []models.Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+func (r *attachmentRepository) GetByID(ctx context.Context, id int) (*models.Attachment, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	a, err := scanAttachment(q.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Create adds an attachment to a live expense.
+func (r *attachmentRepository) Create(ctx context.Context, attachment *models.Attachment) error {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getExpense(tx, attachment.ExpenseID, false); err != nil {
+		return err
+	}
+
+	id, err := tx.ExecReturningID(
+		"INSERT INTO attachments (expense_id, filename, content_type, size_bytes, sha256) VALUES (?, ?, ?, ?, ?)",
+		attachment.ExpenseID, attachment.Filename, attachment.ContentType, attachment.Size, attachment.SHA256,
+	)
+	if err != nil {
+		return err
+	}
+
+	created, err := scanAttachment(tx.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", id))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*attachment = created
+	return nil
+}
+
+func (r *attachmentRepository) Delete(ctx context.Context, id int) error {
+	q, cancel := r.db.WithContext(ctx, database.OpWrite)
+	defer cancel()
+	result, err := q.Exec("DELETE FROM attachments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAttachmentNotFound
+	}
+	return nil
+}
+
+func (r *attachmentRepository) IsReferenced(ctx context.Context, sha256 string) (bool, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	var count int
+	if err := q.QueryRow("SELECT COUNT(*) FROM attachments WHERE sha256 = ?", sha256).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *attachmentRepository) Referenced(ctx context.Context) (map[string]bool, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	rows, err := q.Query("SELECT DISTINCT sha256 FROM attachments")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referenced := map[string]bool{}
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return nil, err
+		}
+		referenced[digest] = true
+	}
+	return referenced, rows.Err()
+}
+
+// loadAttachmentCounts sets how many attachments each expense has.
+func loadAttachmentCounts(q database.Querier, expenses []models.Expense) error {
+	if len(expenses) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Expense, len(expenses))
+	placeholders := make([]string, len(expenses))
+	args := make([]interface{}, len(expenses))
+	for i := range expenses {
+		expenses[i].Attachments = 0
+		byID[expenses[i].ID] = &expenses[i]
+		placeholders[i] = "?"
+		args[i] = expenses[i].ID
+	}
+
+	rows, err := q.Query(`
+        SELECT expense_id, COUNT(*) FROM attachments
+        WHERE expense_id IN (`+strings.Join(placeholders, ", ")+`)
+        GROUP BY expense_id
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var expenseID, count int
+		if err := rows.Scan(&expenseID, &count); err != nil {
+			return err
+		}
+		if e := byID[expenseID]; e != nil {
+			e.Attachments = count
+		}
+	}
+	return rows.Err()
+}
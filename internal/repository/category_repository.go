@@ -0,0 +1,314 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+// DefaultCategory is where expenses go when no rule matches. It always exists
+// and cannot be renamed or deleted.
+const DefaultCategory = "Other"
+
+const maxIconLength = 64
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type CategoryRepository interface {
+	GetAll(ctx context.Context, includeArchived bool) ([]models.Category, error)
+	GetByID(ctx context.Context, id int) (*models.Category, error)
+	Create(ctx context.Context, category *models.Category) error
+	Update(ctx context.Context, id int, category *models.Category) error
+	Delete(ctx context.Context, id int) error
+
+	// Resolve finds the category an expense or rule refers to, by ID when
+	// id is non-zero and by name otherwise
+	Resolve(ctx context.Context, id int, name string) (*models.Category, error)
+}
+
+type categoryRepository struct {
+	db *database.DB
+}
+
+func NewCategoryRepository(db *database.DB) CategoryRepository {
+	return &categoryRepository{db: db}
+}
+
+const categoryColumns = `id, name, parent_id, color, icon, archived, created_at, updated_at`
+
+func scanCategory(row rowScanner) (models.Category, error) {
+	var c models.Category
+	var parentID sql.NullInt64
+	err := row.Scan(&c.ID, &c.Name, &parentID, &c.Color, &c.Icon, &c.Archived, &c.CreatedAt, &c.UpdatedAt)
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		c.ParentID = &id
+	}
+	return c, err
+}
+
+// GetAll lists categories in tree order: each category is followed by its
+// subcategories, with siblings sorted by name.
+func (r *categoryRepository) GetAll(ctx context.Context, includeArchived bool) ([]models.Category, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	tree, err := loadCategoryTree(q)
+	if err != nil {
+		return nil, err
+	}
+
+	categories := []models.Category{}
+	for _, c := range tree.ordered {
+		if includeArchived || !c.Archived {
+			categories = append(categories, *c)
+		}
+	}
+
+	// Joining the path with a byte that sorts before any name keeps every
+	// category directly ahead of its subcategories
+	sortKey := func(c models.Category) string {
+		return strings.Join(tree.names(c.ID), "\x00")
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		return sortKey(categories[i]) < sortKey(categories[j])
+	})
+	return categories, nil
+}
+
+func (r *categoryRepository) GetByID(ctx context.Context, id int) (*models.Category, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	return getCategory(q, id)
+}
+
+func (r *categoryRepository) Create(ctx context.Context, category *models.Category) error {
+	if err := validateCategory(category); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkCategoryNameFree(tx, category.Name, 0); err != nil {
+		return err
+	}
+	if err := checkCategoryParent(tx, 0, category.ParentID); err != nil {
+		return err
+	}
+
+	id, err := tx.ExecReturningID(`
+        INSERT INTO categories (name, parent_id, color, icon, archived, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    `, category.Name, category.ParentID, category.Color, category.Icon, category.Archived)
+	if err != nil {
+		return err
+	}
+
+	created, err := getCategory(tx, int(id))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*category = *created
+	return nil
+}
+
+func (r *categoryRepository) Update(ctx context.Context, id int, category *models.Category) error {
+	if err := validateCategory(category); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := getCategory(tx, id)
+	if err != nil {
+		return err
+	}
+	if existing.Name == DefaultCategory && category.Name != DefaultCategory {
+		return ErrDefaultCategory
+	}
+	if err := checkCategoryNameFree(tx, category.Name, id); err != nil {
+		return err
+	}
+	if err := checkCategoryParent(tx, id, category.ParentID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        UPDATE categories
+        SET name = ?, parent_id = ?, color = ?, icon = ?, archived = ?, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, category.Name, category.ParentID, category.Color, category.Icon, category.Archived, id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := getCategory(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*category = *updated
+	return nil
+}
+
+// Delete removes a category that nothing refers to. Categories still used by
+// expenses (including trashed ones) or rules should be archived instead, and
+// subcategories have to be moved or deleted first.
+func (r *categoryRepository) Delete(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := getCategory(tx, id)
+	if err != nil {
+		return err
+	}
+	if existing.Name == DefaultCategory {
+		return ErrDefaultCategory
+	}
+
+	var uses, children int
+	err = tx.QueryRow(`
+        SELECT (SELECT COUNT(*) FROM expenses WHERE category_id = ?)
+             + (SELECT COUNT(*) FROM expense_splits WHERE category_id = ?)
+             + (SELECT COUNT(*) FROM categorization_rules WHERE category_id = ?),
+               (SELECT COUNT(*) FROM categories WHERE parent_id = ?)
+    `, id, id, id, id).Scan(&uses, &children)
+	if err != nil {
+		return err
+	}
+	if uses > 0 {
+		return ErrCategoryInUse
+	}
+	if children > 0 {
+		return ErrCategoryHasChildren
+	}
+
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *categoryRepository) Resolve(ctx context.Context, id int, name string) (*models.Category, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	return resolveCategory(q, id, name)
+}
+
+func getCategory(q database.Querier, id int) (*models.Category, error) {
+	tree, err := loadCategoryTree(q)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := tree.byID[id]
+	if !ok {
+		return nil, ErrCategoryNotFound
+	}
+	return c, nil
+}
+
+// resolveCategory looks a category up by ID, or by name when id is zero.
+// Names match exactly first and then ignoring case and surrounding spaces.
+// When both are given they must agree.
+func resolveCategory(q database.Querier, id int, name string) (*models.Category, error) {
+	name = strings.TrimSpace(name)
+
+	tree, err := loadCategoryTree(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if id != 0 {
+		c, ok := tree.byID[id]
+		if !ok {
+			return nil, ErrCategoryNotFound
+		}
+		if name != "" && !strings.EqualFold(name, c.Name) {
+			return nil, fmt.Errorf("%w: category %q does not match category_id %d", ErrInvalidCategory, name, id)
+		}
+		return c, nil
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("%w: category is required", ErrInvalidCategory)
+	}
+
+	c := tree.byName(name)
+	if c == nil {
+		return nil, fmt.Errorf("%w: %q", ErrCategoryNotFound, name)
+	}
+	return c, nil
+}
+
+func checkCategoryNameFree(q database.Querier, name string, exceptID int) error {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM categories WHERE LOWER(name) = LOWER(?) AND id <> ?", name, exceptID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCategoryExists
+	}
+	return nil
+}
+
+// checkCategoryParent makes sure parentID exists and, when category id is
+// being moved, is not the category itself or one of its subcategories.
+func checkCategoryParent(q database.Querier, id int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+
+	tree, err := loadCategoryTree(q)
+	if err != nil {
+		return err
+	}
+	if _, ok := tree.byID[*parentID]; !ok {
+		return fmt.Errorf("%w: parent category %d does not exist", ErrInvalidCategory, *parentID)
+	}
+	if id != 0 && tree.within(*parentID, id) {
+		return fmt.Errorf("%w: a category cannot be moved under itself", ErrInvalidCategory)
+	}
+	return nil
+}
+
+func validateCategory(c *models.Category) error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Color = strings.TrimSpace(c.Color)
+	c.Icon = strings.TrimSpace(c.Icon)
+
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCategory)
+	}
+	if c.Color != "" && !colorPattern.MatchString(c.Color) {
+		return fmt.Errorf("%w: color must look like #1a2b3c", ErrInvalidCategory)
+	}
+	if utf8.RuneCountInString(c.Icon) > maxIconLength {
+		return fmt.Errorf("%w: icon is longer than %d characters", ErrInvalidCategory, maxIconLength)
+	}
+	return nil
+}
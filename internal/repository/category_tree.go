@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+// categoryTree is the whole category list keyed by ID. The list is small, so
+// it is loaded in one query whenever paths, subtrees or rollups are needed.
+type categoryTree struct {
+	byID    map[int]*models.Category
+	ordered []*models.Category // by ID
+}
+
+func loadCategoryTree(q database.Querier) (*categoryTree, error) {
+	rows, err := q.Query("SELECT " + categoryColumns + " FROM categories ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	t := &categoryTree{byID: make(map[int]*models.Category)}
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		t.byID[c.ID] = &c
+		t.ordered = append(t.ordered, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, c := range t.ordered {
+		c.Path = strings.Join(t.names(c.ID), models.CategoryPathSeparator)
+	}
+	return t, nil
+}
+
+// ancestors returns the chain from the root down to id, or nil when id is
+// unknown. A parent loop, which the repository never creates, ends the chain
+// instead of looping forever.
+func (t *categoryTree) ancestors(id int) []*models.Category {
+	var chain []*models.Category
+	seen := make(map[int]bool)
+	for c := t.byID[id]; c != nil && !seen[c.ID]; {
+		seen[c.ID] = true
+		chain = append(chain, c)
+		if c.ParentID == nil {
+			break
+		}
+		c = t.byID[*c.ParentID]
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+func (t *categoryTree) names(id int) []string {
+	chain := t.ancestors(id)
+	names := make([]string, len(chain))
+	for i, c := range chain {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// within reports whether id is ancestor or one of its descendants.
+func (t *categoryTree) within(id, ancestor int) bool {
+	for _, c := range t.ancestors(id) {
+		if c.ID == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// byName finds a category by exact name, then ignoring case.
+func (t *categoryTree) byName(name string) *models.Category {
+	for _, c := range t.ordered {
+		if c.Name == name {
+			return c
+		}
+	}
+	for _, c := range t.ordered {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// rollup returns the name a category is reported under at the given depth,
+// where 1 is the top level: its ancestor at that depth, or the category
+// itself when it is shallower. Depth 0 reports every category on its own.
+func (t *categoryTree) rollup(id, depth int) (string, bool) {
+	chain := t.ancestors(id)
+	if len(chain) == 0 {
+		return "", false
+	}
+	if depth <= 0 || depth > len(chain) {
+		depth = len(chain)
+	}
+	return chain[depth-1].Name, true
+}
+
+// categorySubtree is a subquery selecting the IDs of one category and
+// everything below it. root is the condition picking that category, such as
+// "id = ?".
+func categorySubtree(root string) string {
+	return `(
+            WITH RECURSIVE subtree(id) AS (
+                SELECT id FROM categories WHERE ` + root + `
+                UNION
+                SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+            )
+            SELECT id FROM subtree
+        )`
+}
+
+// categoryRollup decides which name each stats group is reported under for
+// a StatsFilter.
+type categoryRollup struct {
+	tree   *categoryTree
+	depth  int
+	parent *models.Category
+}
+
+func newCategoryRollup(q database.Querier, filter models.StatsFilter) (*categoryRollup, error) {
+	tree, err := loadCategoryTree(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rollup := &categoryRollup{tree: tree, depth: filter.Depth}
+	if rollup.depth < 0 {
+		rollup.depth = 0
+	}
+
+	if filter.Parent != "" {
+		rollup.parent = tree.byName(strings.TrimSpace(filter.Parent))
+		if rollup.parent == nil {
+			return nil, fmt.Errorf("%w: %q", ErrCategoryNotFound, filter.Parent)
+		}
+
+		// Drilling down reports the parent's children, so anything shallower
+		// would collapse the whole subtree into the parent again
+		if level := len(tree.ancestors(rollup.parent.ID)); rollup.depth <= level {
+			rollup.depth = level + 1
+		}
+	}
+
+	return rollup, nil
+}
+
+// appendFilters adds the category and drill-down conditions to a stats query
+// and returns their arguments. Split expenses are filtered line by line.
+func (c *categoryRollup) appendFilters(query *string, filter models.StatsFilter) []interface{} {
+	var args []interface{}
+	if filter.Category != "" {
+		*query += " AND " + statsCategoryID + " IN " + categorySubtree("name = ?")
+		args = append(args, filter.Category)
+	}
+	if c.parent != nil {
+		*query += " AND " + statsCategoryID + " IN " + categorySubtree("id = ?")
+		args = append(args, c.parent.ID)
+	}
+	return args
+}
+
+// label names each group after the category it rolls up into. Groups whose
+// category no longer exists keep an empty name.
+func (c *categoryRollup) label(groups []amountGroup) {
+	for i := range groups {
+		groups[i].category, _ = c.tree.rollup(groups[i].categoryID, c.depth)
+	}
+}
+
+// describe records how categories were grouped in a stats response.
+func (c *categoryRollup) describe(stats map[string]interface{}) {
+	stats["depth"] = c.depth
+	if c.parent != nil {
+		stats["parent"] = c.parent.Name
+	}
+}
@@ -0,0 +1,516 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+const (
+	maxClaimNameLength  = 100
+	maxClaimNotesLength = 1000
+)
+
+// claimTransitions lists the statuses a claim can move to from each status.
+// Going back a step undoes a submission or payment recorded by mistake.
+var claimTransitions = map[string][]string{
+	models.ClaimDraft:     {models.ClaimSubmitted},
+	models.ClaimSubmitted: {models.ClaimDraft, models.ClaimPaid},
+	models.ClaimPaid:      {models.ClaimSubmitted},
+}
+
+type ClaimRepository interface {
+	// GetAll lists claims, newest first, optionally with one status
+	GetAll(ctx context.Context, status string) ([]models.Claim, error)
+	GetByID(ctx context.Context, id int) (*models.Claim, error)
+
+	// Create starts a draft claim
+	Create(ctx context.Context, claim *models.Claim) error
+
+	// Update changes a claim's name and notes; see SetStatus for its status
+	Update(ctx context.Context, id int, claim *models.Claim) error
+
+	// Delete removes a draft claim. Its expenses stay reimbursable, so they
+	// can go on another claim
+	Delete(ctx context.Context, id int) error
+
+	// AddExpenses puts reimbursable expenses on a draft claim, and
+	// RemoveExpenses takes them off. Nothing changes if any of them cannot
+	// be moved
+	AddExpenses(ctx context.Context, id int, expenseIDs []int) (int, error)
+	RemoveExpenses(ctx context.Context, id int, expenseIDs []int) (int, error)
+
+	// SetStatus moves a claim to the next status or back a step
+	SetStatus(ctx context.Context, id int, status string) (*models.Claim, error)
+}
+
+type claimRepository struct {
+	db *database.DB
+}
+
+func NewClaimRepository(db *database.DB) ClaimRepository {
+	return &claimRepository{db: db}
+}
+
+const claimColumns = `id, name, status, notes, submitted_at, paid_at, created_at, updated_at`
+
+func scanClaim(row rowScanner) (models.Claim, error) {
+	var c models.Claim
+	var submittedAt, paidAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.Name, &c.Status, &c.Notes, &submittedAt, &paidAt, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return c, err
+	}
+	if submittedAt.Valid {
+		c.SubmittedAt = &submittedAt.Time
+	}
+	if paidAt.Valid {
+		c.PaidAt = &paidAt.Time
+	}
+	return c, nil
+}
+
+func (r *claimRepository) GetAll(ctx context.Context, status string) ([]models.Claim, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	query := "SELECT " + claimColumns + " FROM claims"
+	args := []interface{}{}
+	if status != "" {
+		if err := checkClaimStatus(status); err != nil {
+			return nil, err
+		}
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claims := []models.Claim{}
+	for rows.Next() {
+		c, err := scanClaim(rows)
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadClaimTotals(q, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (r *claimRepository) GetByID(ctx context.Context, id int) (*models.Claim, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	return getClaim(q, id)
+}
+
+func (r *claimRepository) Create(ctx context.Context, claim *models.Claim) error {
+	if err := validateClaim(claim); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := tx.ExecReturningID(`
+        INSERT INTO claims (name, status, notes, created_at, updated_at)
+        VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    `, claim.Name, models.ClaimDraft, claim.Notes)
+	if err != nil {
+		return err
+	}
+
+	created, err := getClaim(tx, int(id))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*claim = *created
+	return nil
+}
+
+func (r *claimRepository) Update(ctx context.Context, id int, claim *models.Claim) error {
+	if err := validateClaim(claim); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getClaim(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE claims SET name = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		claim.Name, claim.Notes, id,
+	); err != nil {
+		return err
+	}
+
+	updated, err := getClaim(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*claim = *updated
+	return nil
+}
+
+func (r *claimRepository) Delete(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	claim, err := getClaim(tx, id)
+	if err != nil {
+		return err
+	}
+	if claim.Status != models.ClaimDraft {
+		return ErrClaimLocked
+	}
+
+	expenseIDs, err := claimExpenseIDs(tx, id)
+	if err != nil {
+		return err
+	}
+	for _, expenseID := range expenseIDs {
+		if err := setExpenseClaim(tx, expenseID, nil); err != nil {
+			return err
+		}
+	}
+	// Trashed expenses left the claim when they were deleted, but older
+	// rows may still point at it
+	if _, err := tx.Exec("UPDATE expenses SET claim_id = NULL, version = version + 1 WHERE claim_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM claims WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *claimRepository) AddExpenses(ctx context.Context, id int, expenseIDs []int) (int, error) {
+	return r.moveExpenses(ctx, id, expenseIDs, true)
+}
+
+func (r *claimRepository) RemoveExpenses(ctx context.Context, id int, expenseIDs []int) (int, error) {
+	return r.moveExpenses(ctx, id, expenseIDs, false)
+}
+
+// moveExpenses puts expenses on a draft claim or takes them off it,
+// recording each change in the expense's history.
+func (r *claimRepository) moveExpenses(ctx context.Context, id int, expenseIDs []int, add bool) (int, error) {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	claim, err := getClaim(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	if claim.Status != models.ClaimDraft {
+		return 0, ErrClaimLocked
+	}
+
+	seen := make(map[int]bool)
+	updated := 0
+	for _, expenseID := range expenseIDs {
+		if seen[expenseID] {
+			continue
+		}
+		seen[expenseID] = true
+
+		expense, err := getExpense(tx, expenseID, false)
+		if err != nil {
+			return 0, err
+		}
+		onClaim := expense.ClaimID != nil && *expense.ClaimID == id
+
+		var claimID *int
+		switch {
+		case add && onClaim, !add && expense.ClaimID == nil:
+			continue
+		case add && expense.ClaimID != nil:
+			return 0, fmt.Errorf("%w: expense %d is already on another claim", ErrInvalidClaim, expenseID)
+		case add && (expense.Reimbursable == nil || !*expense.Reimbursable):
+			return 0, fmt.Errorf("%w: expense %d is not reimbursable", ErrInvalidClaim, expenseID)
+		case !add && !onClaim:
+			return 0, fmt.Errorf("%w: expense %d is not on claim %q", ErrInvalidClaim, expenseID, claim.Name)
+		case add:
+			claimID = &id
+		}
+
+		if err := setExpenseClaim(tx, expenseID, claimID); err != nil {
+			return 0, err
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		if _, err := tx.Exec("UPDATE claims SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func (r *claimRepository) SetStatus(ctx context.Context, id int, status string) (*models.Claim, error) {
+	status = strings.ToLower(strings.TrimSpace(status))
+	if err := checkClaimStatus(status); err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	claim, err := getClaim(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if claim.Status == status {
+		return claim, nil
+	}
+
+	allowed := false
+	for _, next := range claimTransitions[claim.Status] {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: a %s claim cannot be marked %s", ErrInvalidClaim, claim.Status, status)
+	}
+	if status == models.ClaimSubmitted && claim.Status == models.ClaimDraft && claim.Expenses == 0 {
+		return nil, fmt.Errorf("%w: the claim has no expenses", ErrInvalidClaim)
+	}
+
+	// Each timestamp records when the claim last reached that status
+	query := "UPDATE claims SET status = ?, updated_at = CURRENT_TIMESTAMP"
+	switch status {
+	case models.ClaimDraft:
+		query += ", submitted_at = NULL"
+	case models.ClaimSubmitted:
+		if claim.Status == models.ClaimDraft {
+			query += ", submitted_at = CURRENT_TIMESTAMP"
+		} else {
+			query += ", paid_at = NULL"
+		}
+	case models.ClaimPaid:
+		query += ", paid_at = CURRENT_TIMESTAMP"
+	}
+	if _, err := tx.Exec(query+" WHERE id = ?", status, id); err != nil {
+		return nil, err
+	}
+
+	updated, err := getClaim(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func getClaim(q database.Querier, id int) (*models.Claim, error) {
+	c, err := scanClaim(q.QueryRow("SELECT "+claimColumns+" FROM claims WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrClaimNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	claims := []models.Claim{c}
+	if err := loadClaimTotals(q, claims); err != nil {
+		return nil, err
+	}
+	return &claims[0], nil
+}
+
+// loadClaimTotals counts and totals each claim's live expenses by currency.
+func loadClaimTotals(q database.Querier, claims []models.Claim) error {
+	if len(claims) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Claim, len(claims))
+	placeholders := make([]string, len(claims))
+	args := make([]interface{}, len(claims))
+	for i := range claims {
+		claims[i].Totals = map[string]models.Money{}
+		byID[claims[i].ID] = &claims[i]
+		placeholders[i] = "?"
+		args[i] = claims[i].ID
+	}
+
+	rows, err := q.Query(`
+        SELECT claim_id, currency, COUNT(*), SUM(amount_cents)
+        FROM expenses
+        WHERE deleted_at IS NULL AND claim_id IN (`+strings.Join(placeholders, ", ")+`)
+        GROUP BY claim_id, currency
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var claimID, count int
+		var code string
+		var total models.Money
+		if err := rows.Scan(&claimID, &code, &count, &total); err != nil {
+			return err
+		}
+		if c := byID[claimID]; c != nil {
+			c.Expenses += count
+			c.Totals[code] = total
+		}
+	}
+	return rows.Err()
+}
+
+func claimExpenseIDs(q database.Querier, id int) ([]int, error) {
+	rows, err := q.Query("SELECT id FROM expenses WHERE deleted_at IS NULL AND claim_id = ? ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var expenseID int
+		if err := rows.Scan(&expenseID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, expenseID)
+	}
+	return ids, rows.Err()
+}
+
+func setExpenseClaim(tx *database.Tx, expenseID int, claimID *int) error {
+	before, err := getExpense(tx, expenseID, false)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE expenses SET claim_id = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", claimID, expenseID)
+	if err != nil {
+		return err
+	}
+	after, err := getExpense(tx, expenseID, true)
+	if err != nil {
+		return err
+	}
+	return recordChange(tx, expenseID, models.ActionUpdate, models.SourceManual, before, after)
+}
+
+// claimLocked reports whether an expense is on a claim that has been
+// submitted, so what was claimed can no longer change.
+func claimLocked(q database.Querier, claimID *int) (bool, error) {
+	if claimID == nil {
+		return false, nil
+	}
+	var status string
+	err := q.QueryRow("SELECT status FROM claims WHERE id = ?", *claimID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return status != models.ClaimDraft, nil
+}
+
+// prepareReimbursement works out whether an expense is reimbursable and the
+// claim it stays on. before is the stored expense on update and nil on
+// create. An expense no longer reimbursable leaves its draft claim, and one
+// on a submitted claim cannot change what was claimed.
+func prepareReimbursement(q database.Querier, expense, before *models.Expense, txType string) (bool, *int, error) {
+	reimbursable := false
+	var claimID *int
+	if before != nil {
+		reimbursable = before.Reimbursable != nil && *before.Reimbursable
+		claimID = before.ClaimID
+	}
+	if expense.Reimbursable != nil {
+		reimbursable = *expense.Reimbursable
+	}
+	if reimbursable && txType != models.TransactionExpense {
+		return false, nil, fmt.Errorf("%w: only expenses can be reimbursable", ErrInvalidTransaction)
+	}
+
+	locked, err := claimLocked(q, claimID)
+	if err != nil {
+		return false, nil, err
+	}
+	if !locked {
+		if !reimbursable {
+			claimID = nil
+		}
+		return reimbursable, claimID, nil
+	}
+
+	if !reimbursable || txType != before.Type || expense.Amount != before.Amount ||
+		expense.Currency != before.Currency || !expense.Date.Equal(before.Date) {
+		return false, nil, ErrClaimLocked
+	}
+	return reimbursable, claimID, nil
+}
+
+func checkClaimStatus(status string) error {
+	for _, valid := range models.ClaimStatuses {
+		if status == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: status must be one of %s", ErrInvalidClaim, strings.Join(models.ClaimStatuses, ", "))
+}
+
+func validateClaim(claim *models.Claim) error {
+	claim.Name = strings.TrimSpace(claim.Name)
+	claim.Notes = strings.TrimSpace(claim.Notes)
+	if claim.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidClaim)
+	}
+	if utf8.RuneCountInString(claim.Name) > maxClaimNameLength {
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalidClaim, maxClaimNameLength)
+	}
+	if utf8.RuneCountInString(claim.Notes) > maxClaimNotesLength {
+		return fmt.Errorf("%w: notes are longer than %d characters", ErrInvalidClaim, maxClaimNotesLength)
+	}
+	return nil
+}
@@ -0,0 +1,507 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+const (
+	maxFieldLabelLength = 100
+	maxFieldTextLength  = 500
+)
+
+// Field names are used as query parameters and map keys, so they are kept to
+// lower-case identifiers.
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+type CustomFieldRepository interface {
+	GetAll(ctx context.Context) ([]models.CustomField, error)
+	GetByID(ctx context.Context, id int) (*models.CustomField, error)
+	Create(ctx context.Context, field *models.CustomField) error
+
+	// Update changes a field's definition. The type cannot change, and enum
+	// options cannot be removed, while expenses have values that would stop
+	// being valid
+	Update(ctx context.Context, id int, field *models.CustomField) error
+
+	// Delete removes a field together with every expense's value for it
+	Delete(ctx context.Context, id int) error
+}
+
+type customFieldRepository struct {
+	db *database.DB
+}
+
+func NewCustomFieldRepository(db *database.DB) CustomFieldRepository {
+	return &customFieldRepository{db: db}
+}
+
+const customFieldColumns = `id, name, label, type, options, required, created_at, updated_at`
+
+func scanCustomField(row rowScanner) (models.CustomField, error) {
+	var f models.CustomField
+	var options string
+	if err := row.Scan(&f.ID, &f.Name, &f.Label, &f.Type, &options, &f.Required, &f.CreatedAt, &f.UpdatedAt); err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal([]byte(options), &f.Options); err != nil {
+		return f, fmt.Errorf("custom field %s has malformed options: %w", f.Name, err)
+	}
+	if f.Options == nil {
+		f.Options = []string{}
+	}
+	return f, nil
+}
+
+// GetAll lists the custom fields by name.
+func (r *customFieldRepository) GetAll(ctx context.Context) ([]models.CustomField, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	return getCustomFields(q)
+}
+
+func (r *customFieldRepository) GetByID(ctx context.Context, id int) (*models.CustomField, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	return getCustomField(q, id)
+}
+
+func (r *customFieldRepository) Create(ctx context.Context, field *models.CustomField) error {
+	if err := validateCustomField(field); err != nil {
+		return err
+	}
+	options, err := json.Marshal(field.Options)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkFieldNameFree(tx, field.Name, 0); err != nil {
+		return err
+	}
+	id, err := tx.ExecReturningID(`
+        INSERT INTO custom_fields (name, label, type, options, required, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    `, field.Name, field.Label, field.Type, string(options), field.Required)
+	if err != nil {
+		return err
+	}
+
+	created, err := getCustomField(tx, int(id))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*field = *created
+	return nil
+}
+
+func (r *customFieldRepository) Update(ctx context.Context, id int, field *models.CustomField) error {
+	if err := validateCustomField(field); err != nil {
+		return err
+	}
+	options, err := json.Marshal(field.Options)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := getCustomField(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkFieldNameFree(tx, field.Name, id); err != nil {
+		return err
+	}
+
+	var used int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM expense_field_values WHERE field_id = ?", id).Scan(&used); err != nil {
+		return err
+	}
+	if used > 0 && field.Type != current.Type {
+		return fmt.Errorf("%w: %d expenses have a %s value", ErrCustomFieldInUse, used, current.Type)
+	}
+	if used > 0 && field.Type == models.FieldEnum {
+		if err := keepEnumValues(tx, id, field.Options); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+        UPDATE custom_fields
+        SET name = ?, label = ?, type = ?, options = ?, required = ?, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, field.Name, field.Label, field.Type, string(options), field.Required, id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := getCustomField(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*field = *updated
+	return nil
+}
+
+// keepEnumValues moves stored values onto the new spelling of their option
+// and refuses options being removed while in use.
+func keepEnumValues(tx *database.Tx, fieldID int, options []string) error {
+	for _, option := range options {
+		if _, err := tx.Exec(
+			"UPDATE expense_field_values SET value = ? WHERE field_id = ? AND LOWER(value) = LOWER(?)",
+			option, fieldID, option,
+		); err != nil {
+			return err
+		}
+	}
+
+	placeholders := make([]string, len(options))
+	args := []interface{}{fieldID}
+	for i, option := range options {
+		placeholders[i] = "?"
+		args = append(args, option)
+	}
+	var value string
+	err := tx.QueryRow(`
+        SELECT value FROM expense_field_values
+        WHERE field_id = ? AND value NOT IN (`+strings.Join(placeholders, ", ")+`)
+        LIMIT 1
+    `, args...).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: expenses still use the option %q", ErrCustomFieldInUse, value)
+}
+
+func (r *customFieldRepository) Delete(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getCustomField(tx, id); err != nil {
+		return err
+	}
+	// Values are removed explicitly in case foreign keys are off
+	if _, err := tx.Exec("DELETE FROM expense_field_values WHERE field_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM custom_fields WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func getCustomFields(q database.Querier) ([]models.CustomField, error) {
+	rows, err := q.Query("SELECT " + customFieldColumns + " FROM custom_fields ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := []models.CustomField{}
+	for rows.Next() {
+		f, err := scanCustomField(rows)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, rows.Err()
+}
+
+func getCustomField(q database.Querier, id int) (*models.CustomField, error) {
+	f, err := scanCustomField(q.QueryRow("SELECT "+customFieldColumns+" FROM custom_fields WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrCustomFieldNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// getCustomFieldByName looks a field up by its name, ignoring case.
+func getCustomFieldByName(q database.Querier, name string) (*models.CustomField, error) {
+	f, err := scanCustomField(q.QueryRow(
+		"SELECT "+customFieldColumns+" FROM custom_fields WHERE name = ?",
+		strings.ToLower(strings.TrimSpace(name)),
+	))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidCustomField, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func checkFieldNameFree(q database.Querier, name string, exceptID int) error {
+	var count int
+	if err := q.QueryRow("SELECT COUNT(*) FROM custom_fields WHERE name = ? AND id <> ?", name, exceptID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCustomFieldExists
+	}
+	return nil
+}
+
+// validateCustomField normalizes a definition: the name is lower-cased, the
+// label defaults to the name and enum options are trimmed and de-duplicated.
+func validateCustomField(field *models.CustomField) error {
+	field.Name = strings.ToLower(strings.TrimSpace(field.Name))
+	if !fieldNamePattern.MatchString(field.Name) {
+		return fmt.Errorf("%w: name must start with a letter and contain only letters, digits and underscores", ErrInvalidCustomField)
+	}
+	field.Label = strings.TrimSpace(field.Label)
+	if field.Label == "" {
+		field.Label = field.Name
+	}
+	if utf8.RuneCountInString(field.Label) > maxFieldLabelLength {
+		return fmt.Errorf("%w: label is longer than %d characters", ErrInvalidCustomField, maxFieldLabelLength)
+	}
+
+	field.Type = strings.ToLower(strings.TrimSpace(field.Type))
+	valid := false
+	for _, t := range models.FieldTypes {
+		valid = valid || field.Type == t
+	}
+	if !valid {
+		return fmt.Errorf("%w: type must be one of %s", ErrInvalidCustomField, strings.Join(models.FieldTypes, ", "))
+	}
+
+	seen := make(map[string]bool)
+	options := []string{}
+	for _, option := range field.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || seen[strings.ToLower(option)] {
+			continue
+		}
+		if utf8.RuneCountInString(option) > maxFieldTextLength {
+			return fmt.Errorf("%w: option %q is longer than %d characters", ErrInvalidCustomField, option, maxFieldTextLength)
+		}
+		seen[strings.ToLower(option)] = true
+		options = append(options, option)
+	}
+	switch {
+	case field.Type == models.FieldEnum && len(options) == 0:
+		return fmt.Errorf("%w: an enum field needs at least one option", ErrInvalidCustomField)
+	case field.Type != models.FieldEnum && len(options) > 0:
+		return fmt.Errorf("%w: only enum fields have options", ErrInvalidCustomField)
+	}
+	field.Options = options
+	return nil
+}
+
+// NormalizeFieldValue checks a value against its field and returns it in
+// canonical form. An empty value stays empty.
+func NormalizeFieldValue(field models.CustomField, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	switch field.Type {
+	case models.FieldNumber:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return "", fmt.Errorf("%w: %s must be a number, not %q", ErrInvalidCustomField, field.Name, value)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case models.FieldDate:
+		d, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s must be a YYYY-MM-DD date, not %q", ErrInvalidCustomField, field.Name, value)
+		}
+		return d.Format("2006-01-02"), nil
+	case models.FieldEnum:
+		for _, option := range field.Options {
+			if strings.EqualFold(option, value) {
+				return option, nil
+			}
+		}
+		return "", fmt.Errorf("%w: %s must be one of %s, not %q", ErrInvalidCustomField, field.Name, strings.Join(field.Options, ", "), value)
+	default:
+		if utf8.RuneCountInString(value) > maxFieldTextLength {
+			return "", fmt.Errorf("%w: %s is longer than %d characters", ErrInvalidCustomField, field.Name, maxFieldTextLength)
+		}
+		return value, nil
+	}
+}
+
+// NormalizeFieldValues checks an expense's values against the field
+// definitions. Field names are matched ignoring case; unknown fields and
+// missing required ones are errors. Empty values are dropped.
+func NormalizeFieldValues(fields []models.CustomField, values models.FieldValues) (models.FieldValues, error) {
+	return normalizeFieldValues(fields, values, true)
+}
+
+func normalizeFieldValues(fields []models.CustomField, values models.FieldValues, enforceRequired bool) (models.FieldValues, error) {
+	byName := make(map[string]models.CustomField, len(fields))
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+
+	normalized := models.FieldValues{}
+	for name, value := range values {
+		field, ok := byName[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidCustomField, name)
+		}
+		value, err := NormalizeFieldValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			normalized[field.Name] = value
+		}
+	}
+
+	for _, f := range fields {
+		if enforceRequired && f.Required && normalized[f.Name] == "" {
+			return nil, fmt.Errorf("%w: %s is required", ErrInvalidCustomField, f.Name)
+		}
+	}
+	return normalized, nil
+}
+
+// prepareFieldValues checks an expense's field values and returns them by
+// field ID. A nil map means the values are left alone and returns nil.
+func prepareFieldValues(q database.Querier, values models.FieldValues, enforceRequired bool) (map[int]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	fields, err := getCustomFields(q)
+	if err != nil {
+		return nil, err
+	}
+	normalized, err := normalizeFieldValues(fields, values, enforceRequired)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]string, len(normalized))
+	for _, f := range fields {
+		if value, ok := normalized[f.Name]; ok {
+			byID[f.ID] = value
+		}
+	}
+	return byID, nil
+}
+
+// setExpenseFields replaces an expense's custom field values.
+func setExpenseFields(tx *database.Tx, expenseID int, values map[int]string) error {
+	if _, err := tx.Exec("DELETE FROM expense_field_values WHERE expense_id = ?", expenseID); err != nil {
+		return err
+	}
+	for fieldID, value := range values {
+		if _, err := tx.Exec(
+			"INSERT INTO expense_field_values (expense_id, field_id, value) VALUES (?, ?, ?)",
+			expenseID, fieldID, value,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFields fills in the custom field values of the given expenses with one
+// query.
+func loadFields(q database.Querier, expenses []models.Expense) error {
+	if len(expenses) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Expense, len(expenses))
+	placeholders := make([]string, len(expenses))
+	args := make([]interface{}, len(expenses))
+	for i := range expenses {
+		expenses[i].Fields = models.FieldValues{}
+		byID[expenses[i].ID] = &expenses[i]
+		placeholders[i] = "?"
+		args[i] = expenses[i].ID
+	}
+
+	rows, err := q.Query(`
+        SELECT expense_field_values.expense_id, custom_fields.name, expense_field_values.value
+        FROM expense_field_values JOIN custom_fields ON custom_fields.id = expense_field_values.field_id
+        WHERE expense_field_values.expense_id IN (`+strings.Join(placeholders, ", ")+`)
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var expenseID int
+		var name, value string
+		if err := rows.Scan(&expenseID, &name, &value); err != nil {
+			return err
+		}
+		if e := byID[expenseID]; e != nil {
+			e.Fields[name] = value
+		}
+	}
+	return rows.Err()
+}
+
+// appendFieldFilters adds custom field conditions to a query's where clause.
+// Each value is normalized like a stored one, so "1.50" finds 1.5; an empty
+// value matches expenses without one.
+func appendFieldFilters(q database.Querier, where *string, filters map[string]string) ([]interface{}, error) {
+	args := []interface{}{}
+	for name, value := range filters {
+		field, err := getCustomFieldByName(q, name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := NormalizeFieldValue(*field, value)
+		if err != nil {
+			return nil, err
+		}
+
+		if value == "" {
+			*where += " AND NOT EXISTS (SELECT 1 FROM expense_field_values WHERE expense_id = expenses.id AND field_id = ?)"
+			args = append(args, field.ID)
+			continue
+		}
+		*where += " AND EXISTS (SELECT 1 FROM expense_field_values WHERE expense_id = expenses.id AND field_id = ? AND value = ?)"
+		args = append(args, field.ID, value)
+	}
+	return args, nil
+}
@@ -1,7 +1,50 @@
 package repository
 
-import "errors"
+import (
+    "errors"
+    "expense-tracker/internal/database"
+)
 
 var (
-    ErrExpenseNotFound = errors.New("expense not found")
+    ErrExpenseNotFound    = errors.New("expense not found")
+    ErrInvalidDateRange   = errors.New("dates must be in YYYY-MM-DD format")
+    ErrVersionNotFound    = errors.New("expense version not found")
+    ErrVersionConflict    = errors.New("expense has been changed since it was read")
+    ErrInvalidTag         = errors.New("invalid tag")
+    ErrInvalidSplit       = errors.New("invalid split")
+    ErrInvalidTransaction = errors.New("invalid transaction")
+    ErrInvalidFilter      = errors.New("invalid filter")
+    ErrInvalidBulkChange  = errors.New("invalid bulk change")
+    
+    // ErrTimeout wraps the error of an operation that ran past its
+    // configured timeout
+    ErrTimeout = database.ErrTimeout
+    
+    ErrCategoryNotFound    = errors.New("category not found")
+    ErrCategoryExists      = errors.New("a category with that name already exists")
+    ErrCategoryInUse       = errors.New("category is used by expenses or rules; archive it instead")
+    ErrCategoryHasChildren = errors.New("category has subcategories; move or delete them first")
+    ErrDefaultCategory     = errors.New("the default category cannot be renamed or deleted")
+    ErrInvalidCategory     = errors.New("invalid category")
+    
+    ErrAccountNotFound = errors.New("account not found")
+    ErrAccountExists   = errors.New("an account with that name already exists")
+    ErrAccountInUse    = errors.New("account is used by expenses")
+    ErrInvalidAccount  = errors.New("invalid account")
+    
+    ErrAttachmentNotFound = errors.New("attachment not found")
+    
+    ErrCustomFieldNotFound = errors.New("custom field not found")
+    ErrCustomFieldExists   = errors.New("a custom field with that name already exists")
+    ErrCustomFieldInUse    = errors.New("custom field values are in use")
+    ErrInvalidCustomField  = errors.New("invalid custom field")
+    
+    ErrMerchantNotFound = errors.New("merchant not found")
+    ErrMerchantExists   = errors.New("a merchant with that name already exists")
+    ErrAliasExists      = errors.New("alias is already used by another merchant")
+    ErrInvalidMerchant  = errors.New("invalid merchant")
+    
+    ErrClaimNotFound = errors.New("claim not found")
+    ErrClaimLocked   = errors.New("claim has been submitted; reopen it as a draft first")
+    ErrInvalidClaim  = errors.New("invalid claim")
 )
\ No newline at end of file
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"expense-tracker/internal/currency"
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+type ExchangeRateRepository interface {
+	Upsert(ctx context.Context, rates []models.ExchangeRate) (int, error)
+	List(ctx context.Context, base, currencyCode string, limit int) ([]models.ExchangeRate, error)
+	// Converter loads the rates needed to convert the given currencies for
+	// dates in [from, to], including the last rate published before from.
+	Converter(ctx context.Context, currencies []string, from, to time.Time) (*currency.Converter, error)
+}
+
+type exchangeRateRepository struct {
+	db *database.DB
+}
+
+func NewExchangeRateRepository(db *database.DB) ExchangeRateRepository {
+	return &exchangeRateRepository{db: db}
+}
+
+func (r *exchangeRateRepository) Upsert(ctx context.Context, rates []models.ExchangeRate) (int, error) {
+	tx, err := r.db.BeginTx(ctx, database.OpBulk)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+        INSERT INTO exchange_rates (date, base_currency, currency, rate)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (base_currency, currency, date) DO UPDATE SET rate = excluded.rate
+    `)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, rate := range rates {
+		if _, err := stmt.ExecContext(ctx, rate.Date, rate.BaseCurrency, rate.Currency, rate.Rate); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(rates), nil
+}
+
+func (r *exchangeRateRepository) List(ctx context.Context, base, currencyCode string, limit int) ([]models.ExchangeRate, error) {
+	query := `
+        SELECT date, base_currency, currency, rate
+        FROM exchange_rates
+        WHERE 1=1
+    `
+	args := []interface{}{}
+
+	if base != "" {
+		query += " AND base_currency = ?"
+		args = append(args, base)
+	}
+	if currencyCode != "" {
+		query += " AND currency = ?"
+		args = append(args, currencyCode)
+	}
+
+	query += " ORDER BY date DESC, base_currency, currency"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	return r.query(ctx, query, args...)
+}
+
+func (r *exchangeRateRepository) Converter(ctx context.Context, currencies []string, from, to time.Time) (*currency.Converter, error) {
+	if len(currencies) == 0 {
+		return currency.NewConverter(nil), nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(currencies)), ", ")
+	query := `
+        SELECT date, base_currency, currency, rate
+        FROM exchange_rates r
+        WHERE currency IN (` + placeholders + `)
+          AND date <= ?
+          AND date >= COALESCE((
+              SELECT MAX(p.date) FROM exchange_rates p
+              WHERE p.base_currency = r.base_currency AND p.currency = r.currency AND p.date <= ?
+          ), ?)
+    `
+	args := make([]interface{}, 0, len(currencies)+3)
+	for _, c := range currencies {
+		args = append(args, c)
+	}
+	args = append(args, to, from, from)
+
+	rates, err := r.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return currency.NewConverter(rates), nil
+}
+
+func (r *exchangeRateRepository) query(ctx context.Context, query string, args ...interface{}) ([]models.ExchangeRate, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []models.ExchangeRate{}
+	for rows.Next() {
+		var rate models.ExchangeRate
+		if err := rows.Scan(&rate.Date, &rate.BaseCurrency, &rate.Currency, &rate.Rate); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
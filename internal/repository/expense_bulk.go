@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+// bulkSampleSize is how many affected expenses a bulk result shows
+const bulkSampleSize = 10
+
+// BulkUpdate applies the same changes to every selected expense. Nothing is
+// saved if any of them fails, and a dry run makes the changes only to roll
+// them back, so it reports exactly what a real run would.
+func (r *expenseRepository) BulkUpdate(ctx context.Context, selection models.BulkSelection, changes models.BulkChanges, dryRun bool) (*models.BulkResult, error) {
+	if changes.IsEmpty() {
+		return nil, fmt.Errorf("%w: no changes given", ErrInvalidBulkChange)
+	}
+	add, err := NormalizeTags(changes.AddTags)
+	if err != nil {
+		return nil, err
+	}
+	remove, err := NormalizeTags(changes.RemoveTags)
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[string]bool)
+	for _, tag := range remove {
+		removed[tag] = true
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpBulk)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids, err := r.selectExpenses(tx, selection)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.BulkResult{DryRun: dryRun, Matched: len(ids), Sample: []models.Expense{}}
+	for _, id := range ids {
+		expense, err := getExpense(tx, id, false)
+		if err != nil {
+			return nil, fmt.Errorf("expense %d: %w", id, err)
+		}
+		changed, err := applyBulkChanges(expense, changes, add, removed)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			continue
+		}
+
+		expense.Fields = nil
+		updated, err := updateExpense(tx, id, expense, models.ActionUpdate, models.SourceBulkEdit)
+		if err != nil {
+			return nil, fmt.Errorf("expense %d: %w", id, err)
+		}
+		addBulkSample(result, updated)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// BulkDelete moves every selected expense to the trash, or none of them if
+// one cannot be deleted. A dry run reports what would go, like BulkUpdate.
+func (r *expenseRepository) BulkDelete(ctx context.Context, selection models.BulkSelection, dryRun bool) (*models.BulkResult, error) {
+	tx, err := r.db.BeginTx(ctx, database.OpBulk)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids, err := r.selectExpenses(tx, selection)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.BulkResult{DryRun: dryRun, Matched: len(ids), Sample: []models.Expense{}}
+	for _, id := range ids {
+		deleted, err := deleteExpense(tx, id, models.SourceBulkEdit)
+		if err != nil {
+			return nil, fmt.Errorf("expense %d: %w", id, err)
+		}
+		addBulkSample(result, deleted)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func addBulkSample(result *models.BulkResult, expense *models.Expense) {
+	result.Affected++
+	if len(result.Sample) < bulkSampleSize {
+		result.Sample = append(result.Sample, *expense)
+	}
+}
+
+// applyBulkChanges sets the changed fields on a loaded expense and reports
+// whether any of them differ from what it had.
+func applyBulkChanges(expense *models.Expense, changes models.BulkChanges, add []string, removed map[string]bool) (bool, error) {
+	changed := false
+	if changes.Category != nil && !strings.EqualFold(strings.TrimSpace(*changes.Category), expense.Category) {
+		expense.CategoryID = 0
+		expense.Category = *changes.Category
+		changed = true
+	}
+	if changes.Vendor != nil && *changes.Vendor != expense.Vendor {
+		expense.Vendor = *changes.Vendor
+		changed = true
+	}
+	if changes.PaymentMethod != nil && *changes.PaymentMethod != expense.PaymentMethod {
+		expense.PaymentMethod = *changes.PaymentMethod
+		changed = true
+	}
+
+	tags := append([]string{}, add...)
+	for _, tag := range expense.Tags {
+		if !removed[tag] {
+			tags = append(tags, tag)
+		}
+	}
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return false, err
+	}
+	if strings.Join(tags, ",") != strings.Join(expense.Tags, ",") {
+		expense.Tags = tags
+		changed = true
+	}
+	return changed, nil
+}
+
+// selectExpenses returns the IDs of the selected expenses: listed IDs in the
+// order given, or the expenses matching the filter in its sort order. A
+// listed expense that is missing or in the trash fails when it is loaded.
+func (r *expenseRepository) selectExpenses(tx *database.Tx, selection models.BulkSelection) ([]int, error) {
+	switch {
+	case selection.Filter != nil && len(selection.IDs) > 0:
+		return nil, fmt.Errorf("%w: give either ids or a filter, not both", ErrInvalidBulkChange)
+	case selection.Filter == nil && len(selection.IDs) == 0:
+		return nil, fmt.Errorf("%w: ids or a filter is required", ErrInvalidBulkChange)
+	case selection.Filter == nil:
+		seen := make(map[int]bool)
+		ids := make([]int, 0, len(selection.IDs))
+		for _, id := range selection.IDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+
+	list, err := r.buildExpenseQuery(tx, *selection.Filter)
+	if err != nil {
+		return nil, err
+	}
+	// An empty filter would select every expense, which is never what a
+	// fix-up means
+	if !list.filtered() {
+		return nil, fmt.Errorf("%w: the filter has no conditions", ErrInvalidBulkChange)
+	}
+	order, err := list.order(*selection.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(list.idsSQL(list.orderBy(order)), list.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+// Fields that change on every write and would only add noise to diffs
+var historyIgnoredFields = map[string]bool{"updated_at": true, "version": true}
+
+// recordChange appends an entry to the expense's history. It must run in the
+// same transaction as the change it describes.
+func recordChange(q database.Querier, expenseID int, action, source string, before, after *models.Expense) error {
+	var version int
+	err := q.QueryRow("SELECT COALESCE(MAX(version), 0) FROM expense_history WHERE expense_id = ?", expenseID).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	// Expenses created before history existed get their prior state
+	// recorded first, so it stays reachable by revert
+	if version == 0 && before != nil {
+		if err := insertChange(q, expenseID, 1, models.ActionBaseline, models.SourceMigration, nil, before); err != nil {
+			return err
+		}
+		version = 1
+	}
+
+	return insertChange(q, expenseID, version+1, action, source, before, after)
+}
+
+func insertChange(q database.Querier, expenseID, version int, action, source string, before, after *models.Expense) error {
+	beforeData, err := snapshotJSON(before)
+	if err != nil {
+		return err
+	}
+	afterData, err := snapshotJSON(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`
+        INSERT INTO expense_history (expense_id, version, action, source, before_data, after_data, changed_at)
+        VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+    `, expenseID, version, action, source, beforeData, afterData)
+	return err
+}
+
+func snapshotJSON(e *models.Expense) (interface{}, error) {
+	if e == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (r *expenseRepository) GetHistory(ctx context.Context, id int) ([]models.ExpenseChange, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	rows, err := q.Query(`
+        SELECT id, expense_id, version, action, source, before_data, after_data, changed_at
+        FROM expense_history
+        WHERE expense_id = ?
+        ORDER BY version DESC
+    `, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.ExpenseChange{}
+	for rows.Next() {
+		var c models.ExpenseChange
+		var before, after sql.NullString
+		if err := rows.Scan(&c.ID, &c.ExpenseID, &c.Version, &c.Action, &c.Source, &before, &after, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			c.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			c.After = json.RawMessage(after.String)
+		}
+		c.ChangedFields = changedFields(c.Before, c.After)
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(changes) == 0 {
+		return nil, ErrExpenseNotFound
+	}
+	return changes, nil
+}
+
+// RevertToVersion restores the field values an expense had at version. The
+// revert is itself recorded as a new version, so it can be undone too.
+func (r *expenseRepository) RevertToVersion(ctx context.Context, id, version int) (*models.Expense, error) {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var data sql.NullString
+	err = tx.QueryRow("SELECT after_data FROM expense_history WHERE expense_id = ? AND version = ?", id, version).Scan(&data)
+	if err == sql.ErrNoRows || (err == nil && !data.Valid) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot models.Expense
+	if err := json.Unmarshal([]byte(data.String), &snapshot); err != nil {
+		return nil, err
+	}
+
+	// The category is restored by ID, since its name may have changed
+	// since the snapshot. Snapshots from before categories had IDs only
+	// carry the name.
+	if snapshot.CategoryID != 0 {
+		snapshot.Category = ""
+	}
+	// A snapshot without an account restores the expense to none, rather
+	// than keeping the current one as an update leaving it out would.
+	// Snapshots from before transaction types are all expenses.
+	none := 0
+	if snapshot.AccountID == nil {
+		snapshot.AccountID = &none
+	}
+	if snapshot.TransferAccountID == nil {
+		snapshot.TransferAccountID = &none
+	}
+	snapshot.Account = ""
+	snapshot.TransferAccount = ""
+	if snapshot.Type == "" {
+		snapshot.Type = models.TransactionExpense
+	}
+	for i := range snapshot.Splits {
+		if snapshot.Splits[i].CategoryID != 0 {
+			snapshot.Splits[i].Category = ""
+		}
+	}
+	// Values of custom fields deleted since are dropped
+	if snapshot.Fields != nil {
+		fields, err := getCustomFields(tx)
+		if err != nil {
+			return nil, err
+		}
+		defined := make(map[string]bool, len(fields))
+		for _, f := range fields {
+			defined[f.Name] = true
+		}
+		for name := range snapshot.Fields {
+			if !defined[name] {
+				delete(snapshot.Fields, name)
+			}
+		}
+	}
+
+	reverted, err := updateExpense(tx, id, &snapshot, models.ActionRevert, models.SourceManual)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+// changedFields lists the top-level JSON fields that differ between two
+// snapshots.
+func changedFields(before, after json.RawMessage) []string {
+	var b, a map[string]interface{}
+	if len(before) > 0 {
+		if err := json.Unmarshal(before, &b); err != nil {
+			return nil
+		}
+	}
+	if len(after) > 0 {
+		if err := json.Unmarshal(after, &a); err != nil {
+			return nil
+		}
+	}
+	if b == nil || a == nil {
+		return nil
+	}
+
+	fields := []string{}
+	for key, value := range a {
+		if !historyIgnoredFields[key] && !reflect.DeepEqual(b[key], value) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok && !historyIgnoredFields[key] {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,345 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+// expenseQuery is the FROM and WHERE clauses of an expense list together with
+// their arguments. GetAll counts and pages through the same expenseQuery, so
+// the total always describes the rows being listed.
+type expenseQuery struct {
+	from  string
+	where string
+	args  []interface{}
+
+	// terms are the search terms, if any; ranked is set when full-text
+	// search supplies match_rank and the match_* highlight columns
+	terms  []string
+	ranked bool
+}
+
+// add appends a condition, starting with " AND", and its arguments.
+func (q *expenseQuery) add(clause string, args ...interface{}) {
+	q.where += clause
+	q.args = append(q.args, args...)
+}
+
+func (q *expenseQuery) countSQL() string {
+	return "SELECT COUNT(*) FROM " + q.from + q.where
+}
+
+// idsSQL selects just the expense IDs, for changing the listed expenses.
+func (q *expenseQuery) idsSQL(orderBy string) string {
+	return "SELECT expenses.id FROM " + q.from + q.where + " ORDER BY " + orderBy
+}
+
+// filtered reports whether the query narrows the list down at all.
+func (q *expenseQuery) filtered() bool {
+	return q.where != liveExpenses || len(q.terms) > 0
+}
+
+func (q *expenseQuery) selectSQL(orderBy string) string {
+	columns := expenseColumns
+	if q.ranked {
+		columns += ", match_description, match_vendor, match_payment_method"
+	}
+	return "SELECT " + columns + " FROM " + q.from + q.where + " ORDER BY " + orderBy
+}
+
+const liveExpenses = " WHERE deleted_at IS NULL"
+
+// buildExpenseQuery turns a filter into the query GetAll runs. Custom field
+// filters are looked up through db.
+func (r *expenseRepository) buildExpenseQuery(db database.Querier, filter models.ExpenseFilter) (*expenseQuery, error) {
+	q := &expenseQuery{from: expenseFrom, where: liveExpenses, args: []interface{}{}}
+
+	q.terms = searchTerms(filter.Query)
+	q.ranked = len(q.terms) > 0 && r.db.FullTextSearch()
+	if q.ranked {
+		q.from += ` JOIN (
+            SELECT rowid AS match_id, bm25(expenses_fts) AS match_rank,
+                   highlight(expenses_fts, 0, char(1), char(2)) AS match_description,
+                   highlight(expenses_fts, 1, char(1), char(2)) AS match_vendor,
+                   highlight(expenses_fts, 2, char(1), char(2)) AS match_payment_method
+            FROM expenses_fts
+            WHERE expenses_fts MATCH ?
+        ) matches ON matches.match_id = expenses.id`
+		q.args = append(q.args, ftsMatchExpr(q.terms))
+	} else if len(q.terms) > 0 {
+		clause, likeArgs := likeSearchClause(q.terms)
+		q.add(clause, likeArgs...)
+	}
+
+	if !filter.StartDate.IsZero() {
+		q.add(" AND date >= ?", filter.StartDate)
+	}
+	if !filter.EndDate.IsZero() {
+		q.add(" AND date <= ?", filter.EndDate)
+	}
+
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		return nil, fmt.Errorf("%w: min_amount is more than max_amount", ErrInvalidFilter)
+	}
+	if filter.MinAmount != nil {
+		q.add(" AND expenses.amount_cents >= ?", int64(*filter.MinAmount))
+	}
+	if filter.MaxAmount != nil {
+		q.add(" AND expenses.amount_cents <= ?", int64(*filter.MaxAmount))
+	}
+
+	if vendor := strings.TrimSpace(filter.Vendor); vendor != "" {
+		q.add(` AND LOWER(expenses.vendor) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(vendor))+"%")
+	}
+	if method := strings.TrimSpace(filter.PaymentMethod); method != "" {
+		q.add(` AND LOWER(expenses.payment_method) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(method))+"%")
+	}
+
+	q.addCategories(filter)
+
+	if len(filter.TagsAny) > 0 || len(filter.TagsAll) > 0 {
+		tagsAny, err := NormalizeTags(filter.TagsAny)
+		if err != nil {
+			return nil, err
+		}
+		tagsAll, err := NormalizeTags(filter.TagsAll)
+		if err != nil {
+			return nil, err
+		}
+		clause, tagArgs := tagFilterClause(tagsAny, tagsAll)
+		q.add(clause, tagArgs...)
+	}
+	if filter.AccountID != 0 {
+		q.add(" AND (expenses.account_id = ? OR expenses.transfer_account_id = ?)", filter.AccountID, filter.AccountID)
+	}
+	if filter.Type != "" {
+		txType, err := normalizeTransactionType(filter.Type)
+		if err != nil {
+			return nil, err
+		}
+		q.add(" AND expenses.type = ?", txType)
+	}
+	if filter.Reimbursable != nil {
+		q.add(" AND expenses.reimbursable = ?", *filter.Reimbursable)
+	}
+	if filter.ClaimID != 0 {
+		q.add(" AND expenses.claim_id = ?", filter.ClaimID)
+	}
+	if filter.Unclaimed {
+		q.add(" AND expenses.claim_id IS NULL")
+	}
+
+	if err := q.addTimestampRange("created_at", filter.CreatedFrom, filter.CreatedBefore); err != nil {
+		return nil, err
+	}
+	if err := q.addTimestampRange("updated_at", filter.UpdatedFrom, filter.UpdatedBefore); err != nil {
+		return nil, err
+	}
+
+	fieldArgs, err := appendFieldFilters(db, &q.where, filter.Fields)
+	if err != nil {
+		return nil, err
+	}
+	q.args = append(q.args, fieldArgs...)
+	return q, nil
+}
+
+// addCategories limits the list to the filter's categories and their
+// subcategories. A split expense matches when any of its lines does.
+// Uncategorized expenses, those in the default category, are matched
+// alongside the named categories or, when Uncategorized is false, left out.
+func (q *expenseQuery) addCategories(filter models.ExpenseFilter) {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, name := range append([]string{filter.Category}, filter.Categories...) {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var conditions []string
+	var args []interface{}
+	if len(names) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+		subtree := categorySubtree("name IN (" + placeholders + ")")
+		conditions = append(conditions, `expenses.category_id IN `+subtree+`
+            OR expenses.id IN (SELECT expense_id FROM expense_splits WHERE category_id IN `+subtree+`)`)
+		for i := 0; i < 2; i++ {
+			for _, name := range names {
+				args = append(args, name)
+			}
+		}
+	}
+	if filter.Uncategorized != nil && *filter.Uncategorized {
+		conditions = append(conditions, "expenses.category_id IN (SELECT id FROM categories WHERE name = ?)")
+		args = append(args, DefaultCategory)
+	}
+	if len(conditions) > 0 {
+		q.add(" AND ("+strings.Join(conditions, " OR ")+")", args...)
+	}
+
+	if filter.Uncategorized != nil && !*filter.Uncategorized {
+		q.add(" AND expenses.category_id NOT IN (SELECT id FROM categories WHERE name = ?)", DefaultCategory)
+	}
+}
+
+// addTimestampRange limits a timestamp column to [from, before). Timestamps
+// are stored in UTC as written by CURRENT_TIMESTAMP, so the bounds are
+// compared in the same form.
+func (q *expenseQuery) addTimestampRange(column string, from, before time.Time) error {
+	if !from.IsZero() && !before.IsZero() && !from.Before(before) {
+		return fmt.Errorf("%w: the %s range is empty", ErrInvalidFilter, column)
+	}
+	const layout = "2006-01-02 15:04:05"
+	if !from.IsZero() {
+		q.add(" AND expenses."+column+" >= ?", from.UTC().Format(layout))
+	}
+	if !before.IsZero() {
+		q.add(" AND expenses."+column+" < ?", before.UTC().Format(layout))
+	}
+	return nil
+}
+
+// expenseSorts maps the sort keys the expense list accepts to the column each
+// one orders by. Text is sorted ignoring case.
+var expenseSorts = map[string]string{
+	"date":           "expenses.date",
+	"amount":         "expenses.amount_cents",
+	"description":    "LOWER(expenses.description)",
+	"vendor":         "LOWER(expenses.vendor)",
+	"payment_method": "LOWER(expenses.payment_method)",
+	"category":       "LOWER(" + categoryName + ")",
+	"account":        "LOWER(COALESCE(accounts.name, ''))",
+	"currency":       "expenses.currency",
+	"type":           "expenses.type",
+	"created_at":     "expenses.created_at",
+	"updated_at":     "expenses.updated_at",
+	"id":             "expenses.id",
+}
+
+// Dates, amounts and timestamps list the latest or largest first unless asked
+// otherwise; everything else runs A to Z.
+var descendingSorts = map[string]bool{
+	"date":       true,
+	"amount":     true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// SortRelevance orders search results best match first. It is the default
+// when the filter has a query.
+const SortRelevance = "relevance"
+
+// expenseOrder is a filter's sort once defaults are applied.
+type expenseOrder struct {
+	key  string
+	desc bool
+}
+
+// order resolves and checks a filter's sort and order.
+func (q *expenseQuery) order(filter models.ExpenseFilter) (expenseOrder, error) {
+	key := strings.ToLower(strings.TrimSpace(filter.Sort))
+	if key == "" {
+		key = "date"
+		if len(q.terms) > 0 {
+			key = SortRelevance
+		}
+	}
+
+	if key == SortRelevance {
+		if len(q.terms) == 0 {
+			return expenseOrder{}, fmt.Errorf("%w: sorting by relevance needs a search query", ErrInvalidFilter)
+		}
+		if order := strings.ToLower(strings.TrimSpace(filter.Order)); order != "" && order != "desc" {
+			return expenseOrder{}, fmt.Errorf("%w: relevance lists the best matches first", ErrInvalidFilter)
+		}
+		return expenseOrder{key: key, desc: true}, nil
+	}
+
+	if _, ok := expenseSorts[key]; !ok {
+		keys := make([]string, 0, len(expenseSorts)+1)
+		for k := range expenseSorts {
+			keys = append(keys, k)
+		}
+		keys = append(keys, SortRelevance)
+		sort.Strings(keys)
+		return expenseOrder{}, fmt.Errorf("%w: sort must be one of %s", ErrInvalidFilter, strings.Join(keys, ", "))
+	}
+
+	o := expenseOrder{key: key, desc: descendingSorts[key]}
+	switch strings.ToLower(strings.TrimSpace(filter.Order)) {
+	case "":
+	case "asc":
+		o.desc = false
+	case "desc":
+		o.desc = true
+	default:
+		return expenseOrder{}, fmt.Errorf("%w: order must be asc or desc", ErrInvalidFilter)
+	}
+	return o, nil
+}
+
+// orderBy returns the ORDER BY clause for a sort. Every order ends on
+// expenses.id, so rows that tie keep their place from one page to the next.
+func (q *expenseQuery) orderBy(o expenseOrder) string {
+	if o.key == SortRelevance {
+		// Without full-text search there is no rank; newest first will do
+		if q.ranked {
+			return "match_rank, expenses.date DESC, expenses.id DESC"
+		}
+		return "expenses.date DESC, expenses.id DESC"
+	}
+
+	direction := "ASC"
+	if o.desc {
+		direction = "DESC"
+	}
+	if o.key == "id" {
+		return "expenses.id " + direction
+	}
+	return expenseSorts[o.key] + " " + direction + ", expenses.id " + direction
+}
+
+// expenseCursor is a position in a list ordered by date: the date and ID of
+// the row it was taken from. A back cursor pages towards the start of the
+// list, a forward one towards the end. Clients only see it encoded.
+type expenseCursor struct {
+	Date time.Time `json:"d"`
+	ID   int       `json:"i"`
+	Back bool      `json:"b,omitempty"`
+}
+
+func newExpenseCursor(e models.Expense, back bool) string {
+	data, _ := json.Marshal(expenseCursor{Date: e.Date, ID: e.ID, Back: back})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeExpenseCursor(s string) (expenseCursor, error) {
+	var c expenseCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.ID <= 0 || c.Date.IsZero() {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+	}
+	return c, nil
+}
+
+// addCursor limits the query to the rows past a cursor in the given order.
+func (q *expenseQuery) addCursor(c expenseCursor, desc bool) {
+	cmp := ">"
+	if desc {
+		cmp = "<"
+	}
+	q.add(" AND (expenses.date "+cmp+" ? OR (expenses.date = ? AND expenses.id "+cmp+" ?))", c.Date, c.Date, c.ID)
+}
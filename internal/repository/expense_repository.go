@@ -1,20 +1,66 @@
 package repository
 
 import (
+    "context"
+    "database/sql"
+    "expense-tracker/internal/currency"
     "expense-tracker/internal/database"
     "expense-tracker/internal/models"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
 )
 
 type ExpenseRepository interface {
-    GetAll(filter models.ExpenseFilter, page, limit int) ([]models.Expense, *PaginationInfo, error)
-    GetByID(id int) (*models.Expense, error)
-    Create(expense *models.Expense) error
-    Update(id int, expense *models.Expense) error
-    Delete(id int) error
-    GetStats(startDate, endDate, category string) (map[string]interface{}, error)
-    GetMonthlyStats(startDate, endDate, category string) (map[string]interface{}, error)
-    BulkInsert(expenses []models.Expense) ([]models.Expense, error)
-    CheckForDuplicates(expenses []models.Expense) ([]DuplicateInfo, error)
+    GetAll(ctx context.Context, filter models.ExpenseFilter, page, limit int) ([]models.Expense, *PaginationInfo, error)
+    
+    // GetAllByCursor returns the limit expenses past a cursor from an
+    // earlier page. Unlike page numbers, cursors don't skip or repeat rows
+    // when expenses are added in between
+    GetAllByCursor(ctx context.Context, filter models.ExpenseFilter, cursor string, limit int) ([]models.Expense, *PaginationInfo, error)
+    GetByID(ctx context.Context, id int) (*models.Expense, error)
+    Create(ctx context.Context, expense *models.Expense) error
+    
+    // Update and Delete only go ahead while the expense is at ifVersion,
+    // and fail with ErrVersionConflict once it has moved on. An ifVersion
+    // of 0 applies the change whatever the version
+    Update(ctx context.Context, id int, expense *models.Expense, ifVersion int) error
+    Delete(ctx context.Context, id int, ifVersion int) error
+    
+    GetStats(ctx context.Context, filter models.StatsFilter) (map[string]interface{}, error)
+    GetMonthlyStats(ctx context.Context, filter models.StatsFilter) (map[string]interface{}, error)
+    
+    // GetCashFlow reports income, spending and their difference per month.
+    // Transfers between accounts are left out.
+    GetCashFlow(ctx context.Context, filter models.StatsFilter) (map[string]interface{}, error)
+    BulkInsert(ctx context.Context, expenses []models.Expense) ([]models.Expense, error)
+    CheckForDuplicates(ctx context.Context, expenses []models.Expense) ([]DuplicateInfo, error)
+    
+    // Trash holds soft-deleted expenses until they are restored or purged
+    GetTrash(ctx context.Context, page, limit int) ([]models.Expense, *PaginationInfo, error)
+    Restore(ctx context.Context, id int) error
+    Purge(ctx context.Context, id int) error
+    PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, error)
+    
+    // History is the audit log of every change to an expense
+    GetHistory(ctx context.Context, id int) ([]models.ExpenseChange, error)
+    RevertToVersion(ctx context.Context, id, version int) (*models.Expense, error)
+    
+    // Recategorize sets new categories by expense ID, recording source as
+    // the origin of each change
+    Recategorize(ctx context.Context, categories map[int]string, source string) (int, error)
+    
+    // SetVendors sets new vendors by expense ID, like Recategorize
+    SetVendors(ctx context.Context, vendors map[int]string, source string) (int, error)
+    
+    // UpdateTags adds and removes tags on several expenses at once
+    UpdateTags(ctx context.Context, ids []int, add, remove []string) (int, error)
+    
+    // BulkUpdate and BulkDelete change every selected expense in one
+    // transaction, or on a dry run report what would change without saving
+    BulkUpdate(ctx context.Context, selection models.BulkSelection, changes models.BulkChanges, dryRun bool) (*models.BulkResult, error)
+    BulkDelete(ctx context.Context, selection models.BulkSelection, dryRun bool) (*models.BulkResult, error)
 }
 
 type PaginationInfo struct {
@@ -23,6 +69,11 @@ type PaginationInfo struct {
     Limit       int  `json:"limit"`
     HasNext     bool `json:"has_next"`
     HasPrevious bool `json:"has_previous"`
+    
+    // Cursors continue from either end of the page with GetAllByCursor.
+    // They are only set for lists sorted by date
+    NextCursor     string `json:"next_cursor,omitempty"`
+    PreviousCursor string `json:"previous_cursor,omitempty"`
 }
 
 type DuplicateInfo struct {
@@ -33,78 +84,138 @@ type DuplicateInfo struct {
 }
 
 type expenseRepository struct {
-    db *database.DB
+    db       *database.DB
+    settings SettingsRepository
+    rates    ExchangeRateRepository
 }
 
 func NewExpenseRepository(db *database.DB) ExpenseRepository {
-    return &expenseRepository{db: db}
+    return &expenseRepository{
+        db:       db,
+        settings: NewSettingsRepository(db),
+        rates:    NewExchangeRateRepository(db),
+    }
 }
 
-func (r *expenseRepository) GetAll(filter models.ExpenseFilter, page, limit int) ([]models.Expense, *PaginationInfo, error) {
-    // Build query with filters
-    query := `
-        SELECT id, date, category, description, amount, vendor, payment_method, created_at, updated_at
-        FROM expenses
-        WHERE 1=1
-    `
-    args := []interface{}{}
-    
-    if !filter.StartDate.IsZero() {
-        query += " AND date >= ?"
-        args = append(args, filter.StartDate)
+// expenseColumns is the column list scanExpense expects, in order. It must
+// be selected from expenseFrom so the category and account names are
+// available.
+const expenseColumns = `expenses.id, expenses.type, expenses.date, expenses.category_id, ` + categoryName + `, expenses.description, expenses.amount_cents, expenses.currency, expenses.vendor, expenses.payment_method, expenses.account_id, COALESCE(accounts.name, ''), expenses.transfer_account_id, COALESCE(transfer_accounts.name, ''), expenses.reconciled, expenses.reimbursable, expenses.claim_id, expenses.version, expenses.created_at, expenses.updated_at, expenses.deleted_at`
+
+// The join is an outer one so an expense whose category row went missing
+// (possible only with foreign keys off) can still be loaded and repaired.
+const (
+    expenseFrom  = `expenses LEFT JOIN categories ON categories.id = expenses.category_id
+        LEFT JOIN accounts ON accounts.id = expenses.account_id
+        LEFT JOIN accounts transfer_accounts ON transfer_accounts.id = expenses.transfer_account_id`
+    categoryName = `COALESCE(categories.name, '')`
+)
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// scanExpense reads the columns in expenseColumns followed by any extra
+// columns the query selected.
+func scanExpense(row rowScanner, extra ...interface{}) (models.Expense, error) {
+    var e models.Expense
+    var accountID, transferAccountID, claimID sql.NullInt64
+    var reimbursable bool
+    var deletedAt sql.NullTime
+    dest := []interface{}{&e.ID, &e.Type, &e.Date, &e.CategoryID, &e.Category, &e.Description,
+                          &e.Amount, &e.Currency, &e.Vendor, &e.PaymentMethod,
+                          &accountID, &e.Account, &transferAccountID, &e.TransferAccount, &e.Reconciled,
+                          &reimbursable, &claimID, &e.Version, &e.CreatedAt, &e.UpdatedAt, &deletedAt}
+    err := row.Scan(append(dest, extra...)...)
+    e.AccountID = nullableID(accountID)
+    e.TransferAccountID = nullableID(transferAccountID)
+    e.Reimbursable = &reimbursable
+    e.ClaimID = nullableID(claimID)
+    if deletedAt.Valid {
+        e.DeletedAt = &deletedAt.Time
+    }
+    return e, err
+}
+
+func nullableID(id sql.NullInt64) *int {
+    if !id.Valid {
+        return nil
+    }
+    v := int(id.Int64)
+    return &v
+}
+
+// loadExpenseDetails fills in the tags and split lines, which are stored
+// outside the expenses table.
+func loadExpenseDetails(q database.Querier, expenses []models.Expense) error {
+    if err := loadTags(q, expenses); err != nil {
+        return err
+    }
+    if err := loadSplits(q, expenses); err != nil {
+        return err
+    }
+    if err := loadFields(q, expenses); err != nil {
+        return err
+    }
+    return loadAttachmentCounts(q, expenses)
+}
+
+// getExpense loads one expense, optionally including trashed ones.
+func getExpense(q database.Querier, id int, includeDeleted bool) (*models.Expense, error) {
+    query := "SELECT " + expenseColumns + " FROM " + expenseFrom + " WHERE expenses.id = ?"
+    if !includeDeleted {
+        query += " AND deleted_at IS NULL"
     }
-    if !filter.EndDate.IsZero() {
-        query += " AND date <= ?"
-        args = append(args, filter.EndDate)
+    
+    e, err := scanExpense(q.QueryRow(query, id))
+    if err == sql.ErrNoRows {
+        return nil, ErrExpenseNotFound
     }
-    if filter.Category != "" {
-        query += " AND category = ?"
-        args = append(args, filter.Category)
+    if err != nil {
+        return nil, err
     }
     
-    // Count total for pagination
-    countQuery := "SELECT COUNT(*) FROM expenses WHERE 1=1"
-    countArgs := args // Use same filters for count
-    if !filter.StartDate.IsZero() {
-        countQuery += " AND date >= ?"
+    loaded := []models.Expense{e}
+    if err := loadExpenseDetails(q, loaded); err != nil {
+        return nil, err
     }
-    if !filter.EndDate.IsZero() {
-        countQuery += " AND date <= ?"
+    
+    return &loaded[0], nil
+}
+
+func (r *expenseRepository) GetAll(ctx context.Context, filter models.ExpenseFilter, page, limit int) ([]models.Expense, *PaginationInfo, error) {
+    q, cancel := r.db.WithContext(ctx, database.OpRead)
+    defer cancel()
+    
+    // The count and data queries are built once so they can't disagree
+    list, err := r.buildExpenseQuery(q, filter)
+    if err != nil {
+        return nil, nil, err
     }
-    if filter.Category != "" {
-        countQuery += " AND category = ?"
+    order, err := list.order(filter)
+    if err != nil {
+        return nil, nil, err
     }
     
+    // Count total for pagination
     var total int
-    err := r.db.QueryRow(countQuery, countArgs...).Scan(&total)
+    err = q.QueryRow(list.countSQL(), list.args...).Scan(&total)
     if err != nil {
         return nil, nil, err
     }
     
-    // Add ordering and pagination
-    query += " ORDER BY date DESC"
+    query := list.selectSQL(list.orderBy(order))
+    args := list.args
     if limit > 0 {
         offset := (page - 1) * limit
         query += " LIMIT ? OFFSET ?"
         args = append(args, limit, offset)
     }
     
-    rows, err := r.db.Query(query, args...)
+    expenses, err := listExpenses(q, list, query, args)
     if err != nil {
         return nil, nil, err
     }
-    defer rows.Close()
-    
-    var expenses []models.Expense
-    for rows.Next() {
-        var e models.Expense
-        err := rows.Scan(&e.ID, &e.Date, &e.Category, &e.Description, 
-                        &e.Amount, &e.Vendor, &e.PaymentMethod, &e.CreatedAt, &e.UpdatedAt)
-        if err != nil {
-            return nil, nil, err
-        }
-        expenses = append(expenses, e)
-    }
     
     // Build pagination info
     pagination := &PaginationInfo{
@@ -114,256 +225,1062 @@ func (r *expenseRepository) GetAll(filter models.ExpenseFilter, page, limit int)
         HasNext:     page*limit < total,
         HasPrevious: page > 1,
     }
+    if order.key == "date" {
+        pagination.setCursors(expenses)
+    }
     
     return expenses, pagination, nil
 }
 
-func (r *expenseRepository) GetByID(id int) (*models.Expense, error) {
-    query := `
-        SELECT id, date, category, description, amount, vendor, payment_method, created_at, updated_at
-        FROM expenses WHERE id = ?
-    `
+func (r *expenseRepository) GetAllByCursor(ctx context.Context, filter models.ExpenseFilter, cursor string, limit int) ([]models.Expense, *PaginationInfo, error) {
+    c, err := decodeExpenseCursor(cursor)
+    if err != nil {
+        return nil, nil, err
+    }
+    if limit <= 0 {
+        return nil, nil, fmt.Errorf("%w: cursor pages need a limit", ErrInvalidFilter)
+    }
+    q, cancel := r.db.WithContext(ctx, database.OpRead)
+    defer cancel()
+    list, err := r.buildExpenseQuery(q, filter)
+    if err != nil {
+        return nil, nil, err
+    }
+    order, err := list.order(filter)
+    if err != nil {
+        return nil, nil, err
+    }
+    if order.key != "date" {
+        return nil, nil, fmt.Errorf("%w: cursors only page through expenses sorted by date", ErrInvalidFilter)
+    }
     
-    var e models.Expense
-    err := r.db.QueryRow(query, id).Scan(&e.ID, &e.Date, &e.Category, &e.Description,
-                                          &e.Amount, &e.Vendor, &e.PaymentMethod, 
-                                          &e.CreatedAt, &e.UpdatedAt)
+    // The total covers the whole list, not just what lies past the cursor
+    var total int
+    if err := q.QueryRow(list.countSQL(), list.args...).Scan(&total); err != nil {
+        return nil, nil, err
+    }
+    
+    // A back cursor walks the list in reverse, and the page is flipped
+    // round afterwards. One extra row shows whether there is more
+    walk := order
+    if c.Back {
+        walk.desc = !walk.desc
+    }
+    list.addCursor(c, walk.desc)
+    expenses, err := listExpenses(q, list, list.selectSQL(list.orderBy(walk))+" LIMIT ?", append(list.args, limit+1))
+    if err != nil {
+        return nil, nil, err
+    }
+    more := len(expenses) > limit
+    if more {
+        expenses = expenses[:limit]
+    }
+    if c.Back {
+        for i, j := 0, len(expenses)-1; i < j; i, j = i+1, j-1 {
+            expenses[i], expenses[j] = expenses[j], expenses[i]
+        }
+    }
+    
+    // The row the cursor came from lies behind the page
+    pagination := &PaginationInfo{Total: total, Limit: limit}
+    if c.Back {
+        pagination.HasNext = true
+        pagination.HasPrevious = more
+    } else {
+        pagination.HasNext = more
+        pagination.HasPrevious = true
+    }
+    pagination.setCursors(expenses)
+    
+    return expenses, pagination, nil
+}
+
+// setCursors points the next and previous cursors past the ends of a page.
+func (p *PaginationInfo) setCursors(expenses []models.Expense) {
+    if len(expenses) == 0 {
+        return
+    }
+    if p.HasNext {
+        p.NextCursor = newExpenseCursor(expenses[len(expenses)-1], false)
+    }
+    if p.HasPrevious {
+        p.PreviousCursor = newExpenseCursor(expenses[0], true)
+    }
+}
+
+// listExpenses runs a query built from list and loads the expenses it
+// returns, with highlights when list is a search.
+func listExpenses(q database.Querier, list *expenseQuery, query string, args []interface{}) ([]models.Expense, error) {
+    rows, err := q.Query(query, args...)
     if err != nil {
         return nil, err
     }
+    defer rows.Close()
+    
+    var expenses []models.Expense
+    for rows.Next() {
+        var e models.Expense
+        if list.ranked {
+            var hl models.ExpenseHighlight
+            e, err = scanExpense(rows, &hl.Description, &hl.Vendor, &hl.PaymentMethod)
+            hl.Description = markHighlight(hl.Description)
+            hl.Vendor = markHighlight(hl.Vendor)
+            hl.PaymentMethod = markHighlight(hl.PaymentMethod)
+            e.Highlight = &hl
+        } else {
+            e, err = scanExpense(rows)
+            if len(list.terms) > 0 {
+                highlightExpense(&e, list.terms)
+            }
+        }
+        if err != nil {
+            return nil, err
+        }
+        expenses = append(expenses, e)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
     
-    return &e, nil
+    if err := loadExpenseDetails(q, expenses); err != nil {
+        return nil, err
+    }
+    return expenses, nil
 }
 
-func (r *expenseRepository) Create(expense *models.Expense) error {
-    query := `
-        INSERT INTO expenses (date, category, description, amount, vendor, payment_method, created_at, updated_at)
-        VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-    `
+func (r *expenseRepository) GetByID(ctx context.Context, id int) (*models.Expense, error) {
+    q, cancel := r.db.WithContext(ctx, database.OpRead)
+    defer cancel()
+    return getExpense(q, id, false)
+}
+
+func (r *expenseRepository) Create(ctx context.Context, expense *models.Expense) error {
+    if err := r.normalizeCurrency(ctx, expense); err != nil {
+        return err
+    }
     
-    result, err := r.db.Exec(query, expense.Date, expense.Category, expense.Description, 
-                           expense.Amount, expense.Vendor, expense.PaymentMethod)
+    tx, err := r.db.BeginTx(ctx, database.OpWrite)
     if err != nil {
         return err
     }
+    defer tx.Rollback()
     
-    id, err := result.LastInsertId()
+    created, err := insertExpense(tx, expense, models.SourceManual)
     if err != nil {
         return err
     }
     
-    expense.ID = int(id)
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+    
+    *expense = *created
     return nil
 }
 
-func (r *expenseRepository) Update(id int, expense *models.Expense) error {
-    query := `
-        UPDATE expenses 
-        SET date = ?, category = ?, description = ?, amount = ?, vendor = ?, payment_method = ?, updated_at = CURRENT_TIMESTAMP
-        WHERE id = ?
-    `
+const insertExpenseSQL = `
+    INSERT INTO expenses (type, date, category_id, description, amount_cents, currency, vendor, payment_method, account_id, transfer_account_id, reimbursable, created_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`
+
+// insertExpense stores a new expense and records its creation in history.
+func insertExpense(tx *database.Tx, expense *models.Expense, source string) (*models.Expense, error) {
+    txn, err := prepareTransaction(tx, expense, nil, expense.Splits)
+    if err != nil {
+        return nil, err
+    }
+    category, err := resolveCategory(tx, expense.CategoryID, expense.Category)
+    if err != nil {
+        return nil, err
+    }
+    tags, err := NormalizeTags(expense.Tags)
+    if err != nil {
+        return nil, err
+    }
+    if err := prepareSplits(tx, expense.Amount, expense.Splits); err != nil {
+        return nil, err
+    }
+    // Required fields apply to every new expense
+    values := expense.Fields
+    if values == nil {
+        values = models.FieldValues{}
+    }
+    fields, err := prepareFieldValues(tx, values, true)
+    if err != nil {
+        return nil, err
+    }
+    reimbursable, _, err := prepareReimbursement(tx, expense, nil, txn.txType)
+    if err != nil {
+        return nil, err
+    }
     
-    result, err := r.db.Exec(query, expense.Date, expense.Category, expense.Description, 
-                           expense.Amount, expense.Vendor, expense.PaymentMethod, id)
+    id, err := tx.ExecReturningID(insertExpenseSQL, txn.txType, expense.Date, category.ID, expense.Description, 
+                                  expense.Amount, expense.Currency, expense.Vendor, expense.PaymentMethod,
+                                  txn.accountID, txn.transferAccountID, reimbursable)
     if err != nil {
-        return err
+        return nil, err
+    }
+    if err := setExpenseTags(tx, int(id), tags); err != nil {
+        return nil, err
+    }
+    if err := setExpenseSplits(tx, int(id), nil, expense.Splits); err != nil {
+        return nil, err
+    }
+    if err := setExpenseFields(tx, int(id), fields); err != nil {
+        return nil, err
     }
     
-    rowsAffected, err := result.RowsAffected()
+    created, err := getExpense(tx, int(id), true)
     if err != nil {
-        return err
+        return nil, err
     }
     
-    if rowsAffected == 0 {
-        return ErrExpenseNotFound
+    if err := recordChange(tx, created.ID, models.ActionCreate, source, nil, created); err != nil {
+        return nil, err
     }
     
-    expense.ID = id
-    return nil
+    return created, nil
 }
 
-func (r *expenseRepository) Delete(id int) error {
-    result, err := r.db.Exec("DELETE FROM expenses WHERE id = ?", id)
+func (r *expenseRepository) Update(ctx context.Context, id int, expense *models.Expense, ifVersion int) error {
+    if err := r.normalizeCurrency(ctx, expense); err != nil {
+        return err
+    }
+    
+    tx, err := r.db.BeginTx(ctx, database.OpWrite)
     if err != nil {
         return err
     }
+    defer tx.Rollback()
     
-    rowsAffected, err := result.RowsAffected()
+    if err := checkVersion(tx, id, ifVersion); err != nil {
+        return err
+    }
+    updated, err := updateExpense(tx, id, expense, models.ActionUpdate, models.SourceManual)
     if err != nil {
         return err
     }
     
-    if rowsAffected == 0 {
-        return ErrExpenseNotFound
+    if err := tx.Commit(); err != nil {
+        return err
     }
     
+    *expense = *updated
     return nil
 }
 
-func (r *expenseRepository) GetStats(startDate, endDate, category string) (map[string]interface{}, error) {
-    query := `
-        SELECT category, SUM(amount) as total
-        FROM expenses
-        WHERE date BETWEEN ? AND ?
-    `
-    args := []interface{}{startDate, endDate}
-    
-    if category != "" {
-        query += " AND category = ?"
-        args = append(args, category)
+// checkVersion makes sure a live expense is still at the version a client
+// last saw, unless version is 0. The no-op UPDATE locks the row, so no other
+// writer can change it before the caller's transaction ends.
+func checkVersion(tx *database.Tx, id, version int) error {
+    if version == 0 {
+        return nil
     }
-    
-    query += `
-        GROUP BY category
-        ORDER BY total DESC
-    `
-    
-    rows, err := r.db.Query(query, args...)
+    result, err := tx.Exec("UPDATE expenses SET version = version WHERE id = ? AND version = ? AND deleted_at IS NULL", id, version)
     if err != nil {
-        return nil, err
+        return err
     }
-    defer rows.Close()
-    
-    stats := make(map[string]interface{})
-    categories := make(map[string]float64)
-    
-    var totalAmount float64
-    for rows.Next() {
-        var category string
-        var amount float64
-        if err := rows.Scan(&category, &amount); err != nil {
-            return nil, err
-        }
-        categories[category] = amount
-        totalAmount += amount
+    if n, err := result.RowsAffected(); err != nil || n > 0 {
+        return err
     }
-    
-    stats["categories"] = categories
-    stats["total"] = totalAmount
-    
-    return stats, nil
+    if _, err := getExpense(tx, id, false); err != nil {
+        return err
+    }
+    return ErrVersionConflict
 }
 
-func (r *expenseRepository) GetMonthlyStats(startDate, endDate, category string) (map[string]interface{}, error) {
-    query := `
-        SELECT strftime('%Y-%m', date) as month, category, SUM(amount) as total
-        FROM expenses
-        WHERE date BETWEEN ? AND ?
-    `
-    args := []interface{}{startDate, endDate}
-    
-    if category != "" {
-        query += " AND category = ?"
-        args = append(args, category)
+// updateExpense overwrites the editable fields of a live expense and records
+// the change in history.
+func updateExpense(tx *database.Tx, id int, expense *models.Expense, action, source string) (*models.Expense, error) {
+    before, err := getExpense(tx, id, false)
+    if err != nil {
+        return nil, err
     }
     
-    query += `
-        GROUP BY strftime('%Y-%m', date), category
-        ORDER BY month ASC, category ASC
-    `
-    
-    rows, err := r.db.Query(query, args...)
+    // Kept splits must still add up to the new amount
+    splits := expense.Splits
+    if splits == nil {
+        splits = before.Splits
+    }
+    txn, err := prepareTransaction(tx, expense, before, splits)
     if err != nil {
         return nil, err
     }
-    defer rows.Close()
     
-    stats := make(map[string]interface{})
-    monthlyData := make(map[string]map[string]float64)
-    allCategories := make(map[string]bool)
-    
-    var totalAmount float64
-    for rows.Next() {
-        var month, category string
-        var amount float64
-        if err := rows.Scan(&month, &category, &amount); err != nil {
-            return nil, err
-        }
-        
-        if monthlyData[month] == nil {
-            monthlyData[month] = make(map[string]float64)
-        }
-        monthlyData[month][category] = amount
-        allCategories[category] = true
-        totalAmount += amount
+    category, err := resolveCategory(tx, expense.CategoryID, expense.Category)
+    if err != nil {
+        return nil, err
     }
-    
-    // Convert to array format for frontend
-    monthlyArray := make([]map[string]interface{}, 0)
-    for month, categories := range monthlyData {
-        monthData := map[string]interface{}{
-            "month":      month,
-            "categories": categories,
-        }
-        
-        // Calculate monthly total
-        var monthTotal float64
-        for _, amount := range categories {
-            monthTotal += amount
-        }
-        monthData["total"] = monthTotal
-        
-        monthlyArray = append(monthlyArray, monthData)
+    tags, err := NormalizeTags(expense.Tags)
+    if err != nil {
+        return nil, err
     }
-    
-    // Sort by month using a more robust approach
-    for i := 0; i < len(monthlyArray)-1; i++ {
-        for j := i + 1; j < len(monthlyArray); j++ {
-            month1, ok1 := monthlyArray[i]["month"].(string)
-            month2, ok2 := monthlyArray[j]["month"].(string)
-            if ok1 && ok2 && month1 > month2 {
-                monthlyArray[i], monthlyArray[j] = monthlyArray[j], monthlyArray[i]
-            }
-        }
+    if err := prepareSplits(tx, expense.Amount, splits); err != nil {
+        return nil, err
     }
-    
-    // Get list of all categories for consistent ordering
-    categoryList := make([]string, 0, len(allCategories))
-    for category := range allCategories {
-        categoryList = append(categoryList, category)
+    // A revert restores the values as they were, even if a field has
+    // become required since
+    fields, err := prepareFieldValues(tx, expense.Fields, action != models.ActionRevert)
+    if err != nil {
+        return nil, err
     }
-    
-    stats["monthly"] = monthlyArray
-    stats["categories"] = categoryList
-    stats["total"] = totalAmount
-    
-    return stats, nil
-}
-
-func (r *expenseRepository) BulkInsert(expenses []models.Expense) ([]models.Expense, error) {
-    tx, err := r.db.Begin()
+    reimbursable, claimID, err := prepareReimbursement(tx, expense, before, txn.txType)
     if err != nil {
         return nil, err
     }
-    defer tx.Rollback()
+    
+    // Reconciliation was against the old accounts' statements
+    reconciled := before.Reconciled && sameAccount(before.AccountID, txn.accountID) &&
+        sameAccount(before.TransferAccountID, txn.transferAccountID)
     
     query := `
-        INSERT INTO expenses (date, category, description, amount, vendor, payment_method, created_at, updated_at)
-        VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+        UPDATE expenses 
+        SET type = ?, date = ?, category_id = ?, description = ?, amount_cents = ?, currency = ?, vendor = ?, payment_method = ?,
+            account_id = ?, transfer_account_id = ?, reconciled = ?, reimbursable = ?, claim_id = ?,
+            version = version + 1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND deleted_at IS NULL
     `
     
-    stmt, err := tx.Prepare(query)
+    _, err = tx.Exec(query, txn.txType, expense.Date, category.ID, expense.Description, 
+                     expense.Amount, expense.Currency, expense.Vendor, expense.PaymentMethod,
+                     txn.accountID, txn.transferAccountID, reconciled, reimbursable, claimID, id)
     if err != nil {
         return nil, err
     }
-    defer stmt.Close()
+    if tags != nil {
+        if err := setExpenseTags(tx, id, tags); err != nil {
+            return nil, err
+        }
+    }
+    if err := setExpenseSplits(tx, id, before.Splits, splits); err != nil {
+        return nil, err
+    }
+    if fields != nil {
+        if err := setExpenseFields(tx, id, fields); err != nil {
+            return nil, err
+        }
+    }
+    
+    after, err := getExpense(tx, id, true)
+    if err != nil {
+        return nil, err
+    }
+    
+    if err := recordChange(tx, id, action, source, before, after); err != nil {
+        return nil, err
+    }
+    
+    return after, nil
+}
+
+func (r *expenseRepository) Delete(ctx context.Context, id int, ifVersion int) error {
+    tx, err := r.db.BeginTx(ctx, database.OpWrite)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    
+    if err := checkVersion(tx, id, ifVersion); err != nil {
+        return err
+    }
+    if _, err := deleteExpense(tx, id, models.SourceManual); err != nil {
+        return err
+    }
+    
+    return tx.Commit()
+}
+
+// deleteExpense moves a live expense to the trash and records the change in
+// history. It returns the expense as it is in the trash.
+func deleteExpense(tx *database.Tx, id int, source string) (*models.Expense, error) {
+    before, err := getExpense(tx, id, false)
+    if err != nil {
+        return nil, err
+    }
+    locked, err := claimLocked(tx, before.ClaimID)
+    if err != nil {
+        return nil, err
+    }
+    if locked {
+        return nil, ErrClaimLocked
+    }
+    
+    // Soft delete: the row moves to the trash until restored or purged. It
+    // leaves its draft claim, and is not put back on it by a restore.
+    _, err = tx.Exec("UPDATE expenses SET deleted_at = ?, claim_id = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NULL",
+                     time.Now().UTC(), id)
+    if err != nil {
+        return nil, err
+    }
+    
+    after, err := getExpense(tx, id, true)
+    if err != nil {
+        return nil, err
+    }
+    
+    if err := recordChange(tx, id, models.ActionDelete, source, before, after); err != nil {
+        return nil, err
+    }
+    
+    return after, nil
+}
+
+func (r *expenseRepository) GetStats(ctx context.Context, filter models.StatsFilter) (map[string]interface{}, error) {
+    q, cancel := r.db.WithContext(ctx, database.OpReport)
+    defer cancel()
+    
+    day := r.db.Dialect().DayExpr("date")
+    where, args, rollup, err := r.statsWhere(q, filter, true)
+    if err != nil {
+        return nil, err
+    }
+    
+    query := `
+        SELECT '' as month, ` + statsCategoryID + `, currency, ` + day + ` as day, SUM(` + statsAmount + `) as total
+        FROM expenses` + statsSplitJoin + where + `
+        GROUP BY ` + statsCategoryID + `, currency, ` + day + `
+    `
+    
+    groups, err := r.queryAmountGroups(q, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    rollup.label(groups)
+    
+    tagGroups, err := r.queryTagGroups(q, "", where, args)
+    if err != nil {
+        return nil, err
+    }
+    groupField, fieldGroups, err := r.queryFieldGroups(q, filter.GroupBy, "", where, args)
+    if err != nil {
+        return nil, err
+    }
+    
+    conversion, err := r.newStatsConversion(ctx, groups)
+    if err != nil {
+        return nil, err
+    }
+    
+    stats := make(map[string]interface{})
+    categories := make(map[string]models.Money)
+    byCurrency := make(map[string]*currencyTotals)
+    
+    var totalAmount models.Money
+    for _, g := range groups {
+        if byCurrency[g.currency] == nil {
+            byCurrency[g.currency] = &currencyTotals{Categories: make(map[string]models.Money)}
+        }
+        byCurrency[g.currency].Categories[g.category] += g.amount
+        byCurrency[g.currency].Total += g.amount
+        
+        amount, ok := conversion.convert(g)
+        if !ok {
+            continue
+        }
+        categories[g.category] += amount
+        totalAmount += amount
+    }
+    
+    tags := make(map[string]models.Money)
+    for _, g := range tagGroups {
+        if amount, ok := conversion.convert(g); ok {
+            tags[g.tag] += amount
+        }
+    }
+    if groupField != nil {
+        values := make(map[string]models.Money)
+        for _, g := range fieldGroups {
+            if amount, ok := conversion.convert(g); ok {
+                values[g.value] += amount
+            }
+        }
+        stats["group_by"] = groupField.Name
+        stats["groups"] = values
+    }
+    
+    stats["type"] = statsType(filter)
+    stats["categories"] = categories
+    stats["tags"] = tags
+    stats["total"] = totalAmount
+    stats["base_currency"] = conversion.base
+    stats["by_currency"] = byCurrency
+    stats["missing_rates"] = conversion.missingRates()
+    rollup.describe(stats)
+    
+    return stats, nil
+}
+
+func (r *expenseRepository) GetMonthlyStats(ctx context.Context, filter models.StatsFilter) (map[string]interface{}, error) {
+    q, cancel := r.db.WithContext(ctx, database.OpReport)
+    defer cancel()
+    
+    month := r.db.Dialect().MonthExpr("date")
+    day := r.db.Dialect().DayExpr("date")
+    where, args, rollup, err := r.statsWhere(q, filter, true)
+    if err != nil {
+        return nil, err
+    }
+    
+    query := `
+        SELECT ` + month + ` as month, ` + statsCategoryID + `, currency, ` + day + ` as day, SUM(` + statsAmount + `) as total
+        FROM expenses` + statsSplitJoin + where + `
+        GROUP BY ` + month + `, ` + statsCategoryID + `, currency, ` + day + `
+    `
+    
+    groups, err := r.queryAmountGroups(q, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    rollup.label(groups)
+    
+    tagGroups, err := r.queryTagGroups(q, month, where, args)
+    if err != nil {
+        return nil, err
+    }
+    groupField, fieldGroups, err := r.queryFieldGroups(q, filter.GroupBy, month, where, args)
+    if err != nil {
+        return nil, err
+    }
+    
+    conversion, err := r.newStatsConversion(ctx, groups)
+    if err != nil {
+        return nil, err
+    }
+    
+    stats := make(map[string]interface{})
+    monthlyData := make(map[string]map[string]models.Money)
+    monthlyByCurrency := make(map[string]map[string]models.Money)
+    byCurrency := make(map[string]models.Money)
+    allCategories := make(map[string]bool)
+    
+    var totalAmount models.Money
+    for _, g := range groups {
+        if monthlyData[g.month] == nil {
+            monthlyData[g.month] = make(map[string]models.Money)
+            monthlyByCurrency[g.month] = make(map[string]models.Money)
+        }
+        monthlyByCurrency[g.month][g.currency] += g.amount
+        byCurrency[g.currency] += g.amount
+        allCategories[g.category] = true
+        
+        amount, ok := conversion.convert(g)
+        if !ok {
+            continue
+        }
+        monthlyData[g.month][g.category] += amount
+        totalAmount += amount
+    }
+    
+    monthlyTags := make(map[string]map[string]models.Money)
+    allTags := make(map[string]bool)
+    for _, g := range tagGroups {
+        if monthlyTags[g.month] == nil {
+            monthlyTags[g.month] = make(map[string]models.Money)
+        }
+        allTags[g.tag] = true
+        if amount, ok := conversion.convert(g); ok {
+            monthlyTags[g.month][g.tag] += amount
+        }
+    }
+    
+    monthlyValues := make(map[string]map[string]models.Money)
+    allValues := make(map[string]bool)
+    for _, g := range fieldGroups {
+        if monthlyValues[g.month] == nil {
+            monthlyValues[g.month] = make(map[string]models.Money)
+        }
+        allValues[g.value] = true
+        if amount, ok := conversion.convert(g); ok {
+            monthlyValues[g.month][g.value] += amount
+        }
+    }
+    
+    // Convert to array format for frontend
+    monthlyArray := make([]map[string]interface{}, 0)
+    for month, categories := range monthlyData {
+        tags := monthlyTags[month]
+        if tags == nil {
+            tags = make(map[string]models.Money)
+        }
+        monthData := map[string]interface{}{
+            "month":       month,
+            "categories":  categories,
+            "tags":        tags,
+            "by_currency": monthlyByCurrency[month],
+        }
+        if groupField != nil {
+            values := monthlyValues[month]
+            if values == nil {
+                values = make(map[string]models.Money)
+            }
+            monthData["groups"] = values
+        }
+        
+        // Calculate monthly total
+        var monthTotal models.Money
+        for _, amount := range categories {
+            monthTotal += amount
+        }
+        monthData["total"] = monthTotal
+        
+        monthlyArray = append(monthlyArray, monthData)
+    }
+    
+    // Sort by month using a more robust approach
+    for i := 0; i < len(monthlyArray)-1; i++ {
+        for j := i + 1; j < len(monthlyArray); j++ {
+            month1, ok1 := monthlyArray[i]["month"].(string)
+            month2, ok2 := monthlyArray[j]["month"].(string)
+            if ok1 && ok2 && month1 > month2 {
+                monthlyArray[i], monthlyArray[j] = monthlyArray[j], monthlyArray[i]
+            }
+        }
+    }
+    
+    // Get list of all categories for consistent ordering
+    categoryList := make([]string, 0, len(allCategories))
+    for category := range allCategories {
+        categoryList = append(categoryList, category)
+    }
+    tagList := make([]string, 0, len(allTags))
+    for tag := range allTags {
+        tagList = append(tagList, tag)
+    }
+    sort.Strings(tagList)
+    if groupField != nil {
+        valueList := make([]string, 0, len(allValues))
+        for value := range allValues {
+            valueList = append(valueList, value)
+        }
+        sort.Strings(valueList)
+        stats["group_by"] = groupField.Name
+        stats["groups"] = valueList
+    }
+    
+    stats["type"] = statsType(filter)
+    stats["monthly"] = monthlyArray
+    stats["categories"] = categoryList
+    stats["tags"] = tagList
+    stats["total"] = totalAmount
+    stats["base_currency"] = conversion.base
+    stats["by_currency"] = byCurrency
+    stats["missing_rates"] = conversion.missingRates()
+    rollup.describe(stats)
+    
+    return stats, nil
+}
+
+func (r *expenseRepository) GetCashFlow(ctx context.Context, filter models.StatsFilter) (map[string]interface{}, error) {
+    q, cancel := r.db.WithContext(ctx, database.OpReport)
+    defer cancel()
+    
+    month := r.db.Dialect().MonthExpr("date")
+    day := r.db.Dialect().DayExpr("date")
+    where, args, _, err := r.statsWhere(q, filter, false)
+    if err != nil {
+        return nil, err
+    }
+    where += " AND expenses.type <> ?"
+    args = append(args, models.TransactionTransfer)
+    
+    rows, err := q.Query(`
+        SELECT `+month+` as month, expenses.type, currency, `+day+` as day, SUM(`+statsAmount+`) as total
+        FROM expenses`+statsSplitJoin+where+`
+        GROUP BY `+month+`, expenses.type, currency, `+day, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var groups []amountGroup
+    for rows.Next() {
+        var g amountGroup
+        var day string
+        if err := rows.Scan(&g.month, &g.txType, &g.currency, &day, &g.amount); err != nil {
+            return nil, err
+        }
+        if g.day, err = parseGroupDay(day); err != nil {
+            return nil, err
+        }
+        groups = append(groups, g)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
+    
+    conversion, err := r.newStatsConversion(ctx, groups)
+    if err != nil {
+        return nil, err
+    }
+    
+    type monthFlow struct {
+        Month    string       `json:"month"`
+        Income   models.Money `json:"income"`
+        Spending models.Money `json:"spending"`
+        Net      models.Money `json:"net"`
+    }
+    
+    byMonth := make(map[string]*monthFlow)
+    var income, spending models.Money
+    for _, g := range groups {
+        amount, ok := conversion.convert(g)
+        if !ok {
+            continue
+        }
+        flow := byMonth[g.month]
+        if flow == nil {
+            flow = &monthFlow{Month: g.month}
+            byMonth[g.month] = flow
+        }
+        if g.txType == models.TransactionIncome {
+            flow.Income += amount
+            income += amount
+        } else {
+            flow.Spending += amount
+            spending += amount
+        }
+    }
+    
+    monthly := make([]monthFlow, 0, len(byMonth))
+    for _, flow := range byMonth {
+        flow.Net = flow.Income - flow.Spending
+        monthly = append(monthly, *flow)
+    }
+    sort.Slice(monthly, func(i, j int) bool { return monthly[i].Month < monthly[j].Month })
+    
+    return map[string]interface{}{
+        "monthly":       monthly,
+        "income":        income,
+        "spending":      spending,
+        "net":           income - spending,
+        "base_currency": conversion.base,
+        "missing_rates": conversion.missingRates(),
+    }, nil
+}
+
+// statsWhere builds the where clause and arguments the stats reports share:
+// live expenses in the date range and category filter. With byType only the
+// filter's transaction type is counted, which is spending unless another
+// type was asked for.
+func (r *expenseRepository) statsWhere(q database.Querier, filter models.StatsFilter, byType bool) (string, []interface{}, *categoryRollup, error) {
+    where := " WHERE deleted_at IS NULL"
+    args, err := appendDateRange(&where, filter.StartDate, filter.EndDate)
+    if err != nil {
+        return "", nil, nil, err
+    }
+    
+    if byType {
+        txType, err := normalizeTransactionType(filter.Type)
+        if err != nil {
+            return "", nil, nil, err
+        }
+        if txType == "" {
+            txType = models.TransactionExpense
+        }
+        where += " AND expenses.type = ?"
+        args = append(args, txType)
+    }
+    
+    rollup, err := newCategoryRollup(q, filter)
+    if err != nil {
+        return "", nil, nil, err
+    }
+    args = append(args, rollup.appendFilters(&where, filter)...)
+    
+    fieldArgs, err := appendFieldFilters(q, &where, filter.Fields)
+    if err != nil {
+        return "", nil, nil, err
+    }
+    args = append(args, fieldArgs...)
+    
+    if filter.ExcludeReimbursable {
+        where += " AND expenses.reimbursable = FALSE"
+    } else if filter.ExcludeReimbursed {
+        where += " AND NOT EXISTS (SELECT 1 FROM claims WHERE claims.id = expenses.claim_id AND claims.status = ?)"
+        args = append(args, models.ClaimPaid)
+    }
+    
+    return where, args, rollup, nil
+}
+
+func statsType(filter models.StatsFilter) string {
+    if txType, err := normalizeTransactionType(filter.Type); err == nil && txType != "" {
+        return txType
+    }
+    return models.TransactionExpense
+}
+
+// appendDateRange adds inclusive YYYY-MM-DD bounds to a stats query. Bounds
+// are bound as time.Time so they compare like the stored dates on every
+// backend; an empty bound is left open.
+func appendDateRange(query *string, startDate, endDate string) ([]interface{}, error) {
+    args := []interface{}{}
+    
+    if startDate != "" {
+        start, err := time.Parse("2006-01-02", startDate)
+        if err != nil {
+            return nil, ErrInvalidDateRange
+        }
+        *query += " AND date >= ?"
+        args = append(args, start)
+    }
+    if endDate != "" {
+        end, err := time.Parse("2006-01-02", endDate)
+        if err != nil {
+            return nil, ErrInvalidDateRange
+        }
+        *query += " AND date <= ?"
+        args = append(args, end)
+    }
+    
+    return args, nil
+}
+
+// amountGroup is one row of a stats query: the sum of expenses, or split
+// lines, sharing a month, category, currency and day. Grouping by day lets each group be
+// converted with the rate for its own date. category is the name the group
+// is reported under once subcategories are rolled up; tag groups from
+// queryTagGroups carry the tag instead, custom field groups from
+// queryFieldGroups the value, and cash flow groups the transaction type.
+type amountGroup struct {
+    month      string
+    categoryID int
+    category   string
+    tag        string
+    value      string
+    txType     string
+    currency   string
+    day        time.Time
+    amount     models.Money
+}
+
+// currencyTotals reports stats in an expense's original currency.
+type currencyTotals struct {
+    Categories map[string]models.Money `json:"categories"`
+    Total      models.Money            `json:"total"`
+}
+
+func (r *expenseRepository) queryAmountGroups(q database.Querier, query string, args ...interface{}) ([]amountGroup, error) {
+    rows, err := q.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var groups []amountGroup
+    for rows.Next() {
+        var g amountGroup
+        var day string
+        if err := rows.Scan(&g.month, &g.categoryID, &g.currency, &day, &g.amount); err != nil {
+            return nil, err
+        }
+        if g.day, err = parseGroupDay(day); err != nil {
+            return nil, err
+        }
+        groups = append(groups, g)
+    }
+    
+    return groups, rows.Err()
+}
+
+// queryTagGroups sums the expenses matched by a stats query's where clause
+// per tag, grouped by month when monthExpr is set. An expense with several
+// tags counts towards each of them.
+func (r *expenseRepository) queryTagGroups(q database.Querier, monthExpr, where string, args []interface{}) ([]amountGroup, error) {
+    day := r.db.Dialect().DayExpr("date")
+    month := "''"
+    groupBy := "tags.name, currency, " + day
+    if monthExpr != "" {
+        month = monthExpr
+        groupBy = monthExpr + ", " + groupBy
+    }
+    
+    rows, err := q.Query(`
+        SELECT `+month+` as month, tags.name, currency, `+day+` as day, SUM(`+statsAmount+`) as total
+        FROM expenses`+statsSplitJoin+`
+        JOIN expense_tags ON expense_tags.expense_id = expenses.id
+        JOIN tags ON tags.id = expense_tags.tag_id`+where+`
+        GROUP BY `+groupBy, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var groups []amountGroup
+    for rows.Next() {
+        var g amountGroup
+        var day string
+        if err := rows.Scan(&g.month, &g.tag, &g.currency, &day, &g.amount); err != nil {
+            return nil, err
+        }
+        if g.day, err = parseGroupDay(day); err != nil {
+            return nil, err
+        }
+        groups = append(groups, g)
+    }
+    
+    return groups, rows.Err()
+}
+
+// noFieldValue is the group expenses without a value for the grouped custom
+// field are reported under.
+const noFieldValue = "(none)"
+
+// queryFieldGroups sums the expenses matched by a stats query's where clause
+// per value of the custom field named groupBy, grouped by month when
+// monthExpr is set. It returns a nil field and no groups when groupBy is
+// empty.
+func (r *expenseRepository) queryFieldGroups(q database.Querier, groupBy, monthExpr, where string, args []interface{}) (*models.CustomField, []amountGroup, error) {
+    if strings.TrimSpace(groupBy) == "" {
+        return nil, nil, nil
+    }
+    field, err := getCustomFieldByName(q, groupBy)
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    day := r.db.Dialect().DayExpr("date")
+    value := "COALESCE(expense_field_values.value, '')"
+    month := "''"
+    groupBy = value + ", currency, " + day
+    if monthExpr != "" {
+        month = monthExpr
+        groupBy = monthExpr + ", " + groupBy
+    }
+    
+    rows, err := q.Query(`
+        SELECT `+month+` as month, `+value+`, currency, `+day+` as day, SUM(`+statsAmount+`) as total
+        FROM expenses`+statsSplitJoin+`
+        LEFT JOIN expense_field_values ON expense_field_values.expense_id = expenses.id
+            AND expense_field_values.field_id = ?`+where+`
+        GROUP BY `+groupBy, append([]interface{}{field.ID}, args...)...)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer rows.Close()
     
-    var savedExpenses []models.Expense
+    var groups []amountGroup
+    for rows.Next() {
+        var g amountGroup
+        var day string
+        if err := rows.Scan(&g.month, &g.value, &g.currency, &day, &g.amount); err != nil {
+            return nil, nil, err
+        }
+        if g.day, err = parseGroupDay(day); err != nil {
+            return nil, nil, err
+        }
+        if g.value == "" {
+            g.value = noFieldValue
+        }
+        groups = append(groups, g)
+    }
     
-    for _, expense := range expenses {
-        result, err := stmt.Exec(expense.Date, expense.Category, expense.Description, 
-                               expense.Amount, expense.Vendor, expense.PaymentMethod)
-        if err != nil {
+    return field, groups, rows.Err()
+}
+
+func parseGroupDay(day string) (time.Time, error) {
+    if len(day) >= 10 {
+        day = day[:10]
+    }
+    return time.Parse("2006-01-02", day)
+}
+
+// statsConversion converts stats groups into the base currency, remembering
+// currencies that had no usable rate so the caller can report them.
+type statsConversion struct {
+    base      string
+    converter *currency.Converter
+    missing   map[string]bool
+}
+
+func (r *expenseRepository) newStatsConversion(ctx context.Context, groups []amountGroup) (*statsConversion, error) {
+    base, err := r.settings.BaseCurrency(ctx)
+    if err != nil {
+        return nil, err
+    }
+    
+    conversion := &statsConversion{
+        base:      base,
+        converter: currency.NewConverter(nil),
+        missing:   make(map[string]bool),
+    }
+    
+    // Only load rates when something actually needs converting
+    currencies := map[string]bool{}
+    var from, to time.Time
+    for _, g := range groups {
+        if g.currency == base {
+            continue
+        }
+        currencies[g.currency] = true
+        if from.IsZero() || g.day.Before(from) {
+            from = g.day
+        }
+        if g.day.After(to) {
+            to = g.day
+        }
+    }
+    if len(currencies) == 0 {
+        return conversion, nil
+    }
+    
+    codes := []string{base}
+    for code := range currencies {
+        codes = append(codes, code)
+    }
+    
+    conversion.converter, err = r.rates.Converter(ctx, codes, from, to)
+    if err != nil {
+        return nil, err
+    }
+    
+    return conversion, nil
+}
+
+func (c *statsConversion) convert(g amountGroup) (models.Money, bool) {
+    amount, err := c.converter.Convert(g.amount, g.currency, c.base, g.day)
+    if err != nil {
+        c.missing[g.currency] = true
+        return 0, false
+    }
+    return amount, true
+}
+
+func (c *statsConversion) missingRates() []string {
+    missing := make([]string, 0, len(c.missing))
+    for code := range c.missing {
+        missing = append(missing, code)
+    }
+    sort.Strings(missing)
+    return missing
+}
+
+func (r *expenseRepository) BulkInsert(ctx context.Context, expenses []models.Expense) ([]models.Expense, error) {
+    // Validate everything before taking the write lock
+    expenses = append([]models.Expense(nil), expenses...)
+    for i := range expenses {
+        if err := r.normalizeCurrency(ctx, &expenses[i]); err != nil {
             return nil, err
         }
-        
-        id, err := result.LastInsertId()
+    }
+    
+    tx, err := r.db.BeginTx(ctx, database.OpBulk)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+    
+    var savedExpenses []models.Expense
+    
+    for _, expense := range expenses {
+        created, err := insertExpense(tx, &expense, models.SourceCSVImport)
         if err != nil {
             return nil, err
         }
         
-        expense.ID = int(id)
-        savedExpenses = append(savedExpenses, expense)
+        savedExpenses = append(savedExpenses, *created)
     }
     
     if err := tx.Commit(); err != nil {
@@ -373,7 +1290,7 @@ func (r *expenseRepository) BulkInsert(expenses []models.Expense) ([]models.Expe
     return savedExpenses, nil
 }
 
-func (r *expenseRepository) CheckForDuplicates(expenses []models.Expense) ([]DuplicateInfo, error) {
+func (r *expenseRepository) CheckForDuplicates(ctx context.Context, expenses []models.Expense) ([]DuplicateInfo, error) {
     if len(expenses) == 0 {
         return []DuplicateInfo{}, nil
     }
@@ -393,18 +1310,27 @@ func (r *expenseRepository) CheckForDuplicates(expenses []models.Expense) ([]Dup
     query := `
         SELECT id, date 
         FROM expenses 
-        WHERE date = ? AND description = ? AND amount = ? AND payment_method = ?
+        WHERE date = ? AND description = ? AND amount_cents = ? AND currency = ? AND payment_method = ?
+          AND deleted_at IS NULL
         LIMIT 1
     `
     
+    q, cancel := r.db.WithContext(ctx, database.OpBulk)
+    defer cancel()
+    
     for i, expense := range expenses {
+        if err := r.normalizeCurrency(ctx, &expense); err != nil {
+            return nil, err
+        }
+        
         var matchingID int
         var matchingDateStr string
         
-        err := r.db.QueryRow(query, 
+        err := q.QueryRow(query, 
             expense.Date, 
             expense.Description, 
             expense.Amount, 
+            expense.Currency,
             expense.PaymentMethod,
         ).Scan(&matchingID, &matchingDateStr)
         
@@ -416,7 +1342,351 @@ func (r *expenseRepository) CheckForDuplicates(expenses []models.Expense) ([]Dup
         }
         // If err == sql.ErrNoRows, that means no duplicate found, which is expected
         // Any other error would be a database error, but we'll continue processing other expenses
+        // unless the request was cancelled or timed out, since then every later check fails too
+        if err != nil && ctx.Err() != nil {
+            return nil, err
+        }
     }
     
     return duplicateInfos, nil
-}
\ No newline at end of file
+}
+
+// normalizeCurrency validates the expense currency, defaulting to the
+// account's currency, or else the base currency, when none was given.
+func (r *expenseRepository) normalizeCurrency(ctx context.Context, expense *models.Expense) error {
+    if expense.Currency == "" {
+        // An expense on an account is in the account's currency; a bad
+        // account reference is reported when the expense is written
+        q, cancel := r.db.WithContext(ctx, database.OpRead)
+        account, err := resolveAccount(q, expense.AccountID, expense.Account)
+        cancel()
+        if err == nil && account != nil {
+            expense.Currency = account.Currency
+            return nil
+        }
+        
+        base, err := r.settings.BaseCurrency(ctx)
+        if err != nil {
+            return err
+        }
+        expense.Currency = base
+        return nil
+    }
+    
+    code, err := currency.NormalizeCode(expense.Currency)
+    if err != nil {
+        return err
+    }
+    expense.Currency = code
+    return nil
+}
+
+func (r *expenseRepository) GetTrash(ctx context.Context, page, limit int) ([]models.Expense, *PaginationInfo, error) {
+    q, cancel := r.db.WithContext(ctx, database.OpRead)
+    defer cancel()
+    
+    var total int
+    err := q.QueryRow("SELECT COUNT(*) FROM expenses WHERE deleted_at IS NOT NULL").Scan(&total)
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    query := `
+        SELECT ` + expenseColumns + `
+        FROM ` + expenseFrom + `
+        WHERE deleted_at IS NOT NULL
+        ORDER BY deleted_at DESC, expenses.id DESC
+    `
+    args := []interface{}{}
+    if limit > 0 {
+        query += " LIMIT ? OFFSET ?"
+        args = append(args, limit, (page-1)*limit)
+    }
+    
+    rows, err := q.Query(query, args...)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer rows.Close()
+    
+    expenses := []models.Expense{}
+    for rows.Next() {
+        e, err := scanExpense(rows)
+        if err != nil {
+            return nil, nil, err
+        }
+        expenses = append(expenses, e)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, nil, err
+    }
+    rows.Close()
+    
+    if err := loadExpenseDetails(q, expenses); err != nil {
+        return nil, nil, err
+    }
+    
+    pagination := &PaginationInfo{
+        Total:       total,
+        Page:        page,
+        Limit:       limit,
+        HasNext:     page*limit < total,
+        HasPrevious: page > 1,
+    }
+    
+    return expenses, pagination, nil
+}
+
+func (r *expenseRepository) Restore(ctx context.Context, id int) error {
+    tx, err := r.db.BeginTx(ctx, database.OpWrite)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    
+    before, err := getTrashedExpense(tx, id)
+    if err != nil {
+        return err
+    }
+    
+    _, err = tx.Exec(`
+        UPDATE expenses SET deleted_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND deleted_at IS NOT NULL
+    `, id)
+    if err != nil {
+        return err
+    }
+    
+    after, err := getExpense(tx, id, true)
+    if err != nil {
+        return err
+    }
+    
+    if err := recordChange(tx, id, models.ActionRestore, models.SourceManual, before, after); err != nil {
+        return err
+    }
+    
+    return tx.Commit()
+}
+
+// Purge permanently removes an expense that is already in the trash. Its
+// history is kept.
+func (r *expenseRepository) Purge(ctx context.Context, id int) error {
+    tx, err := r.db.BeginTx(ctx, database.OpWrite)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    
+    before, err := getTrashedExpense(tx, id)
+    if err != nil {
+        return err
+    }
+    
+    if err := purgeExpense(tx, before, models.SourceManual); err != nil {
+        return err
+    }
+    
+    return tx.Commit()
+}
+
+// PurgeDeletedBefore permanently removes expenses trashed before cutoff.
+func (r *expenseRepository) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+    tx, err := r.db.BeginTx(ctx, database.OpBulk)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+    
+    rows, err := tx.Query("SELECT "+expenseColumns+" FROM "+expenseFrom+" WHERE deleted_at IS NOT NULL AND deleted_at < ?", cutoff.UTC())
+    if err != nil {
+        return 0, err
+    }
+    
+    var expired []models.Expense
+    for rows.Next() {
+        e, err := scanExpense(rows)
+        if err != nil {
+            rows.Close()
+            return 0, err
+        }
+        expired = append(expired, e)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+    if err := loadExpenseDetails(tx, expired); err != nil {
+        return 0, err
+    }
+    
+    for i := range expired {
+        if err := purgeExpense(tx, &expired[i], models.SourceRetention); err != nil {
+            return 0, err
+        }
+    }
+    
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+    
+    return int64(len(expired)), nil
+}
+
+func (r *expenseRepository) Recategorize(ctx context.Context, categories map[int]string, source string) (int, error) {
+    tx, err := r.db.BeginTx(ctx, database.OpBulk)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+    
+    ids := make([]int, 0, len(categories))
+    for id := range categories {
+        ids = append(ids, id)
+    }
+    sort.Ints(ids)
+    
+    updated := 0
+    for _, id := range ids {
+        expense, err := getExpense(tx, id, false)
+        if err == ErrExpenseNotFound {
+            continue
+        }
+        if err != nil {
+            return 0, err
+        }
+        if expense.Category == categories[id] {
+            continue
+        }
+        
+        expense.CategoryID = 0
+        expense.Category = categories[id]
+        expense.Fields = nil
+        if _, err := updateExpense(tx, id, expense, models.ActionUpdate, source); err != nil {
+            return 0, err
+        }
+        updated++
+    }
+    
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+    
+    return updated, nil
+}
+
+func (r *expenseRepository) SetVendors(ctx context.Context, vendors map[int]string, source string) (int, error) {
+    tx, err := r.db.BeginTx(ctx, database.OpBulk)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+    
+    updated, err := setVendors(tx, vendors, source)
+    if err != nil {
+        return 0, err
+    }
+    
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+    
+    return updated, nil
+}
+
+// UpdateTags applies the same tag changes to every listed expense in one
+// transaction. Nothing changes if any of them is missing.
+func (r *expenseRepository) UpdateTags(ctx context.Context, ids []int, add, remove []string) (int, error) {
+    add, err := NormalizeTags(add)
+    if err != nil {
+        return 0, err
+    }
+    remove, err = NormalizeTags(remove)
+    if err != nil {
+        return 0, err
+    }
+    
+    removed := make(map[string]bool)
+    for _, tag := range remove {
+        removed[tag] = true
+    }
+    
+    tx, err := r.db.BeginTx(ctx, database.OpBulk)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+    
+    seen := make(map[int]bool)
+    updated := 0
+    for _, id := range ids {
+        if seen[id] {
+            continue
+        }
+        seen[id] = true
+        
+        expense, err := getExpense(tx, id, false)
+        if err != nil {
+            return 0, err
+        }
+        
+        tags := append([]string{}, add...)
+        for _, tag := range expense.Tags {
+            if !removed[tag] {
+                tags = append(tags, tag)
+            }
+        }
+        if tags, err = NormalizeTags(tags); err != nil {
+            return 0, err
+        }
+        if strings.Join(tags, ",") == strings.Join(expense.Tags, ",") {
+            continue
+        }
+        
+        expense.Tags = tags
+        expense.Fields = nil
+        if _, err := updateExpense(tx, id, expense, models.ActionUpdate, models.SourceManual); err != nil {
+            return 0, err
+        }
+        updated++
+    }
+    
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+    
+    return updated, nil
+}
+
+func getTrashedExpense(q database.Querier, id int) (*models.Expense, error) {
+    expense, err := getExpense(q, id, true)
+    if err != nil {
+        return nil, err
+    }
+    if expense.DeletedAt == nil {
+        return nil, ErrExpenseNotFound
+    }
+    return expense, nil
+}
+
+func purgeExpense(tx *database.Tx, expense *models.Expense, source string) error {
+    // Tags and splits are removed explicitly in case foreign keys are off
+    if err := setExpenseTags(tx, expense.ID, nil); err != nil {
+        return err
+    }
+    if _, err := tx.Exec("DELETE FROM expense_splits WHERE expense_id = ?", expense.ID); err != nil {
+        return err
+    }
+    if _, err := tx.Exec("DELETE FROM expense_field_values WHERE expense_id = ?", expense.ID); err != nil {
+        return err
+    }
+    // The files themselves are left for the attachment store to sweep
+    if _, err := tx.Exec("DELETE FROM attachments WHERE expense_id = ?", expense.ID); err != nil {
+        return err
+    }
+    if _, err := tx.Exec("DELETE FROM expenses WHERE id = ?", expense.ID); err != nil {
+        return err
+    }
+    
+    return recordChange(tx, expense.ID, models.ActionPurge, source, expense, nil)
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+// Stats attribute a split expense to its lines and anything else to the
+// expense itself. Both expressions assume expense_splits is left-joined.
+const (
+	statsSplitJoin  = ` LEFT JOIN expense_splits ON expense_splits.expense_id = expenses.id`
+	statsCategoryID = `COALESCE(expense_splits.category_id, expenses.category_id)`
+	statsAmount     = `COALESCE(expense_splits.amount_cents, expenses.amount_cents)`
+)
+
+const maxSplitNoteLength = 500
+
+// prepareSplits resolves the category of every line and checks that the
+// lines add up to the expense amount. A nil list is left alone.
+func prepareSplits(q database.Querier, amount models.Money, splits []models.ExpenseSplit) error {
+	if len(splits) == 0 {
+		return nil
+	}
+	if len(splits) < 2 {
+		return fmt.Errorf("%w: a split needs at least two lines", ErrInvalidSplit)
+	}
+
+	var sum models.Money
+	for i := range splits {
+		line := &splits[i]
+		if line.Amount == 0 {
+			return fmt.Errorf("%w: line %d has no amount", ErrInvalidSplit, i+1)
+		}
+		line.Note = strings.TrimSpace(line.Note)
+		if len(line.Note) > maxSplitNoteLength {
+			return fmt.Errorf("%w: line %d note is longer than %d characters", ErrInvalidSplit, i+1, maxSplitNoteLength)
+		}
+
+		category, err := resolveCategory(q, line.CategoryID, line.Category)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		line.CategoryID = category.ID
+		line.Category = category.Name
+		sum += line.Amount
+	}
+
+	if sum != amount {
+		return fmt.Errorf("%w: lines add up to %s but the expense is %s", ErrInvalidSplit, sum, amount)
+	}
+	return nil
+}
+
+// setExpenseSplits replaces an expense's split lines. Lines that are already
+// stored as given are kept, so their IDs stay stable across edits and
+// reverts that do not touch them.
+func setExpenseSplits(tx *database.Tx, expenseID int, current, splits []models.ExpenseSplit) error {
+	if sameSplits(current, splits) {
+		return nil
+	}
+
+	if _, err := tx.Exec("DELETE FROM expense_splits WHERE expense_id = ?", expenseID); err != nil {
+		return err
+	}
+	for i, line := range splits {
+		_, err := tx.Exec(`
+            INSERT INTO expense_splits (expense_id, position, category_id, amount_cents, note)
+            VALUES (?, ?, ?, ?, ?)
+        `, expenseID, i, line.CategoryID, line.Amount, line.Note)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameSplits(a, b []models.ExpenseSplit) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].CategoryID != b[i].CategoryID || a[i].Amount != b[i].Amount || a[i].Note != b[i].Note {
+			return false
+		}
+	}
+	return true
+}
+
+// loadSplits fills in the split lines of the given expenses with one query.
+func loadSplits(q database.Querier, expenses []models.Expense) error {
+	if len(expenses) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Expense, len(expenses))
+	placeholders := make([]string, len(expenses))
+	args := make([]interface{}, len(expenses))
+	for i := range expenses {
+		expenses[i].Splits = []models.ExpenseSplit{}
+		byID[expenses[i].ID] = &expenses[i]
+		placeholders[i] = "?"
+		args[i] = expenses[i].ID
+	}
+
+	rows, err := q.Query(`
+        SELECT expense_splits.expense_id, expense_splits.id, expense_splits.category_id,
+               COALESCE(categories.name, ''), expense_splits.amount_cents, expense_splits.note
+        FROM expense_splits LEFT JOIN categories ON categories.id = expense_splits.category_id
+        WHERE expense_splits.expense_id IN (`+strings.Join(placeholders, ", ")+`)
+        ORDER BY expense_splits.expense_id, expense_splits.position
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var expenseID int
+		var line models.ExpenseSplit
+		if err := rows.Scan(&expenseID, &line.ID, &line.CategoryID, &line.Category, &line.Amount, &line.Note); err != nil {
+			return err
+		}
+		if e := byID[expenseID]; e != nil {
+			e.Splits = append(e.Splits, line)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,598 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+const (
+	maxMerchantNameLength = 100
+	maxAliasLength        = 200
+)
+
+type MerchantRepository interface {
+	GetAll(ctx context.Context) ([]models.Merchant, error)
+	GetByID(ctx context.Context, id int) (*models.Merchant, error)
+	Create(ctx context.Context, merchant *models.Merchant) error
+
+	// Update renames a merchant and, unless Aliases is nil, replaces its
+	// aliases. Expenses with the old name as their vendor are renamed too
+	Update(ctx context.Context, id int, merchant *models.Merchant) error
+
+	// Delete removes a merchant and its aliases; expenses keep their vendor
+	Delete(ctx context.Context, id int) error
+
+	// Merge folds source into target: target takes over source's aliases
+	// and name as an alias, and expenses that source's name or aliases
+	// match are renamed. It returns target and how many expenses were
+	// renamed
+	Merge(ctx context.Context, sourceID, targetID int) (*models.Merchant, int, error)
+
+	// Directory loads every merchant and alias for matching vendors
+	Directory(ctx context.Context) (*MerchantDirectory, error)
+}
+
+type merchantRepository struct {
+	db *database.DB
+}
+
+func NewMerchantRepository(db *database.DB) MerchantRepository {
+	return &merchantRepository{db: db}
+}
+
+// The expense count compares case-insensitively, like renames do
+const merchantColumns = `merchants.id, merchants.name,
+        (SELECT COUNT(*) FROM expenses WHERE expenses.deleted_at IS NULL AND LOWER(expenses.vendor) = LOWER(merchants.name)),
+        merchants.created_at, merchants.updated_at`
+
+// GetAll lists the merchants by name.
+func (r *merchantRepository) GetAll(ctx context.Context) ([]models.Merchant, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	rows, err := q.Query("SELECT " + merchantColumns + " FROM merchants ORDER BY LOWER(merchants.name)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	merchants := []models.Merchant{}
+	for rows.Next() {
+		var m models.Merchant
+		if err := rows.Scan(&m.ID, &m.Name, &m.Expenses, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		merchants = append(merchants, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadAliases(q, merchants); err != nil {
+		return nil, err
+	}
+	return merchants, nil
+}
+
+func (r *merchantRepository) GetByID(ctx context.Context, id int) (*models.Merchant, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	return getMerchant(q, id)
+}
+
+func (r *merchantRepository) Create(ctx context.Context, merchant *models.Merchant) error {
+	if err := validateMerchant(merchant); err != nil {
+		return err
+	}
+	if merchant.Aliases == nil {
+		merchant.Aliases = []string{}
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkMerchantTerms(tx, merchant, 0); err != nil {
+		return err
+	}
+	id, err := tx.ExecReturningID(`
+        INSERT INTO merchants (name, created_at, updated_at)
+        VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    `, merchant.Name)
+	if err != nil {
+		return err
+	}
+	if err := setAliases(tx, int(id), merchant.Aliases); err != nil {
+		return err
+	}
+
+	created, err := getMerchant(tx, int(id))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*merchant = *created
+	return nil
+}
+
+func (r *merchantRepository) Update(ctx context.Context, id int, merchant *models.Merchant) error {
+	if err := validateMerchant(merchant); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := getMerchant(tx, id)
+	if err != nil {
+		return err
+	}
+	if merchant.Aliases == nil {
+		merchant.Aliases = current.Aliases
+	}
+	if err := checkMerchantTerms(tx, merchant, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE merchants SET name = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		merchant.Name, id,
+	); err != nil {
+		return err
+	}
+	if err := setAliases(tx, id, merchant.Aliases); err != nil {
+		return err
+	}
+	if merchant.Name != current.Name {
+		if _, err := renameVendor(tx, current.Name, merchant.Name); err != nil {
+			return err
+		}
+	}
+
+	updated, err := getMerchant(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*merchant = *updated
+	return nil
+}
+
+func (r *merchantRepository) Delete(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getMerchant(tx, id); err != nil {
+		return err
+	}
+	// Aliases are removed explicitly in case foreign keys are off
+	if _, err := tx.Exec("DELETE FROM merchant_aliases WHERE merchant_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM merchants WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *merchantRepository) Merge(ctx context.Context, sourceID, targetID int) (*models.Merchant, int, error) {
+	if sourceID == targetID {
+		return nil, 0, fmt.Errorf("%w: a merchant cannot be merged into itself", ErrInvalidMerchant)
+	}
+
+	tx, err := r.db.BeginTx(ctx, database.OpWrite)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	source, err := getMerchant(tx, sourceID)
+	if err != nil {
+		return nil, 0, err
+	}
+	target, err := getMerchant(tx, targetID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The source's name becomes an alias, so it keeps matching
+	aliases, err := normalizeAliases(append(append(target.Aliases, source.Aliases...), source.Name))
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM merchant_aliases WHERE merchant_id = ?", sourceID); err != nil {
+		return nil, 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM merchants WHERE id = ?", sourceID); err != nil {
+		return nil, 0, err
+	}
+	if err := setAliases(tx, targetID, aliases); err != nil {
+		return nil, 0, err
+	}
+	if _, err := tx.Exec("UPDATE merchants SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", targetID); err != nil {
+		return nil, 0, err
+	}
+
+	renamed, err := applyMerge(tx, source, target.Name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	merged, err := getMerchant(tx, targetID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return merged, renamed, nil
+}
+
+func (r *merchantRepository) Directory(ctx context.Context) (*MerchantDirectory, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	return loadDirectory(q)
+}
+
+func loadDirectory(q database.Querier) (*MerchantDirectory, error) {
+	rows, err := q.Query(`
+        SELECT merchants.name, merchant_aliases.pattern
+        FROM merchants LEFT JOIN merchant_aliases ON merchant_aliases.merchant_id = merchants.id
+        ORDER BY LOWER(merchants.name)
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	d := &MerchantDirectory{names: make(map[string]string)}
+	for rows.Next() {
+		var name string
+		var pattern sql.NullString
+		if err := rows.Scan(&name, &pattern); err != nil {
+			return nil, err
+		}
+		d.names[strings.ToLower(name)] = name
+		if pattern.Valid {
+			d.aliases = append(d.aliases, compileAlias(name, pattern.String))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The most specific pattern wins, then the first merchant by name
+	sort.SliceStable(d.aliases, func(i, j int) bool {
+		return d.aliases[i].literal > d.aliases[j].literal
+	})
+	return d, nil
+}
+
+// MerchantDirectory maps raw vendor strings to canonical merchant names.
+type MerchantDirectory struct {
+	names   map[string]string
+	aliases []merchantAlias
+}
+
+type merchantAlias struct {
+	merchant string
+	pattern  *regexp.Regexp
+	// How many characters the pattern matches literally
+	literal int
+}
+
+func compileAlias(merchant, pattern string) merchantAlias {
+	parts := strings.Split(pattern, "*")
+	literal := 0
+	for i, part := range parts {
+		literal += utf8.RuneCountInString(part)
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return merchantAlias{
+		merchant: merchant,
+		pattern:  regexp.MustCompile(`(?is)^` + strings.Join(parts, ".*") + `$`),
+		literal:  literal,
+	}
+}
+
+// Match returns the merchant name for a raw vendor string. A merchant's own
+// name matches regardless of case and wins over any alias.
+func (d *MerchantDirectory) Match(raw string) (string, bool) {
+	raw = collapseSpaces(raw)
+	if d == nil || raw == "" {
+		return "", false
+	}
+	if name, ok := d.names[strings.ToLower(raw)]; ok {
+		return name, true
+	}
+	for _, alias := range d.aliases {
+		if alias.pattern.MatchString(raw) {
+			return alias.merchant, true
+		}
+	}
+	return "", false
+}
+
+// Apply replaces an expense's vendor with its merchant name. An expense
+// without a vendor takes the merchant its description matches, since many
+// statements only have the one column. It reports whether the vendor
+// changed.
+func (d *MerchantDirectory) Apply(expense *models.Expense) bool {
+	raw := expense.Vendor
+	if strings.TrimSpace(raw) == "" {
+		raw = expense.Description
+	}
+	name, ok := d.Match(raw)
+	if !ok || name == expense.Vendor {
+		return false
+	}
+	expense.Vendor = name
+	return true
+}
+
+// renameVendor moves live expenses from one vendor name to another,
+// recording each change in their history.
+func renameVendor(tx *database.Tx, from, to string) (int, error) {
+	rows, err := tx.Query("SELECT id FROM expenses WHERE deleted_at IS NULL AND LOWER(vendor) = LOWER(?)", from)
+	if err != nil {
+		return 0, err
+	}
+	vendors := make(map[int]string)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		vendors[id] = to
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return setVendors(tx, vendors, models.SourceMerchants)
+}
+
+// applyMerge renames the live expenses that source's name or aliases match,
+// by vendor or by description when there is no vendor, to target, unless
+// the merged directory gives them to a merchant with a more specific alias.
+func applyMerge(tx *database.Tx, source *models.Merchant, target string) (int, error) {
+	directory, err := loadDirectory(tx)
+	if err != nil {
+		return 0, err
+	}
+	sourceTerms := &MerchantDirectory{names: map[string]string{strings.ToLower(source.Name): target}}
+	for _, alias := range source.Aliases {
+		sourceTerms.aliases = append(sourceTerms.aliases, compileAlias(target, alias))
+	}
+
+	rows, err := tx.Query("SELECT id, COALESCE(vendor, ''), description FROM expenses WHERE deleted_at IS NULL")
+	if err != nil {
+		return 0, err
+	}
+	vendors := make(map[int]string)
+	for rows.Next() {
+		var expense models.Expense
+		if err := rows.Scan(&expense.ID, &expense.Vendor, &expense.Description); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		probe := expense
+		if sourceTerms.Apply(&probe) && directory.Apply(&expense) && expense.Vendor == target {
+			vendors[expense.ID] = target
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return setVendors(tx, vendors, models.SourceMerchants)
+}
+
+// setVendors changes the vendor of each expense by ID, skipping missing ones
+// and those already set.
+func setVendors(tx *database.Tx, vendors map[int]string, source string) (int, error) {
+	ids := make([]int, 0, len(vendors))
+	for id := range vendors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	updated := 0
+	for _, id := range ids {
+		expense, err := getExpense(tx, id, false)
+		if err == ErrExpenseNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if expense.Vendor == vendors[id] {
+			continue
+		}
+
+		expense.Vendor = vendors[id]
+		expense.Fields = nil
+		if _, err := updateExpense(tx, id, expense, models.ActionUpdate, source); err != nil {
+			return 0, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func getMerchant(q database.Querier, id int) (*models.Merchant, error) {
+	var m models.Merchant
+	err := q.QueryRow("SELECT "+merchantColumns+" FROM merchants WHERE merchants.id = ?", id).
+		Scan(&m.ID, &m.Name, &m.Expenses, &m.CreatedAt, &m.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrMerchantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	merchants := []models.Merchant{m}
+	if err := loadAliases(q, merchants); err != nil {
+		return nil, err
+	}
+	return &merchants[0], nil
+}
+
+func loadAliases(q database.Querier, merchants []models.Merchant) error {
+	byID := make(map[int]*models.Merchant, len(merchants))
+	for i := range merchants {
+		merchants[i].Aliases = []string{}
+		byID[merchants[i].ID] = &merchants[i]
+	}
+	if len(merchants) == 0 {
+		return nil
+	}
+
+	rows, err := q.Query("SELECT merchant_id, pattern FROM merchant_aliases ORDER BY LOWER(pattern)")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var merchantID int
+		var pattern string
+		if err := rows.Scan(&merchantID, &pattern); err != nil {
+			return err
+		}
+		if m := byID[merchantID]; m != nil {
+			m.Aliases = append(m.Aliases, pattern)
+		}
+	}
+	return rows.Err()
+}
+
+func setAliases(tx *database.Tx, merchantID int, aliases []string) error {
+	if _, err := tx.Exec("DELETE FROM merchant_aliases WHERE merchant_id = ?", merchantID); err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if _, err := tx.Exec(
+			"INSERT INTO merchant_aliases (merchant_id, pattern, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+			merchantID, alias,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMerchantTerms makes sure no other merchant has the same name or an
+// alias that is the same, ignoring case, as any of this one's, so every raw
+// string has a single exact match.
+func checkMerchantTerms(q database.Querier, merchant *models.Merchant, exceptID int) error {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM merchants WHERE LOWER(name) = LOWER(?) AND id <> ?", merchant.Name, exceptID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrMerchantExists
+	}
+
+	for _, term := range append([]string{merchant.Name}, merchant.Aliases...) {
+		var owner string
+		err := q.QueryRow(`
+            SELECT merchants.name FROM merchant_aliases JOIN merchants ON merchants.id = merchant_aliases.merchant_id
+            WHERE LOWER(merchant_aliases.pattern) = LOWER(?) AND merchants.id <> ?
+            UNION ALL
+            SELECT name FROM merchants WHERE LOWER(name) = LOWER(?) AND id <> ?
+        `, term, exceptID, term, exceptID).Scan(&owner)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if term == merchant.Name {
+			return fmt.Errorf("%w: %q is an alias of %s", ErrMerchantExists, term, owner)
+		}
+		return fmt.Errorf("%w: %q belongs to %s", ErrAliasExists, term, owner)
+	}
+	return nil
+}
+
+// validateMerchant trims the name and normalizes the aliases.
+func validateMerchant(merchant *models.Merchant) error {
+	merchant.Name = collapseSpaces(merchant.Name)
+	if merchant.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidMerchant)
+	}
+	if utf8.RuneCountInString(merchant.Name) > maxMerchantNameLength {
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalidMerchant, maxMerchantNameLength)
+	}
+	if strings.Contains(merchant.Name, "*") {
+		return fmt.Errorf("%w: name cannot contain *", ErrInvalidMerchant)
+	}
+	if merchant.Aliases == nil {
+		return nil
+	}
+
+	aliases, err := normalizeAliases(merchant.Aliases)
+	if err != nil {
+		return err
+	}
+	// The name already matches itself
+	merchant.Aliases = aliases[:0]
+	for _, alias := range aliases {
+		if !strings.EqualFold(alias, merchant.Name) {
+			merchant.Aliases = append(merchant.Aliases, alias)
+		}
+	}
+	return nil
+}
+
+// normalizeAliases collapses spaces and runs of "*" and drops duplicates,
+// ignoring case. An alias needs at least one character besides "*".
+func normalizeAliases(aliases []string) ([]string, error) {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, raw := range aliases {
+		alias := collapseSpaces(raw)
+		for strings.Contains(alias, "**") {
+			alias = strings.ReplaceAll(alias, "**", "*")
+		}
+		if strings.Trim(alias, "* ") == "" {
+			return nil, fmt.Errorf("%w: alias %q would match every vendor", ErrInvalidMerchant, raw)
+		}
+		if utf8.RuneCountInString(alias) > maxAliasLength {
+			return nil, fmt.Errorf("%w: alias is longer than %d characters", ErrInvalidMerchant, maxAliasLength)
+		}
+		if key := strings.ToLower(alias); !seen[key] {
+			seen[key] = true
+			result = append(result, alias)
+		}
+	}
+	return result, nil
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,2350 @@
+// Package repotest holds the backend-agnostic conformance suite that every
+// database backend must pass. Backends call Run from their own tests with a
+// function that returns a freshly migrated, empty database.
+package repotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"expense-tracker/internal/attachments"
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/integrity"
+	"expense-tracker/internal/models"
+	"expense-tracker/internal/repository"
+)
+
+// OpenFunc returns a migrated database with no expenses. It should register
+// any cleanup with t.Cleanup.
+type OpenFunc func(t *testing.T) *database.DB
+
+// Run executes every conformance test against the backend.
+func Run(t *testing.T, open OpenFunc) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, db *database.DB)
+	}{
+		{"CreateAndGetByID", testCreateAndGetByID},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"TrashRestoreAndPurge", testTrashRestoreAndPurge},
+		{"HistoryAndRevert", testHistoryAndRevert},
+		{"GetAllFiltersAndPagination", testGetAllFiltersAndPagination},
+		{"Search", testSearch},
+		{"StatsUseExactCents", testStatsUseExactCents},
+		{"StatsConvertToBaseCurrency", testStatsConvertToBaseCurrency},
+		{"BulkInsertAndDuplicates", testBulkInsertAndDuplicates},
+		{"Settings", testSettings},
+		{"IntegrityCheck", testIntegrityCheck},
+		{"Categories", testCategories},
+		{"CategoryHierarchy", testCategoryHierarchy},
+		{"Tags", testTags},
+		{"Splits", testSplits},
+		{"Accounts", testAccounts},
+		{"TransactionTypes", testTransactionTypes},
+		{"Attachments", testAttachments},
+		{"CustomFields", testCustomFields},
+		{"Merchants", testMerchants},
+		{"Claims", testClaims},
+		{"FiltersAndSorting", testFiltersAndSorting},
+		{"CursorPagination", testCursorPagination},
+		{"ContextTimeouts", testContextTimeouts},
+		{"BulkChanges", testBulkChanges},
+		{"Versions", testVersions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+func day(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func newExpense(date, category, description string, cents int64) models.Expense {
+	return models.Expense{
+		Date:          day(date),
+		Category:      category,
+		Description:   description,
+		Amount:        models.Money(cents),
+		Vendor:        "Vendor " + description,
+		PaymentMethod: "Card",
+	}
+}
+
+func mustCreate(t *testing.T, repo repository.ExpenseRepository, expenses ...models.Expense) []models.Expense {
+	t.Helper()
+	ctx := t.Context()
+	for i := range expenses {
+		if err := repo.Create(ctx, &expenses[i]); err != nil {
+			t.Fatalf("Create(%q): %v", expenses[i].Description, err)
+		}
+	}
+	return expenses
+}
+
+func testCreateAndGetByID(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+
+	created := mustCreate(t, repo, newExpense("2024-03-15", "Food & Dining", "Ramen", 1234))[0]
+	if created.ID == 0 {
+		t.Fatal("Create did not assign an ID")
+	}
+
+	got, err := repo.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if got.Date.Format("2006-01-02") != "2024-03-15" {
+		t.Errorf("date = %s, want 2024-03-15", got.Date.Format("2006-01-02"))
+	}
+	if got.Amount != 1234 {
+		t.Errorf("amount = %s, want 12.34", got.Amount)
+	}
+	if got.Currency != repository.DefaultBaseCurrency {
+		t.Errorf("currency = %q, want base currency %q", got.Currency, repository.DefaultBaseCurrency)
+	}
+	if got.Category != created.Category || got.Description != created.Description ||
+		got.Vendor != created.Vendor || got.PaymentMethod != created.PaymentMethod {
+		t.Errorf("GetByID = %+v, want fields of %+v", got, created)
+	}
+	if got.CreatedAt.IsZero() || got.UpdatedAt.IsZero() {
+		t.Error("timestamps were not set")
+	}
+
+	if _, err := repo.GetByID(ctx, created.ID+1000); err == nil {
+		t.Error("GetByID of a missing expense returned no error")
+	}
+}
+
+func testUpdate(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	created := mustCreate(t, repo, newExpense("2024-03-15", "Food & Dining", "Ramen", 1234))[0]
+
+	changed := newExpense("2024-03-16", "Shopping", "Groceries", 5678)
+	changed.Currency = "eur"
+	if err := repo.Update(ctx, created.ID, &changed, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	got, err := repo.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.Category != "Shopping" || got.Amount != 5678 || got.Currency != "EUR" ||
+		got.Date.Format("2006-01-02") != "2024-03-16" {
+		t.Errorf("after update got %+v", got)
+	}
+
+	missing := newExpense("2024-03-16", "Shopping", "Nothing", 1)
+	if err := repo.Update(ctx, created.ID+1000, &missing, 0); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("Update of missing expense = %v, want ErrExpenseNotFound", err)
+	}
+}
+
+func testDelete(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	created := mustCreate(t, repo, newExpense("2024-03-15", "Food & Dining", "Ramen", 1234))[0]
+
+	if err := repo.Delete(ctx, created.ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := repo.GetByID(ctx, created.ID); err == nil {
+		t.Error("deleted expense is still returned by GetByID")
+	}
+	if err := repo.Delete(ctx, created.ID, 0); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("second Delete = %v, want ErrExpenseNotFound", err)
+	}
+}
+
+func testTrashRestoreAndPurge(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	created := mustCreate(t, repo,
+		newExpense("2024-03-15", "Food & Dining", "Kept", 100),
+		newExpense("2024-03-15", "Food & Dining", "Trashed", 200),
+	)
+	trashed := created[1]
+
+	if err := repo.Delete(ctx, trashed.ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	// Trashed rows disappear from lists, stats and duplicate checks
+	all, _, err := repo.GetAll(ctx, models.ExpenseFilter{}, 1, 20)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 1 || all[0].ID != created[0].ID {
+		t.Errorf("GetAll = %v, want only Kept", descriptions(all))
+	}
+	stats, err := repo.GetStats(ctx, models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-03-31"})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if total := stats["total"].(models.Money); total != 100 {
+		t.Errorf("stats total = %s, want 1.00", total)
+	}
+	infos, err := repo.CheckForDuplicates(ctx, []models.Expense{newExpense("2024-03-15", "Food & Dining", "Trashed", 200)})
+	if err != nil {
+		t.Fatalf("CheckForDuplicates: %v", err)
+	}
+	if infos[0].IsDuplicate {
+		t.Error("trashed expense reported as a duplicate")
+	}
+
+	trash, pagination, err := repo.GetTrash(ctx, 1, 20)
+	if err != nil {
+		t.Fatalf("GetTrash: %v", err)
+	}
+	if pagination.Total != 1 || len(trash) != 1 || trash[0].ID != trashed.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("GetTrash = %+v", trash)
+	}
+
+	if err := repo.Purge(ctx, created[0].ID); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("Purge of a live expense = %v, want ErrExpenseNotFound", err)
+	}
+
+	if err := repo.Restore(ctx, trashed.ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if _, err := repo.GetByID(ctx, trashed.ID); err != nil {
+		t.Errorf("restored expense not found: %v", err)
+	}
+	if err := repo.Restore(ctx, trashed.ID); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("Restore of a live expense = %v, want ErrExpenseNotFound", err)
+	}
+
+	// Retention purge only removes rows trashed before the cutoff
+	if err := repo.Delete(ctx, trashed.ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if purged, err := repo.PurgeDeletedBefore(ctx, time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("PurgeDeletedBefore(an hour ago) = %d, %v; want 0", purged, err)
+	}
+	if purged, err := repo.PurgeDeletedBefore(ctx, time.Now().Add(time.Minute)); err != nil || purged != 1 {
+		t.Errorf("PurgeDeletedBefore(now) = %d, %v; want 1", purged, err)
+	}
+	if trash, _, _ := repo.GetTrash(ctx, 1, 20); len(trash) != 0 {
+		t.Errorf("trash not empty after purge: %v", descriptions(trash))
+	}
+}
+
+func testHistoryAndRevert(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	created := mustCreate(t, repo, newExpense("2024-03-15", "Food & Dining", "Lunch", 1000))[0]
+
+	edited := created
+	edited.Amount = 1500
+	edited.CategoryID = 0
+	edited.Category = "Shopping"
+	if err := repo.Update(ctx, created.ID, &edited, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := repo.Delete(ctx, created.ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := repo.Restore(ctx, created.ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	history, err := repo.GetHistory(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	var actions []string
+	for _, change := range history {
+		actions = append(actions, change.Action)
+	}
+	want := []string{models.ActionRestore, models.ActionDelete, models.ActionUpdate, models.ActionCreate}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Fatalf("history actions = %v, want %v (newest first)", actions, want)
+	}
+	update := history[2]
+	if update.Version != 2 || update.Source != models.SourceManual {
+		t.Errorf("update entry = version %d source %q", update.Version, update.Source)
+	}
+	if strings.Join(update.ChangedFields, ",") != "amount,category,category_id" {
+		t.Errorf("changed fields = %v, want [amount category category_id]", update.ChangedFields)
+	}
+
+	reverted, err := repo.RevertToVersion(ctx, created.ID, 1)
+	if err != nil {
+		t.Fatalf("RevertToVersion: %v", err)
+	}
+	if reverted.Amount != 1000 || reverted.Category != "Food & Dining" || reverted.CategoryID != created.CategoryID {
+		t.Errorf("reverted = %s %q, want 10.00 Food & Dining", reverted.Amount, reverted.Category)
+	}
+	if history, _ := repo.GetHistory(ctx, created.ID); len(history) != 5 || history[0].Action != models.ActionRevert {
+		t.Errorf("revert was not recorded: %d entries", len(history))
+	}
+
+	if _, err := repo.RevertToVersion(ctx, created.ID, 99); !errors.Is(err, repository.ErrVersionNotFound) {
+		t.Errorf("RevertToVersion(99) = %v, want ErrVersionNotFound", err)
+	}
+	if _, err := repo.GetHistory(ctx, created.ID+1000); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("GetHistory(unknown) = %v, want ErrExpenseNotFound", err)
+	}
+
+	// History survives a purge, but a purged expense cannot be reverted
+	if err := repo.Delete(ctx, created.ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := repo.Purge(ctx, created.ID); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if history, err := repo.GetHistory(ctx, created.ID); err != nil || history[0].Action != models.ActionPurge {
+		t.Errorf("history after purge = %v, %v", history, err)
+	}
+	if _, err := repo.RevertToVersion(ctx, created.ID, 1); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("RevertToVersion of a purged expense = %v, want ErrExpenseNotFound", err)
+	}
+
+	imported, err := repo.BulkInsert(ctx, []models.Expense{newExpense("2024-03-16", "Shopping", "Imported", 700)})
+	if err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+	if history, err := repo.GetHistory(ctx, imported[0].ID); err != nil || history[0].Source != models.SourceCSVImport {
+		t.Errorf("imported history = %v, %v; want csv_import source", history, err)
+	}
+}
+
+func testGetAllFiltersAndPagination(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	mustCreate(t, repo,
+		newExpense("2024-01-10", "Food & Dining", "A", 100),
+		newExpense("2024-02-10", "Transportation", "B", 200),
+		newExpense("2024-02-29", "Food & Dining", "C", 300),
+		newExpense("2024-03-01", "Food & Dining", "D", 400),
+		newExpense("2024-04-05", "Shopping", "E", 500),
+	)
+
+	all, pagination, err := repo.GetAll(ctx, models.ExpenseFilter{}, 1, 2)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if pagination.Total != 5 || !pagination.HasNext || pagination.HasPrevious {
+		t.Errorf("page 1 pagination = %+v", pagination)
+	}
+	if len(all) != 2 || all[0].Description != "E" || all[1].Description != "D" {
+		t.Errorf("page 1 = %v, want newest first [E D]", descriptions(all))
+	}
+
+	last, pagination, err := repo.GetAll(ctx, models.ExpenseFilter{}, 3, 2)
+	if err != nil {
+		t.Fatalf("GetAll page 3: %v", err)
+	}
+	if len(last) != 1 || last[0].Description != "A" || pagination.HasNext || !pagination.HasPrevious {
+		t.Errorf("page 3 = %v, pagination %+v", descriptions(last), pagination)
+	}
+
+	// End date is inclusive
+	filter := models.ExpenseFilter{
+		StartDate: day("2024-02-01"),
+		EndDate:   day("2024-02-29"),
+		Category:  "Food & Dining",
+	}
+	filtered, pagination, err := repo.GetAll(ctx, filter, 1, 20)
+	if err != nil {
+		t.Fatalf("GetAll filtered: %v", err)
+	}
+	if pagination.Total != 1 || len(filtered) != 1 || filtered[0].Description != "C" {
+		t.Errorf("filtered = %v (total %d), want [C]", descriptions(filtered), pagination.Total)
+	}
+}
+
+func testSearch(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	ride := newExpense("2024-03-12", "Transportation", "Grab ride to airport", 2350)
+	ride.Vendor = "Grab"
+	food := newExpense("2024-03-20", "Food Delivery", "GrabFood dinner", 1800)
+	food.Vendor = "Grab"
+	other := newExpense("2024-04-02", "Transportation", "Taxi <late>", 3100)
+	other.PaymentMethod = "Cash"
+	created := mustCreate(t, repo, ride, food, other)
+
+	search := func(filter models.ExpenseFilter, page, limit int) ([]models.Expense, *repository.PaginationInfo) {
+		t.Helper()
+		results, pagination, err := repo.GetAll(ctx, filter, page, limit)
+		if err != nil {
+			t.Fatalf("GetAll(%+v): %v", filter, err)
+		}
+		return results, pagination
+	}
+
+	results, pagination := search(models.ExpenseFilter{Query: "grab"}, 1, 20)
+	if pagination.Total != 2 || len(results) != 2 {
+		t.Fatalf("q=grab = %v, want 2 results", descriptions(results))
+	}
+	for _, e := range results {
+		if e.Highlight == nil || !strings.Contains(e.Highlight.Vendor, "<mark>") {
+			t.Errorf("%q has no vendor highlight: %+v", e.Description, e.Highlight)
+		}
+	}
+
+	// Search combines with the other filters and pagination
+	results, _ = search(models.ExpenseFilter{Query: "grab", Category: "Transportation"}, 1, 20)
+	if len(results) != 1 || results[0].ID != created[0].ID {
+		t.Errorf("q=grab category=Transportation = %v, want the ride", descriptions(results))
+	}
+	results, _ = search(models.ExpenseFilter{Query: "grab", StartDate: day("2024-03-15")}, 1, 20)
+	if len(results) != 1 || results[0].ID != created[1].ID {
+		t.Errorf("q=grab from 2024-03-15 = %v, want GrabFood", descriptions(results))
+	}
+	results, pagination = search(models.ExpenseFilter{Query: "grab"}, 2, 1)
+	if pagination.Total != 2 || len(results) != 1 || pagination.HasNext || !pagination.HasPrevious {
+		t.Errorf("q=grab page 2 = %v %+v", descriptions(results), pagination)
+	}
+
+	// All terms must match, and query syntax is treated as plain text
+	results, _ = search(models.ExpenseFilter{Query: "ride airport"}, 1, 20)
+	if len(results) != 1 || results[0].ID != created[0].ID {
+		t.Errorf("q=ride airport = %v", descriptions(results))
+	}
+	results, _ = search(models.ExpenseFilter{Query: `cash" OR "grab`}, 1, 20)
+	if len(results) != 0 {
+		t.Errorf("quoted query = %v, want no results", descriptions(results))
+	}
+	results, _ = search(models.ExpenseFilter{Query: "late"}, 1, 20)
+	if len(results) != 1 || !strings.Contains(results[0].Highlight.Description, "&lt;<mark>late</mark>&gt;") {
+		t.Errorf("q=late highlight = %+v, want escaped text", results)
+	}
+
+	// The index follows updates and deletes
+	edited := created[2]
+	edited.Description = "Bus fare"
+	edited.Vendor = "SBS Transit"
+	if err := repo.Update(ctx, edited.ID, &edited, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if results, _ = search(models.ExpenseFilter{Query: "taxi"}, 1, 20); len(results) != 0 {
+		t.Errorf("q=taxi after update = %v", descriptions(results))
+	}
+	if results, _ = search(models.ExpenseFilter{Query: "bus"}, 1, 20); len(results) != 1 {
+		t.Errorf("q=bus after update = %v", descriptions(results))
+	}
+	if err := repo.Delete(ctx, created[0].ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := repo.Purge(ctx, created[0].ID); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if results, _ = search(models.ExpenseFilter{Query: "airport"}, 1, 20); len(results) != 0 {
+		t.Errorf("q=airport after purge = %v", descriptions(results))
+	}
+}
+
+func testStatsUseExactCents(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	mustCreate(t, repo,
+		newExpense("2024-01-31", "Food & Dining", "A", 10),
+		newExpense("2024-01-15", "Food & Dining", "B", 10),
+		newExpense("2024-02-01", "Food & Dining", "C", 10),
+		newExpense("2024-02-29", "Shopping", "D", 20),
+		newExpense("2024-03-01", "Shopping", "E", 99999),
+	)
+
+	stats, err := repo.GetStats(ctx, models.StatsFilter{StartDate: "2024-01-01", EndDate: "2024-02-29"})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if total := stats["total"].(models.Money); total != 50 {
+		t.Errorf("total = %s, want 0.50", total)
+	}
+	categories := stats["categories"].(map[string]models.Money)
+	if categories["Food & Dining"] != 30 || categories["Shopping"] != 20 {
+		t.Errorf("categories = %v", categories)
+	}
+
+	filtered, err := repo.GetStats(ctx, models.StatsFilter{StartDate: "2024-01-01", EndDate: "2024-02-29", Category: "Shopping"})
+	if err != nil {
+		t.Fatalf("GetStats by category: %v", err)
+	}
+	if total := filtered["total"].(models.Money); total != 20 {
+		t.Errorf("Shopping total = %s, want 0.20", total)
+	}
+
+	monthly, err := repo.GetMonthlyStats(ctx, models.StatsFilter{StartDate: "2024-01-01", EndDate: "2024-03-31"})
+	if err != nil {
+		t.Fatalf("GetMonthlyStats: %v", err)
+	}
+	months := monthly["monthly"].([]map[string]interface{})
+	want := []struct {
+		month string
+		total models.Money
+	}{{"2024-01", 20}, {"2024-02", 30}, {"2024-03", 99999}}
+	if len(months) != len(want) {
+		t.Fatalf("got %d months, want %d", len(months), len(want))
+	}
+	for i, w := range want {
+		if months[i]["month"] != w.month || months[i]["total"].(models.Money) != w.total {
+			t.Errorf("month %d = %v %v, want %s %s", i, months[i]["month"], months[i]["total"], w.month, w.total)
+		}
+	}
+	if total := monthly["total"].(models.Money); total != 100049 {
+		t.Errorf("monthly total = %s, want 1000.49", total)
+	}
+
+	if _, err := repo.GetStats(ctx, models.StatsFilter{StartDate: "01/02/2024"}); !errors.Is(err, repository.ErrInvalidDateRange) {
+		t.Errorf("GetStats with bad date = %v, want ErrInvalidDateRange", err)
+	}
+}
+
+func testStatsConvertToBaseCurrency(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	settings := repository.NewSettingsRepository(db)
+	rates := repository.NewExchangeRateRepository(db)
+
+	if err := settings.Update(ctx, &models.Settings{BaseCurrency: "SGD"}); err != nil {
+		t.Fatalf("Update settings: %v", err)
+	}
+	_, err := rates.Upsert(ctx, []models.ExchangeRate{
+		{Date: day("2024-03-01"), BaseCurrency: "EUR", Currency: "SGD", Rate: 1.40},
+		{Date: day("2024-03-15"), BaseCurrency: "EUR", Currency: "SGD", Rate: 1.45},
+		{Date: day("2024-03-15"), BaseCurrency: "EUR", Currency: "JPY", Rate: 161.5},
+	})
+	if err != nil {
+		t.Fatalf("Upsert rates: %v", err)
+	}
+
+	eur := newExpense("2024-03-16", "Food & Dining", "EUR later", 1000)
+	eur.Currency = "EUR"
+	eurEarly := newExpense("2024-03-05", "Food & Dining", "EUR early", 1000)
+	eurEarly.Currency = "EUR"
+	jpy := newExpense("2024-03-16", "Shopping", "JPY", 16150)
+	jpy.Currency = "JPY"
+	unknown := newExpense("2024-03-16", "Shopping", "No rate", 500)
+	unknown.Currency = "XAU"
+	mustCreate(t, repo, eur, eurEarly, jpy, unknown, newExpense("2024-03-16", "Shopping", "Local", 500))
+
+	stats, err := repo.GetStats(ctx, models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-03-31"})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if stats["base_currency"] != "SGD" {
+		t.Errorf("base_currency = %v, want SGD", stats["base_currency"])
+	}
+	// 10 EUR at 1.45 + 10 EUR at 1.40 + 161.50 JPY (1 EUR) at 1.45 + 5 SGD
+	categories := stats["categories"].(map[string]models.Money)
+	if categories["Food & Dining"] != 2850 || categories["Shopping"] != 645 {
+		t.Errorf("converted categories = %v", categories)
+	}
+	if total := stats["total"].(models.Money); total != 3495 {
+		t.Errorf("converted total = %s, want 34.95", total)
+	}
+	missing := stats["missing_rates"].([]string)
+	if len(missing) != 1 || missing[0] != "XAU" {
+		t.Errorf("missing_rates = %v, want [XAU]", missing)
+	}
+
+	monthly, err := repo.GetMonthlyStats(ctx, models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-03-31"})
+	if err != nil {
+		t.Fatalf("GetMonthlyStats: %v", err)
+	}
+	byCurrency := monthly["by_currency"].(map[string]models.Money)
+	if byCurrency["EUR"] != 2000 || byCurrency["XAU"] != 500 || byCurrency["SGD"] != 500 {
+		t.Errorf("by_currency = %v", byCurrency)
+	}
+}
+
+func testBulkInsertAndDuplicates(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+
+	saved, err := repo.BulkInsert(ctx, []models.Expense{
+		newExpense("2024-03-15", "Food & Dining", "A", 1010),
+		newExpense("2024-03-16", "Food & Dining", "B", 2020),
+	})
+	if err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+	if len(saved) != 2 || saved[0].ID == 0 || saved[1].ID == 0 || saved[0].ID == saved[1].ID {
+		t.Fatalf("BulkInsert returned %+v", saved)
+	}
+
+	otherCurrency := newExpense("2024-03-15", "Food & Dining", "A", 1010)
+	otherCurrency.Currency = "EUR"
+	infos, err := repo.CheckForDuplicates(ctx, []models.Expense{
+		newExpense("2024-03-15", "Food & Dining", "A", 1010),
+		newExpense("2024-03-15", "Food & Dining", "A", 1011),
+		otherCurrency,
+	})
+	if err != nil {
+		t.Fatalf("CheckForDuplicates: %v", err)
+	}
+	if !infos[0].IsDuplicate || infos[0].MatchingExpenseID == nil || *infos[0].MatchingExpenseID != saved[0].ID {
+		t.Errorf("exact copy not reported as duplicate: %+v", infos[0])
+	}
+	if infos[1].IsDuplicate || infos[2].IsDuplicate {
+		t.Errorf("different amount or currency reported as duplicate: %+v", infos[1:])
+	}
+}
+
+func testSettings(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	settings := repository.NewSettingsRepository(db)
+
+	got, err := settings.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.BaseCurrency != repository.DefaultBaseCurrency {
+		t.Errorf("default base currency = %q", got.BaseCurrency)
+	}
+
+	if err := settings.Update(ctx, &models.Settings{BaseCurrency: "euro"}); err == nil {
+		t.Error("Update accepted an invalid currency code")
+	}
+	if err := settings.Update(ctx, &models.Settings{BaseCurrency: "jpy"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if base, _ := settings.BaseCurrency(ctx); base != "JPY" {
+		t.Errorf("base currency = %q, want JPY", base)
+	}
+}
+
+func descriptions(expenses []models.Expense) []string {
+	result := make([]string, len(expenses))
+	for i, e := range expenses {
+		result[i] = e.Description
+	}
+	return result
+}
+
+func testIntegrityCheck(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	mustCreate(t, repo,
+		newExpense("2024-03-15", "Food & Dining", "Fine", 100),
+		newExpense("2024-03-15", "Shopping", "Huge", int64(models.MaxAmount)+1),
+	)
+
+	report, err := integrity.Check(ctx, db, integrity.Options{Fix: true})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if report.OK || report.Issues != 1 || report.Fixed != 0 {
+		t.Fatalf("report = ok %v, %d issues, %d fixed; want 1 issue", report.OK, report.Issues, report.Fixed)
+	}
+	for _, check := range report.Checks {
+		want := integrity.StatusOK
+		switch check.Name {
+		case "amounts":
+			want = integrity.StatusFailed
+		case "integrity_check", "foreign_key_check":
+			if db.Dialect() != database.SQLite {
+				want = integrity.StatusSkipped
+			}
+		}
+		if check.Status != want {
+			t.Errorf("%s status = %s, want %s", check.Name, check.Status, want)
+		}
+	}
+}
+
+func testCategories(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	categories := repository.NewCategoryRepository(db)
+	expenses := repository.NewExpenseRepository(db)
+
+	coffee := models.Category{Name: " Coffee ", Color: "#6f4e37", Icon: "cup"}
+	if err := categories.Create(ctx, &coffee); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if coffee.ID == 0 || coffee.Name != "Coffee" {
+		t.Fatalf("created = %+v", coffee)
+	}
+	if err := categories.Create(ctx, &models.Category{Name: "coffee"}); !errors.Is(err, repository.ErrCategoryExists) {
+		t.Errorf("duplicate Create = %v, want ErrCategoryExists", err)
+	}
+	if err := categories.Create(ctx, &models.Category{Name: "Bad", Color: "red"}); !errors.Is(err, repository.ErrInvalidCategory) {
+		t.Errorf("Create with bad color = %v, want ErrInvalidCategory", err)
+	}
+
+	// Expenses refer to categories by ID or by name, ignoring case
+	latte := newExpense("2024-03-15", "COFFEE", "Latte", 550)
+	if err := expenses.Create(ctx, &latte); err != nil {
+		t.Fatalf("Create expense: %v", err)
+	}
+	if latte.CategoryID != coffee.ID || latte.Category != "Coffee" {
+		t.Errorf("expense category = %d %q, want %d Coffee", latte.CategoryID, latte.Category, coffee.ID)
+	}
+	unknown := newExpense("2024-03-15", "Nope", "Unknown", 100)
+	if err := expenses.Create(ctx, &unknown); !errors.Is(err, repository.ErrCategoryNotFound) {
+		t.Errorf("Create with unknown category = %v, want ErrCategoryNotFound", err)
+	}
+	mismatch := newExpense("2024-03-15", "Shopping", "Mismatch", 100)
+	mismatch.CategoryID = coffee.ID
+	if err := expenses.Create(ctx, &mismatch); !errors.Is(err, repository.ErrInvalidCategory) {
+		t.Errorf("Create with mismatched category = %v, want ErrInvalidCategory", err)
+	}
+
+	// Renames show up on existing expenses
+	coffee.Name = "Coffee & Tea"
+	if err := categories.Update(ctx, coffee.ID, &coffee); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, _ := expenses.GetByID(ctx, latte.ID); got == nil || got.Category != "Coffee & Tea" {
+		t.Errorf("expense after rename = %+v", got)
+	}
+	stats, err := expenses.GetStats(ctx, models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-03-31", Category: "Coffee & Tea"})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if total := stats["total"].(models.Money); total != 550 {
+		t.Errorf("stats total for renamed category = %s, want 5.50", total)
+	}
+
+	// Categories in use can be archived but not deleted
+	if err := categories.Delete(ctx, coffee.ID); !errors.Is(err, repository.ErrCategoryInUse) {
+		t.Errorf("Delete in use = %v, want ErrCategoryInUse", err)
+	}
+	coffee.Archived = true
+	if err := categories.Update(ctx, coffee.ID, &coffee); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	active, err := categories.GetAll(ctx, false)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	for _, c := range active {
+		if c.ID == coffee.ID {
+			t.Error("archived category listed")
+		}
+	}
+	all, _ := categories.GetAll(ctx, true)
+	if len(all) != len(active)+1 {
+		t.Errorf("GetAll(true) = %d categories, want %d", len(all), len(active)+1)
+	}
+
+	unused := models.Category{Name: "Unused"}
+	if err := categories.Create(ctx, &unused); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := categories.Delete(ctx, unused.ID); err != nil {
+		t.Errorf("Delete unused: %v", err)
+	}
+	if _, err := categories.GetByID(ctx, unused.ID); !errors.Is(err, repository.ErrCategoryNotFound) {
+		t.Errorf("GetByID after delete = %v, want ErrCategoryNotFound", err)
+	}
+
+	other, err := categories.Resolve(ctx, 0, repository.DefaultCategory)
+	if err != nil {
+		t.Fatalf("Resolve(Other): %v", err)
+	}
+	if err := categories.Delete(ctx, other.ID); !errors.Is(err, repository.ErrDefaultCategory) {
+		t.Errorf("Delete(Other) = %v, want ErrDefaultCategory", err)
+	}
+	other.Name = "Misc"
+	if err := categories.Update(ctx, other.ID, other); !errors.Is(err, repository.ErrDefaultCategory) {
+		t.Errorf("rename Other = %v, want ErrDefaultCategory", err)
+	}
+}
+
+func testCategoryHierarchy(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	categories := repository.NewCategoryRepository(db)
+	expenses := repository.NewExpenseRepository(db)
+
+	food, err := categories.Resolve(ctx, 0, "Food & Dining")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	coffee := models.Category{Name: "Coffee", ParentID: &food.ID}
+	if err := categories.Create(ctx, &coffee); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if coffee.Path != "Food & Dining > Coffee" {
+		t.Errorf("path = %q", coffee.Path)
+	}
+	espresso := models.Category{Name: "Espresso Bars", ParentID: &coffee.ID}
+	if err := categories.Create(ctx, &espresso); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// A category cannot move under itself or a descendant
+	food.ParentID = &espresso.ID
+	if err := categories.Update(ctx, food.ID, food); !errors.Is(err, repository.ErrInvalidCategory) {
+		t.Errorf("Update into own subtree = %v, want ErrInvalidCategory", err)
+	}
+	missing := 99999
+	if err := categories.Create(ctx, &models.Category{Name: "Orphan", ParentID: &missing}); !errors.Is(err, repository.ErrInvalidCategory) {
+		t.Errorf("Create with missing parent = %v, want ErrInvalidCategory", err)
+	}
+
+	// Subcategories follow their parent in the list
+	all, err := categories.GetAll(ctx, false)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	var paths []string
+	for _, c := range all {
+		if strings.HasPrefix(c.Path, "Food") {
+			paths = append(paths, c.Path)
+		}
+	}
+	want := "Food & Dining|Food & Dining > Coffee|Food & Dining > Coffee > Espresso Bars|Food Delivery"
+	if got := strings.Join(paths, "|"); got != want {
+		t.Errorf("order = %s, want %s", got, want)
+	}
+
+	mustCreate(t, expenses,
+		newExpense("2024-03-01", "Food & Dining", "Dinner", 3000),
+		newExpense("2024-03-02", "Coffee", "Latte", 550),
+		newExpense("2024-03-03", "Espresso Bars", "Ristretto", 400),
+		newExpense("2024-04-01", "Shopping", "Shoes", 8000),
+	)
+	filter := models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-04-30"}
+
+	leaves, err := expenses.GetStats(ctx, filter)
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if got := leaves["categories"].(map[string]models.Money); got["Coffee"] != 550 || got["Espresso Bars"] != 400 {
+		t.Errorf("depth 0 categories = %v", got)
+	}
+
+	filter.Depth = 1
+	top, err := expenses.GetStats(ctx, filter)
+	if err != nil {
+		t.Fatalf("GetStats depth 1: %v", err)
+	}
+	got := top["categories"].(map[string]models.Money)
+	if len(got) != 2 || got["Food & Dining"] != 3950 || got["Shopping"] != 8000 {
+		t.Errorf("depth 1 categories = %v", got)
+	}
+
+	monthly, err := expenses.GetMonthlyStats(ctx, filter)
+	if err != nil {
+		t.Fatalf("GetMonthlyStats depth 1: %v", err)
+	}
+	march := monthly["monthly"].([]map[string]interface{})[0]["categories"].(map[string]models.Money)
+	if len(march) != 1 || march["Food & Dining"] != 3950 {
+		t.Errorf("March at depth 1 = %v", march)
+	}
+
+	// Drilling into a node reports its children, with expenses filed on the
+	// node itself under its own name
+	filter.Depth = 0
+	filter.Parent = "Food & Dining"
+	drill, err := expenses.GetStats(ctx, filter)
+	if err != nil {
+		t.Fatalf("GetStats drill-down: %v", err)
+	}
+	got = drill["categories"].(map[string]models.Money)
+	if len(got) != 2 || got["Food & Dining"] != 3000 || got["Coffee"] != 950 {
+		t.Errorf("drill-down categories = %v", got)
+	}
+	if total := drill["total"].(models.Money); total != 3950 {
+		t.Errorf("drill-down total = %s, want 39.50", total)
+	}
+
+	filter.Parent = "Nope"
+	if _, err := expenses.GetStats(ctx, filter); !errors.Is(err, repository.ErrCategoryNotFound) {
+		t.Errorf("drill into unknown = %v, want ErrCategoryNotFound", err)
+	}
+
+	// Filtering by a parent includes its subcategories
+	list, _, err := expenses.GetAll(ctx, models.ExpenseFilter{Category: "Coffee"}, 1, 20)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("GetAll(Coffee) = %v, want Latte and Ristretto", descriptions(list))
+	}
+
+	if err := categories.Delete(ctx, espresso.ID); !errors.Is(err, repository.ErrCategoryInUse) {
+		t.Errorf("Delete in use = %v, want ErrCategoryInUse", err)
+	}
+	empty := models.Category{Name: "Tea", ParentID: &food.ID}
+	if err := categories.Create(ctx, &empty); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	leaf := models.Category{Name: "Green Tea", ParentID: &empty.ID}
+	if err := categories.Create(ctx, &leaf); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := categories.Delete(ctx, empty.ID); !errors.Is(err, repository.ErrCategoryHasChildren) {
+		t.Errorf("Delete with children = %v, want ErrCategoryHasChildren", err)
+	}
+}
+
+func testTags(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+
+	flight := newExpense("2024-03-01", "Transportation", "Flight", 50000)
+	flight.Tags = []string{" Trip-Japan ", "work", "trip-japan"}
+	sushi := newExpense("2024-03-02", "Food & Dining", "Sushi", 4000)
+	sushi.Tags = []string{"trip-japan"}
+	gift := newExpense("2024-03-03", "Shopping", "Gift", 2500)
+	gift.Tags = []string{"gift"}
+	plain := newExpense("2024-03-04", "Shopping", "Plain", 100)
+	created := mustCreate(t, repo, flight, sushi, gift, plain)
+
+	if got := strings.Join(created[0].Tags, ","); got != "trip-japan,work" {
+		t.Errorf("normalized tags = %q, want trip-japan,work", got)
+	}
+	if created[3].Tags == nil || len(created[3].Tags) != 0 {
+		t.Errorf("untagged expense tags = %#v, want empty list", created[3].Tags)
+	}
+	bad := newExpense("2024-03-04", "Shopping", "Bad", 100)
+	bad.Tags = []string{"a,b"}
+	if err := repo.Create(ctx, &bad); !errors.Is(err, repository.ErrInvalidTag) {
+		t.Errorf("Create with comma tag = %v, want ErrInvalidTag", err)
+	}
+
+	filtered := func(filter models.ExpenseFilter) string {
+		t.Helper()
+		list, _, err := repo.GetAll(ctx, filter, 1, 20)
+		if err != nil {
+			t.Fatalf("GetAll(%+v): %v", filter, err)
+		}
+		names := descriptions(list)
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	if got := filtered(models.ExpenseFilter{TagsAll: []string{"Trip-Japan"}}); got != "Flight,Sushi" {
+		t.Errorf("tag trip-japan = %s", got)
+	}
+	if got := filtered(models.ExpenseFilter{TagsAll: []string{"trip-japan", "work"}}); got != "Flight" {
+		t.Errorf("tags_all = %s", got)
+	}
+	if got := filtered(models.ExpenseFilter{TagsAny: []string{"work", "gift"}}); got != "Flight,Gift" {
+		t.Errorf("tags_any = %s", got)
+	}
+
+	// Updates without tags keep them; an empty list clears them
+	edit := created[1]
+	edit.Tags = nil
+	edit.Amount = 4200
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if strings.Join(edit.Tags, ",") != "trip-japan" {
+		t.Errorf("tags after update without tags = %v", edit.Tags)
+	}
+	edit.Tags = []string{}
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if len(edit.Tags) != 0 {
+		t.Errorf("tags after clearing = %v", edit.Tags)
+	}
+
+	// Bulk tagging changes every listed expense or none
+	updated, err := repo.UpdateTags(ctx, []int{created[1].ID, created[3].ID, created[0].ID}, []string{"trip-japan"}, []string{"work"})
+	if err != nil {
+		t.Fatalf("UpdateTags: %v", err)
+	}
+	if updated != 3 {
+		t.Errorf("UpdateTags updated %d, want 3", updated)
+	}
+	if got := filtered(models.ExpenseFilter{TagsAll: []string{"trip-japan"}}); got != "Flight,Plain,Sushi" {
+		t.Errorf("after bulk tagging = %s", got)
+	}
+	if _, err := repo.UpdateTags(ctx, []int{created[2].ID, 999999}, []string{"lost"}, nil); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("UpdateTags with missing expense = %v, want ErrExpenseNotFound", err)
+	}
+	if got := filtered(models.ExpenseFilter{TagsAll: []string{"lost"}}); got != "" {
+		t.Errorf("partial bulk tagging was kept: %s", got)
+	}
+	history, err := repo.GetHistory(ctx, created[3].ID)
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if fields := strings.Join(history[0].ChangedFields, ","); fields != "tags" {
+		t.Errorf("bulk tagging changed fields = %s, want tags", fields)
+	}
+
+	// An expense counts towards each of its tags
+	stats, err := repo.GetStats(ctx, models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-03-31"})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	tags := stats["tags"].(map[string]models.Money)
+	if tags["trip-japan"] != 54300 || tags["gift"] != 2500 || len(tags) != 2 {
+		t.Errorf("tag totals = %v", tags)
+	}
+	monthly, err := repo.GetMonthlyStats(ctx, models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-03-31"})
+	if err != nil {
+		t.Fatalf("GetMonthlyStats: %v", err)
+	}
+	march := monthly["monthly"].([]map[string]interface{})[0]["tags"].(map[string]models.Money)
+	if march["trip-japan"] != 54300 {
+		t.Errorf("March tag totals = %v", march)
+	}
+
+	list, err := repository.NewTagRepository(db).GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll tags: %v", err)
+	}
+	var names []string
+	for _, tag := range list {
+		names = append(names, fmt.Sprintf("%s:%d", tag.Name, tag.ExpenseCount))
+	}
+	if got := strings.Join(names, ","); got != "gift:1,trip-japan:3" {
+		t.Errorf("tags = %s, want gift:1,trip-japan:3 (unused tags removed)", got)
+	}
+}
+
+func testSplits(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+
+	receipt := newExpense("2024-03-10", "Food & Dining", "Supermarket", 5000)
+	receipt.Splits = []models.ExpenseSplit{
+		{Category: "Food & Dining", Amount: 3500, Note: " groceries "},
+		{Category: "Shopping", Amount: 1500, Note: "detergent"},
+	}
+	created := mustCreate(t, repo, receipt, newExpense("2024-03-11", "Shopping", "Lamp", 2000))
+	receipt = created[0]
+	if len(receipt.Splits) != 2 || receipt.Splits[0].Note != "groceries" || receipt.Splits[1].Category != "Shopping" {
+		t.Fatalf("splits = %+v", receipt.Splits)
+	}
+
+	// Lines must add up to the expense
+	bad := newExpense("2024-03-10", "Shopping", "Bad", 5000)
+	bad.Splits = []models.ExpenseSplit{{Category: "Shopping", Amount: 1000}, {Category: "Utilities", Amount: 1000}}
+	if err := repo.Create(ctx, &bad); !errors.Is(err, repository.ErrInvalidSplit) {
+		t.Errorf("Create with short split = %v, want ErrInvalidSplit", err)
+	}
+	edit := receipt
+	edit.Splits = nil
+	edit.Amount = 6000
+	if err := repo.Update(ctx, edit.ID, &edit, 0); !errors.Is(err, repository.ErrInvalidSplit) {
+		t.Errorf("Update amount without splits = %v, want ErrInvalidSplit", err)
+	}
+
+	// The list still shows one transaction, and finds it by any line
+	list, pagination, err := repo.GetAll(ctx, models.ExpenseFilter{Category: "Shopping"}, 1, 20)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if pagination.Total != 2 || len(list) != 2 {
+		t.Errorf("GetAll(Shopping) = %v, want Supermarket and Lamp once each", descriptions(list))
+	}
+
+	stats, err := repo.GetStats(ctx, models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-03-31"})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	categories := stats["categories"].(map[string]models.Money)
+	if categories["Food & Dining"] != 3500 || categories["Shopping"] != 3500 {
+		t.Errorf("split categories = %v", categories)
+	}
+	if total := stats["total"].(models.Money); total != 7000 {
+		t.Errorf("total = %s, want 70.00", total)
+	}
+	shopping, err := repo.GetMonthlyStats(ctx, models.StatsFilter{StartDate: "2024-03-01", EndDate: "2024-03-31", Category: "Shopping"})
+	if err != nil {
+		t.Fatalf("GetMonthlyStats: %v", err)
+	}
+	if total := shopping["total"].(models.Money); total != 3500 {
+		t.Errorf("Shopping monthly total = %s, want 35.00", total)
+	}
+
+	// Unsplitting and reverting
+	edit = receipt
+	edit.Splits = []models.ExpenseSplit{}
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if len(edit.Splits) != 0 {
+		t.Errorf("splits after clearing = %v", edit.Splits)
+	}
+	history, err := repo.GetHistory(ctx, receipt.ID)
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	reverted, err := repo.RevertToVersion(ctx, receipt.ID, history[1].Version)
+	if err != nil {
+		t.Fatalf("RevertToVersion: %v", err)
+	}
+	if len(reverted.Splits) != 2 || reverted.Splits[1].Amount != 1500 {
+		t.Errorf("splits after revert = %+v", reverted.Splits)
+	}
+}
+
+func testAccounts(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	accounts := repository.NewAccountRepository(db)
+
+	card := models.Account{Name: " Visa ", Type: models.AccountCreditCard, OpeningBalance: -10000}
+	if err := accounts.Create(ctx, &card); err != nil {
+		t.Fatalf("Create account: %v", err)
+	}
+	if card.Name != "Visa" || card.Currency != repository.DefaultBaseCurrency || card.Balance != -10000 {
+		t.Errorf("created account = %+v", card)
+	}
+	wallet := models.Account{Name: "Wallet", Type: models.AccountCash, Currency: "jpy", OpeningBalance: 500000}
+	if err := accounts.Create(ctx, &wallet); err != nil {
+		t.Fatalf("Create account: %v", err)
+	}
+	if err := accounts.Create(ctx, &models.Account{Name: "VISA", Type: models.AccountBank}); !errors.Is(err, repository.ErrAccountExists) {
+		t.Errorf("Create duplicate name = %v, want ErrAccountExists", err)
+	}
+	if err := accounts.Create(ctx, &models.Account{Name: "Loan", Type: "loan"}); !errors.Is(err, repository.ErrInvalidAccount) {
+		t.Errorf("Create with bad type = %v, want ErrInvalidAccount", err)
+	}
+
+	// Expenses name an account by ID or name and default to its currency
+	lunch := newExpense("2024-05-03", "Food & Dining", "Lunch", 1250)
+	lunch.Account = "visa"
+	taxi := newExpense("2024-05-01", "Transportation", "Taxi", 2000)
+	taxi.AccountID = &card.ID
+	ramen := newExpense("2024-05-02", "Food & Dining", "Ramen", 120000)
+	ramen.AccountID = &wallet.ID
+	loose := newExpense("2024-05-02", "Other", "Loose", 300)
+	created := mustCreate(t, repo, lunch, taxi, ramen, loose)
+	if created[0].AccountID == nil || *created[0].AccountID != card.ID || created[0].Account != "Visa" {
+		t.Errorf("expense account = %v %q", created[0].AccountID, created[0].Account)
+	}
+	if created[2].Currency != "JPY" {
+		t.Errorf("expense currency = %s, want the account's JPY", created[2].Currency)
+	}
+	if created[3].AccountID != nil {
+		t.Errorf("expense without account has account_id %d", *created[3].AccountID)
+	}
+	euro := newExpense("2024-05-02", "Other", "Euro", 300)
+	euro.Currency = "EUR"
+	euro.AccountID = &card.ID
+	if err := repo.Create(ctx, &euro); !errors.Is(err, repository.ErrInvalidAccount) {
+		t.Errorf("Create in another currency = %v, want ErrInvalidAccount", err)
+	}
+	missing := newExpense("2024-05-02", "Other", "Missing", 300)
+	missing.Account = "Nope"
+	if err := repo.Create(ctx, &missing); !errors.Is(err, repository.ErrAccountNotFound) {
+		t.Errorf("Create with unknown account = %v, want ErrAccountNotFound", err)
+	}
+
+	list, _, err := repo.GetAll(ctx, models.ExpenseFilter{AccountID: card.ID}, 1, 20)
+	if err != nil {
+		t.Fatalf("GetAll by account: %v", err)
+	}
+	if got := strings.Join(descriptions(list), ","); got != "Lunch,Taxi" {
+		t.Errorf("account filter = %s, want Lunch,Taxi", got)
+	}
+
+	// The register runs in date order from the opening balance, carrying
+	// anything before the range into it
+	register, err := accounts.GetRegister(ctx, card.ID, "", "")
+	if err != nil {
+		t.Fatalf("GetRegister: %v", err)
+	}
+	var balances []string
+	for _, entry := range register.Entries {
+		balances = append(balances, entry.Balance.String())
+	}
+	if got := strings.Join(balances, ","); got != "-120.00,-132.50" || register.ClosingBalance != -13250 {
+		t.Errorf("running balances = %s closing %s", got, register.ClosingBalance)
+	}
+	register, err = accounts.GetRegister(ctx, card.ID, "2024-05-02", "")
+	if err != nil {
+		t.Fatalf("GetRegister: %v", err)
+	}
+	if register.OpeningBalance != -12000 || len(register.Entries) != 1 {
+		t.Errorf("ranged register opening %s with %d entries", register.OpeningBalance, len(register.Entries))
+	}
+	if _, err := accounts.GetRegister(ctx, card.ID, "May", ""); !errors.Is(err, repository.ErrInvalidDateRange) {
+		t.Errorf("GetRegister with bad date = %v, want ErrInvalidDateRange", err)
+	}
+
+	// Reconciling only takes the account's own expenses
+	if _, err := accounts.Reconcile(ctx, card.ID, []int{created[1].ID, created[2].ID}, true); !errors.Is(err, repository.ErrInvalidAccount) {
+		t.Errorf("Reconcile another account's expense = %v, want ErrInvalidAccount", err)
+	}
+	n, err := accounts.Reconcile(ctx, card.ID, []int{created[1].ID, created[1].ID}, true)
+	if err != nil || n != 1 {
+		t.Fatalf("Reconcile = %d, %v", n, err)
+	}
+	got, err := accounts.GetByID(ctx, card.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.Balance != -13250 || got.ReconciledBalance != -12000 || got.ExpenseCount != 2 || got.Unreconciled != 1 {
+		t.Errorf("balances = %+v", got)
+	}
+
+	// Edits keep the account unless told otherwise; moving the expense
+	// clears the reconciled mark
+	taxiEdit := created[1]
+	taxiEdit.AccountID = nil
+	taxiEdit.Account = ""
+	taxiEdit.Description = "Cab"
+	if err := repo.Update(ctx, taxiEdit.ID, &taxiEdit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if taxiEdit.AccountID == nil || *taxiEdit.AccountID != card.ID || !taxiEdit.Reconciled {
+		t.Errorf("after update without account: account %v reconciled %v", taxiEdit.AccountID, taxiEdit.Reconciled)
+	}
+	none := 0
+	taxiEdit.AccountID = &none
+	if err := repo.Update(ctx, taxiEdit.ID, &taxiEdit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if taxiEdit.AccountID != nil || taxiEdit.Reconciled {
+		t.Errorf("after removing account: account %v reconciled %v", taxiEdit.AccountID, taxiEdit.Reconciled)
+	}
+
+	if err := accounts.Delete(ctx, card.ID); !errors.Is(err, repository.ErrAccountInUse) {
+		t.Errorf("Delete account in use = %v, want ErrAccountInUse", err)
+	}
+	wallet.Currency = "USD"
+	if err := accounts.Update(ctx, wallet.ID, &wallet); !errors.Is(err, repository.ErrAccountInUse) {
+		t.Errorf("Update currency in use = %v, want ErrAccountInUse", err)
+	}
+	empty := models.Account{Name: "Spare", Type: models.AccountEWallet}
+	if err := accounts.Create(ctx, &empty); err != nil {
+		t.Fatalf("Create account: %v", err)
+	}
+	if err := accounts.Delete(ctx, empty.ID); err != nil {
+		t.Errorf("Delete unused account: %v", err)
+	}
+	if _, err := accounts.GetByID(ctx, empty.ID); !errors.Is(err, repository.ErrAccountNotFound) {
+		t.Errorf("GetByID after delete = %v, want ErrAccountNotFound", err)
+	}
+}
+
+func testTransactionTypes(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	accounts := repository.NewAccountRepository(db)
+
+	bank := models.Account{Name: "Bank", Type: models.AccountBank, OpeningBalance: 100000}
+	card := models.Account{Name: "Card", Type: models.AccountCreditCard}
+	for _, a := range []*models.Account{&bank, &card} {
+		if err := accounts.Create(ctx, a); err != nil {
+			t.Fatalf("Create account: %v", err)
+		}
+	}
+
+	salary := newExpense("2024-01-31", "Other", "Salary", 300000)
+	salary.Type = models.TransactionIncome
+	salary.AccountID = &bank.ID
+	shoes := newExpense("2024-01-10", "Shopping", "Shoes", 8000)
+	shoes.AccountID = &card.ID
+	refund := newExpense("2024-02-02", "Shopping", "Refund", -2000)
+	refund.AccountID = &card.ID
+	payment := models.Expense{Type: " Transfer ", Date: day("2024-02-05"), Description: "Card payment",
+		Amount: 6000, AccountID: &bank.ID, TransferAccount: "card"}
+	created := mustCreate(t, repo, salary, shoes, refund, payment)
+
+	if created[1].Type != models.TransactionExpense {
+		t.Errorf("default type = %q, want expense", created[1].Type)
+	}
+	if created[3].Type != models.TransactionTransfer || created[3].Category != repository.DefaultCategory ||
+		created[3].TransferAccountID == nil || *created[3].TransferAccountID != card.ID {
+		t.Errorf("transfer = %+v", created[3])
+	}
+
+	invalid := []struct {
+		name    string
+		expense models.Expense
+	}{
+		{"unknown type", models.Expense{Type: "gift", Date: day("2024-02-05"), Category: "Other", Description: "x", Amount: 1}},
+		{"transfer without destination", models.Expense{Type: "transfer", Date: day("2024-02-05"), Description: "x", Amount: 1, AccountID: &bank.ID}},
+		{"transfer to itself", models.Expense{Type: "transfer", Date: day("2024-02-05"), Description: "x", Amount: 1, AccountID: &bank.ID, TransferAccountID: &bank.ID}},
+		{"destination on an expense", models.Expense{Date: day("2024-02-05"), Category: "Other", Description: "x", Amount: 1, TransferAccountID: &card.ID}},
+	}
+	for _, tt := range invalid {
+		if err := repo.Create(ctx, &tt.expense); !errors.Is(err, repository.ErrInvalidTransaction) {
+			t.Errorf("Create %s = %v, want ErrInvalidTransaction", tt.name, err)
+		}
+	}
+
+	// Spending stats leave out income and transfers; refunds still count
+	stats, err := repo.GetStats(ctx, models.StatsFilter{})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if total := stats["total"].(models.Money); total != 6000 {
+		t.Errorf("spending total = %s, want 60.00", total)
+	}
+	stats, err = repo.GetStats(ctx, models.StatsFilter{Type: "income"})
+	if err != nil {
+		t.Fatalf("GetStats income: %v", err)
+	}
+	if total := stats["total"].(models.Money); total != 300000 {
+		t.Errorf("income total = %s, want 3000.00", total)
+	}
+	if _, err := repo.GetStats(ctx, models.StatsFilter{Type: "gift"}); !errors.Is(err, repository.ErrInvalidTransaction) {
+		t.Errorf("GetStats bad type = %v, want ErrInvalidTransaction", err)
+	}
+
+	flow, err := repo.GetCashFlow(ctx, models.StatsFilter{})
+	if err != nil {
+		t.Fatalf("GetCashFlow: %v", err)
+	}
+	monthly, err := json.Marshal(flow["monthly"])
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `[{"month":"2024-01","income":3000.00,"spending":80.00,"net":2920.00},{"month":"2024-02","income":0.00,"spending":-20.00,"net":20.00}]`
+	if string(monthly) != want {
+		t.Errorf("cash flow = %s, want %s", monthly, want)
+	}
+	if net := flow["net"].(models.Money); net != 294000 {
+		t.Errorf("net = %s, want 2940.00", net)
+	}
+
+	// Transfers move money between the two accounts
+	for _, tt := range []struct {
+		id   int
+		want models.Money
+	}{{bank.ID, 100000 + 300000 - 6000}, {card.ID, -8000 + 2000 + 6000}} {
+		got, err := accounts.GetByID(ctx, tt.id)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.Balance != tt.want {
+			t.Errorf("%s balance = %s, want %s", got.Name, got.Balance, tt.want)
+		}
+	}
+	list, _, err := repo.GetAll(ctx, models.ExpenseFilter{AccountID: card.ID, Type: "transfer"}, 1, 20)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if got := strings.Join(descriptions(list), ","); got != "Card payment" {
+		t.Errorf("transfers into card = %s", got)
+	}
+
+	// Updates keep the type; changing it away from transfer drops the
+	// destination
+	edit := created[3]
+	edit.Type = ""
+	edit.TransferAccountID = nil
+	edit.TransferAccount = ""
+	edit.Amount = 7000
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if edit.Type != models.TransactionTransfer || edit.TransferAccountID == nil {
+		t.Errorf("after update without type: %q to %v", edit.Type, edit.TransferAccountID)
+	}
+	edit.Type = models.TransactionExpense
+	edit.TransferAccountID = nil
+	edit.TransferAccount = ""
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if edit.Type != models.TransactionExpense || edit.TransferAccountID != nil {
+		t.Errorf("after changing to expense: %q to %v", edit.Type, edit.TransferAccountID)
+	}
+	reverted, err := repo.RevertToVersion(ctx, edit.ID, 1)
+	if err != nil {
+		t.Fatalf("RevertToVersion: %v", err)
+	}
+	if reverted.Type != models.TransactionTransfer || reverted.TransferAccountID == nil || reverted.Amount != 6000 {
+		t.Errorf("reverted = %+v", reverted)
+	}
+}
+
+func testAttachments(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	dir := t.TempDir()
+	files := attachments.NewManager(db, dir, 64)
+
+	created := mustCreate(t, repo,
+		newExpense("2024-03-01", "Food & Dining", "Lunch", 1250),
+		newExpense("2024-03-02", "Food & Dining", "Dinner", 2000),
+	)
+	lunch, dinner := created[0].ID, created[1].ID
+	receipt := "%PDF-1.4\nreceipt\n"
+
+	first, err := files.Add(ctx, lunch, `C:\scans\lunch.pdf`, strings.NewReader(receipt))
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if first.Filename != "lunch.pdf" || first.ContentType != "application/pdf" || first.Size != int64(len(receipt)) {
+		t.Errorf("Add = %+v", first)
+	}
+	// The same content on another expense is stored once
+	second, err := files.Add(ctx, dinner, "copy.pdf", strings.NewReader(receipt))
+	if err != nil {
+		t.Fatalf("Add copy: %v", err)
+	}
+	if second.SHA256 != first.SHA256 {
+		t.Errorf("digests differ: %s and %s", first.SHA256, second.SHA256)
+	}
+	if _, err := os.Stat(files.Path(first)); err != nil {
+		t.Errorf("stored file: %v", err)
+	}
+
+	rejected := []struct {
+		name    string
+		content string
+		want    error
+	}{
+		{"empty", "", attachments.ErrEmpty},
+		{"text", "just some notes", attachments.ErrUnsupportedType},
+		{"too large", "%PDF-1.4\n" + strings.Repeat("x", 64), attachments.ErrTooLarge},
+	}
+	for _, tt := range rejected {
+		if _, err := files.Add(ctx, lunch, tt.name, strings.NewReader(tt.content)); !errors.Is(err, tt.want) {
+			t.Errorf("Add %s = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := files.Add(ctx, 999, "x.pdf", strings.NewReader(receipt)); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("Add to missing expense = %v, want ErrExpenseNotFound", err)
+	}
+
+	list, err := files.List(ctx, lunch)
+	if err != nil || len(list) != 1 || list[0].ID != first.ID {
+		t.Errorf("List = %+v, %v", list, err)
+	}
+	got, err := repo.GetByID(ctx, lunch)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.Attachments != 1 {
+		t.Errorf("attachment count = %d, want 1", got.Attachments)
+	}
+
+	// Deleting one of two attachments with the same content keeps the file
+	if err := files.Delete(ctx, first.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(files.Path(second)); err != nil {
+		t.Errorf("shared file removed with one attachment: %v", err)
+	}
+	if err := files.Delete(ctx, first.ID); !errors.Is(err, repository.ErrAttachmentNotFound) {
+		t.Errorf("Delete again = %v, want ErrAttachmentNotFound", err)
+	}
+
+	// Trashed expenses keep their attachments until purged
+	if err := repo.Delete(ctx, dinner, 0); err != nil {
+		t.Fatalf("Delete expense: %v", err)
+	}
+	if removed, err := files.Sweep(ctx); err != nil || removed != 0 {
+		t.Errorf("Sweep with the expense in the trash = %d, %v; want 0", removed, err)
+	}
+	if err := repo.Restore(ctx, dinner); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if list, err := files.List(ctx, dinner); err != nil || len(list) != 1 {
+		t.Errorf("List after restore = %+v, %v", list, err)
+	}
+
+	// A stray file left in the directory is swept along with purged ones
+	stray := filepath.Join(dir, "ab", strings.Repeat("ab", 32))
+	if err := os.MkdirAll(filepath.Dir(stray), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stray, []byte("orphan"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, dinner, 0); err != nil {
+		t.Fatalf("Delete expense: %v", err)
+	}
+	if err := repo.Purge(ctx, dinner); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if removed, err := files.Sweep(ctx); err != nil || removed != 2 {
+		t.Errorf("Sweep after purge = %d, %v; want 2", removed, err)
+	}
+	for _, path := range []string{files.Path(second), stray} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after sweep: %v", path, err)
+		}
+	}
+	if _, err := files.Get(ctx, second.ID); !errors.Is(err, repository.ErrAttachmentNotFound) {
+		t.Errorf("Get after purge = %v, want ErrAttachmentNotFound", err)
+	}
+}
+
+func testCustomFields(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	fields := repository.NewCustomFieldRepository(db)
+
+	project := models.CustomField{Name: " Project ", Label: "Project code", Type: models.FieldText}
+	hours := models.CustomField{Name: "hours", Type: models.FieldNumber}
+	client := models.CustomField{Name: "client", Type: models.FieldEnum, Options: []string{"Acme", " Globex ", "acme", ""}}
+	due := models.CustomField{Name: "due", Type: models.FieldDate}
+	for _, f := range []*models.CustomField{&project, &hours, &client, &due} {
+		if err := fields.Create(ctx, f); err != nil {
+			t.Fatalf("Create %s: %v", f.Name, err)
+		}
+	}
+	if project.Name != "project" || hours.Label != "hours" || strings.Join(client.Options, ",") != "Acme,Globex" {
+		t.Errorf("normalized definitions: %+v %+v %+v", project, hours, client)
+	}
+
+	invalid := []models.CustomField{
+		{Name: "2fast", Type: models.FieldText},
+		{Name: "size", Type: "blob"},
+		{Name: "tier", Type: models.FieldEnum},
+		{Name: "note", Type: models.FieldText, Options: []string{"a"}},
+	}
+	for _, f := range invalid {
+		if err := fields.Create(ctx, &f); !errors.Is(err, repository.ErrInvalidCustomField) {
+			t.Errorf("Create %+v = %v, want ErrInvalidCustomField", f, err)
+		}
+	}
+	if err := fields.Create(ctx, &models.CustomField{Name: "PROJECT", Type: models.FieldText}); !errors.Is(err, repository.ErrCustomFieldExists) {
+		t.Errorf("Create duplicate = %v, want ErrCustomFieldExists", err)
+	}
+
+	lunch := newExpense("2024-03-01", "Food & Dining", "Lunch", 1250)
+	lunch.Fields = models.FieldValues{"Project": " P-1 ", "hours": "1.50", "client": "ACME", "due": "2024-04-01"}
+	dinner := newExpense("2024-03-02", "Food & Dining", "Dinner", 2000)
+	dinner.Fields = models.FieldValues{"project": "P-2", "client": "globex", "hours": ""}
+	snack := newExpense("2024-03-03", "Food & Dining", "Snack", 500)
+	created := mustCreate(t, repo, lunch, dinner, snack)
+
+	want := models.FieldValues{"project": "P-1", "hours": "1.5", "client": "Acme", "due": "2024-04-01"}
+	if fmt.Sprint(created[0].Fields) != fmt.Sprint(want) {
+		t.Errorf("stored fields = %v, want %v", created[0].Fields, want)
+	}
+	if len(created[2].Fields) != 0 {
+		t.Errorf("expense without fields = %v", created[2].Fields)
+	}
+
+	for _, values := range []models.FieldValues{
+		{"hours": "lots"},
+		{"due": "01/04/2024"},
+		{"client": "Initech"},
+		{"unknown": "x"},
+	} {
+		bad := newExpense("2024-03-04", "Food & Dining", "Bad", 100)
+		bad.Fields = values
+		if err := repo.Create(ctx, &bad); !errors.Is(err, repository.ErrInvalidCustomField) {
+			t.Errorf("Create with %v = %v, want ErrInvalidCustomField", values, err)
+		}
+	}
+
+	// Filters normalize the value like a stored one; empty finds unset
+	for _, tt := range []struct {
+		filter map[string]string
+		want   string
+	}{
+		{map[string]string{"hours": "1.5000"}, "Lunch"},
+		{map[string]string{"client": "globex"}, "Dinner"},
+		{map[string]string{"client": ""}, "Snack"},
+		{map[string]string{"project": "P-2", "client": "Globex"}, "Dinner"},
+	} {
+		list, _, err := repo.GetAll(ctx, models.ExpenseFilter{Fields: tt.filter}, 1, 20)
+		if err != nil {
+			t.Fatalf("GetAll %v: %v", tt.filter, err)
+		}
+		if got := strings.Join(descriptions(list), ","); got != tt.want {
+			t.Errorf("GetAll %v = %s, want %s", tt.filter, got, tt.want)
+		}
+	}
+	if _, _, err := repo.GetAll(ctx, models.ExpenseFilter{Fields: map[string]string{"nope": "1"}}, 1, 20); !errors.Is(err, repository.ErrInvalidCustomField) {
+		t.Errorf("GetAll unknown field = %v, want ErrInvalidCustomField", err)
+	}
+
+	stats, err := repo.GetStats(ctx, models.StatsFilter{GroupBy: "client"})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	groups, _ := json.Marshal(stats["groups"])
+	if stats["group_by"] != "client" || string(groups) != `{"(none)":5.00,"Acme":12.50,"Globex":20.00}` {
+		t.Errorf("grouped by client: %v %s", stats["group_by"], groups)
+	}
+	monthly, err := repo.GetMonthlyStats(ctx, models.StatsFilter{GroupBy: "project", Fields: map[string]string{"client": "acme"}})
+	if err != nil {
+		t.Fatalf("GetMonthlyStats: %v", err)
+	}
+	if got := fmt.Sprint(monthly["groups"]); got != "[P-1]" {
+		t.Errorf("monthly groups = %s, want [P-1]", got)
+	}
+
+	// Updates keep values left out and replace them when given
+	edit := created[0]
+	edit.Fields = nil
+	edit.Amount = 1300
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if edit.Fields["project"] != "P-1" {
+		t.Errorf("fields after update without them = %v", edit.Fields)
+	}
+	edit.Fields = models.FieldValues{"project": "P-9"}
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if fmt.Sprint(edit.Fields) != "map[project:P-9]" {
+		t.Errorf("fields after replacing = %v", edit.Fields)
+	}
+
+	// Definitions cannot change in ways that would invalidate stored values
+	client.Options = []string{"ACME"}
+	if err := fields.Update(ctx, client.ID, &client); !errors.Is(err, repository.ErrCustomFieldInUse) {
+		t.Errorf("removing a used option = %v, want ErrCustomFieldInUse", err)
+	}
+	client.Options = []string{"GLOBEX", "Initech"}
+	if err := fields.Update(ctx, client.ID, &client); err != nil {
+		t.Fatalf("respelling an option: %v", err)
+	}
+	if got, _ := repo.GetByID(ctx, created[1].ID); got.Fields["client"] != "GLOBEX" {
+		t.Errorf("value after respelling = %q", got.Fields["client"])
+	}
+	hours.Type = models.FieldText
+	if err := fields.Update(ctx, hours.ID, &hours); err != nil {
+		t.Errorf("changing the type of an unused field: %v", err)
+	}
+	project.Type = models.FieldNumber
+	if err := fields.Update(ctx, project.ID, &project); !errors.Is(err, repository.ErrCustomFieldInUse) {
+		t.Errorf("changing the type of a used field = %v, want ErrCustomFieldInUse", err)
+	}
+
+	// Required fields apply to new expenses and to updates that set fields,
+	// but not to reverts
+	costCenter := models.CustomField{Name: "cost_center", Type: models.FieldText, Required: true}
+	if err := fields.Create(ctx, &costCenter); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	missing := newExpense("2024-03-05", "Food & Dining", "Missing", 100)
+	if err := repo.Create(ctx, &missing); !errors.Is(err, repository.ErrInvalidCustomField) {
+		t.Errorf("Create without a required field = %v, want ErrInvalidCustomField", err)
+	}
+	edit.Fields = models.FieldValues{"project": "P-10"}
+	if err := repo.Update(ctx, edit.ID, &edit, 0); !errors.Is(err, repository.ErrInvalidCustomField) {
+		t.Errorf("Update without a required field = %v, want ErrInvalidCustomField", err)
+	}
+	// The snapshot's client is no longer an option, so it cannot be restored
+	if _, err := repo.RevertToVersion(ctx, edit.ID, 1); !errors.Is(err, repository.ErrInvalidCustomField) {
+		t.Errorf("RevertToVersion to a removed option = %v, want ErrInvalidCustomField", err)
+	}
+	client.Options = append(client.Options, "Acme")
+	if err := fields.Update(ctx, client.ID, &client); err != nil {
+		t.Fatalf("adding an option: %v", err)
+	}
+	if _, err := repo.RevertToVersion(ctx, edit.ID, 1); err != nil {
+		t.Errorf("RevertToVersion: %v", err)
+	}
+
+	// Deleting a field removes its values, and reverts skip them
+	if err := fields.Delete(ctx, project.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	reverted, err := repo.RevertToVersion(ctx, edit.ID, 1)
+	if err != nil {
+		t.Fatalf("RevertToVersion after deleting a field: %v", err)
+	}
+	if _, ok := reverted.Fields["project"]; ok || reverted.Fields["client"] != "Acme" {
+		t.Errorf("reverted fields = %v", reverted.Fields)
+	}
+	if err := fields.Delete(ctx, project.ID); !errors.Is(err, repository.ErrCustomFieldNotFound) {
+		t.Errorf("Delete again = %v, want ErrCustomFieldNotFound", err)
+	}
+}
+
+func testMerchants(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	merchants := repository.NewMerchantRepository(db)
+
+	grab := models.Merchant{Name: " Grab ", Aliases: []string{"GRAB*RIDE*", "grab*ride*", "GRAB  SG", "grab"}}
+	food := models.Merchant{Name: "GrabFood", Aliases: []string{"GRAB**FOOD*"}}
+	for _, m := range []*models.Merchant{&grab, &food} {
+		if err := merchants.Create(ctx, m); err != nil {
+			t.Fatalf("Create %s: %v", m.Name, err)
+		}
+	}
+	if grab.Name != "Grab" || strings.Join(grab.Aliases, ",") != "GRAB SG,GRAB*RIDE*" {
+		t.Errorf("normalized merchant: %q %v", grab.Name, grab.Aliases)
+	}
+	if strings.Join(food.Aliases, ",") != "GRAB*FOOD*" {
+		t.Errorf("normalized aliases: %v", food.Aliases)
+	}
+
+	for _, tt := range []struct {
+		merchant models.Merchant
+		want     error
+	}{
+		{models.Merchant{Name: " "}, repository.ErrInvalidMerchant},
+		{models.Merchant{Name: "Any", Aliases: []string{"* *"}}, repository.ErrInvalidMerchant},
+		{models.Merchant{Name: "Grab*"}, repository.ErrInvalidMerchant},
+		{models.Merchant{Name: "GRAB"}, repository.ErrMerchantExists},
+		{models.Merchant{Name: "grab sg"}, repository.ErrMerchantExists},
+		{models.Merchant{Name: "Taxi", Aliases: []string{"grab*ride*"}}, repository.ErrAliasExists},
+		{models.Merchant{Name: "Taxi", Aliases: []string{"grabfood"}}, repository.ErrAliasExists},
+	} {
+		if err := merchants.Create(ctx, &tt.merchant); !errors.Is(err, tt.want) {
+			t.Errorf("Create %+v = %v, want %v", tt.merchant, err, tt.want)
+		}
+	}
+
+	directory, err := merchants.Directory(ctx)
+	if err != nil {
+		t.Fatalf("Directory: %v", err)
+	}
+	// The most specific alias wins, and equally specific ones go to the
+	// first merchant by name
+	for raw, want := range map[string]string{
+		"GRAB*RIDE 1234":     "Grab",
+		"grab  sg":           "Grab",
+		"GRAB":               "Grab",
+		"grabfood":           "GrabFood",
+		"GRAB*FOOD SG":       "GrabFood",
+		"Grab Ride Food 12":  "Grab",
+		"Starbucks":          "",
+		"":                   "",
+		"Taxi GRAB*RIDE 123": "",
+	} {
+		if got, _ := directory.Match(raw); got != want {
+			t.Errorf("Match(%q) = %q, want %q", raw, got, want)
+		}
+	}
+
+	// The description stands in for a missing vendor
+	ride := newExpense("2024-03-01", "Food & Dining", "GRAB SG", 500)
+	ride.Vendor = ""
+	if !directory.Apply(&ride) || ride.Vendor != "Grab" {
+		t.Errorf("Apply without a vendor = %q", ride.Vendor)
+	}
+	coffee := newExpense("2024-03-01", "Food & Dining", "GRAB SG", 500)
+	coffee.Vendor = "Starbucks"
+	if directory.Apply(&coffee) || coffee.Vendor != "Starbucks" {
+		t.Errorf("Apply with an unknown vendor = %q", coffee.Vendor)
+	}
+
+	raw := newExpense("2024-03-02", "Food & Dining", "Ride", 1200)
+	raw.Vendor = "GRAB*RIDE 1234"
+	lunch := newExpense("2024-03-03", "Food & Dining", "Lunch", 800)
+	lunch.Vendor = "GrabFood"
+	created := mustCreate(t, repo, raw, lunch)
+
+	// Renaming a merchant renames its expenses
+	food.Name = "Grab Food"
+	food.Aliases = nil
+	if err := merchants.Update(ctx, food.ID, &food); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if strings.Join(food.Aliases, ",") != "GRAB*FOOD*" || food.Expenses != 1 {
+		t.Errorf("updated merchant: %+v", food)
+	}
+	if got, _ := repo.GetByID(ctx, created[1].ID); got.Vendor != "Grab Food" {
+		t.Errorf("vendor after rename = %q", got.Vendor)
+	}
+
+	updated, err := repo.SetVendors(ctx, map[int]string{created[0].ID: "Grab", created[1].ID: "Grab Food", 99999: "Grab"}, models.SourceMerchants)
+	if err != nil || updated != 1 {
+		t.Errorf("SetVendors = %d, %v; want 1", updated, err)
+	}
+	history, err := repo.GetHistory(ctx, created[0].ID)
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if history[0].Source != models.SourceMerchants {
+		t.Errorf("history source = %q", history[0].Source)
+	}
+
+	// Expenses only the source's aliases match, never reprocessed since
+	// they were saved, move to the target too
+	aliasOnly := newExpense("2024-03-04", "Food & Dining", "Dinner", 1500)
+	aliasOnly.Vendor = "GRAB*FOOD SG 77"
+	byDescription := newExpense("2024-03-05", "Food & Dining", "GRAB*FOOD 42", 900)
+	byDescription.Vendor = ""
+	unrelated := newExpense("2024-03-06", "Food & Dining", "Coffee", 450)
+	unrelated.Vendor = "GRAB COFFEE"
+	pending := mustCreate(t, repo, aliasOnly, byDescription, unrelated)
+
+	// Merging moves aliases and expenses to the target
+	if _, _, err := merchants.Merge(ctx, food.ID, food.ID); !errors.Is(err, repository.ErrInvalidMerchant) {
+		t.Errorf("Merge into itself = %v, want ErrInvalidMerchant", err)
+	}
+	merged, renamed, err := merchants.Merge(ctx, food.ID, grab.ID)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	for i, want := range []string{"Grab", "Grab", "GRAB COFFEE"} {
+		if got, _ := repo.GetByID(ctx, pending[i].ID); got.Vendor != want {
+			t.Errorf("vendor of %q after merge = %q, want %q", pending[i].Description, got.Vendor, want)
+		}
+	}
+	if renamed != 3 || merged.Expenses != 4 || strings.Join(merged.Aliases, ",") != "Grab Food,GRAB SG,GRAB*FOOD*,GRAB*RIDE*" {
+		t.Errorf("Merge = %+v, %d", merged, renamed)
+	}
+	if _, err := merchants.GetByID(ctx, food.ID); !errors.Is(err, repository.ErrMerchantNotFound) {
+		t.Errorf("GetByID merged = %v, want ErrMerchantNotFound", err)
+	}
+
+	if err := merchants.Delete(ctx, grab.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, _ := repo.GetByID(ctx, created[1].ID); got.Vendor != "Grab" {
+		t.Errorf("vendor after deleting the merchant = %q", got.Vendor)
+	}
+	list, err := merchants.GetAll(ctx)
+	if err != nil || len(list) != 0 {
+		t.Errorf("GetAll after delete = %v, %v", list, err)
+	}
+}
+
+func testClaims(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	claims := repository.NewClaimRepository(db)
+	yes, no := true, false
+
+	lunch := newExpense("2024-03-01", "Food & Dining", "Client lunch", 4000)
+	lunch.Reimbursable = &yes
+	taxi := newExpense("2024-03-02", "Transportation", "Taxi", 1550)
+	taxi.Reimbursable = &yes
+	hotel := newExpense("2024-03-02", "Shopping", "Hotel", 9000)
+	hotel.Reimbursable = &yes
+	hotel.Currency = "EUR"
+	dinner := newExpense("2024-03-03", "Food & Dining", "Own dinner", 2000)
+	created := mustCreate(t, repo, lunch, taxi, hotel, dinner)
+	if created[3].Reimbursable == nil || *created[3].Reimbursable {
+		t.Errorf("reimbursable by default = %v", created[3].Reimbursable)
+	}
+
+	salary := newExpense("2024-03-04", "Other", "Salary", 100000)
+	salary.Type = models.TransactionIncome
+	salary.Reimbursable = &yes
+	if err := repo.Create(ctx, &salary); !errors.Is(err, repository.ErrInvalidTransaction) {
+		t.Errorf("Create reimbursable income = %v, want ErrInvalidTransaction", err)
+	}
+
+	claim := models.Claim{Name: " March trip ", Status: models.ClaimPaid}
+	if err := claims.Create(ctx, &claim); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if claim.Name != "March trip" || claim.Status != models.ClaimDraft {
+		t.Errorf("created claim: %+v", claim)
+	}
+	if err := claims.Create(ctx, &models.Claim{Name: " "}); !errors.Is(err, repository.ErrInvalidClaim) {
+		t.Errorf("Create without a name = %v, want ErrInvalidClaim", err)
+	}
+	if _, err := claims.SetStatus(ctx, claim.ID, models.ClaimSubmitted); !errors.Is(err, repository.ErrInvalidClaim) {
+		t.Errorf("submitting an empty claim = %v, want ErrInvalidClaim", err)
+	}
+
+	// Adding is all or nothing
+	if _, err := claims.AddExpenses(ctx, claim.ID, []int{created[0].ID, created[3].ID}); !errors.Is(err, repository.ErrInvalidClaim) {
+		t.Errorf("AddExpenses with a personal expense = %v, want ErrInvalidClaim", err)
+	}
+	n, err := claims.AddExpenses(ctx, claim.ID, []int{created[0].ID, created[1].ID, created[2].ID, created[1].ID})
+	if err != nil || n != 3 {
+		t.Fatalf("AddExpenses = %d, %v; want 3", n, err)
+	}
+	other := models.Claim{Name: "Other"}
+	if err := claims.Create(ctx, &other); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := claims.AddExpenses(ctx, other.ID, []int{created[0].ID}); !errors.Is(err, repository.ErrInvalidClaim) {
+		t.Errorf("AddExpenses on another claim = %v, want ErrInvalidClaim", err)
+	}
+
+	got, err := claims.GetByID(ctx, claim.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.Expenses != 3 || fmt.Sprint(got.Totals) != "map[EUR:90.00 USD:55.50]" {
+		t.Errorf("claim totals: %d %v", got.Expenses, got.Totals)
+	}
+	list, _, err := repo.GetAll(ctx, models.ExpenseFilter{Reimbursable: &yes, Unclaimed: true}, 1, 20)
+	if err != nil || len(list) != 0 {
+		t.Errorf("unclaimed reimbursable = %v, %v", descriptions(list), err)
+	}
+	list, _, err = repo.GetAll(ctx, models.ExpenseFilter{ClaimID: claim.ID}, 1, 20)
+	if err != nil || len(list) != 3 {
+		t.Errorf("claim's expenses = %v, %v", descriptions(list), err)
+	}
+
+	// Dropping the flag takes an expense off a draft claim
+	edit := created[2]
+	edit.Reimbursable = &no
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if edit.ClaimID != nil {
+		t.Errorf("claim after dropping the flag = %v", *edit.ClaimID)
+	}
+
+	if _, err := claims.SetStatus(ctx, claim.ID, models.ClaimPaid); !errors.Is(err, repository.ErrInvalidClaim) {
+		t.Errorf("paying a draft = %v, want ErrInvalidClaim", err)
+	}
+	submitted, err := claims.SetStatus(ctx, claim.ID, models.ClaimSubmitted)
+	if err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	if submitted.SubmittedAt == nil || submitted.PaidAt != nil {
+		t.Errorf("submitted claim: %+v", submitted)
+	}
+
+	// A submitted claim is locked, but details outside it can change
+	if _, err := claims.RemoveExpenses(ctx, claim.ID, []int{created[0].ID}); !errors.Is(err, repository.ErrClaimLocked) {
+		t.Errorf("RemoveExpenses from a submitted claim = %v, want ErrClaimLocked", err)
+	}
+	edit = created[0]
+	edit.Amount = 4500
+	if err := repo.Update(ctx, edit.ID, &edit, 0); !errors.Is(err, repository.ErrClaimLocked) {
+		t.Errorf("changing a claimed amount = %v, want ErrClaimLocked", err)
+	}
+	edit = created[0]
+	edit.Description = "Client lunch with Acme"
+	edit.Reimbursable = nil
+	if err := repo.Update(ctx, edit.ID, &edit, 0); err != nil {
+		t.Errorf("changing a claimed description: %v", err)
+	}
+	if err := repo.Delete(ctx, created[1].ID, 0); !errors.Is(err, repository.ErrClaimLocked) {
+		t.Errorf("Delete a claimed expense = %v, want ErrClaimLocked", err)
+	}
+	if err := claims.Delete(ctx, claim.ID); !errors.Is(err, repository.ErrClaimLocked) {
+		t.Errorf("Delete a submitted claim = %v, want ErrClaimLocked", err)
+	}
+
+	// Stats can leave out what was paid back, or everything reimbursable.
+	// The hotel is left out of totals for want of a EUR rate.
+	for _, tt := range []struct {
+		filter models.StatsFilter
+		want   string
+	}{
+		{models.StatsFilter{EndDate: "2024-03-31"}, "75.50"},
+		{models.StatsFilter{EndDate: "2024-03-31", ExcludeReimbursed: true}, "75.50"},
+		{models.StatsFilter{EndDate: "2024-03-31", ExcludeReimbursable: true}, "20.00"},
+	} {
+		stats, err := repo.GetStats(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("GetStats: %v", err)
+		}
+		if got := stats["total"].(models.Money).String(); got != tt.want {
+			t.Errorf("GetStats %+v total = %s, want %s", tt.filter, got, tt.want)
+		}
+	}
+	paid, err := claims.SetStatus(ctx, claim.ID, models.ClaimPaid)
+	if err != nil || paid.PaidAt == nil {
+		t.Fatalf("SetStatus paid = %+v, %v", paid, err)
+	}
+	stats, err := repo.GetStats(ctx, models.StatsFilter{EndDate: "2024-03-31", ExcludeReimbursed: true})
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	if got := stats["total"].(models.Money).String(); got != "20.00" {
+		t.Errorf("total without reimbursed = %s, want 20.00", got)
+	}
+
+	// Reopening goes back one step at a time
+	if _, err := claims.SetStatus(ctx, claim.ID, models.ClaimDraft); !errors.Is(err, repository.ErrInvalidClaim) {
+		t.Errorf("reopening a paid claim = %v, want ErrInvalidClaim", err)
+	}
+	for _, status := range []string{models.ClaimSubmitted, models.ClaimDraft} {
+		if _, err := claims.SetStatus(ctx, claim.ID, status); err != nil {
+			t.Fatalf("SetStatus %s: %v", status, err)
+		}
+	}
+	if err := repo.Delete(ctx, created[1].ID, 0); err != nil {
+		t.Fatalf("Delete from a draft claim: %v", err)
+	}
+	if err := repo.Restore(ctx, created[1].ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restored, _ := repo.GetByID(ctx, created[1].ID); restored.ClaimID != nil {
+		t.Errorf("restored expense is back on claim %d", *restored.ClaimID)
+	}
+
+	if err := claims.Delete(ctx, claim.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if kept, _ := repo.GetByID(ctx, created[0].ID); kept.ClaimID != nil || !*kept.Reimbursable {
+		t.Errorf("expense after deleting its claim: claim %v, reimbursable %v", kept.ClaimID, *kept.Reimbursable)
+	}
+	if _, err := claims.GetByID(ctx, claim.ID); !errors.Is(err, repository.ErrClaimNotFound) {
+		t.Errorf("GetByID deleted = %v, want ErrClaimNotFound", err)
+	}
+	if _, err := claims.GetAll(ctx, "pending"); !errors.Is(err, repository.ErrInvalidClaim) {
+		t.Errorf("GetAll unknown status = %v, want ErrInvalidClaim", err)
+	}
+}
+
+func testFiltersAndSorting(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	coffee := newExpense("2024-05-01", "Food & Dining", "coffee", 450)
+	coffee.Vendor = "Blue Bottle"
+	coffee.PaymentMethod = "Cash"
+	bus := newExpense("2024-05-01", "Transportation", "Bus", 275)
+	bus.Vendor = "City_Transit"
+	misc := newExpense("2024-05-02", "Other", "Misc", 1000)
+	shoes := newExpense("2024-05-03", "Shopping", "Shoes", 8999)
+	shoes.Vendor = "Bluefly"
+	mustCreate(t, repo, coffee, bus, misc, shoes)
+
+	list := func(filter models.ExpenseFilter) []string {
+		t.Helper()
+		expenses, pagination, err := repo.GetAll(ctx, filter, 1, 0)
+		if err != nil {
+			t.Fatalf("GetAll(%+v): %v", filter, err)
+		}
+		if pagination.Total != len(expenses) {
+			t.Errorf("GetAll(%+v) total = %d for %d expenses", filter, pagination.Total, len(expenses))
+		}
+		return descriptions(expenses)
+	}
+	money := func(cents int64) *models.Money {
+		m := models.Money(cents)
+		return &m
+	}
+	yes, no := true, false
+
+	tests := []struct {
+		name   string
+		filter models.ExpenseFilter
+		want   string
+	}{
+		{"default newest first", models.ExpenseFilter{}, "[Shoes Misc Bus coffee]"},
+		{"amount range", models.ExpenseFilter{MinAmount: money(275), MaxAmount: money(1000)}, "[Misc Bus coffee]"},
+		{"vendor ignores case", models.ExpenseFilter{Vendor: "BLUE", Sort: "id", Order: "asc"}, "[coffee Shoes]"},
+		{"vendor wildcards are literal", models.ExpenseFilter{Vendor: "y_T"}, "[Bus]"},
+		{"payment method", models.ExpenseFilter{PaymentMethod: "cash"}, "[coffee]"},
+		{"several categories", models.ExpenseFilter{Categories: []string{"Shopping", "Transportation"}}, "[Shoes Bus]"},
+		{"uncategorized", models.ExpenseFilter{Uncategorized: &yes}, "[Misc]"},
+		{"uncategorized or a category", models.ExpenseFilter{Uncategorized: &yes, Categories: []string{"Shopping"}}, "[Shoes Misc]"},
+		{"categorized only", models.ExpenseFilter{Uncategorized: &no, MaxAmount: money(1000)}, "[Bus coffee]"},
+		{"created before now", models.ExpenseFilter{CreatedBefore: time.Now().Add(-time.Hour)}, "[]"},
+		{"updated since", models.ExpenseFilter{UpdatedFrom: time.Now().Add(-time.Hour), Vendor: "blue"}, "[Shoes coffee]"},
+		{"amount ascending", models.ExpenseFilter{Sort: "amount", Order: "asc"}, "[Bus coffee Misc Shoes]"},
+		{"amount defaults to largest", models.ExpenseFilter{Sort: "amount"}, "[Shoes Misc coffee Bus]"},
+		{"description ignores case", models.ExpenseFilter{Sort: "description"}, "[Bus coffee Misc Shoes]"},
+		{"date ties break on id", models.ExpenseFilter{Sort: "date", Order: "asc"}, "[coffee Bus Misc Shoes]"},
+		{"category", models.ExpenseFilter{Sort: "category"}, "[coffee Misc Shoes Bus]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(list(tt.filter)); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// Equal dates keep the same order from page to page
+	first, _, err := repo.GetAll(ctx, models.ExpenseFilter{Sort: "date"}, 2, 1)
+	if err != nil {
+		t.Fatalf("GetAll page 2: %v", err)
+	}
+	second, _, err := repo.GetAll(ctx, models.ExpenseFilter{Sort: "date"}, 3, 1)
+	if err != nil {
+		t.Fatalf("GetAll page 3: %v", err)
+	}
+	if len(first) != 1 || len(second) != 1 || first[0].Description != "Misc" || second[0].Description != "Bus" {
+		t.Errorf("pages 2 and 3 = %v %v, want [Misc] [Bus]", descriptions(first), descriptions(second))
+	}
+
+	for _, filter := range []models.ExpenseFilter{
+		{Sort: "colour"},
+		{Sort: "date", Order: "sideways"},
+		{Sort: repository.SortRelevance},
+		{MinAmount: money(500), MaxAmount: money(100)},
+		{CreatedFrom: day("2024-02-01"), CreatedBefore: day("2024-01-01")},
+	} {
+		if _, _, err := repo.GetAll(ctx, filter, 1, 0); !errors.Is(err, repository.ErrInvalidFilter) {
+			t.Errorf("GetAll(%+v) = %v, want ErrInvalidFilter", filter, err)
+		}
+	}
+}
+
+func testCursorPagination(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	mustCreate(t, repo,
+		newExpense("2024-06-01", "Other", "A", 100),
+		newExpense("2024-06-02", "Other", "B", 100),
+		newExpense("2024-06-02", "Other", "C", 100),
+		newExpense("2024-06-02", "Other", "D", 100),
+		newExpense("2024-06-03", "Other", "E", 100),
+	)
+
+	first, pagination, err := repo.GetAll(ctx, models.ExpenseFilter{}, 1, 2)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if fmt.Sprint(descriptions(first)) != "[E D]" || pagination.NextCursor == "" || pagination.PreviousCursor != "" {
+		t.Fatalf("first page = %v, pagination %+v", descriptions(first), pagination)
+	}
+
+	// A new expense at the top doesn't shift the pages after it
+	mustCreate(t, repo, newExpense("2024-06-04", "Other", "F", 100))
+
+	second, pagination, err := repo.GetAllByCursor(ctx, models.ExpenseFilter{}, pagination.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("GetAllByCursor: %v", err)
+	}
+	if fmt.Sprint(descriptions(second)) != "[C B]" || pagination.Total != 6 || !pagination.HasNext || !pagination.HasPrevious {
+		t.Errorf("second page = %v, pagination %+v", descriptions(second), pagination)
+	}
+	back := pagination.PreviousCursor
+
+	third, pagination, err := repo.GetAllByCursor(ctx, models.ExpenseFilter{}, pagination.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("GetAllByCursor: %v", err)
+	}
+	if fmt.Sprint(descriptions(third)) != "[A]" || pagination.HasNext || pagination.NextCursor != "" {
+		t.Errorf("last page = %v, pagination %+v", descriptions(third), pagination)
+	}
+
+	previous, pagination, err := repo.GetAllByCursor(ctx, models.ExpenseFilter{}, back, 2)
+	if err != nil {
+		t.Fatalf("GetAllByCursor back: %v", err)
+	}
+	if fmt.Sprint(descriptions(previous)) != "[E D]" || !pagination.HasPrevious || !pagination.HasNext {
+		t.Errorf("previous page = %v, pagination %+v", descriptions(previous), pagination)
+	}
+	previous, pagination, err = repo.GetAllByCursor(ctx, models.ExpenseFilter{}, pagination.PreviousCursor, 2)
+	if err != nil {
+		t.Fatalf("GetAllByCursor back: %v", err)
+	}
+	if fmt.Sprint(descriptions(previous)) != "[F]" || pagination.HasPrevious || pagination.PreviousCursor != "" {
+		t.Errorf("top page = %v, pagination %+v", descriptions(previous), pagination)
+	}
+
+	// Cursors follow the filter's order and combine with its conditions
+	ascending := models.ExpenseFilter{Sort: "date", Order: "asc", StartDate: day("2024-06-02")}
+	page, pagination, err := repo.GetAll(ctx, ascending, 1, 2)
+	if err != nil {
+		t.Fatalf("GetAll ascending: %v", err)
+	}
+	page, _, err = repo.GetAllByCursor(ctx, ascending, pagination.NextCursor, 3)
+	if err != nil {
+		t.Fatalf("GetAllByCursor ascending: %v", err)
+	}
+	if fmt.Sprint(descriptions(page)) != "[D E F]" {
+		t.Errorf("ascending second page = %v, want [D E F]", descriptions(page))
+	}
+
+	if _, _, err := repo.GetAllByCursor(ctx, models.ExpenseFilter{}, "not a cursor", 2); !errors.Is(err, repository.ErrInvalidFilter) {
+		t.Errorf("malformed cursor = %v, want ErrInvalidFilter", err)
+	}
+	if _, _, err := repo.GetAllByCursor(ctx, models.ExpenseFilter{Sort: "amount"}, back, 2); !errors.Is(err, repository.ErrInvalidFilter) {
+		t.Errorf("cursor with sort=amount = %v, want ErrInvalidFilter", err)
+	}
+	if _, pagination, _ := repo.GetAll(ctx, models.ExpenseFilter{Sort: "amount"}, 1, 2); pagination.NextCursor != "" {
+		t.Errorf("sort=amount returned a cursor")
+	}
+}
+
+func testContextTimeouts(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	created := mustCreate(t, repo, newExpense("2024-07-01", "Shopping", "Kettle", 3500))[0]
+
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+
+	if _, _, err := repo.GetAll(expired, models.ExpenseFilter{}, 1, 10); !errors.Is(err, repository.ErrTimeout) {
+		t.Errorf("GetAll past deadline = %v, want ErrTimeout", err)
+	}
+	if _, err := repo.GetStats(expired, models.StatsFilter{}); !errors.Is(err, repository.ErrTimeout) {
+		t.Errorf("GetStats past deadline = %v, want ErrTimeout", err)
+	}
+	late := newExpense("2024-07-02", "Shopping", "Toaster", 2500)
+	if err := repo.Create(expired, &late); !errors.Is(err, repository.ErrTimeout) {
+		t.Errorf("Create past deadline = %v, want ErrTimeout", err)
+	}
+
+	// A client going away is not a timeout
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := repo.GetByID(canceled, created.ID); !errors.Is(err, context.Canceled) || errors.Is(err, repository.ErrTimeout) {
+		t.Errorf("GetByID canceled = %v, want context.Canceled", err)
+	}
+
+	expenses, _, err := repo.GetAll(ctx, models.ExpenseFilter{}, 1, 10)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if fmt.Sprint(descriptions(expenses)) != "[Kettle]" {
+		t.Errorf("expenses = %v, want only [Kettle]", descriptions(expenses))
+	}
+}
+
+func testBulkChanges(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+
+	first := newExpense("2024-08-01", "Other", "Order 1", 1500)
+	first.Vendor = "AMZN Mktp"
+	second := newExpense("2024-08-02", "Other", "Order 2", 2500)
+	second.Vendor = "amzn mktp"
+	second.Tags = []string{"online"}
+	cafe := newExpense("2024-08-03", "Food & Dining", "Coffee", 400)
+	cafe.Vendor = "Cafe"
+	created := mustCreate(t, repo, first, second, cafe)
+
+	category := "Shopping"
+	vendor := "Amazon"
+	changes := models.BulkChanges{Category: &category, Vendor: &vendor, AddTags: []string{"Import-Fix"}}
+	byVendor := models.BulkSelection{Filter: &models.ExpenseFilter{Vendor: "amzn", Sort: "date", Order: "asc"}}
+
+	preview, err := repo.BulkUpdate(ctx, byVendor, changes, true)
+	if err != nil {
+		t.Fatalf("BulkUpdate dry run: %v", err)
+	}
+	if !preview.DryRun || preview.Matched != 2 || preview.Affected != 2 || len(preview.Sample) != 2 {
+		t.Fatalf("dry run = %+v, want 2 matched and affected", preview)
+	}
+	if s := preview.Sample[0]; s.ID != created[0].ID || s.Category != "Shopping" || s.Vendor != "Amazon" ||
+		strings.Join(s.Tags, ",") != "import-fix" {
+		t.Errorf("dry run sample = %+v", s)
+	}
+	if got, _ := repo.GetByID(ctx, created[0].ID); got.Category != "Other" || got.Vendor != "AMZN Mktp" {
+		t.Errorf("dry run saved its changes: %+v", got)
+	}
+
+	result, err := repo.BulkUpdate(ctx, byVendor, changes, false)
+	if err != nil {
+		t.Fatalf("BulkUpdate: %v", err)
+	}
+	if result.DryRun || result.Matched != 2 || result.Affected != 2 {
+		t.Errorf("BulkUpdate = %+v, want 2 matched and affected", result)
+	}
+	got, _ := repo.GetByID(ctx, created[1].ID)
+	if got.Category != "Shopping" || got.Vendor != "Amazon" || strings.Join(got.Tags, ",") != "import-fix,online" {
+		t.Errorf("after BulkUpdate = %+v", got)
+	}
+	if history, _ := repo.GetHistory(ctx, created[1].ID); len(history) == 0 || history[0].Source != models.SourceBulkEdit {
+		t.Errorf("history = %+v, want a bulk_edit change first", history)
+	}
+
+	// Expenses that already have the changes are matched but not touched
+	byID := models.BulkSelection{IDs: []int{created[1].ID, created[0].ID, created[1].ID}}
+	if result, err := repo.BulkUpdate(ctx, byID, changes, false); err != nil || result.Matched != 2 || result.Affected != 0 {
+		t.Errorf("repeated BulkUpdate = %+v, %v; want 2 matched, none affected", result, err)
+	}
+
+	// One failure leaves every expense as it was
+	method := "Cash"
+	missing := models.BulkSelection{IDs: []int{created[2].ID, 999999}}
+	if _, err := repo.BulkUpdate(ctx, missing, models.BulkChanges{PaymentMethod: &method}, false); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("BulkUpdate with a missing ID = %v, want ErrExpenseNotFound", err)
+	}
+	if got, _ := repo.GetByID(ctx, created[2].ID); got.PaymentMethod == "Cash" {
+		t.Error("BulkUpdate with a missing ID changed the other expense")
+	}
+	unknown := "No Such Category"
+	if _, err := repo.BulkUpdate(ctx, byID, models.BulkChanges{Category: &unknown}, false); !errors.Is(err, repository.ErrCategoryNotFound) {
+		t.Errorf("BulkUpdate to an unknown category = %v, want ErrCategoryNotFound", err)
+	}
+
+	for name, bad := range map[string]struct {
+		selection models.BulkSelection
+		changes   models.BulkChanges
+	}{
+		"no selection":   {models.BulkSelection{}, changes},
+		"empty filter":   {models.BulkSelection{Filter: &models.ExpenseFilter{}}, changes},
+		"ids and filter": {models.BulkSelection{IDs: []int{created[0].ID}, Filter: byVendor.Filter}, changes},
+		"no changes":     {byID, models.BulkChanges{}},
+	} {
+		if _, err := repo.BulkUpdate(ctx, bad.selection, bad.changes, true); !errors.Is(err, repository.ErrInvalidBulkChange) {
+			t.Errorf("BulkUpdate with %s = %v, want ErrInvalidBulkChange", name, err)
+		}
+	}
+
+	preview, err = repo.BulkDelete(ctx, byID, true)
+	if err != nil || preview.Affected != 2 || len(preview.Sample) != 2 {
+		t.Fatalf("BulkDelete dry run = %+v, %v", preview, err)
+	}
+	if _, err := repo.GetByID(ctx, created[0].ID); err != nil {
+		t.Errorf("dry run deleted the expense: %v", err)
+	}
+	if result, err := repo.BulkDelete(ctx, byID, false); err != nil || result.Affected != 2 {
+		t.Fatalf("BulkDelete = %+v, %v", result, err)
+	}
+	trash, _, err := repo.GetTrash(ctx, 1, 20)
+	if err != nil {
+		t.Fatalf("GetTrash: %v", err)
+	}
+	deleted := descriptions(trash)
+	sort.Strings(deleted)
+	if fmt.Sprint(deleted) != "[Order 1 Order 2]" {
+		t.Errorf("trash = %v, want both orders", deleted)
+	}
+	remaining, _, _ := repo.GetAll(ctx, models.ExpenseFilter{}, 1, 20)
+	if fmt.Sprint(descriptions(remaining)) != "[Coffee]" {
+		t.Errorf("remaining = %v, want [Coffee]", descriptions(remaining))
+	}
+}
+
+func testVersions(t *testing.T, db *database.DB) {
+	ctx := t.Context()
+	repo := repository.NewExpenseRepository(db)
+	created := mustCreate(t, repo, newExpense("2024-09-01", "Shopping", "Lamp", 4500))[0]
+	if created.Version != 1 {
+		t.Fatalf("new expense version = %d, want 1", created.Version)
+	}
+
+	first := created
+	first.Amount = 4000
+	if err := repo.Update(ctx, created.ID, &first, 1); err != nil {
+		t.Fatalf("Update at the current version: %v", err)
+	}
+	if first.Version != 2 {
+		t.Errorf("version after Update = %d, want 2", first.Version)
+	}
+
+	// A second writer that read version 1 loses
+	second := created
+	second.Description = "Desk lamp"
+	if err := repo.Update(ctx, created.ID, &second, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("Update at a stale version = %v, want ErrVersionConflict", err)
+	}
+	if err := repo.Delete(ctx, created.ID, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("Delete at a stale version = %v, want ErrVersionConflict", err)
+	}
+	got, err := repo.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.Description != "Lamp" || got.Amount != 4000 || got.Version != 2 {
+		t.Errorf("after conflicts = %s %s version %d, want Lamp 40.00 version 2", got.Description, got.Amount, got.Version)
+	}
+	if err := repo.Update(ctx, created.ID+1000, &second, 1); !errors.Is(err, repository.ErrExpenseNotFound) {
+		t.Errorf("Update of a missing expense = %v, want ErrExpenseNotFound", err)
+	}
+
+	// Every kind of change moves the version on, without showing up as a
+	// changed field in history
+	if _, err := repo.UpdateTags(ctx, []int{created.ID}, []string{"home"}, nil); err != nil {
+		t.Fatalf("UpdateTags: %v", err)
+	}
+	if got, _ := repo.GetByID(ctx, created.ID); got.Version != 3 {
+		t.Errorf("version after UpdateTags = %d, want 3", got.Version)
+	}
+	history, err := repo.GetHistory(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if fields := fmt.Sprint(history[0].ChangedFields); fields != "[tags]" {
+		t.Errorf("changed fields = %s, want [tags]", fields)
+	}
+
+	if err := repo.Delete(ctx, created.ID, 3); err != nil {
+		t.Fatalf("Delete at the current version: %v", err)
+	}
+	if err := repo.Restore(ctx, created.ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got, _ := repo.GetByID(ctx, created.ID); got.Version != 5 {
+		t.Errorf("version after Delete and Restore = %d, want 5", got.Version)
+	}
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"expense-tracker/internal/models"
+)
+
+// Sentinels passed to the FTS5 highlight() function. They cannot appear in
+// user text, so the snippet can be HTML-escaped before they become tags.
+const (
+	highlightOpen  = "\x01"
+	highlightClose = "\x02"
+)
+
+// searchTerms splits a free-text query into the words the FTS5 unicode61
+// tokenizer would index, so both search paths agree on what a term is.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// ftsMatchExpr turns terms into an FTS5 query matching every term as a word
+// prefix. Quoting each term keeps user input from being parsed as FTS5
+// syntax.
+func ftsMatchExpr(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// likeSearchClause is the fallback for databases without FTS5: every term
+// must appear in at least one of the searched columns.
+func likeSearchClause(terms []string) (string, []interface{}) {
+	var clause strings.Builder
+	var args []interface{}
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		clause.WriteString(` AND (LOWER(description) LIKE ? ESCAPE '\' OR LOWER(vendor) LIKE ? ESCAPE '\' OR LOWER(payment_method) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	return clause.String(), args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// markHighlight HTML-escapes text produced by highlight() and turns the
+// sentinels into <mark> tags.
+func markHighlight(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, highlightOpen, "<mark>")
+	return strings.ReplaceAll(s, highlightClose, "</mark>")
+}
+
+// highlightTerms marks case-insensitive occurrences of terms in s. It is used
+// when FTS5 is not available to produce the same output as highlight().
+func highlightTerms(s string, terms []string) string {
+	lower := strings.ToLower(s)
+	marked := make([]bool, len(lower))
+	for _, term := range terms {
+		for start := 0; start < len(lower); {
+			i := strings.Index(lower[start:], term)
+			if i < 0 {
+				break
+			}
+			for j := start + i; j < start+i+len(term); j++ {
+				marked[j] = true
+			}
+			start += i + len(term)
+		}
+	}
+
+	// ToLower can change byte lengths for some scripts; skip highlighting
+	// rather than risk splitting a character.
+	if len(lower) != len(s) {
+		return html.EscapeString(s)
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			out.WriteString(highlightOpen)
+		}
+		out.WriteByte(s[i])
+		if marked[i] && (i == len(s)-1 || !marked[i+1]) {
+			out.WriteString(highlightClose)
+		}
+	}
+	return markHighlight(out.String())
+}
+
+func highlightExpense(e *models.Expense, terms []string) {
+	e.Highlight = &models.ExpenseHighlight{
+		Description:   highlightTerms(e.Description, terms),
+		Vendor:        highlightTerms(e.Vendor, terms),
+		PaymentMethod: highlightTerms(e.PaymentMethod, terms),
+	}
+}
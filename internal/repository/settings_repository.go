@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+
+	"expense-tracker/internal/currency"
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+const settingBaseCurrency = "base_currency"
+
+// DefaultBaseCurrency is used when the settings table has no base currency.
+const DefaultBaseCurrency = "USD"
+
+type SettingsRepository interface {
+	Get(ctx context.Context) (*models.Settings, error)
+	Update(ctx context.Context, settings *models.Settings) error
+	BaseCurrency(ctx context.Context) (string, error)
+}
+
+type settingsRepository struct {
+	db *database.DB
+}
+
+func NewSettingsRepository(db *database.DB) SettingsRepository {
+	return &settingsRepository{db: db}
+}
+
+func (r *settingsRepository) Get(ctx context.Context) (*models.Settings, error) {
+	base, err := r.BaseCurrency(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Settings{BaseCurrency: base}, nil
+}
+
+func (r *settingsRepository) Update(ctx context.Context, settings *models.Settings) error {
+	base, err := currency.NormalizeCode(settings.BaseCurrency)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO settings (key, value, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+        ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+    `
+	q, cancel := r.db.WithContext(ctx, database.OpWrite)
+	defer cancel()
+	if _, err := q.Exec(query, settingBaseCurrency, base); err != nil {
+		return err
+	}
+
+	settings.BaseCurrency = base
+	return nil
+}
+
+func (r *settingsRepository) BaseCurrency(ctx context.Context) (string, error) {
+	value, found, err := r.get(ctx, settingBaseCurrency)
+	if err != nil {
+		return "", err
+	}
+	if !found || value == "" {
+		return DefaultBaseCurrency, nil
+	}
+	return value, nil
+}
+
+func (r *settingsRepository) get(ctx context.Context, key string) (string, bool, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	rows, err := q.Query("SELECT value FROM settings WHERE key = ?", key)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", false, rows.Err()
+	}
+	var value string
+	if err := rows.Scan(&value); err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
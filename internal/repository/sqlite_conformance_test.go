@@ -0,0 +1,20 @@
+package repository_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/repository/repotest"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) *database.DB {
+		db, err := database.New(filepath.Join(t.TempDir(), "expenses.db"), database.DefaultOptions())
+		if err != nil {
+			t.Fatalf("database.New: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
+}
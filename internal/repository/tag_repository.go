@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+const maxTagLength = 64
+
+type TagRepository interface {
+	GetAll(ctx context.Context) ([]models.Tag, error)
+}
+
+type tagRepository struct {
+	db *database.DB
+}
+
+func NewTagRepository(db *database.DB) TagRepository {
+	return &tagRepository{db: db}
+}
+
+// GetAll lists every tag in use, with how many live expenses carry it.
+func (r *tagRepository) GetAll(ctx context.Context) ([]models.Tag, error) {
+	q, cancel := r.db.WithContext(ctx, database.OpRead)
+	defer cancel()
+	rows, err := q.Query(`
+        SELECT tags.id, tags.name, tags.created_at, COUNT(expenses.id)
+        FROM tags
+        LEFT JOIN expense_tags ON expense_tags.tag_id = tags.id
+        LEFT JOIN expenses ON expenses.id = expense_tags.expense_id AND expenses.deleted_at IS NULL
+        GROUP BY tags.id, tags.name, tags.created_at
+        ORDER BY tags.name
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.ExpenseCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// NormalizeTags trims and lower-cases tags, drops blanks and duplicates and
+// sorts the rest, so equal tag sets always compare equal. A nil list stays
+// nil, since it means "leave the tags alone" on update.
+func NormalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("%w: %q contains a comma", ErrInvalidTag, tag)
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidTag, tag, maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// setExpenseTags replaces an expense's tags, creating tags on first use.
+// Tags no expense uses any more are removed.
+func setExpenseTags(tx *database.Tx, expenseID int, tags []string) error {
+	result, err := tx.Exec("DELETE FROM expense_tags WHERE expense_id = ?", expenseID)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range tags {
+		tagID, err := ensureTag(tx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO expense_tags (expense_id, tag_id) VALUES (?, ?)", expenseID, tagID); err != nil {
+			return err
+		}
+	}
+
+	if removed, err := result.RowsAffected(); err == nil && removed > 0 {
+		return pruneTags(tx)
+	}
+	return nil
+}
+
+func ensureTag(tx *database.Tx, name string) (int, error) {
+	var id int
+	err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		newID, err := tx.ExecReturningID("INSERT INTO tags (name, created_at) VALUES (?, CURRENT_TIMESTAMP)", name)
+		return int(newID), err
+	}
+	return id, err
+}
+
+func pruneTags(q database.Querier) error {
+	_, err := q.Exec("DELETE FROM tags WHERE NOT EXISTS (SELECT 1 FROM expense_tags WHERE expense_tags.tag_id = tags.id)")
+	return err
+}
+
+// loadTags fills in the tags of the given expenses with one query.
+func loadTags(q database.Querier, expenses []models.Expense) error {
+	if len(expenses) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Expense, len(expenses))
+	placeholders := make([]string, len(expenses))
+	args := make([]interface{}, len(expenses))
+	for i := range expenses {
+		expenses[i].Tags = []string{}
+		byID[expenses[i].ID] = &expenses[i]
+		placeholders[i] = "?"
+		args[i] = expenses[i].ID
+	}
+
+	rows, err := q.Query(`
+        SELECT expense_tags.expense_id, tags.name
+        FROM expense_tags JOIN tags ON tags.id = expense_tags.tag_id
+        WHERE expense_tags.expense_id IN (`+strings.Join(placeholders, ", ")+`)
+        ORDER BY tags.name
+    `, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var expenseID int
+		var name string
+		if err := rows.Scan(&expenseID, &name); err != nil {
+			return err
+		}
+		if e := byID[expenseID]; e != nil {
+			e.Tags = append(e.Tags, name)
+		}
+	}
+	return rows.Err()
+}
+
+// tagFilterClause restricts expenses to those with any, or all, of the
+// given tag names.
+func tagFilterClause(anyOf, allOf []string) (string, []interface{}) {
+	const tagged = ` AND expenses.id IN (
+            SELECT expense_tags.expense_id FROM expense_tags
+            JOIN tags ON tags.id = expense_tags.tag_id
+            WHERE tags.name `
+
+	var clause strings.Builder
+	var args []interface{}
+	if len(anyOf) > 0 {
+		placeholders := make([]string, len(anyOf))
+		for i, name := range anyOf {
+			placeholders[i] = "?"
+			args = append(args, name)
+		}
+		clause.WriteString(tagged + "IN (" + strings.Join(placeholders, ", ") + "))")
+	}
+	for _, name := range allOf {
+		clause.WriteString(tagged + "= ?)")
+		args = append(args, name)
+	}
+	return clause.String(), args
+}
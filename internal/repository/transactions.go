@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"expense-tracker/internal/database"
+	"expense-tracker/internal/models"
+)
+
+// transaction is the type and accounts an expense is written with.
+type transaction struct {
+	txType            string
+	accountID         *int
+	transferAccountID *int
+}
+
+// normalizeTransactionType lower-cases a type and checks it is known. An
+// empty type stays empty so callers can apply their own default.
+func normalizeTransactionType(t string) (string, error) {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if t == "" {
+		return "", nil
+	}
+	for _, valid := range models.TransactionTypes {
+		if t == valid {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("%w: type must be one of %s", ErrInvalidTransaction, strings.Join(models.TransactionTypes, ", "))
+}
+
+// prepareTransaction works out the type and accounts of an expense. before
+// is the stored expense on update and nil on create; what the expense leaves
+// out is kept from it. A transfer moves money between two different accounts
+// in the expense's currency, cannot be split and falls back to the default
+// category.
+func prepareTransaction(q database.Querier, expense *models.Expense, before *models.Expense, splits []models.ExpenseSplit) (transaction, error) {
+	var txn transaction
+	var currentAccount, currentTransfer *int
+
+	txType, err := normalizeTransactionType(expense.Type)
+	if err != nil {
+		return txn, err
+	}
+	if before != nil {
+		currentAccount, currentTransfer = before.AccountID, before.TransferAccountID
+		if txType == "" {
+			txType = before.Type
+		}
+	}
+	if txType == "" {
+		txType = models.TransactionExpense
+	}
+	txn.txType = txType
+
+	account, err := expenseAccount(q, expense.Currency, expense.AccountID, expense.Account, currentAccount)
+	if err != nil {
+		return txn, err
+	}
+	if account != nil {
+		txn.accountID = &account.ID
+	}
+
+	namesTransfer := (expense.TransferAccountID != nil && *expense.TransferAccountID != 0) ||
+		strings.TrimSpace(expense.TransferAccount) != ""
+	if txType != models.TransactionTransfer {
+		if namesTransfer {
+			return txn, fmt.Errorf("%w: only transfers have a transfer account", ErrInvalidTransaction)
+		}
+		return txn, nil
+	}
+
+	transfer, err := expenseAccount(q, expense.Currency, expense.TransferAccountID, expense.TransferAccount, currentTransfer)
+	if err != nil {
+		return txn, err
+	}
+	switch {
+	case account == nil || transfer == nil:
+		return txn, fmt.Errorf("%w: a transfer needs both an account and a transfer account", ErrInvalidTransaction)
+	case account.ID == transfer.ID:
+		return txn, fmt.Errorf("%w: a transfer cannot go into the account it comes from", ErrInvalidTransaction)
+	case len(splits) > 0:
+		return txn, fmt.Errorf("%w: transfers cannot be split", ErrInvalidTransaction)
+	}
+	txn.transferAccountID = &transfer.ID
+
+	// Transfers are not spending, so they need not be categorized
+	if expense.CategoryID == 0 && strings.TrimSpace(expense.Category) == "" {
+		expense.Category = DefaultCategory
+	}
+	return txn, nil
+}
@@ -4,6 +4,7 @@ let previewData = null;
 let currentPage = 1;
 let totalPages = 1;
 let csrfToken = null;
+let categoryNames = [];
 
 document.addEventListener('DOMContentLoaded', async function() {
     await fetchCSRFToken();
@@ -13,7 +14,11 @@ document.addEventListener('DOMContentLoaded', async function() {
     setupAddExpenseForm();
     loadCategoryRules();
     loadDynamicCategories();
+    loadAccounts();
     setupChartViewToggle();
+    document.getElementById('searchQuery').addEventListener('keydown', event => {
+        if (event.key === 'Enter') loadExpenses();
+    });
 });
 
 async function fetchCSRFToken() {
@@ -115,12 +120,14 @@ async function addExpense() {
     
     // Get form data
     const expenseData = {
+        type: document.getElementById('expenseType').value,
         date: document.getElementById('expenseDate').value,
         category: document.getElementById('expenseCategory').value,
         description: document.getElementById('expenseDescription').value,
         amount: parseFloat(document.getElementById('expenseAmount').value),
         vendor: document.getElementById('expenseVendor').value || '',
-        payment_method: document.getElementById('expensePaymentMethod').value
+        payment_method: document.getElementById('expensePaymentMethod').value,
+        tags: splitTags(document.getElementById('expenseTags').value)
     };
     
     // Validate required fields
@@ -390,8 +397,14 @@ async function loadExpenses(page = 1) {
     const startDate = document.getElementById('startDate').value;
     const endDate = document.getElementById('endDate').value;
     const category = document.getElementById('category').value;
+    const query = document.getElementById('searchQuery').value.trim();
+    const tags = document.getElementById('tagFilter').value.trim();
+    const accountId = document.getElementById('accountFilter').value;
     
     const params = new URLSearchParams();
+    if (query) params.append('q', query);
+    if (tags) params.append('tags_any', tags);
+    if (accountId) params.append('account_id', accountId);
     if (startDate) params.append('start_date', startDate);
     if (endDate) params.append('end_date', endDate);
     if (category) params.append('category', category);
@@ -440,23 +453,129 @@ function displayExpenses(expenses) {
     }
     
     expenses.forEach(expense => {
+        // Search results carry escaped copies of the text fields with matches marked
+        const hl = expense.highlight;
         const row = document.createElement('tr');
         row.innerHTML = `
             <td>${formatDateYYYYMMDD(new Date(expense.date))}</td>
             <td>${expense.category}</td>
-            <td>${expense.description}</td>
+            <td>${renderType(expense)}<span class="expense-description">${hl ? hl.description : expense.description}</span>${renderTags(expense.tags)}${renderAttachments(expense)}</td>
             <td>${expense.amount < 0 ? `<span class="negative">-$${Math.abs(expense.amount).toFixed(2)}</span>` : `$${expense.amount.toFixed(2)}`}</td>
-            <td>${expense.vendor || '-'}</td>
-            <td>${expense.payment_method || '-'}</td>
+            <td>${(hl ? hl.vendor : expense.vendor) || '-'}</td>
+            <td>${(hl ? hl.payment_method : expense.payment_method) || '-'}</td>
             <td>
                 <button class="edit-btn" onclick="editExpense(${expense.id})">Edit</button>
+                <button class="edit-btn" onclick="uploadAttachment(${expense.id})">Attach</button>
                 <button class="delete-btn" onclick="deleteExpense(${expense.id})">Delete</button>
             </td>
         `;
+        if (expense.splits && expense.splits.length > 0) {
+            row.title = 'Split: ' + expense.splits
+                .map(line => `${line.category} $${line.amount.toFixed(2)}${line.note ? ` (${line.note})` : ''}`)
+                .join(', ');
+        }
         tbody.appendChild(row);
     });
 }
 
+function renderTags(tags) {
+    if (!tags || tags.length === 0) return '';
+    const escape = text => text.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+    return ` ${tags.map(tag => `<span class="tag">${escape(tag)}</span>`).join(' ')}`;
+}
+
+function renderType(expense) {
+    if (!expense.type || expense.type === 'expense') return '';
+    const label = expense.type === 'transfer' && expense.transfer_account
+        ? `transfer to ${expense.transfer_account.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;')}`
+        : expense.type;
+    return `<span class="type-badge type-${expense.type}">${label}</span> `;
+}
+
+function renderAttachments(expense) {
+    if (!expense.attachments) return '';
+    return ` <button class="attachment-toggle" title="Show attachments" onclick="toggleAttachments(${expense.id}, this)">&#128206; ${expense.attachments}</button>`;
+}
+
+// Lists an expense's attachments in a row under it, or hides the list again
+async function toggleAttachments(id, button) {
+    const row = button.closest('tr');
+    const next = row.nextElementSibling;
+    if (next && next.classList.contains('attachment-row')) {
+        next.remove();
+        return;
+    }
+    
+    try {
+        const response = await apiRequest(`/api/expenses/${id}/attachments`);
+        if (!response.ok) {
+            alert(`Error loading attachments: ${await response.text()}`);
+            return;
+        }
+        const attachments = await response.json();
+        const escape = text => text.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+        const detail = document.createElement('tr');
+        detail.className = 'attachment-row';
+        detail.innerHTML = `<td colspan="7">${attachments.map(a => `
+            <span class="attachment">
+                <a href="/api/attachments/${a.id}" target="_blank" rel="noopener">${escape(a.filename)}</a>
+                (${(a.size / 1024).toFixed(1)} KB)
+                <button class="delete-btn" onclick="deleteAttachment(${a.id})">Remove</button>
+            </span>`).join('')}</td>`;
+        row.after(detail);
+    } catch (error) {
+        console.error('Load attachments error:', error);
+    }
+}
+
+function uploadAttachment(id) {
+    const input = document.createElement('input');
+    input.type = 'file';
+    input.accept = 'application/pdf,image/jpeg,image/png,image/gif,image/webp';
+    input.addEventListener('change', async () => {
+        if (!input.files[0]) return;
+        const formData = new FormData();
+        formData.append('file', input.files[0]);
+        
+        try {
+            const response = await apiRequest(`/api/expenses/${id}/attachments`, {
+                method: 'POST',
+                body: formData
+            });
+            if (response.ok) {
+                loadExpenses(currentPage);
+            } else {
+                alert(`Error uploading attachment: ${await response.text()}`);
+            }
+        } catch (error) {
+            console.error('Upload attachment error:', error);
+            alert(`Error uploading attachment: ${error.message}`);
+        }
+    });
+    input.click();
+}
+
+async function deleteAttachment(id) {
+    if (!confirm('Remove this attachment?')) {
+        return;
+    }
+    
+    try {
+        const response = await apiRequest(`/api/attachments/${id}`, { method: 'DELETE' });
+        if (response.ok) {
+            loadExpenses(currentPage);
+        } else {
+            alert(`Error removing attachment: ${await response.text()}`);
+        }
+    } catch (error) {
+        console.error('Delete attachment error:', error);
+    }
+}
+
+function splitTags(value) {
+    return value.split(/[,;]/).map(tag => tag.trim()).filter(tag => tag !== '');
+}
+
 function displayPagination(pagination) {
     const paginationDiv = document.getElementById('pagination');
     if (!pagination || pagination.total === 0) {
@@ -518,7 +637,7 @@ async function editExpense(id) {
     const originalData = {
         date: cells[0].textContent,
         category: cells[1].textContent,
-        description: cells[2].textContent,
+        description: cells[2].querySelector('.expense-description')?.textContent ?? cells[2].textContent,
         amount: cells[3].textContent.replace('$', ''),
         vendor: cells[4].textContent === '-' ? '' : cells[4].textContent,
         payment_method: cells[5].textContent === '-' ? '' : cells[5].textContent
@@ -528,16 +647,13 @@ async function editExpense(id) {
     const dateValue = new Date(originalData.date).toISOString().split('T')[0];
     
     cells[0].innerHTML = `<input type="date" value="${dateValue}" class="edit-input">`;
+    // Keep the current category selectable even if it has been archived
+    const editCategories = categoryNames.includes(originalData.category)
+        ? categoryNames
+        : [originalData.category, ...categoryNames];
     cells[1].innerHTML = `
         <select class="edit-input">
-            <option value="Food & Dining" ${originalData.category === 'Food & Dining' ? 'selected' : ''}>Food & Dining</option>
-            <option value="Food Delivery" ${originalData.category === 'Food Delivery' ? 'selected' : ''}>Food Delivery</option>
-            <option value="Transportation" ${originalData.category === 'Transportation' ? 'selected' : ''}>Transportation</option>
-            <option value="Shopping" ${originalData.category === 'Shopping' ? 'selected' : ''}>Shopping</option>
-            <option value="Utilities" ${originalData.category === 'Utilities' ? 'selected' : ''}>Utilities</option>
-            <option value="Mobile & Telecom" ${originalData.category === 'Mobile & Telecom' ? 'selected' : ''}>Mobile & Telecom</option>
-            <option value="Healthcare" ${originalData.category === 'Healthcare' ? 'selected' : ''}>Healthcare</option>
-            <option value="Other" ${originalData.category === 'Other' ? 'selected' : ''}>Other</option>
+            ${editCategories.map(name => `<option value="${name}" ${originalData.category === name ? 'selected' : ''}>${name}</option>`).join('')}
         </select>
     `;
     cells[2].innerHTML = `<input type="text" value="${originalData.description}" class="edit-input">`;
@@ -615,6 +731,7 @@ function cancelEdit(id, originalDataStr) {
     cells[5].textContent = originalData.payment_method || '-';
     cells[6].innerHTML = `
         <button class="edit-btn" onclick="editExpense(${id})">Edit</button>
+        <button class="edit-btn" onclick="uploadAttachment(${id})">Attach</button>
         <button class="delete-btn" onclick="deleteExpense(${id})">Delete</button>
     `;
 }
@@ -1061,6 +1178,21 @@ async function addRule() {
     }
     
     try {
+        // Rules can only point at existing categories, so create a new one first
+        if (categoryCustom) {
+            const created = await apiRequest('/api/categories', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ name: categoryCustom })
+            });
+            if (!created.ok && created.status !== 409) {
+                alert('Error adding category: ' + await created.text());
+                return;
+            }
+        }
+        
         const response = await apiRequest('/api/categorization-rules', {
             method: 'POST',
             headers: {
@@ -1123,25 +1255,24 @@ async function loadDynamicCategories() {
         const response = await fetch('/api/categories');
         const categories = await response.json();
         
+        categoryNames = categories.map(category => category.name);
+        
         const categorySelect = document.getElementById('category');
+        const expenseCategorySelect = document.getElementById('expenseCategory');
         
         // Clear existing options except "All Categories"
         categorySelect.innerHTML = '<option value="">All Categories</option>';
+        expenseCategorySelect.innerHTML = '';
         
-        // Add categories from database
+        // Add categories from database, showing subcategories by their path
         categories.forEach(category => {
             const option = document.createElement('option');
-            option.value = category;
-            option.textContent = category;
+            option.value = category.name;
+            option.textContent = category.path || category.name;
             categorySelect.appendChild(option);
+            expenseCategorySelect.appendChild(option.cloneNode(true));
         });
         
-        // Add "Other" as fallback
-        const otherOption = document.createElement('option');
-        otherOption.value = 'Other';
-        otherOption.textContent = 'Other';
-        categorySelect.appendChild(otherOption);
-        
     } catch (error) {
         console.error('Error loading categories:', error);
         // Fallback to hardcoded categories if API fails
@@ -1158,6 +1289,24 @@ async function loadDynamicCategories() {
     }
 }
 
+async function loadAccounts() {
+    try {
+        const response = await fetch('/api/accounts');
+        const accounts = await response.json();
+        
+        const accountSelect = document.getElementById('accountFilter');
+        accountSelect.innerHTML = '<option value="">All Accounts</option>';
+        accounts.forEach(account => {
+            const option = document.createElement('option');
+            option.value = account.id;
+            option.textContent = `${account.name} (${account.currency} ${account.balance.toFixed(2)})`;
+            accountSelect.appendChild(option);
+        });
+    } catch (error) {
+        console.error('Error loading accounts:', error);
+    }
+}
+
 async function loadRuleCategoryOptions() {
     try {
         const response = await fetch('/api/categories');
@@ -1171,8 +1320,8 @@ async function loadRuleCategoryOptions() {
         // Add existing categories
         categories.forEach(category => {
             const option = document.createElement('option');
-            option.value = category;
-            option.textContent = category;
+            option.value = category.name;
+            option.textContent = category.path || category.name;
             categorySelect.appendChild(option);
         });
         